@@ -0,0 +1,9 @@
+// Package api holds the OpenAPI specification and protobuf definitions of the service.
+package api
+
+import _ "embed"
+
+// Spec is the OpenAPI 3 specification of the REST API.
+//
+//go:embed wallet.yaml
+var Spec []byte
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	"EWallet/internal"
+	"EWallet/pkg/config"
+	"EWallet/pkg/importer"
+	"EWallet/pkg/logger"
+	"EWallet/pkg/repository"
+
+	migrate "github.com/rubenv/sql-migrate"
+)
+
+const importUsage = `usage: ewallet import [-dry-run] [-format csv|jsonl] [settings] FILE
+
+Applies the deposits, withdrawals and transfers of FILE ("-" for stdin) to the database of the
+service settings (-config, the environment and the flags, as for the service) and prints the report.
+Rows imported before are skipped, run the same file again to resume.
+`
+
+// runImport is the import subcommand. It exits with 1 when a row failed and with 2 on usage errors.
+func runImport(args []string) int {
+	flags := flag.NewFlagSet("import", flag.ContinueOnError)
+	flags.Usage = func() {
+		fmt.Fprint(flags.Output(), importUsage)
+		flags.PrintDefaults()
+	}
+	dryRun := flags.Bool("dry-run", false, "check the rows without moving money")
+	format := flags.String("format", "", "file format, by the file extension by default (.jsonl and .ndjson are JSON Lines)")
+	cfg, err := config.Load(flags, args, os.Getenv)
+	if errors.Is(err, flag.ErrHelp) {
+		return 2
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return 2
+	}
+	name := flags.Arg(0)
+	if *format == "" {
+		*format = importer.FormatCSV
+		if ext := strings.ToLower(filepath.Ext(name)); ext == ".jsonl" || ext == ".ndjson" {
+			*format = importer.FormatJSONL
+		}
+	}
+
+	log, err := logger.New(cfg.Log.Level, cfg.Log.Format)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	log.SetOutput(os.Stderr)
+	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer cancel()
+	var file io.Reader = os.Stdin
+	if name != "-" {
+		f, err := os.Open(name)
+		if err != nil {
+			log.Errorf("err opening %s: %v", name, err)
+			return 2
+		}
+		defer f.Close()
+		file = f
+	}
+	rows, err := importer.NewReader(*format, file)
+	if err != nil {
+		log.Errorf("err reading %s: %v", name, err)
+		return 2
+	}
+	pg, err := repository.NewRepo(ctx, log, cfg.DB.DSN)
+	if err != nil {
+		log.Errorf("Failed to connect to database: %v", err)
+		return 1
+	}
+	defer pg.Close()
+	pg.SetCurrency(cfg.Wallet.Currency)
+	pg.SetPool(repository.PoolConfig{
+		MaxOpenConns:    cfg.DB.MaxOpenConns,
+		MaxIdleConns:    cfg.DB.MaxIdleConns,
+		ConnMaxLifetime: cfg.DB.ConnMaxLifetime,
+		ConnMaxIdleTime: cfg.DB.ConnMaxIdleTime,
+	})
+	if err = pg.Migrate(migrate.Up); err != nil {
+		log.Errorf("err migrating pg: %v", err)
+		return 1
+	}
+	app := internal.NewApp(log, pg, nil, nil, cfg.Idempotency.Retention, []byte(cfg.Statements.SigningKey))
+	app.SetIdempotencyLease(cfg.Idempotency.Lease)
+	report, err := app.Import(ctx, rows, *dryRun)
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	_ = enc.Encode(report)
+	if err != nil {
+		log.Errorf("import stopped, run it again to resume: %v", err)
+		return 1
+	}
+	if report.Failed > 0 {
+		return 1
+	}
+	return 0
+}
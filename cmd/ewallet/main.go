@@ -3,58 +3,240 @@ package main
 import (
 	"context"
 	"errors"
+	"flag"
 	"fmt"
-	"net/http"
 	"os"
 	"os/signal"
+	"strings"
+	"sync"
 	"syscall"
+	"time"
 
+	"EWallet/pkg/events"
 	"EWallet/pkg/exchange"
+	"EWallet/pkg/health"
+	"EWallet/pkg/jobs"
 
 	"EWallet/internal"
 	"EWallet/internal/rest"
+	"EWallet/internal/rpc"
+	"EWallet/pkg/config"
 	"EWallet/pkg/logger"
+	"EWallet/pkg/metrics"
 	"EWallet/pkg/repository"
+	"EWallet/pkg/screening"
+	"EWallet/pkg/tracing"
+	"EWallet/pkg/webhooks"
 
+	"github.com/gin-gonic/gin"
 	_ "github.com/jackc/pgx/v4/stdlib"
 	migrate "github.com/rubenv/sql-migrate"
+	"github.com/sirupsen/logrus"
 )
 
-const port = 3000
-
-var (
-	pgDSN  = os.Getenv("PG_DSN")
-	addr   = fmt.Sprintf(":3000")
-	xrHost = os.Getenv("XR_HOST")
-	apiKey = os.Getenv("API_KEY")
-	secret = os.Getenv("SECRET_JWT")
+const (
+	idempotencyCleanupInterval = time.Hour
+	eventsDispatchInterval     = time.Second
+	eventsBatchSize            = 100
+	webhookDeliveryInterval    = time.Second
+	webhookBatchSize           = 50
+	monthlyStatementsInterval  = time.Hour
 )
 
 func main() {
-	log := logger.NewLogger()
+	if len(os.Args) > 1 && os.Args[1] == "import" {
+		os.Exit(runImport(os.Args[2:]))
+	}
+	flags := flag.NewFlagSet("ewallet", flag.ContinueOnError)
+	printConfig := flags.Bool("print-config", false, "print the configuration with the secrets redacted and exit")
+	cfg, err := config.Load(flags, os.Args[1:], os.Getenv)
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	if *printConfig {
+		fmt.Print(cfg.Redacted())
+		return
+	}
+	log, err := logger.New(cfg.Log.Level, cfg.Log.Format)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	log.Debugf("config:\n%s", cfg.Redacted())
+	if !log.IsLevelEnabled(logrus.DebugLevel) {
+		gin.SetMode(gin.ReleaseMode)
+	}
+	// ctx runs the background loops, they stop after the servers and the job worker are drained.
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
-	pg, err := repository.NewRepo(ctx, log, pgDSN)
+	// The tracer provider goes first: the store, the exchange client and the router are instrumented
+	// with the provider installed when they are created.
+	tp, err := tracing.Setup(ctx, tracing.Config{
+		Exporter:    cfg.Tracing.Exporter,
+		Endpoint:    cfg.Tracing.Endpoint,
+		Insecure:    cfg.Tracing.Insecure,
+		SampleRatio: cfg.Tracing.SampleRatio,
+	})
+	if err != nil {
+		log.Panicf("err setting up tracing: %v", err)
+	}
+	pg, err := repository.NewRepo(ctx, log, cfg.DB.DSN)
 	if err != nil {
 		log.Panicf("Failed to connect to database: %v", err)
 	}
+	pg.SetCurrency(cfg.Wallet.Currency)
+	pg.SetPool(repository.PoolConfig{
+		MaxOpenConns:    cfg.DB.MaxOpenConns,
+		MaxIdleConns:    cfg.DB.MaxIdleConns,
+		ConnMaxLifetime: cfg.DB.ConnMaxLifetime,
+		ConnMaxIdleTime: cfg.DB.ConnMaxIdleTime,
+	})
+	metrics.Registry.MustRegister(pg.StatsCollector())
 
 	if err = pg.Migrate(migrate.Up); err != nil {
 		log.Panicf("err migrating pg: %v", err)
 	}
-	exch := exchange.NewExchangeRate(log, xrHost, apiKey)
-	app := internal.NewApp(log, pg, exch)
-	r := rest.NewRouter(log, app, secret)
-	go func() {
-		if err = r.Run(ctx, addr); err != nil && !errors.Is(err, http.ErrServerClosed) {
-			log.Panicf("Error starting server: %v", err)
-		}
-	}()
+	exch := exchange.NewExchangeRate(log, cfg.Exchange.Host, cfg.Exchange.APIKey, cfg.Exchange.Timeout)
+	exch.SetBaseCurrency(cfg.Wallet.Currency)
+	var screener internal.Screener
+	if cfg.Features.Screening {
+		screener = screening.NewScreener(log, screening.DefaultRules(pg)...)
+	}
+	sink, err := newEventsSink(cfg.Events.Sink)
+	if err != nil {
+		log.Panicf("err creating events sink: %v", err)
+	}
+	if cfg.Features.Webhooks {
+		sink = events.NewMultiSink(sink, webhooks.NewSink(pg))
+	}
+	app := internal.NewApp(log, pg, exch, screener, cfg.Idempotency.Retention, []byte(cfg.Statements.SigningKey))
+	app.SetIdempotencyLease(cfg.Idempotency.Lease)
+	worker := jobs.NewWorker(log, pg, cfg.Jobs.PollInterval, cfg.Jobs.Concurrency, cfg.Jobs.VisibilityTimeout)
+	worker.SetRetention(cfg.Jobs.Retention)
+	app.RegisterJobs(worker)
+
+	loops := []func(context.Context){
+		func(ctx context.Context) { app.RunIdempotencyCleanup(ctx, idempotencyCleanupInterval) },
+		worker.Run,
+		app.RunEventStream,
+		events.NewDispatcher(log, pg, sink, eventsDispatchInterval, eventsBatchSize).Run,
+	}
+	if cfg.Features.MonthlyStatements {
+		loops = append(loops, func(ctx context.Context) { app.RunMonthlyStatements(ctx, monthlyStatementsInterval) })
+	}
+	if cfg.Features.Webhooks {
+		loops = append(loops, webhooks.NewWorker(log, pg, nil, webhookDeliveryInterval, webhookBatchSize).Run)
+	}
+	var background sync.WaitGroup
+	for _, run := range loops {
+		background.Add(1)
+		go func(run func(context.Context)) {
+			defer background.Done()
+			run(ctx)
+		}(run)
+	}
+
+	// serveCtx is done on a signal or when a server fails, the servers then drain their requests.
+	serveCtx, stopServing := context.WithCancel(context.Background())
+	defer stopServing()
+	var servers sync.WaitGroup
+	serve := func(name string, run func(ctx context.Context) error) {
+		servers.Add(1)
+		go func() {
+			defer servers.Done()
+			defer stopServing()
+			if err := run(serveCtx); err != nil {
+				log.Errorf("%s server: %v", name, err)
+			}
+		}()
+	}
+	if cfg.Features.GRPC {
+		keys, _ := cfg.GRPC.Keys()
+		server := rpc.NewServer(log, app, cfg.Auth.JWTSecret, keys, cfg.Auth.Admins...)
+		server.SetShutdownTimeout(cfg.GRPC.ShutdownTimeout)
+		serve("gRPC", func(ctx context.Context) error {
+			return server.Run(ctx, cfg.GRPC.Addr)
+		})
+	}
+	router := rest.NewRouter(log, app, cfg.Auth.JWTSecret, cfg.Auth.Admins...)
+	router.SetServerTimeouts(rest.ServerTimeouts{
+		ReadHeader:    cfg.HTTP.ReadHeaderTimeout,
+		Read:          cfg.HTTP.ReadTimeout,
+		Write:         cfg.HTTP.WriteTimeout,
+		Idle:          cfg.HTTP.IdleTimeout,
+		Shutdown:      cfg.HTTP.ShutdownTimeout,
+		ShutdownDelay: cfg.HTTP.ShutdownDelay,
+	})
+	router.SetHealth(newHealthChecker(log, cfg, pg, exch))
+	serve("HTTP", func(ctx context.Context) error {
+		return router.Run(ctx, cfg.HTTP.Addr)
+	})
 
 	sigCh := make(chan os.Signal, 1)
-	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGHUP, syscall.SIGQUIT)
-	<-sigCh
+	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP, syscall.SIGQUIT)
+	select {
+	case sig := <-sigCh:
+		log.Infof("received %s, shutting down", sig)
+	case <-serveCtx.Done():
+	}
+	// The servers go first so no new work comes in, then the jobs finish, then the loops and the pool stop.
+	stopServing()
+	servers.Wait()
+	drainCtx, drainCancel := context.WithTimeout(context.Background(), cfg.Jobs.DrainTimeout)
+	if err = worker.Shutdown(drainCtx); err != nil {
+		log.Errorf("err draining jobs, the unfinished ones are queued again: %v", err)
+	}
+	drainCancel()
 	cancel()
+	background.Wait()
 	pg.Close()
+	flushCtx, flushCancel := context.WithTimeout(context.Background(), 5*time.Second)
+	if err = tp.Shutdown(flushCtx); err != nil {
+		log.Errorf("err flushing spans: %v", err)
+	}
+	flushCancel()
 	log.Info("Shutting down")
 }
+
+func newEventsSink(target string) (events.Sink, error) {
+	switch {
+	case target == "" || target == "stdout":
+		return events.NewWriterSink(os.Stdout), nil
+	case strings.HasPrefix(target, "file:"):
+		return events.NewFileSink(strings.TrimPrefix(target, "file:"))
+	case strings.HasPrefix(target, "http://") || strings.HasPrefix(target, "https://"):
+		return events.NewHTTPSink(target), nil
+	}
+	return nil, fmt.Errorf("unknown events sink %q", target)
+}
+
+// newHealthChecker checks the database and the migrations, which the service can't work without, and
+// the exchange API, which only currency conversion needs.
+func newHealthChecker(log *logrus.Logger, cfg config.Config, pg *repository.PG, exch *exchange.Rate) *health.Checker {
+	checks := []health.Check{
+		{Name: "db", Critical: true, Run: func(ctx context.Context) (map[string]interface{}, error) {
+			return nil, pg.Ping(ctx)
+		}},
+		{Name: "migrations", Critical: true, Run: func(ctx context.Context) (map[string]interface{}, error) {
+			pending, err := pg.PendingMigrations(ctx)
+			if err != nil {
+				return nil, err
+			}
+			details := map[string]interface{}{"pending": pending}
+			if len(pending) > 0 {
+				return details, fmt.Errorf("%d migrations are not applied", len(pending))
+			}
+			return details, nil
+		}},
+	}
+	if cfg.Exchange.Host != "" {
+		checks = append(checks, health.Check{Name: "exchange", TTL: cfg.Health.ExchangeTTL, Run: func(ctx context.Context) (map[string]interface{}, error) {
+			return nil, exch.Ping(ctx)
+		}})
+	}
+	return health.NewChecker(log, cfg.Health.Timeout, checks...)
+}
@@ -0,0 +1,87 @@
+package internal
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"EWallet/pkg/metrics"
+	"EWallet/pkg/repository"
+	"EWallet/pkg/tracing"
+
+	"github.com/google/uuid"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// DefaultIdempotencyLease is the time a request holds its idempotency key unless set otherwise.
+const DefaultIdempotencyLease = time.Minute
+
+// SetIdempotencyLease sets the time a request holds its idempotency key. A retry of a request that
+// didn't finish in that time, because the process died for one, executes it again instead of getting
+// ErrIdempotencyInProgress until the key expires.
+func (s *App) SetIdempotencyLease(lease time.Duration) {
+	s.idempotencyLease = lease
+}
+
+// BeginIdempotent reserves the key for the request identified by fingerprint. It returns the stored
+// response when the same request already finished. Otherwise the request has to be executed and
+// token, which identifies its reservation, is passed to FinishIdempotent.
+func (s *App) BeginIdempotent(ctx context.Context, scope repository.IdempotencyScope, key, fingerprint string) (_ *repository.IdempotencyKey, token string, err error) {
+	ctx, span := tracing.Start(ctx, "App.BeginIdempotent")
+	defer tracing.End(span, &err)
+	now := time.Now()
+	token = uuid.New().String()
+	record, reserved, err := s.store.ReserveIdempotencyKey(ctx, scope, key, fingerprint, token, now.Add(s.idempotencyLease), now.Add(s.idempotencyRetention))
+	if err != nil {
+		return nil, "", fmt.Errorf("err reserving idempotency key: %w", err)
+	}
+	switch {
+	case reserved:
+		return nil, token, nil
+	case record.Fingerprint != fingerprint:
+		return nil, "", repository.ErrIdempotencyMismatch
+	case record.StatusCode == nil:
+		return nil, "", repository.ErrIdempotencyInProgress
+	}
+	metrics.MetricIdempotentReplays.WithLabelValues(scope.Operation).Inc()
+	return &record, "", nil
+}
+
+// FinishIdempotent stores the response of a successful request, any other outcome frees the key
+// so the request can be retried. Once a retry took the key over after the lease the request of token
+// settles nothing and ErrIdempotencyKeyLost is returned.
+func (s *App) FinishIdempotent(ctx context.Context, scope repository.IdempotencyScope, key, token string, statusCode int, response []byte) (err error) {
+	ctx, span := tracing.Start(ctx, "App.FinishIdempotent", attribute.Int("http.status_code", statusCode))
+	defer tracing.End(span, &err)
+	if statusCode >= 200 && statusCode < 300 {
+		if err = s.store.SaveIdempotentResponse(ctx, scope, key, token, statusCode, response); err != nil {
+			return fmt.Errorf("err saving idempotent response: %w", err)
+		}
+		return nil
+	}
+	if err = s.store.ReleaseIdempotencyKey(ctx, scope, key, token); err != nil {
+		return fmt.Errorf("err releasing idempotency key: %w", err)
+	}
+	return nil
+}
+
+// RunIdempotencyCleanup deletes expired idempotency keys every interval until ctx is done.
+func (s *App) RunIdempotencyCleanup(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			cnt, err := s.store.DeleteExpiredIdempotencyKeys(ctx)
+			if err != nil {
+				s.log.Errorf("err cleaning up idempotency keys: %v", err)
+				continue
+			}
+			if cnt > 0 {
+				s.log.Infof("deleted %d expired idempotency keys", cnt)
+			}
+		}
+	}
+}
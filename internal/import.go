@@ -0,0 +1,199 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+
+	"EWallet/pkg/importer"
+	"EWallet/pkg/repository"
+	"EWallet/pkg/screening"
+	"EWallet/pkg/tracing"
+
+	"go.opentelemetry.io/otel/attribute"
+)
+
+const importProgressEvery = 1000
+
+// rejections are the errors that fail a single item of a bulk operation instead of the whole operation.
+var rejections = []struct {
+	err     error
+	field   string
+	message string
+}{
+	{repository.ErrWalletNotFound, "wallet_id", "wallet not found"},
+	{repository.ErrWalletTargetNotFound, "wallet_target", "target wallet not found"},
+	{repository.ErrWalletFrozen, "", "wallet is frozen"},
+	{repository.ErrInsufficientFunds, "sum", "insufficient funds"},
+	{repository.ErrDuplicateKey, "uuid", "operation with this uuid already exists"},
+	{screening.ErrTransactionDenied, "", "transaction denied"},
+}
+
+// rejection returns the field and the message of a rejection, ok is false for any other error.
+func rejection(err error) (field, message string, ok bool) {
+	for _, r := range rejections {
+		if errors.Is(err, r.err) {
+			return r.field, r.message, true
+		}
+	}
+	return "", "", false
+}
+
+// importKey is the scope a uuid is unique in: the same uuid may be used by another wallet or operation.
+type importKey struct {
+	walletID  int
+	operation string
+	uuid      string
+}
+
+// Import applies the rows through the same operations as the API, bypassing the screening. Rows are
+// identified by their wallet, operation and uuid: a row already applied is skipped, so an interrupted import is resumed by
+// running it again. A dry run checks every row against the current wallets without moving money.
+// Row problems are collected in the report; any other error stops the import.
+func (s *App) Import(ctx context.Context, rows importer.Reader, dryRun bool) (report importer.Report, err error) {
+	ctx, span := tracing.Start(ctx, "App.Import", attribute.Bool("dry_run", dryRun))
+	defer tracing.End(span, &err)
+	report = importer.Report{DryRun: dryRun, Errors: []importer.RowError{}}
+	seen := make(map[importKey]importer.Row)
+	wallets := make(map[int]*repository.Wallet)
+	for {
+		row, err := rows.Next()
+		if errors.Is(err, io.EOF) {
+			return report, nil
+		}
+		report.Total++
+		var rowErr *importer.RowError
+		if errors.As(err, &rowErr) {
+			report.Fail(*rowErr)
+			continue
+		}
+		if err != nil {
+			return report, fmt.Errorf("err reading import: %w", err)
+		}
+		if errs := row.Validate(); len(errs) > 0 {
+			report.Fail(errs...)
+			continue
+		}
+		key := importKey{walletID: row.WalletId, operation: row.Operation, uuid: row.UUID}
+		if prev, ok := seen[key]; ok {
+			if prev.Same(row) {
+				report.Skipped++
+			} else {
+				report.Fail(importer.RowError{Line: row.Line, UUID: row.UUID, Field: "uuid", Message: "is used by line " + strconv.Itoa(prev.Line)})
+			}
+			continue
+		}
+		seen[key] = row
+		applied, err := s.importRow(ctx, row, dryRun, wallets)
+		switch {
+		case errors.As(err, &rowErr):
+			report.Fail(*rowErr)
+		case err != nil:
+			return report, err
+		case applied:
+			report.Applied++
+		default:
+			report.Skipped++
+		}
+		if report.Total%importProgressEvery == 0 {
+			s.log.WithContext(ctx).Infof("import: %d rows read, %d applied, %d skipped, %d failed", report.Total, report.Applied, report.Skipped, report.Failed)
+		}
+	}
+}
+
+// importRow applies the row and reports whether it moved money, false means it was imported before.
+func (s *App) importRow(ctx context.Context, row importer.Row, dryRun bool, wallets map[int]*repository.Wallet) (bool, error) {
+	fail := func(field, message string) error {
+		return &importer.RowError{Line: row.Line, UUID: row.UUID, Field: field, Message: message}
+	}
+	// Only failed attempts leave the uuid free: the row is applied again, e.g. when the import resumes
+	// after the wallet was topped up.
+	existing, err := s.store.GetTransactionByUUID(ctx, row.WalletId, row.Operation, row.UUID)
+	switch {
+	case errors.Is(err, repository.ErrTransactionNotFound):
+	case err != nil:
+		return false, fmt.Errorf("err checking line %d: %w", row.Line, err)
+	case existing.Status == repository.StatusFailed:
+	case !sameTransaction(existing, row):
+		return false, fail("uuid", "is used by another transaction")
+	default:
+		return false, nil
+	}
+
+	request := &repository.FinRequest{Sum: row.Sum, WalletTarget: row.WalletTarget, UUID: row.UUID}
+	switch {
+	case dryRun:
+		err = s.simulate(ctx, row, wallets)
+	case row.Operation == "deposit":
+		err = s.store.Deposit(ctx, row.WalletId, request)
+	case row.Operation == "withdraw":
+		err = s.store.Withdrawal(ctx, row.WalletId, request)
+	default:
+		err = s.store.Transfer(ctx, row.WalletId, request)
+	}
+	if err != nil {
+		if field, message, ok := rejection(err); ok {
+			return false, fail(field, message)
+		}
+		return false, fmt.Errorf("err importing line %d: %w", row.Line, err)
+	}
+	return true, nil
+}
+
+// simulate checks the row against the wallets as the previous rows of the dry run left them.
+func (s *App) simulate(ctx context.Context, row importer.Row, wallets map[int]*repository.Wallet) error {
+	wallet := func(id int, notFound error) (*repository.Wallet, error) {
+		if w, ok := wallets[id]; ok {
+			return w, nil
+		}
+		w, err := s.store.GetWallet(ctx, id)
+		if errors.Is(err, repository.ErrWalletNotFound) {
+			return nil, notFound
+		}
+		if err != nil {
+			return nil, err
+		}
+		wallets[id] = &w
+		return &w, nil
+	}
+	source, err := wallet(row.WalletId, repository.ErrWalletNotFound)
+	if err != nil {
+		return err
+	}
+	var target *repository.Wallet
+	if row.Operation == "transfer" {
+		if target, err = wallet(row.WalletTarget, repository.ErrWalletTargetNotFound); err != nil {
+			return err
+		}
+	}
+	if source.Frozen || target != nil && target.Frozen {
+		return repository.ErrWalletFrozen
+	}
+	if row.Operation == "deposit" {
+		source.Balance = roundCents(source.Balance + row.Sum)
+		return nil
+	}
+	if roundCents(source.Balance-row.Sum) < 0 {
+		return repository.ErrInsufficientFunds
+	}
+	source.Balance = roundCents(source.Balance - row.Sum)
+	if target != nil {
+		target.Balance = roundCents(target.Balance + row.Sum)
+	}
+	return nil
+}
+
+func sameTransaction(t repository.Transaction, row importer.Row) bool {
+	target := 0
+	if t.ToId != nil {
+		target = *t.ToId
+	}
+	return row.Same(importer.Row{Operation: t.Operation, WalletId: t.FromId, WalletTarget: target, Sum: t.Sum, UUID: t.UUID})
+}
+
+func roundCents(amount float64) float64 {
+	return math.Round(amount*100) / 100
+}
@@ -0,0 +1,193 @@
+package internal
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"EWallet/pkg/export"
+	"EWallet/pkg/importer"
+	"EWallet/pkg/jobs"
+	"EWallet/pkg/repository"
+	"EWallet/pkg/tracing"
+
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// importJob is the payload of an import job, the file is kept with the job so a retry reads it again.
+type importJob struct {
+	Format string `json:"format"`
+	DryRun bool   `json:"dry_run"`
+	Data   string `json:"data"`
+}
+
+type exportJob struct {
+	WalletId int       `json:"wallet_id"`
+	Format   string    `json:"format"`
+	From     time.Time `json:"from"`
+	To       time.Time `json:"to"`
+	Columns  []string  `json:"columns,omitempty"`
+}
+
+type statementsJob struct {
+	Month string `json:"month"`
+}
+
+// RegisterJobs makes the worker run the background jobs of the service.
+func (s *App) RegisterJobs(w *jobs.Worker) {
+	w.Register(repository.JobPayout, s.runPayoutJob)
+	w.Register(repository.JobImport, s.runImportJob)
+	w.Register(repository.JobExport, s.runExportJob)
+	w.Register(repository.JobMonthlyStatements, s.runStatementsJob)
+}
+
+func (s *App) GetJob(ctx context.Context, id int64) (job repository.Job, err error) {
+	ctx, span := tracing.Start(ctx, "App.GetJob", attribute.Int64("job.id", id))
+	defer tracing.End(span, &err)
+	job, err = s.store.GetJob(ctx, id)
+	if err != nil {
+		return repository.Job{}, fmt.Errorf("err getting job: %w", err)
+	}
+	return job, nil
+}
+
+func (s *App) GetJobs(ctx context.Context, status, jobType string, limit int) (list []repository.Job, err error) {
+	ctx, span := tracing.Start(ctx, "App.GetJobs")
+	defer tracing.End(span, &err)
+	list, err = s.store.GetJobs(ctx, status, jobType, limit)
+	if err != nil {
+		return nil, fmt.Errorf("err getting jobs: %w", err)
+	}
+	return list, nil
+}
+
+// GetJobOutput returns the file produced by the job with its content type.
+func (s *App) GetJobOutput(ctx context.Context, id int64) (output []byte, contentType string, err error) {
+	ctx, span := tracing.Start(ctx, "App.GetJobOutput", attribute.Int64("job.id", id))
+	defer tracing.End(span, &err)
+	output, contentType, err = s.store.GetJobOutput(ctx, id)
+	if err != nil {
+		return nil, "", fmt.Errorf("err getting job output: %w", err)
+	}
+	return output, contentType, nil
+}
+
+// EnqueueImport queues the import of the file for the user, the job result is the import report.
+func (s *App) EnqueueImport(ctx context.Context, username, format string, data []byte, dryRun bool) (_ repository.Job, err error) {
+	ctx, span := tracing.Start(ctx, "App.EnqueueImport", attribute.String("format", format))
+	defer tracing.End(span, &err)
+	return s.enqueue(ctx, repository.Job{Type: repository.JobImport, Username: username}, importJob{Format: format, DryRun: dryRun, Data: string(data)})
+}
+
+// EnqueueExport queues the export of the statement of wallet id for [from, to) for the user, the job
+// output is the file.
+func (s *App) EnqueueExport(ctx context.Context, username string, id int, format string, from, to time.Time, columns []string) (_ repository.Job, err error) {
+	ctx, span := tracing.Start(ctx, "App.EnqueueExport", walletID(id), attribute.String("format", format))
+	defer tracing.End(span, &err)
+	if _, err = s.store.GetWallet(ctx, id); err != nil {
+		return repository.Job{}, fmt.Errorf("err getting wallet: %w", err)
+	}
+	payload := exportJob{WalletId: id, Format: format, From: from, To: to, Columns: columns}
+	return s.enqueue(ctx, repository.Job{Type: repository.JobExport, Username: username}, payload)
+}
+
+func (s *App) enqueue(ctx context.Context, job repository.Job, payload interface{}) (repository.Job, error) {
+	var err error
+	if job.Payload, err = json.Marshal(payload); err != nil {
+		return repository.Job{}, fmt.Errorf("err encoding %s job: %w", job.Type, err)
+	}
+	if job, err = s.store.EnqueueJob(ctx, job); err != nil {
+		return repository.Job{}, fmt.Errorf("err enqueueing job: %w", err)
+	}
+	return job, nil
+}
+
+// decode reads the payload of the job, a payload that can't be read fails the job for good.
+func decode(job repository.Job, payload interface{}) error {
+	if err := json.Unmarshal(job.Payload, payload); err != nil {
+		return jobs.Permanent(fmt.Errorf("err decoding %s job: %w", job.Type, err))
+	}
+	return nil
+}
+
+func (s *App) runPayoutJob(ctx context.Context, job repository.Job) (jobs.Result, error) {
+	var payload repository.PayoutJob
+	if err := decode(job, &payload); err != nil {
+		return jobs.Result{}, err
+	}
+	payout, err := s.ExecutePayout(ctx, payload.PayoutId)
+	if errors.Is(err, repository.ErrPayoutNotFound) {
+		return jobs.Result{}, jobs.Permanent(err)
+	}
+	if err != nil {
+		return jobs.Result{}, err
+	}
+	return jobs.Result{Value: map[string]interface{}{"payout_id": payout.Id, "status": payout.Status}}, nil
+}
+
+func (s *App) runImportJob(ctx context.Context, job repository.Job) (jobs.Result, error) {
+	var payload importJob
+	if err := decode(job, &payload); err != nil {
+		return jobs.Result{}, err
+	}
+	rows, err := importer.NewReader(payload.Format, strings.NewReader(payload.Data))
+	if err != nil {
+		return jobs.Result{}, jobs.Permanent(err)
+	}
+	// Rows applied by an earlier attempt are skipped, so a retry resumes the import.
+	report, err := s.Import(ctx, rows, payload.DryRun)
+	if err != nil {
+		return jobs.Result{}, err
+	}
+	return jobs.Result{Value: report}, nil
+}
+
+func (s *App) runExportJob(ctx context.Context, job repository.Job) (jobs.Result, error) {
+	var payload exportJob
+	if err := decode(job, &payload); err != nil {
+		return jobs.Result{}, err
+	}
+	var buf bytes.Buffer
+	w, err := s.NewStatementWriter(payload.Format, &buf, payload.Columns)
+	if err != nil {
+		return jobs.Result{}, jobs.Permanent(err)
+	}
+	err = s.WalkStatement(ctx, payload.WalletId, payload.From, payload.To, w.Begin, w.Entry)
+	if err == nil {
+		err = w.End()
+	}
+	if errors.Is(err, repository.ErrWalletNotFound) {
+		return jobs.Result{}, jobs.Permanent(err)
+	}
+	if err != nil {
+		return jobs.Result{}, fmt.Errorf("err exporting the statement: %w", err)
+	}
+	return jobs.Result{
+		Value:       map[string]interface{}{"filename": export.Filename(payload.Format, payload.WalletId), "size": buf.Len()},
+		Output:      buf.Bytes(),
+		ContentType: export.ContentType(payload.Format),
+	}, nil
+}
+
+func (s *App) runStatementsJob(ctx context.Context, job repository.Job) (jobs.Result, error) {
+	var payload statementsJob
+	if err := decode(job, &payload); err != nil {
+		return jobs.Result{}, err
+	}
+	month, err := time.Parse("2006-01", payload.Month)
+	if err != nil {
+		return jobs.Result{}, jobs.Permanent(fmt.Errorf("err parsing month: %w", err))
+	}
+	cnt, err := s.GenerateMonthlyStatements(ctx, month)
+	if err != nil {
+		return jobs.Result{}, err
+	}
+	if cnt > 0 {
+		s.log.WithContext(ctx).Infof("generated %d statements for %s", cnt, payload.Month)
+	}
+	return jobs.Result{Value: map[string]interface{}{"month": payload.Month, "generated": cnt}}, nil
+}
@@ -0,0 +1,197 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+
+	"EWallet/pkg/metrics"
+	"EWallet/pkg/repository"
+	"EWallet/pkg/screening"
+	"EWallet/pkg/tracing"
+
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// CreatePayout stores the payout of the request from wallet id and queues its execution for the user,
+// the items are paid by the payout job. The wallet has to hold the total of the items up front, otherwise
+// nothing is stored. The check is advisory: the balance is not locked, so the items are checked again
+// when the job pays them and may still fail with insufficient funds.
+func (s *App) CreatePayout(ctx context.Context, username string, id int, request repository.PayoutRequest) (payout repository.Payout, err error) {
+	ctx, span := tracing.Start(ctx, "App.CreatePayout", walletID(id), attribute.Int("payout.items", len(request.Items)))
+	defer tracing.End(span, &err)
+	wallet, err := s.store.GetWallet(ctx, id)
+	if err != nil {
+		return repository.Payout{}, fmt.Errorf("err getting wallet: %w", err)
+	}
+	if wallet.Frozen {
+		return repository.Payout{}, repository.ErrWalletFrozen
+	}
+	var total int64
+	for _, item := range request.Items {
+		total += int64(math.Round(item.Sum * 100))
+	}
+	if int64(math.Round(wallet.Balance*100)) < total {
+		metrics.MetricInsufficientFunds.WithLabelValues("payout").Inc()
+		return repository.Payout{}, fmt.Errorf("payout total %.2f: %w", float64(total)/100, repository.ErrInsufficientFunds)
+	}
+	payout, err = s.store.CreatePayout(ctx, username, repository.Payout{
+		WalletId: id,
+		Atomic:   request.Atomic,
+		Total:    float64(total) / 100,
+		Items:    request.Items,
+	})
+	if err != nil {
+		return repository.Payout{}, fmt.Errorf("err creating payout: %w", err)
+	}
+	return payout, nil
+}
+
+// ExecutePayout pays the pending items of the payout and sets its final status, unless items are held for
+// review: the payout stays processing until the reviews resolve them. Items paid by an earlier,
+// interrupted run are not paid twice, so it is safe to run again until the payout is finished.
+func (s *App) ExecutePayout(ctx context.Context, id int) (payout repository.Payout, err error) {
+	ctx, span := tracing.Start(ctx, "App.ExecutePayout", attribute.Int("payout.id", id))
+	defer tracing.End(span, &err)
+	payout, err = s.GetPayout(ctx, id)
+	if err != nil {
+		return repository.Payout{}, err
+	}
+	if payout.Status != repository.PayoutProcessing {
+		return payout, nil
+	}
+	if payout.Atomic {
+		err = s.executeAtomicPayout(ctx, payout)
+	} else {
+		err = s.executePayout(ctx, payout)
+	}
+	if err != nil {
+		return repository.Payout{}, fmt.Errorf("err executing payout %d: %w", payout.Id, err)
+	}
+	if err = s.store.FinishPayout(ctx, payout.Id); err != nil {
+		return repository.Payout{}, fmt.Errorf("err finishing payout %d: %w", payout.Id, err)
+	}
+	return s.GetPayout(ctx, payout.Id)
+}
+
+func (s *App) GetPayout(ctx context.Context, id int) (payout repository.Payout, err error) {
+	ctx, span := tracing.Start(ctx, "App.GetPayout", attribute.Int("payout.id", id))
+	defer tracing.End(span, &err)
+	payout, err = s.store.GetPayout(ctx, id)
+	if err != nil {
+		return repository.Payout{}, fmt.Errorf("err getting payout: %w", err)
+	}
+	return payout, nil
+}
+
+// executePayout pays the pending items one by one as single transfers, screening included: an item
+// held for review waits for it, a rejected item fails without stopping the others.
+func (s *App) executePayout(ctx context.Context, payout repository.Payout) error {
+	for _, item := range payout.Items {
+		if item.Status != repository.PayoutItemPending {
+			continue
+		}
+		status, reason := repository.PayoutItemCompleted, (*string)(nil)
+		err := s.Transfer(ctx, payout.WalletId, &repository.FinRequest{Sum: item.Sum, WalletTarget: item.WalletTarget, UUID: item.UUID})
+		if errors.Is(err, repository.ErrDuplicateKey) {
+			status, reason, err = s.paidBefore(ctx, payout, item)
+		}
+		if errors.Is(err, screening.ErrPendingReview) {
+			status = repository.PayoutItemPendingReview
+		} else if err != nil {
+			_, message, ok := rejection(err)
+			if !ok {
+				return err
+			}
+			status, reason = repository.PayoutItemFailed, &message
+		}
+		if err = s.store.SetPayoutItemStatus(ctx, payout.Id, item.Position, status, reason); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// paidBefore returns the outcome of an item whose uuid is taken by a transfer of the payout wallet: the
+// transfer of an earlier run when it matches the item, a duplicate key error otherwise. Other wallets
+// may use the uuid, their transfers are not looked at.
+func (s *App) paidBefore(ctx context.Context, payout repository.Payout, item repository.PayoutItem) (string, *string, error) {
+	t, err := s.store.GetTransactionByUUID(ctx, payout.WalletId, "transfer", item.UUID)
+	if err != nil {
+		return "", nil, fmt.Errorf("err getting transaction of item %d: %w", item.Position, err)
+	}
+	if t.ToId == nil || *t.ToId != item.WalletTarget || math.Round(t.Sum*100) != math.Round(item.Sum*100) {
+		return "", nil, repository.ErrDuplicateKey
+	}
+	switch t.Status {
+	case repository.StatusCompleted, repository.StatusReversed:
+		return repository.PayoutItemCompleted, nil, nil
+	case repository.StatusFailed:
+		reason := "transfer failed"
+		return repository.PayoutItemFailed, &reason, nil
+	}
+	return repository.PayoutItemPendingReview, nil, nil
+}
+
+// executeAtomicPayout screens every item before any money moves, an item the screening would deny or
+// hold fails the payout as a whole. A payout whose items are not all pending was executed by an earlier
+// run: the money moves and the item statuses are committed together, so only failing it may be left
+// unfinished.
+func (s *App) executeAtomicPayout(ctx context.Context, payout repository.Payout) error {
+	if payout.Pending != len(payout.Items) {
+		if payout.Completed > 0 {
+			return nil
+		}
+		return s.failAtomicPayout(ctx, payout, -1, "")
+	}
+	for _, item := range payout.Items {
+		if s.screener == nil {
+			break
+		}
+		decision, err := s.screener.Screen(ctx, screening.Operation{
+			Kind:     "transfer",
+			WalletID: payout.WalletId,
+			TargetID: item.WalletTarget,
+			Sum:      item.Sum,
+			UUID:     item.UUID,
+		})
+		if err != nil {
+			return fmt.Errorf("err screening item %d: %w", item.Position, err)
+		}
+		switch decision.Verdict {
+		case screening.Deny:
+			return s.failAtomicPayout(ctx, payout, item.Position, "transaction denied")
+		case screening.Review:
+			return s.failAtomicPayout(ctx, payout, item.Position, "requires review, not allowed in an atomic payout")
+		}
+	}
+	err := s.store.ExecuteAtomicPayout(ctx, payout.Id)
+	var itemErr *repository.PayoutItemError
+	if errors.As(err, &itemErr) {
+		_, message, _ := rejection(itemErr.Err)
+		return s.failAtomicPayout(ctx, payout, itemErr.Position, message)
+	}
+	return err
+}
+
+// failAtomicPayout fails every pending item, the one at position with the reason.
+func (s *App) failAtomicPayout(ctx context.Context, payout repository.Payout, position int, reason string) error {
+	notPaid := fmt.Sprintf("not paid, item %d failed", position)
+	if position < 0 {
+		notPaid = "not paid, the payout failed"
+	}
+	for _, item := range payout.Items {
+		if item.Status != repository.PayoutItemPending {
+			continue
+		}
+		message := notPaid
+		if item.Position == position {
+			message = reason
+		}
+		if err := s.store.SetPayoutItemStatus(ctx, payout.Id, item.Position, repository.PayoutItemFailed, &message); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -13,9 +13,8 @@ type UserInfo struct {
 
 func (r *Router) authHandler(c *gin.Context) {
 	var user UserInfo
-	err := c.BindJSON(&user)
-	if err != nil {
-		c.JSON(http.StatusBadRequest, err)
+	if err := c.ShouldBindJSON(&user); err != nil {
+		r.badRequest(c, err)
 		return
 	}
 	if user.Username == "aspan" && user.Password == "12345" {
@@ -23,7 +22,33 @@ func (r *Router) authHandler(c *gin.Context) {
 		c.JSON(http.StatusOK, tokenString)
 		return
 	} else {
-		c.JSON(http.StatusUnauthorized, "authentication failed")
+		r.problem(c, http.StatusUnauthorized, CodeUnauthorized, "authentication failed")
 		return
 	}
 }
+
+func (r *Router) adminAuth() func(c *gin.Context) {
+	return func(c *gin.Context) {
+		if !r.admins[r.GetUserSession(c).Username] {
+			r.problem(c, http.StatusForbidden, CodeForbidden, "admin role required")
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
+
+// authorizeWallet lets the owner of the wallet and admins through, otherwise it answers the request
+// with a problem and reports false.
+func (r *Router) authorizeWallet(c *gin.Context, id int, action string) bool {
+	wallet, err := r.app.GetWallet(c, id, "")
+	if err != nil {
+		r.fail(c, err)
+		return false
+	}
+	if username := r.GetUserSession(c).Username; wallet.Owner != username && !r.admins[username] {
+		r.problem(c, http.StatusForbidden, CodeForbidden, "only the wallet owner can "+action)
+		return false
+	}
+	return true
+}
@@ -0,0 +1,231 @@
+package rest
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"EWallet/pkg/logger"
+	"EWallet/pkg/repository"
+	"EWallet/pkg/screening"
+	"EWallet/pkg/validation"
+
+	"github.com/getkin/kin-openapi/openapi3"
+	"github.com/getkin/kin-openapi/openapi3filter"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+const (
+	problemContentType = "application/problem+json"
+	requestIDHeader    = "X-Request-ID"
+	requestIDKey       = "RequestID"
+)
+
+// Error codes are part of the API: clients match on them, so they never change once published.
+const (
+	CodeInvalidRequest          = "invalid_request"
+	CodeValidationFailed        = "validation_failed"
+	CodeUnauthorized            = "unauthorized"
+	CodeForbidden               = "forbidden"
+	CodeNotFound                = "not_found"
+	CodeInternal                = "internal_error"
+	CodeWalletNotFound          = "wallet_not_found"
+	CodeWalletTargetNotFound    = "wallet_target_not_found"
+	CodeWalletFrozen            = "wallet_frozen"
+	CodeInsufficientFunds       = "insufficient_funds"
+	CodeDuplicateKey            = "duplicate_key"
+	CodeTransactionNotFound     = "transaction_not_found"
+	CodeInvalidCursor           = "invalid_cursor"
+	CodeStatementNotFound       = "statement_not_found"
+	CodePayoutNotFound          = "payout_not_found"
+	CodeJobNotFound             = "job_not_found"
+	CodeJobOutputNotFound       = "job_output_not_found"
+	CodeTransactionStatus       = "invalid_transaction_status"
+	CodeTransactionDenied       = "transaction_denied"
+	CodeReviewNotFound          = "review_not_found"
+	CodeReviewNotPending        = "review_not_pending"
+	CodeIdempotencyMismatch     = "idempotency_key_mismatch"
+	CodeIdempotencyInProgress   = "idempotency_key_in_progress"
+	CodeWebhookNotFound         = "webhook_not_found"
+	CodeWebhookDeliveryNotFound = "webhook_delivery_not_found"
+)
+
+// Problem is the RFC 7807 body of every error response.
+type Problem struct {
+	Type      string                  `json:"type"`
+	Title     string                  `json:"title"`
+	Status    int                     `json:"status"`
+	Code      string                  `json:"code"`
+	Detail    string                  `json:"detail,omitempty"`
+	Instance  string                  `json:"instance,omitempty"`
+	RequestID string                  `json:"request_id,omitempty"`
+	Errors    []validation.FieldError `json:"errors,omitempty"`
+}
+
+// Status is the body of successful responses without a resource.
+type Status struct {
+	Status string `json:"status"`
+}
+
+var (
+	statusOK            = Status{Status: "ok"}
+	statusCompleted     = Status{Status: "completed"}
+	statusPendingReview = Status{Status: "pending_review"}
+)
+
+type knownError struct {
+	err     error
+	status  int
+	code    string
+	message string
+}
+
+var knownErrors = []knownError{
+	{repository.ErrWalletNotFound, http.StatusNotFound, CodeWalletNotFound, "wallet not found"},
+	{repository.ErrWalletTargetNotFound, http.StatusNotFound, CodeWalletTargetNotFound, "target wallet not found"},
+	{repository.ErrWalletFrozen, http.StatusConflict, CodeWalletFrozen, "wallet is frozen"},
+	{repository.ErrInsufficientFunds, http.StatusBadRequest, CodeInsufficientFunds, "insufficient funds"},
+	{repository.ErrDuplicateKey, http.StatusConflict, CodeDuplicateKey, "operation with this uuid already exists"},
+	{repository.ErrTransactionNotFound, http.StatusNotFound, CodeTransactionNotFound, "transaction not found"},
+	{repository.ErrInvalidCursor, http.StatusBadRequest, CodeInvalidCursor, "cursor does not match the query"},
+	{repository.ErrStatementNotFound, http.StatusNotFound, CodeStatementNotFound, "statement not found"},
+	{repository.ErrPayoutNotFound, http.StatusNotFound, CodePayoutNotFound, "payout not found"},
+	{repository.ErrJobNotFound, http.StatusNotFound, CodeJobNotFound, "job not found"},
+	{repository.ErrJobOutputNotFound, http.StatusNotFound, CodeJobOutputNotFound, "job has no output, it did not succeed or produces none"},
+	{repository.ErrTransactionStatus, http.StatusConflict, CodeTransactionStatus, "transaction status does not allow the change"},
+	{repository.ErrReviewNotFound, http.StatusNotFound, CodeReviewNotFound, "review not found"},
+	{repository.ErrReviewNotPending, http.StatusConflict, CodeReviewNotPending, "review is already resolved"},
+	{repository.ErrIdempotencyMismatch, http.StatusUnprocessableEntity, CodeIdempotencyMismatch, "idempotency key was used with a different request"},
+	{repository.ErrIdempotencyInProgress, http.StatusConflict, CodeIdempotencyInProgress, "request with this idempotency key is in progress"},
+	{repository.ErrWebhookNotFound, http.StatusNotFound, CodeWebhookNotFound, "webhook not found"},
+	{repository.ErrWebhookDeliveryNotFound, http.StatusNotFound, CodeWebhookDeliveryNotFound, "webhook delivery not found"},
+	{screening.ErrTransactionDenied, http.StatusForbidden, CodeTransactionDenied, "transaction denied"},
+}
+
+// requestID returns the X-Request-ID of the request, generating one when the client sent none or one
+// unfit for the logs.
+func requestID(c *gin.Context) string {
+	if id := c.GetString(requestIDKey); id != "" {
+		return id
+	}
+	id := c.GetHeader(requestIDHeader)
+	if !logger.ValidRequestID(id) {
+		id = uuid.New().String()
+	}
+	c.Set(requestIDKey, id)
+	c.Header(requestIDHeader, id)
+	return id
+}
+
+// problem writes an error response.
+func (r *Router) problem(c *gin.Context, status int, code, detail string, fields ...validation.FieldError) {
+	c.Header("Content-Type", problemContentType)
+	c.Render(status, problemRender{Problem{
+		Type:      "urn:ewallet:error:" + code,
+		Title:     http.StatusText(status),
+		Status:    status,
+		Code:      code,
+		Detail:    detail,
+		Instance:  c.Request.URL.Path,
+		RequestID: requestID(c),
+		Errors:    fields,
+	}})
+}
+
+// fail writes the error response of err. Errors other than the known ones are logged and
+// reported as internal errors without their message.
+func (r *Router) fail(c *gin.Context, err error) {
+	for _, known := range knownErrors {
+		if errors.Is(err, known.err) {
+			r.problem(c, known.status, known.code, known.message)
+			return
+		}
+	}
+	r.log.WithContext(c).Errorf("request %s %s failed: %v", c.Request.Method, c.Request.URL.Path, err)
+	r.problem(c, http.StatusInternalServerError, CodeInternal, "internal error")
+}
+
+// badRequest reports a request that could not be parsed, with the offending fields when known.
+func (r *Router) badRequest(c *gin.Context, err error) {
+	fields := fieldErrors(err)
+	if len(fields) > 0 {
+		r.problem(c, http.StatusBadRequest, CodeValidationFailed, "request validation failed", fields...)
+		return
+	}
+	r.problem(c, http.StatusBadRequest, CodeInvalidRequest, err.Error())
+}
+
+// invalidField reports a single invalid field.
+func (r *Router) invalidField(c *gin.Context, field, message string) {
+	r.problem(c, http.StatusBadRequest, CodeValidationFailed, "request validation failed", validation.FieldError{Field: field, Message: message})
+}
+
+// pathID parses the :id path parameter, writing the error response when it is not a number.
+func (r *Router) pathID(c *gin.Context) (int, bool) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		r.invalidField(c, "id", "must be an integer")
+		return 0, false
+	}
+	return id, true
+}
+
+func fieldErrors(err error) []validation.FieldError {
+	if fields := validation.Errors(err); fields != nil {
+		return fields
+	}
+	var typeErr *json.UnmarshalTypeError
+	if errors.As(err, &typeErr) {
+		return []validation.FieldError{{Field: typeErr.Field, Message: "must be " + typeErr.Type.String()}}
+	}
+	var multi openapi3.MultiError
+	if errors.As(err, &multi) {
+		var fields []validation.FieldError
+		for _, e := range multi {
+			fields = append(fields, fieldErrors(e)...)
+		}
+		return fields
+	}
+	var reqErr *openapi3filter.RequestError
+	if errors.As(err, &reqErr) {
+		var schemaErr *openapi3.SchemaError
+		switch {
+		case reqErr.Parameter != nil:
+			return []validation.FieldError{{Field: reqErr.Parameter.Name, Message: reasonOf(reqErr.Err, reqErr.Reason)}}
+		case errors.As(reqErr.Err, &schemaErr):
+			return []validation.FieldError{{Field: strings.Join(schemaErr.JSONPointer(), "."), Message: schemaErr.Reason}}
+		}
+	}
+	return nil
+}
+
+func reasonOf(err error, reason string) string {
+	var schemaErr *openapi3.SchemaError
+	if errors.As(err, &schemaErr) {
+		return schemaErr.Reason
+	}
+	if reason != "" {
+		return reason
+	}
+	if err != nil {
+		return err.Error()
+	}
+	return "invalid value"
+}
+
+// problemRender writes the problem with the problem+json content type, which c.JSON would override.
+type problemRender struct {
+	problem Problem
+}
+
+func (p problemRender) Render(w http.ResponseWriter) error {
+	p.WriteContentType(w)
+	return json.NewEncoder(w).Encode(p.problem)
+}
+
+func (p problemRender) WriteContentType(w http.ResponseWriter) {
+	w.Header().Set("Content-Type", problemContentType)
+}
@@ -0,0 +1,38 @@
+package rest
+
+import (
+	"net/http"
+	"sync/atomic"
+
+	"EWallet/pkg/health"
+
+	"github.com/gin-gonic/gin"
+)
+
+// SetHealth sets the dependency checks of /readyz, without them the service is ready while it serves.
+func (r *Router) SetHealth(h *health.Checker) {
+	r.health = h
+}
+
+// healthz reports the process is alive, it does not look at the dependencies.
+func (r *Router) healthz(c *gin.Context) {
+	c.JSON(http.StatusOK, statusOK)
+}
+
+// readyz reports whether the service takes traffic: 200 when ready or degraded, 503 when a critical
+// dependency is down or the server is shutting down.
+func (r *Router) readyz(c *gin.Context) {
+	if atomic.LoadInt32(&r.draining) == 1 {
+		c.JSON(http.StatusServiceUnavailable, health.Report{Status: health.StatusShuttingDown, Checks: map[string]health.Result{}})
+		return
+	}
+	report := health.Report{Status: health.StatusReady, Checks: map[string]health.Result{}}
+	if r.health != nil {
+		report = r.health.Check(c)
+	}
+	status := http.StatusOK
+	if report.Status == health.StatusNotReady {
+		status = http.StatusServiceUnavailable
+	}
+	c.JSON(status, report)
+}
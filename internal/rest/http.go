@@ -3,24 +3,65 @@ package rest
 import (
 	"context"
 	"errors"
+	"fmt"
+	"io"
 	"net/http"
 	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
 
+	"EWallet/pkg/events"
+	"EWallet/pkg/export"
+	"EWallet/pkg/health"
 	"EWallet/pkg/models"
 
 	"EWallet/pkg/repository"
+	"EWallet/pkg/screening"
+	"EWallet/pkg/tracing"
+	"EWallet/pkg/validation"
 
+	"github.com/getkin/kin-openapi/routers"
+	"github.com/getkin/kin-openapi/routers/gorillamux"
 	"github.com/gin-gonic/gin"
 	"github.com/sirupsen/logrus"
-
-	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"go.opentelemetry.io/contrib/instrumentation/github.com/gin-gonic/gin/otelgin"
 )
 
 type Router struct {
-	log    *logrus.Entry
-	router *gin.Engine
-	app    App
-	secret []byte
+	log      *logrus.Entry
+	router   *gin.Engine
+	app      App
+	secret   []byte
+	admins   map[string]bool
+	spec     routers.Router
+	timeouts ServerTimeouts
+	health   *health.Checker
+	// draining is set once the server is stopping, /readyz then reports not ready.
+	draining int32
+	// closing is closed when the server starts shutting down, long-lived streams end on it.
+	closing   chan struct{}
+	closeOnce sync.Once
+}
+
+// ServerTimeouts configure the HTTP server. Write is zero by default: event streams and exports are
+// long responses, slow clients are cut off by Idle and the read timeouts instead. Shutdown is how long
+// in-flight requests are waited for when the server stops. ShutdownDelay keeps serving with /readyz
+// failing before that, so load balancers stop sending requests first.
+type ServerTimeouts struct {
+	ReadHeader    time.Duration
+	Read          time.Duration
+	Write         time.Duration
+	Idle          time.Duration
+	Shutdown      time.Duration
+	ShutdownDelay time.Duration
+}
+
+var DefaultServerTimeouts = ServerTimeouts{
+	ReadHeader: 5 * time.Second,
+	Read:       time.Minute,
+	Idle:       2 * time.Minute,
+	Shutdown:   30 * time.Second,
 }
 
 type App interface {
@@ -32,302 +73,367 @@ type App interface {
 	Deposit(ctx context.Context, id int, request *repository.FinRequest) error
 	Withdrawal(ctx context.Context, id int, request *repository.FinRequest) error
 	Transfer(ctx context.Context, id int, request *repository.FinRequest) error
-	GetTransactions(ctx context.Context, id int, params *models.TransactionQueryParams) ([]repository.Transaction, error)
+	GetTransactions(ctx context.Context, id int, params *models.TransactionQueryParams) (repository.TransactionPage, error)
+	GetStatement(ctx context.Context, id int, from, to time.Time) (repository.Statement, error)
+	WalkStatement(ctx context.Context, id int, from, to time.Time, begin func(repository.Statement) error, entry func(repository.StatementEntry) error) error
+	NewStatementWriter(format string, w io.Writer, columns []string) (export.Writer, error)
+	GetStatementDocuments(ctx context.Context, id int) ([]repository.StatementDocument, error)
+	GetStatementDocument(ctx context.Context, id int, month time.Time) (repository.StatementDocument, error)
 	Freeze(ctx context.Context, id int) error
+	GetReviews(ctx context.Context, status string) ([]repository.Review, error)
+	ApproveReview(ctx context.Context, id int, reviewer string) error
+	RejectReview(ctx context.Context, id int, reviewer string) error
+	GetTransactionStatuses(ctx context.Context, id int) ([]repository.TransactionStatus, error)
+	EnqueueImport(ctx context.Context, username, format string, data []byte, dryRun bool) (repository.Job, error)
+	EnqueueExport(ctx context.Context, username string, id int, format string, from, to time.Time, columns []string) (repository.Job, error)
+	GetJob(ctx context.Context, id int64) (repository.Job, error)
+	GetJobs(ctx context.Context, status, jobType string, limit int) ([]repository.Job, error)
+	GetJobOutput(ctx context.Context, id int64) ([]byte, string, error)
+	CreatePayout(ctx context.Context, username string, id int, request repository.PayoutRequest) (repository.Payout, error)
+	GetPayout(ctx context.Context, id int) (repository.Payout, error)
+	ReverseTransaction(ctx context.Context, id int, reason string) error
+	BeginIdempotent(ctx context.Context, scope repository.IdempotencyScope, key, fingerprint string) (*repository.IdempotencyKey, string, error)
+	FinishIdempotent(ctx context.Context, scope repository.IdempotencyScope, key, token string, statusCode int, response []byte) error
+	CreateWebhook(ctx context.Context, sub repository.WebhookSubscription) (repository.WebhookSubscription, error)
+	GetWebhooks(ctx context.Context, username string) ([]repository.WebhookSubscription, error)
+	DeleteWebhook(ctx context.Context, username string, id int) error
+	TestWebhook(ctx context.Context, username string, id int) (repository.WebhookDelivery, error)
+	GetWebhookDeliveries(ctx context.Context, username string, id int, status string) ([]repository.WebhookDelivery, error)
+	GetWebhookAttempts(ctx context.Context, username string, deliveryID int) ([]repository.WebhookAttempt, error)
+	RedeliverWebhook(ctx context.Context, username string, deliveryID int) (repository.WebhookDelivery, error)
+	SubscribeWallet(id int) *events.Subscription
+	UnsubscribeWallet(sub *events.Subscription)
+	GetWalletEvents(ctx context.Context, id int, afterID int64, limit int) ([]events.Event, error)
 }
 
-func NewRouter(log *logrus.Logger, app App, secret string) *Router {
+// NewRouter creates the router. Users listed in admins get access to the /api/v1/admin routes.
+func NewRouter(log *logrus.Logger, app App, secret string, admins ...string) *Router {
+	validation.Register()
 	r := &Router{
-		log:    log.WithField("component", "router"),
-		router: gin.Default(),
-		app:    app,
-		secret: []byte(secret),
+		log:      log.WithField("component", "router"),
+		router:   gin.New(),
+		app:      app,
+		secret:   []byte(secret),
+		admins:   make(map[string]bool, len(admins)),
+		timeouts: DefaultServerTimeouts,
+		closing:  make(chan struct{}),
+	}
+	for _, admin := range admins {
+		if admin != "" {
+			r.admins[admin] = true
+		}
 	}
+	// App calls get the request context through the gin context, with its span, request ID and cancellation.
+	r.router.ContextWithFallback = true
+	r.router.Use(
+		otelgin.Middleware(tracing.ServiceName, otelgin.WithFilter(traced)),
+		r.requestContext(),
+		r.accessLog(),
+		r.instrument(),
+		gin.CustomRecoveryWithWriter(io.Discard, r.recovery),
+	)
+	spec, err := LoadSpec()
+	if err != nil {
+		r.log.Panicf("err creating the router: %v", err)
+	}
+	if r.spec, err = gorillamux.NewRouter(spec); err != nil {
+		r.log.Panicf("err creating the router: %v", err)
+	}
+	r.router.NoRoute(func(c *gin.Context) {
+		r.problem(c, http.StatusNotFound, CodeNotFound, "route not found")
+	})
 	r.router.GET("/metrics", prometheusHandler())
-	r.router.POST("/auth", r.authHandler)
-	g := r.router.Group("/api/v1").Use(r.jwtAuth())
+	r.router.GET("/healthz", r.healthz)
+	r.router.GET("/readyz", r.readyz)
+	r.router.GET("/openapi.yaml", r.openAPISpec)
+	r.router.GET("/docs", r.docs)
+	r.router.GET("/docs/:file", r.docsAsset)
+	r.router.POST("/auth", r.validate(), r.authHandler)
+	g := r.router.Group("/api/v1").Use(r.jwtAuth(), r.validate())
 	g.GET("/wallet/:id", r.getWallet)
 	g.GET("/wallet/:id/transactions", r.transaction)
-	g.POST("/wallet", r.addWallet)
+	g.GET("/wallet/:id/statement", r.statement)
+	g.GET("/wallet/:id/export", r.exportStatement)
+	g.POST("/wallet/:id/exports", r.createExport)
+	g.GET("/wallet/:id/statements", r.statementDocuments)
+	g.GET("/wallet/:id/statements/:month", r.statementDocument)
+	g.GET("/wallet/:id/stream", r.streamWallet)
+	g.POST("/wallet", r.idempotent("create_wallet"), r.addWallet)
 	g.DELETE("/wallet/:id", r.deleteWallet)
-	g.PUT("/wallet/:id", r.updateWallet)
-	g.PUT("/wallet/freeze/:id")
-	g.PUT("/wallet/:id/deposit", r.deposit)
-	g.PUT("/wallet/:id/withdraw", r.withdrawal)
-	g.PUT("/wallet/:id/transfer", r.transfer)
+	g.PUT("/wallet/:id", r.idempotent("update_wallet"), r.updateWallet)
+	g.PUT("/wallet/freeze/:id", r.freezeWallet)
+	g.PUT("/wallet/:id/deposit", r.idempotent("deposit"), r.deposit)
+	g.PUT("/wallet/:id/withdraw", r.idempotent("withdraw"), r.withdrawal)
+	g.PUT("/wallet/:id/transfer", r.idempotent("transfer"), r.transfer)
+	g.POST("/wallet/:id/payouts", r.idempotent("payout"), r.createPayout)
+	g.GET("/payouts/:id", r.getPayout)
+	g.GET("/transactions/:id/statuses", r.transactionStatuses)
+	g.GET("/jobs/:id", r.getJob)
+	g.GET("/jobs/:id/output", r.jobOutput)
+	g.POST("/webhooks", r.addWebhook)
+	g.GET("/webhooks", r.getWebhooks)
+	g.DELETE("/webhooks/:id", r.deleteWebhook)
+	g.POST("/webhooks/:id/test", r.testWebhook)
+	g.GET("/webhooks/:id/deliveries", r.webhookDeliveries)
+	g.GET("/webhooks/deliveries/:id/attempts", r.webhookAttempts)
+	g.POST("/webhooks/deliveries/:id/redeliver", r.redeliverWebhook)
+	a := r.router.Group("/api/v1/admin").Use(r.jwtAuth(), r.adminAuth(), r.validate())
+	a.GET("/reviews", r.getReviews)
+	a.PUT("/reviews/:id/approve", r.approveReview)
+	a.PUT("/reviews/:id/reject", r.rejectReview)
+	a.PUT("/transactions/:id/reverse", r.reverseTransaction)
+	a.POST("/import", r.importTransactions)
+	a.GET("/jobs", r.getJobs)
+	for _, route := range undocumentedRoutes(spec, r.router.Routes()) {
+		r.log.Warnf("route %s is missing from the openapi spec, its requests are not validated", route)
+	}
 	return r
 }
 
-func prometheusHandler() gin.HandlerFunc {
-	h := promhttp.Handler()
-	return func(c *gin.Context) {
-		h.ServeHTTP(c.Writer, c.Request)
-	}
+// Routes lists the registered routes.
+func (r *Router) Routes() gin.RoutesInfo {
+	return r.router.Routes()
 }
 
-func (r *Router) Run(_ context.Context, addr string) error {
-	return r.router.Run(addr)
+// SetServerTimeouts replaces DefaultServerTimeouts, it is called before Run.
+func (r *Router) SetServerTimeouts(t ServerTimeouts) {
+	r.timeouts = t
+}
+
+// Run serves HTTP on addr until ctx is done, then stops accepting connections and waits for the
+// in-flight requests up to the shutdown timeout. It returns nil once they are all done.
+func (r *Router) Run(ctx context.Context, addr string) error {
+	srv := &http.Server{
+		Addr:              addr,
+		Handler:           r.router,
+		ReadHeaderTimeout: r.timeouts.ReadHeader,
+		ReadTimeout:       r.timeouts.Read,
+		WriteTimeout:      r.timeouts.Write,
+		IdleTimeout:       r.timeouts.Idle,
+	}
+	srv.RegisterOnShutdown(func() {
+		r.closeOnce.Do(func() { close(r.closing) })
+	})
+	served := make(chan error, 1)
+	go func() {
+		served <- srv.ListenAndServe()
+	}()
+	select {
+	case err := <-served:
+		return err
+	case <-ctx.Done():
+	}
+	atomic.StoreInt32(&r.draining, 1)
+	if r.timeouts.ShutdownDelay > 0 {
+		r.log.Infof("reporting not ready for %s before draining", r.timeouts.ShutdownDelay)
+		time.Sleep(r.timeouts.ShutdownDelay)
+	}
+	r.log.Infof("draining http requests for up to %s", r.timeouts.Shutdown)
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), r.timeouts.Shutdown)
+	defer cancel()
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		_ = srv.Close()
+		return fmt.Errorf("err draining http requests: %w", err)
+	}
+	if err := <-served; !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
 }
 
 func (r *Router) addWallet(c *gin.Context) {
-	var input repository.Wallet
-	if err := c.BindJSON(&input); err != nil {
-		c.JSON(http.StatusBadRequest, err)
+	var input models.WalletRequest
+	if err := c.ShouldBindJSON(&input); err != nil {
+		r.badRequest(c, err)
 		return
 	}
-	id, err := r.app.CreateWallet(c, input)
+	id, err := r.app.CreateWallet(c, repository.Wallet{Owner: input.Owner, Balance: input.Balance})
 	if err != nil {
-		r.log.Errorf("failed to store date: %v", err)
-		c.JSON(http.StatusInternalServerError, err)
+		r.fail(c, err)
 		return
 	}
 	c.JSON(http.StatusCreated, gin.H{"id": id})
 }
 
 func (r *Router) getWallet(c *gin.Context) {
-	val := c.Param("id")
-	currency := c.Query("currency")
-	id, err := strconv.Atoi(val)
-	if err != nil {
-		c.JSON(http.StatusBadRequest, err)
+	id, ok := r.pathID(c)
+	if !ok {
 		return
 	}
-	w, err := r.app.GetWallet(c, id, currency)
-	switch {
-	case err == nil:
-	case errors.Is(err, repository.ErrWalletNotFound):
-		c.JSON(http.StatusNotFound, err)
-		return
-	default:
-		r.log.Errorf("failed to get Wallet: %v", err)
-		c.JSON(http.StatusInternalServerError, err)
+	w, err := r.app.GetWallet(c, id, c.Query("currency"))
+	if err != nil {
+		r.fail(c, err)
 		return
 	}
 	c.JSON(http.StatusOK, w)
 }
 
 func (r *Router) deleteWallet(c *gin.Context) {
-	val := c.Param("id")
-	id, err := strconv.Atoi(val)
-	if err != nil {
-		c.JSON(http.StatusBadRequest, err)
+	id, ok := r.pathID(c)
+	if !ok {
 		return
 	}
-	err = r.app.DeleteWallet(c, id)
-	switch {
-	case err == nil:
-	case errors.Is(err, repository.ErrWalletNotFound):
-		c.JSON(http.StatusNotFound, err)
-		return
-	default:
-		r.log.Errorf("failed to delete wallet %v: ", err)
-		c.JSON(http.StatusInternalServerError, err)
+	if err := r.app.DeleteWallet(c, id); err != nil {
+		r.fail(c, err)
 		return
 	}
-	c.JSON(http.StatusNoContent, "Ok")
+	c.Status(http.StatusNoContent)
 }
 
 func (r *Router) freezeWallet(c *gin.Context) {
-	val := c.Param("id")
-	id, err := strconv.Atoi(val)
-	if err != nil {
-		c.JSON(http.StatusBadRequest, err)
+	id, ok := r.pathID(c)
+	if !ok {
 		return
 	}
-	err = r.app.Freeze(c, id)
-	switch {
-	case err == nil:
-	case errors.Is(err, repository.ErrWalletNotFound):
-		c.JSON(http.StatusNotFound, err)
-		return
-	default:
-		r.log.Errorf("failed to freeze wallet %v: ", err)
-		c.JSON(http.StatusInternalServerError, err)
+	if err := r.app.Freeze(c, id); err != nil {
+		r.fail(c, err)
 		return
 	}
-	c.JSON(http.StatusNoContent, "Ok")
+	c.Status(http.StatusNoContent)
 }
 
 func (r *Router) updateWallet(c *gin.Context) {
-	val := c.Param("id")
-	id, err := strconv.Atoi(val)
-	if err != nil {
-		c.JSON(http.StatusBadRequest, err)
+	id, ok := r.pathID(c)
+	if !ok {
 		return
 	}
-	var wallet repository.Wallet
-	if err = c.BindJSON(&wallet); err != nil {
-		c.JSON(http.StatusBadRequest, err)
+	var input models.WalletRequest
+	if err := c.ShouldBindJSON(&input); err != nil {
+		r.badRequest(c, err)
 		return
 	}
-	wallet, err = r.app.UpdateWallet(c, id, wallet)
-	switch {
-	case err == nil:
-	case errors.Is(err, repository.ErrWalletNotFound):
-		c.JSON(http.StatusNotFound, err)
-		return
-	default:
-		r.log.Errorf("failed to update wallet: %v", err)
-		c.JSON(http.StatusInternalServerError, err)
+	wallet, err := r.app.UpdateWallet(c, id, repository.Wallet{Owner: input.Owner, Balance: input.Balance})
+	if err != nil {
+		r.fail(c, err)
 		return
 	}
 	c.JSON(http.StatusOK, wallet)
 }
 
 func (r *Router) deposit(c *gin.Context) {
-	val := c.Param("id")
-	id, err := strconv.Atoi(val)
-	if err != nil {
-		c.JSON(http.StatusBadRequest, err)
-		return
-	}
-	var input repository.FinRequest
-	err = c.BindJSON(&input)
-	if err != nil {
-		c.JSON(http.StatusBadRequest, err)
-		return
-	}
-	if !isValidUUID(input.UUID) {
-		c.JSON(http.StatusBadRequest, "incorrect format of uuid")
-		return
-	}
-	err = r.app.Deposit(c, id, &input)
-	switch {
-	case err == nil:
-	case errors.Is(err, repository.ErrDuplicateKey):
-		c.JSON(http.StatusConflict, err)
-		return
-	case errors.Is(err, repository.ErrWalletNotFound):
-		c.JSON(http.StatusNotFound, err)
-		return
-	default:
-		r.log.Errorf("failed to deposit wallet: %v", err)
-		c.JSON(http.StatusInternalServerError, err)
-		return
-	}
-	c.JSON(http.StatusOK, "Ok")
+	r.operation(c, "deposit", r.app.Deposit)
 }
 
 func (r *Router) withdrawal(c *gin.Context) {
-	val := c.Param("id")
-	id, err := strconv.Atoi(val)
-	if err != nil {
-		c.JSON(http.StatusBadRequest, err)
+	r.operation(c, "withdraw", r.app.Withdrawal)
+}
+
+func (r *Router) transfer(c *gin.Context) {
+	r.operation(c, "transfer", r.app.Transfer)
+}
+
+// operation runs a money movement. Operations held for review are reported with 202.
+func (r *Router) operation(c *gin.Context, operation string, fn func(ctx context.Context, id int, request *repository.FinRequest) error) {
+	id, ok := r.pathID(c)
+	if !ok {
 		return
 	}
-	var input repository.FinRequest
-	err = c.BindJSON(&input)
-	if err != nil {
-		c.JSON(http.StatusBadRequest, err)
+	var input models.OperationRequest
+	if err := c.ShouldBindJSON(&input); err != nil {
+		r.badRequest(c, err)
+		return
+	}
+	if fields := validation.ValidateOperation(operation, id, &input); len(fields) > 0 {
+		r.problem(c, http.StatusBadRequest, CodeValidationFailed, "request validation failed", fields...)
 		return
 	}
+	input.UUID = requestUUID(c, input.UUID)
 	if !isValidUUID(input.UUID) {
-		c.JSON(http.StatusBadRequest, "incorrect format of uuid")
+		r.invalidField(c, "uuid", "incorrect format of uuid")
 		return
 	}
-	err = r.app.Withdrawal(c, id, &input)
+	err := fn(c, id, &repository.FinRequest{Sum: input.Sum, WalletTarget: input.WalletTarget, UUID: input.UUID})
 	switch {
 	case err == nil:
-	case errors.Is(err, repository.ErrDuplicateKey):
-		c.JSON(http.StatusConflict, err)
-		return
-	case errors.Is(err, repository.ErrInsufficientFunds):
-		c.JSON(http.StatusBadRequest, err)
-		return
-	case errors.Is(err, repository.ErrWalletNotFound):
-		c.JSON(http.StatusNotFound, repository.ErrWalletNotFound)
+	case errors.Is(err, screening.ErrPendingReview):
+		c.JSON(http.StatusAccepted, statusPendingReview)
 		return
 	default:
-		r.log.Errorf("failed to withdraw from wallet: %v", err)
-		c.JSON(http.StatusInternalServerError, err)
-		return
-	}
-	c.JSON(http.StatusOK, "Ok")
-}
-
-func (r *Router) transfer(c *gin.Context) {
-	val := c.Param("id")
-	id, err := strconv.Atoi(val)
-	if err != nil {
-		c.JSON(http.StatusBadRequest, err)
-		return
-	}
-	var input repository.FinRequest
-	err = c.BindJSON(&input)
-	if err != nil || input.Sum <= 0 {
-		c.JSON(http.StatusBadRequest, err)
+		r.fail(c, err)
 		return
 	}
-	if !isValidUUID(input.UUID) {
-		c.JSON(http.StatusBadRequest, "incorrect format of uuid")
-		return
-	}
-	if err = r.app.Transfer(c, id, &input); err != nil {
-		switch {
-		case err == nil:
-		case errors.Is(err, repository.ErrDuplicateKey):
-			c.JSON(http.StatusConflict, err)
-			return
-		case errors.Is(err, repository.ErrInsufficientFunds):
-			c.JSON(http.StatusBadRequest, err)
-			return
-		case errors.Is(err, repository.ErrWalletNotFound):
-			c.JSON(http.StatusNotFound, repository.ErrWalletNotFound)
-			return
-		default:
-			r.log.Errorf("failed to transfer money: %v", err)
-			c.JSON(http.StatusInternalServerError, err)
-			return
-		}
-	}
-	c.JSON(http.StatusOK, "Success transferring")
+	c.JSON(http.StatusOK, statusCompleted)
 }
 
 func (r *Router) transaction(c *gin.Context) {
-	val := c.Param("id")
-	id, err := strconv.Atoi(val)
-	if err != nil {
-		c.JSON(http.StatusBadRequest, err)
+	id, ok := r.pathID(c)
+	if !ok {
 		return
 	}
 	params := models.TransactionQueryParams{}
-	if err = getRequestParams(c, &params); err != nil {
+	if err := r.getRequestParams(c, &params); err != nil {
 		return
 	}
 	trans, err := r.app.GetTransactions(c, id, &params)
-	switch {
-	case err == nil:
-	case errors.Is(err, repository.ErrWalletNotFound):
-		c.JSON(http.StatusNotFound, err)
-		return
-	default:
-		r.log.Errorf("failed to get Transactions: %v", err)
-		c.JSON(http.StatusInternalServerError, err)
+	if err != nil {
+		r.fail(c, err)
 		return
 	}
 	c.JSON(http.StatusOK, trans)
 }
 
-func getRequestParams(c *gin.Context, params *models.TransactionQueryParams) error {
+// getRequestParams parses the query of the transaction history. Dates are RFC 3339 timestamps or
+// plain dates (2006-01-02).
+func (r *Router) getRequestParams(c *gin.Context, params *models.TransactionQueryParams) error {
 	params.Sort = c.Query("sort")
-	var err error
-	val := c.Query("limit")
-	params.Limit, err = strconv.Atoi(val)
-	if err != nil && val != "" {
-		c.JSON(http.StatusBadRequest, err)
-		return err
+	params.Cursor = c.Query("cursor")
+	params.Status = c.Query("status")
+	params.Operation = c.Query("operation")
+	params.Direction = c.Query("direction")
+	var fields []validation.FieldError
+	parse := func(name string, fn func(val string) error, message string) {
+		if val := c.Query(name); val != "" && fn(val) != nil {
+			fields = append(fields, validation.FieldError{Field: name, Message: message})
+		}
 	}
-	val = c.Query("offset")
-	params.Offset, err = strconv.Atoi(val)
-	if err != nil && val != "" {
-		c.JSON(http.StatusBadRequest, err)
-		return err
+	// Offset paging was replaced by cursors, a client still sending it would get the first page every time.
+	if _, ok := c.GetQuery("offset"); ok {
+		fields = append(fields, validation.FieldError{Field: "offset", Message: "is not supported, use cursor from the previous page"})
 	}
-	val = c.Query("desc")
-	params.Desc, err = strconv.ParseBool(val)
-	if err != nil && val != "" {
-		c.JSON(http.StatusBadRequest, err)
+	parse("limit", func(val string) (err error) {
+		params.Limit, err = strconv.Atoi(val)
+		return err
+	}, "must be an integer")
+	parse("desc", func(val string) (err error) {
+		params.Desc, err = strconv.ParseBool(val)
+		return err
+	}, "must be a boolean")
+	parse("from", func(val string) (err error) {
+		params.From, err = parseTime(val)
+		return err
+	}, "must be a date or an RFC 3339 timestamp")
+	parse("to", func(val string) (err error) {
+		params.To, err = parseTime(val)
+		return err
+	}, "must be a date or an RFC 3339 timestamp")
+	parse("min_sum", func(val string) (err error) {
+		params.MinSum, err = strconv.ParseFloat(val, 64)
+		return err
+	}, "must be a number")
+	parse("max_sum", func(val string) (err error) {
+		params.MaxSum, err = strconv.ParseFloat(val, 64)
+		return err
+	}, "must be a number")
+	parse("counterparty", func(val string) (err error) {
+		params.Counterparty, err = strconv.Atoi(val)
 		return err
+	}, "must be an integer")
+	if len(fields) == 0 {
+		fields = validation.Validate(params)
+	}
+	if len(fields) > 0 {
+		r.problem(c, http.StatusBadRequest, CodeValidationFailed, "request validation failed", fields...)
+		return fmt.Errorf("invalid query params")
 	}
 	return nil
 }
+
+func parseTime(val string) (*time.Time, error) {
+	t, err := time.Parse(time.RFC3339, val)
+	if err != nil {
+		if t, err = time.Parse("2006-01-02", val); err != nil {
+			return nil, err
+		}
+	}
+	return &t, nil
+}
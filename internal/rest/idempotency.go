@@ -0,0 +1,119 @@
+package rest
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"strconv"
+
+	"EWallet/pkg/ctxutil"
+	"EWallet/pkg/repository"
+
+	"github.com/gin-gonic/gin"
+)
+
+const idempotencyHeader = "Idempotency-Key"
+
+type responseRecorder struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+}
+
+func (w *responseRecorder) Write(b []byte) (int, error) {
+	w.body.Write(b)
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *responseRecorder) WriteString(s string) (int, error) {
+	w.body.WriteString(s)
+	return w.ResponseWriter.WriteString(s)
+}
+
+// idempotent replays the stored response when a request is retried with the same idempotency key.
+// The key is taken from the Idempotency-Key header or the uuid field of the body and is passed
+// to the handler under uuidKey. Keys are scoped by the caller, the operation and the wallet.
+func (r *Router) idempotent(operation string) func(c *gin.Context) {
+	return func(c *gin.Context) {
+		body, err := io.ReadAll(c.Request.Body)
+		if err != nil {
+			r.badRequest(c, err)
+			c.Abort()
+			return
+		}
+		c.Request.Body = io.NopCloser(bytes.NewReader(body))
+		var input struct {
+			UUID string `json:"uuid"`
+		}
+		_ = json.Unmarshal(body, &input)
+		key := c.GetHeader(idempotencyHeader)
+		switch {
+		case key == "":
+			key = input.UUID
+		case input.UUID != "" && input.UUID != key:
+			r.invalidField(c, "uuid", "does not match "+idempotencyHeader)
+			c.Abort()
+			return
+		}
+		if key == "" {
+			c.Next()
+			return
+		}
+		c.Set(uuidKey, key)
+		scope := repository.IdempotencyScope{
+			Username:  r.GetUserSession(c).Username,
+			Operation: operation,
+		}
+		if val := c.Param("id"); val != "" {
+			if scope.WalletID, err = strconv.Atoi(val); err != nil {
+				r.invalidField(c, "id", "must be an integer")
+				c.Abort()
+				return
+			}
+		}
+		fingerprint := requestFingerprint(c.Request.Method, c.Request.URL.Path, body)
+		record, token, err := r.app.BeginIdempotent(c, scope, key, fingerprint)
+		if err != nil {
+			r.fail(c, err)
+			c.Abort()
+			return
+		}
+		if record != nil {
+			c.Header("Idempotent-Replayed", "true")
+			c.Data(*record.StatusCode, "application/json; charset=utf-8", record.Response)
+			c.Abort()
+			return
+		}
+		recorder := &responseRecorder{ResponseWriter: c.Writer}
+		c.Writer = recorder
+		c.Next()
+		// The key is settled even when the client is gone, or it stays reserved until it expires.
+		if err = r.app.FinishIdempotent(ctxutil.Detach(c), scope, key, token, recorder.Status(), recorder.body.Bytes()); err != nil {
+			r.log.WithContext(c).Errorf("failed to finish idempotent request: %v", err)
+		}
+	}
+}
+
+// requestFingerprint hashes the request with the body in canonical JSON form,
+// so retries differing only in formatting are treated as identical.
+func requestFingerprint(method, path string, body []byte) string {
+	var canonical interface{}
+	if err := json.Unmarshal(body, &canonical); err == nil {
+		if b, err := json.Marshal(canonical); err == nil {
+			body = b
+		}
+	}
+	h := sha256.New()
+	h.Write([]byte(method + " " + path + "\n"))
+	h.Write(body)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// requestUUID returns the idempotency key resolved by the idempotent middleware.
+func requestUUID(c *gin.Context, uuid string) string {
+	if uuid != "" {
+		return uuid
+	}
+	return c.GetString(uuidKey)
+}
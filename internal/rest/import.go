@@ -0,0 +1,51 @@
+package rest
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"strconv"
+
+	"EWallet/pkg/importer"
+
+	"github.com/gin-gonic/gin"
+)
+
+// maxImportSize is the largest file accepted by the import endpoint, larger files go through the CLI.
+const maxImportSize = 32 << 20
+
+// importTransactions queues the import of the uploaded CSV (text/csv) or JSON Lines
+// (application/x-ndjson) file, ?dry_run=true only checks the rows. The report is the result of the job.
+func (r *Router) importTransactions(c *gin.Context) {
+	format, err := importer.FormatOf(c.ContentType())
+	if err != nil {
+		r.badRequest(c, err)
+		return
+	}
+	dryRun := false
+	if val := c.Query("dry_run"); val != "" {
+		if dryRun, err = strconv.ParseBool(val); err != nil {
+			r.invalidField(c, "dry_run", "must be a boolean")
+			return
+		}
+	}
+	data, err := io.ReadAll(io.LimitReader(c.Request.Body, maxImportSize+1))
+	if err != nil {
+		r.badRequest(c, err)
+		return
+	}
+	if len(data) > maxImportSize {
+		r.problem(c, http.StatusRequestEntityTooLarge, CodeInvalidRequest, "file is larger than 32 MiB, use the import command")
+		return
+	}
+	if _, err = importer.NewReader(format, bytes.NewReader(data)); err != nil {
+		r.badRequest(c, err)
+		return
+	}
+	job, err := r.app.EnqueueImport(c, r.GetUserSession(c).Username, format, data, dryRun)
+	if err != nil {
+		r.fail(c, err)
+		return
+	}
+	r.accepted(c, job)
+}
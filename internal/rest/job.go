@@ -0,0 +1,75 @@
+package rest
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"EWallet/pkg/repository"
+
+	"github.com/gin-gonic/gin"
+)
+
+const maxJobs = 100
+
+// accepted responds with the queued job, the Location header points to its status.
+func (r *Router) accepted(c *gin.Context, job repository.Job) {
+	c.Header("Location", "/api/v1/jobs/"+strconv.FormatInt(job.Id, 10))
+	c.JSON(http.StatusAccepted, job)
+}
+
+// userJob returns the job of the :id path parameter. Users see their own jobs, admins every job.
+func (r *Router) userJob(c *gin.Context) (repository.Job, bool) {
+	id, ok := r.pathID(c)
+	if !ok {
+		return repository.Job{}, false
+	}
+	job, err := r.app.GetJob(c, int64(id))
+	if err != nil {
+		r.fail(c, err)
+		return repository.Job{}, false
+	}
+	if username := r.GetUserSession(c).Username; job.Username != username && !r.admins[username] {
+		r.fail(c, repository.ErrJobNotFound)
+		return repository.Job{}, false
+	}
+	return job, true
+}
+
+func (r *Router) getJob(c *gin.Context) {
+	job, ok := r.userJob(c)
+	if !ok {
+		return
+	}
+	c.JSON(http.StatusOK, job)
+}
+
+// jobOutput downloads the file produced by a succeeded job.
+func (r *Router) jobOutput(c *gin.Context) {
+	job, ok := r.userJob(c)
+	if !ok {
+		return
+	}
+	output, contentType, err := r.app.GetJobOutput(c, job.Id)
+	if err != nil {
+		r.fail(c, err)
+		return
+	}
+	var result struct {
+		Filename string `json:"filename"`
+	}
+	if json.Unmarshal(job.Result, &result) == nil && result.Filename != "" {
+		c.Header("Content-Disposition", `attachment; filename="`+result.Filename+`"`)
+	}
+	c.Data(http.StatusOK, contentType, output)
+}
+
+// getJobs lists the latest jobs, ?status= and ?type= filter them.
+func (r *Router) getJobs(c *gin.Context) {
+	list, err := r.app.GetJobs(c, c.Query("status"), c.Query("type"), maxJobs)
+	if err != nil {
+		r.fail(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, list)
+}
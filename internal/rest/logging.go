@@ -0,0 +1,77 @@
+package rest
+
+import (
+	"net/http"
+	"runtime/debug"
+	"strings"
+	"time"
+
+	"EWallet/pkg/logger"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// requestContext tags the request with its ID, see requestID. The context of the request carries it
+// to the log lines of the App and the store and to the span of the request.
+func (r *Router) requestContext() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := requestID(c)
+		trace.SpanFromContext(c.Request.Context()).SetAttributes(attribute.String("request.id", id))
+		c.Request = c.Request.WithContext(logger.WithRequestID(c.Request.Context(), id))
+		c.Next()
+	}
+}
+
+// traced leaves the probes and the metrics scrapes out of the traces.
+func traced(req *http.Request) bool {
+	switch req.URL.Path {
+	case "/healthz", "/readyz", "/metrics":
+		return false
+	}
+	return true
+}
+
+// accessLog logs every request once it is done. Probes and metrics scrapes are logged at debug level.
+func (r *Router) accessLog() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		started := time.Now()
+		c.Next()
+		status := c.Writer.Status()
+		fields := logrus.Fields{
+			"method":     c.Request.Method,
+			"route":      c.FullPath(),
+			"path":       c.Request.URL.Path,
+			"status":     status,
+			"latency_ms": float64(time.Since(started).Microseconds()) / 1000,
+			"bytes":      c.Writer.Size(),
+			"client_ip":  c.ClientIP(),
+		}
+		if u, ok := c.Get(sessionKey); ok {
+			if us, ok := u.(*UserSession); ok {
+				fields["user"] = us.Username
+			}
+		}
+		if strings.HasPrefix(c.FullPath(), "/api/v1/wallet/:id") {
+			fields["wallet_id"] = c.Param("id")
+		}
+		entry := r.log.WithContext(c).WithFields(fields)
+		switch {
+		case status >= http.StatusInternalServerError:
+			entry.Error("request failed")
+		case c.FullPath() == "/healthz" || c.FullPath() == "/readyz" || c.FullPath() == "/metrics":
+			entry.Debug("request")
+		default:
+			entry.Info("request")
+		}
+	}
+}
+
+// recovery answers the requests whose handler panicked with an internal error.
+func (r *Router) recovery(c *gin.Context, err interface{}) {
+	r.log.WithContext(c).Errorf("request %s %s panicked: %v\n%s", c.Request.Method, c.Request.URL.Path, err, debug.Stack())
+	r.problem(c, http.StatusInternalServerError, CodeInternal, "internal error")
+	c.Abort()
+}
@@ -0,0 +1,37 @@
+package rest
+
+import (
+	"strconv"
+	"time"
+
+	"EWallet/pkg/metrics"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// instrument counts the requests and their durations by route pattern, method and status. Requests
+// matching no route share one label, so scanners can't create a series per path.
+func (r *Router) instrument() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		started := time.Now()
+		metrics.MetricHTTPRequestsInFlight.Inc()
+		defer metrics.MetricHTTPRequestsInFlight.Dec()
+		c.Next()
+		route := c.FullPath()
+		if route == "" {
+			route = "unmatched"
+		}
+		status := strconv.Itoa(c.Writer.Status())
+		metrics.MetricHTTPRequests.WithLabelValues(route, c.Request.Method, status).Inc()
+		metrics.MetricHTTPRequestDuration.WithLabelValues(route, c.Request.Method, status).Observe(time.Since(started).Seconds())
+	}
+}
+
+// prometheusHandler serves the metrics of metrics.Registry.
+func prometheusHandler() gin.HandlerFunc {
+	h := promhttp.InstrumentMetricHandler(metrics.Registry, promhttp.HandlerFor(metrics.Registry, promhttp.HandlerOpts{}))
+	return func(c *gin.Context) {
+		h.ServeHTTP(c.Writer, c.Request)
+	}
+}
@@ -1,74 +1,49 @@
 package rest
 
 import (
-	"fmt"
 	"net/http"
 	"strings"
-	"time"
+
+	"EWallet/pkg/auth"
 
 	"github.com/gin-gonic/gin"
-	"github.com/golang-jwt/jwt"
 	"github.com/google/uuid"
 )
 
 const (
-	TokenExpireDuration = time.Hour * 1000000
-	sessionKey          = "session"
-	uuidKey             = "UUID"
+	sessionKey = "session"
+	uuidKey    = "UUID"
 )
 
-type MyClaims struct {
-	Username string `json:"username"`
-	jwt.StandardClaims
-}
-
 type UserSession struct {
 	Username string
 }
 
 func (r *Router) GenToken(username string) (string, error) {
-	c := MyClaims{
-		username,
-		jwt.StandardClaims{
-			ExpiresAt: time.Now().Add(TokenExpireDuration).Unix(),
-			Issuer:    "e-wallet",
-		},
-	}
-
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, c)
-	return token.SignedString(r.secret)
+	return auth.GenToken(r.secret, username)
 }
 
-func (r *Router) ParseToken(tokenString string) (*MyClaims, error) {
-	token, err := jwt.ParseWithClaims(tokenString, &MyClaims{}, func(token *jwt.Token) (i interface{}, err error) {
-		return r.secret, nil
-	})
-	if err != nil {
-		return nil, err
-	}
-	if claims, ok := token.Claims.(*MyClaims); ok && token.Valid {
-		return claims, nil
-	}
-	return nil, fmt.Errorf("invalid token: %w", err)
+func (r *Router) ParseToken(tokenString string) (*auth.Claims, error) {
+	return auth.ParseToken(r.secret, tokenString)
 }
 
 func (r *Router) jwtAuth() func(c *gin.Context) {
 	return func(c *gin.Context) {
 		authHeader := c.Request.Header.Get("Authorization")
 		if authHeader == "" {
-			c.JSON(http.StatusUnauthorized, "Unauthorized")
+			r.problem(c, http.StatusUnauthorized, CodeUnauthorized, "missing Authorization header")
 			c.Abort()
 			return
 		}
 		parts := strings.Split(authHeader, " ")
 		if !(len(parts) == 2 && parts[0] == "Bearer") {
-			c.JSON(http.StatusUnauthorized, "Unauthorized")
+			r.problem(c, http.StatusUnauthorized, CodeUnauthorized, "expected a Bearer token")
 			c.Abort()
 			return
 		}
 		claims, err := r.ParseToken(parts[1])
 		if err != nil {
-			c.JSON(http.StatusUnauthorized, err)
+			r.problem(c, http.StatusUnauthorized, CodeUnauthorized, "invalid token")
 			c.Abort()
 			return
 		}
@@ -83,12 +58,12 @@ func (r *Router) jwtAuth() func(c *gin.Context) {
 func (r *Router) GetUserSession(c *gin.Context) *UserSession {
 	u, ok := c.Get(sessionKey)
 	if !ok {
-		r.log.Errorf("session not found in context")
+		r.log.WithContext(c).Errorf("session not found in context")
 		return &UserSession{}
 	}
 	us, ok := u.(*UserSession)
 	if !ok {
-		r.log.Errorf("invalid session type")
+		r.log.WithContext(c).Errorf("invalid session type")
 		return &UserSession{}
 	}
 	return us
@@ -0,0 +1,127 @@
+package rest
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"regexp"
+
+	"EWallet/api"
+
+	"github.com/getkin/kin-openapi/openapi3"
+	"github.com/getkin/kin-openapi/openapi3filter"
+	"github.com/gin-gonic/gin"
+	swaggerFiles "github.com/swaggo/files/v2"
+)
+
+// ginParam matches the path parameters of gin routes, :id is {id} in the spec.
+var ginParam = regexp.MustCompile(`:(\w+)`)
+
+// streamExtension marks the operations whose responses are streamed or binary, they are not buffered
+// to be checked against the spec.
+const streamExtension = "x-stream"
+
+const docsPage = `<!DOCTYPE html>
+<html>
+<head>
+  <title>EWallet API</title>
+  <link rel="stylesheet" href="/docs/swagger-ui.css">
+</head>
+<body>
+<div id="swagger-ui"></div>
+<script src="/docs/swagger-ui-bundle.js"></script>
+<script>SwaggerUIBundle({url: "/openapi.yaml", dom_id: "#swagger-ui"});</script>
+</body>
+</html>`
+
+func init() {
+	// JSON Lines bodies of the import are checked as plain strings, the rows are validated by the importer.
+	openapi3filter.RegisterBodyDecoder("application/x-ndjson", openapi3filter.FileBodyDecoder)
+}
+
+// LoadSpec parses and validates the embedded OpenAPI specification.
+func LoadSpec() (*openapi3.T, error) {
+	spec, err := openapi3.NewLoader().LoadFromData(api.Spec)
+	if err != nil {
+		return nil, fmt.Errorf("err loading openapi spec: %w", err)
+	}
+	if err = spec.Validate(context.Background()); err != nil {
+		return nil, fmt.Errorf("err validating openapi spec: %w", err)
+	}
+	return spec, nil
+}
+
+// undocumentedRoutes lists the routes of the router missing from spec.
+func undocumentedRoutes(spec *openapi3.T, routes gin.RoutesInfo) []string {
+	var missing []string
+	for _, route := range routes {
+		path := ginParam.ReplaceAllString(route.Path, "{$1}")
+		if item := spec.Paths.Find(path); item == nil || item.GetOperation(route.Method) == nil {
+			missing = append(missing, route.Method+" "+route.Path)
+		}
+	}
+	return missing
+}
+
+// streamed reports whether the operation is marked with streamExtension.
+func streamed(op *openapi3.Operation) bool {
+	marked, _ := op.Extensions[streamExtension].(bool)
+	return marked
+}
+
+func (r *Router) openAPISpec(c *gin.Context) {
+	c.Data(http.StatusOK, "application/yaml", api.Spec)
+}
+
+func (r *Router) docs(c *gin.Context) {
+	c.Data(http.StatusOK, "text/html; charset=utf-8", []byte(docsPage))
+}
+
+// docsAsset serves the swagger-ui files embedded in the binary.
+func (r *Router) docsAsset(c *gin.Context) {
+	c.FileFromFS(c.Param("file"), http.FS(swaggerFiles.FS))
+}
+
+// validate rejects requests that do not match the OpenAPI spec with 400. Responses are checked
+// against the spec as well; a mismatch is logged since the response is already sent.
+func (r *Router) validate() func(c *gin.Context) {
+	return func(c *gin.Context) {
+		route, pathParams, err := r.spec.FindRoute(c.Request)
+		if err != nil {
+			// Routes missing from the spec are logged by NewRouter.
+			c.Next()
+			return
+		}
+		input := &openapi3filter.RequestValidationInput{
+			Request:    c.Request,
+			PathParams: pathParams,
+			Route:      route,
+			Options: &openapi3filter.Options{
+				AuthenticationFunc: openapi3filter.NoopAuthenticationFunc,
+			},
+		}
+		if err = openapi3filter.ValidateRequest(c, input); err != nil {
+			r.badRequest(c, err)
+			c.Abort()
+			return
+		}
+		if streamed(route.Operation) {
+			c.Next()
+			return
+		}
+		recorder := &responseRecorder{ResponseWriter: c.Writer}
+		c.Writer = recorder
+		c.Next()
+		c.Writer = recorder.ResponseWriter
+		output := &openapi3filter.ResponseValidationInput{
+			RequestValidationInput: input,
+			Status:                 recorder.Status(),
+			Header:                 recorder.Header(),
+			Options:                &openapi3filter.Options{IncludeResponseStatus: true},
+		}
+		output.SetBodyBytes(recorder.body.Bytes())
+		if err = openapi3filter.ValidateResponse(c, output); err != nil {
+			r.log.WithContext(c).Warnf("response of %s %s does not match the spec: %v", c.Request.Method, route.Path, err)
+		}
+	}
+}
@@ -0,0 +1,54 @@
+package rest
+
+import (
+	"net/http"
+	"strconv"
+
+	"EWallet/pkg/models"
+	"EWallet/pkg/repository"
+	"EWallet/pkg/validation"
+
+	"github.com/gin-gonic/gin"
+)
+
+// createPayout queues the payout of the items from the wallet. It responds with the payout still
+// processing, GET /payouts/{id} shows the outcome of every item once the job has run.
+func (r *Router) createPayout(c *gin.Context) {
+	id, ok := r.pathID(c)
+	if !ok {
+		return
+	}
+	var input models.PayoutRequest
+	if err := c.ShouldBindJSON(&input); err != nil {
+		r.badRequest(c, err)
+		return
+	}
+	if fields := validation.ValidatePayout(id, &input); len(fields) > 0 {
+		r.problem(c, http.StatusBadRequest, CodeValidationFailed, "request validation failed", fields...)
+		return
+	}
+	request := repository.PayoutRequest{Atomic: input.Atomic, Items: make([]repository.PayoutItem, len(input.Items))}
+	for i, item := range input.Items {
+		request.Items[i] = repository.PayoutItem{WalletTarget: item.WalletTarget, Sum: item.Sum, UUID: item.UUID}
+	}
+	payout, err := r.app.CreatePayout(c, r.GetUserSession(c).Username, id, request)
+	if err != nil {
+		r.fail(c, err)
+		return
+	}
+	c.Header("Location", "/api/v1/payouts/"+strconv.Itoa(payout.Id))
+	c.JSON(http.StatusAccepted, payout)
+}
+
+func (r *Router) getPayout(c *gin.Context) {
+	id, ok := r.pathID(c)
+	if !ok {
+		return
+	}
+	payout, err := r.app.GetPayout(c, id)
+	if err != nil {
+		r.fail(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, payout)
+}
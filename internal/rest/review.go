@@ -0,0 +1,37 @@
+package rest
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+func (r *Router) getReviews(c *gin.Context) {
+	reviews, err := r.app.GetReviews(c, c.Query("status"))
+	if err != nil {
+		r.fail(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, reviews)
+}
+
+func (r *Router) approveReview(c *gin.Context) {
+	r.resolveReview(c, r.app.ApproveReview)
+}
+
+func (r *Router) rejectReview(c *gin.Context) {
+	r.resolveReview(c, r.app.RejectReview)
+}
+
+func (r *Router) resolveReview(c *gin.Context, resolve func(ctx context.Context, id int, reviewer string) error) {
+	id, ok := r.pathID(c)
+	if !ok {
+		return
+	}
+	if err := resolve(c, id, r.GetUserSession(c).Username); err != nil {
+		r.fail(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, statusOK)
+}
@@ -0,0 +1,178 @@
+package rest
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"EWallet/pkg/export"
+	"EWallet/pkg/repository"
+
+	"github.com/gin-gonic/gin"
+)
+
+// statementSignatureHeader carries the signature of a stored statement.
+const statementSignatureHeader = "X-Statement-Signature"
+
+// statement returns the account statement for [from, to). from is required, to defaults to now.
+func (r *Router) statement(c *gin.Context) {
+	id, ok := r.pathID(c)
+	if !ok {
+		return
+	}
+	from, to, ok := r.statementPeriod(c, true)
+	if !ok {
+		return
+	}
+	statement, err := r.app.GetStatement(c, id, from, to)
+	if err != nil {
+		r.fail(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, statement)
+}
+
+// statementPeriod parses from and to. Without from the period starts with the wallet history,
+// unless fromRequired is set.
+func (r *Router) statementPeriod(c *gin.Context, fromRequired bool) (time.Time, time.Time, bool) {
+	from := &time.Time{}
+	if val := c.Query("from"); val != "" {
+		var err error
+		if from, err = parseTime(val); err != nil {
+			r.invalidField(c, "from", "must be a date or an RFC 3339 timestamp")
+			return time.Time{}, time.Time{}, false
+		}
+	} else if fromRequired {
+		r.invalidField(c, "from", "is required")
+		return time.Time{}, time.Time{}, false
+	}
+	to := time.Now()
+	if val := c.Query("to"); val != "" {
+		t, err := parseTime(val)
+		if err != nil {
+			r.invalidField(c, "to", "must be a date or an RFC 3339 timestamp")
+			return time.Time{}, time.Time{}, false
+		}
+		to = *t
+	}
+	if !to.After(*from) {
+		r.invalidField(c, "to", "must be after from")
+		return time.Time{}, time.Time{}, false
+	}
+	return *from, to, true
+}
+
+// exportStatement streams the statement as CSV, OFX, camt.053 or PDF. The format is taken from ?format=
+// or the Accept header, ?columns= selects the CSV columns.
+func (r *Router) exportStatement(c *gin.Context) {
+	id, ok := r.pathID(c)
+	if !ok {
+		return
+	}
+	from, to, ok := r.statementPeriod(c, false)
+	if !ok {
+		return
+	}
+	format, err := export.Negotiate(c.Query("format"), c.GetHeader("Accept"))
+	if err != nil {
+		r.invalidField(c, "format", "must be one of: csv, ofx, camt053, pdf")
+		return
+	}
+	var columns []string
+	if val := c.Query("columns"); val != "" {
+		columns = strings.Split(val, ",")
+	}
+	w, err := r.app.NewStatementWriter(format, c.Writer, columns)
+	if err != nil {
+		r.invalidField(c, "columns", "must be a comma separated list of: "+strings.Join(export.Columns, ", "))
+		return
+	}
+	started := false
+	err = r.app.WalkStatement(c, id, from, to, func(s repository.Statement) error {
+		started = true
+		c.Header("Content-Type", export.ContentType(format))
+		c.Header("Content-Disposition", `attachment; filename="`+export.Filename(format, id)+`"`)
+		c.Status(http.StatusOK)
+		return w.Begin(s)
+	}, w.Entry)
+	if err == nil {
+		err = w.End()
+	}
+	if err != nil {
+		if !started {
+			r.fail(c, err)
+			return
+		}
+		// The status is sent already, the client gets a truncated file.
+		r.log.WithContext(c).Errorf("failed to export statement of wallet %d: %v", id, err)
+	}
+}
+
+// createExport queues the export of the statement, for exports too large to stream. It takes the
+// parameters of exportStatement except the Accept header; the file is the output of the job.
+func (r *Router) createExport(c *gin.Context) {
+	id, ok := r.pathID(c)
+	if !ok {
+		return
+	}
+	from, to, ok := r.statementPeriod(c, false)
+	if !ok {
+		return
+	}
+	format, err := export.Negotiate(c.Query("format"), "")
+	if err != nil {
+		r.invalidField(c, "format", "must be one of: csv, ofx, camt053, pdf")
+		return
+	}
+	var columns []string
+	if val := c.Query("columns"); val != "" {
+		columns = strings.Split(val, ",")
+	}
+	if _, err = r.app.NewStatementWriter(format, io.Discard, columns); err != nil {
+		r.invalidField(c, "columns", "must be a comma separated list of: "+strings.Join(export.Columns, ", "))
+		return
+	}
+	job, err := r.app.EnqueueExport(c, r.GetUserSession(c).Username, id, format, from, to, columns)
+	if err != nil {
+		r.fail(c, err)
+		return
+	}
+	r.accepted(c, job)
+}
+
+// statementDocuments lists the monthly statements of the wallet.
+func (r *Router) statementDocuments(c *gin.Context) {
+	id, ok := r.pathID(c)
+	if !ok {
+		return
+	}
+	docs, err := r.app.GetStatementDocuments(c, id)
+	if err != nil {
+		r.fail(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, docs)
+}
+
+// statementDocument downloads the signed PDF statement of the month given as YYYY-MM.
+func (r *Router) statementDocument(c *gin.Context) {
+	id, ok := r.pathID(c)
+	if !ok {
+		return
+	}
+	month, err := time.Parse("2006-01", c.Param("month"))
+	if err != nil {
+		r.invalidField(c, "month", "must be a month like 2024-10")
+		return
+	}
+	doc, err := r.app.GetStatementDocument(c, id, month)
+	if err != nil {
+		r.fail(c, err)
+		return
+	}
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="wallet-%d-statement-%s.pdf"`, id, month.Format("2006-01")))
+	c.Header(statementSignatureHeader, doc.Signature)
+	c.Data(http.StatusOK, export.ContentType(export.FormatPDF), doc.Pdf)
+}
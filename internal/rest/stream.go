@@ -0,0 +1,138 @@
+package rest
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"EWallet/pkg/events"
+
+	"github.com/gin-contrib/sse"
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	replayBatch       = 100
+	keepaliveInterval = 15 * time.Second
+)
+
+type balanceUpdate struct {
+	WalletId int     `json:"wallet_id"`
+	Balance  float64 `json:"balance"`
+}
+
+// streamWallet pushes the wallet events as Server-Sent Events, followed by a "balance" event with
+// the current balance. Events missed since Last-Event-ID (or ?last_event_id) are replayed first.
+func (r *Router) streamWallet(c *gin.Context) {
+	id, ok := r.pathID(c)
+	if !ok {
+		return
+	}
+	lastID := c.GetHeader("Last-Event-ID")
+	if lastID == "" {
+		lastID = c.Query("last_event_id")
+	}
+	var after int64
+	if lastID != "" {
+		var err error
+		if after, err = strconv.ParseInt(lastID, 10, 64); err != nil {
+			r.invalidField(c, "last_event_id", "must be an integer")
+			return
+		}
+	}
+	if !r.authorizeWallet(c, id, "stream it") {
+		return
+	}
+
+	// Subscribe before the replay so that nothing committed in between is lost.
+	sub := r.app.SubscribeWallet(id)
+	defer r.app.UnsubscribeWallet(sub)
+	replayed := make(map[int64]bool)
+	var backlog []events.Event
+	for {
+		batch, err := r.app.GetWalletEvents(c, id, after, replayBatch)
+		if err != nil {
+			r.fail(c, err)
+			return
+		}
+		for _, e := range batch {
+			replayed[e.Id] = true
+			after = e.Id
+		}
+		backlog = append(backlog, batch...)
+		if len(batch) < replayBatch {
+			break
+		}
+	}
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	c.Header("X-Accel-Buffering", "no")
+	c.Status(http.StatusOK)
+	for _, e := range backlog {
+		if !r.writeEvent(c, e) {
+			return
+		}
+	}
+	if !r.writeBalance(c, id) {
+		return
+	}
+	keepalive := time.NewTicker(keepaliveInterval)
+	defer keepalive.Stop()
+	for {
+		select {
+		case <-c.Request.Context().Done():
+			return
+		case <-r.closing:
+			// The client reconnects to another instance with Last-Event-ID.
+			return
+		case <-keepalive.C:
+			if _, err := c.Writer.WriteString(": keepalive\n\n"); err != nil {
+				return
+			}
+			c.Writer.Flush()
+		case e, ok := <-sub.C:
+			if !ok {
+				return
+			}
+			if replayed[e.Id] {
+				continue
+			}
+			if !r.writeEvent(c, e) {
+				return
+			}
+			if e.Type == events.WalletDeleted && e.WalletId == id {
+				return
+			}
+			if !r.writeBalance(c, id) {
+				return
+			}
+		}
+	}
+}
+
+func (r *Router) writeEvent(c *gin.Context, e events.Event) bool {
+	err := sse.Encode(c.Writer, sse.Event{Id: strconv.FormatInt(e.Id, 10), Event: e.Type, Data: e})
+	if err != nil {
+		r.log.WithContext(c).Errorf("failed to write event: %v", err)
+		return false
+	}
+	c.Writer.Flush()
+	return true
+}
+
+func (r *Router) writeBalance(c *gin.Context, id int) bool {
+	wallet, err := r.app.GetWallet(c, id, "")
+	if err != nil {
+		r.log.WithContext(c).Errorf("failed to get Wallet: %v", err)
+		return false
+	}
+	err = sse.Encode(c.Writer, sse.Event{Event: "balance", Data: balanceUpdate{WalletId: id, Balance: wallet.Balance}})
+	if err != nil {
+		r.log.WithContext(c).Errorf("failed to write balance: %v", err)
+		return false
+	}
+	c.Writer.Flush()
+	return true
+}
@@ -0,0 +1,46 @@
+package rest
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+type ReverseRequest struct {
+	Reason string `json:"reason"`
+}
+
+func (r *Router) transactionStatuses(c *gin.Context) {
+	id, ok := r.pathID(c)
+	if !ok {
+		return
+	}
+	statuses, err := r.app.GetTransactionStatuses(c, id)
+	if err != nil {
+		r.fail(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, statuses)
+}
+
+func (r *Router) reverseTransaction(c *gin.Context) {
+	id, ok := r.pathID(c)
+	if !ok {
+		return
+	}
+	var input ReverseRequest
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&input); err != nil {
+			r.badRequest(c, err)
+			return
+		}
+	}
+	if input.Reason == "" {
+		input.Reason = "reversed by " + r.GetUserSession(c).Username
+	}
+	if err := r.app.ReverseTransaction(c, id, input.Reason); err != nil {
+		r.fail(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, statusOK)
+}
@@ -0,0 +1,127 @@
+package rest
+
+import (
+	"errors"
+	"net/http"
+
+	"EWallet/pkg/repository"
+	"EWallet/pkg/webhooks"
+
+	"github.com/gin-gonic/gin"
+)
+
+type WebhookRequest struct {
+	WalletId  int    `json:"wallet_id"`
+	EventType string `json:"event_type"`
+	Url       string `json:"url"`
+}
+
+func (r *Router) addWebhook(c *gin.Context) {
+	var input WebhookRequest
+	if err := c.ShouldBindJSON(&input); err != nil {
+		r.badRequest(c, err)
+		return
+	}
+	if input.EventType == "" {
+		input.EventType = webhooks.AllEvents
+	}
+	if !webhooks.IsValidEventType(input.EventType) {
+		r.invalidField(c, "event_type", "unknown event type")
+		return
+	}
+	switch err := webhooks.CheckURL(c, input.Url); {
+	case errors.Is(err, webhooks.ErrForbiddenAddress):
+		r.invalidField(c, "url", "must not point to a loopback, private or link-local address")
+		return
+	case err != nil:
+		r.invalidField(c, "url", "must be an absolute http(s) url")
+		return
+	}
+	if !r.authorizeWallet(c, input.WalletId, "subscribe to it") {
+		return
+	}
+	sub, err := r.app.CreateWebhook(c, repository.WebhookSubscription{
+		Username:  r.GetUserSession(c).Username,
+		WalletId:  input.WalletId,
+		EventType: input.EventType,
+		Url:       input.Url,
+	})
+	if err != nil {
+		r.fail(c, err)
+		return
+	}
+	c.JSON(http.StatusCreated, sub)
+}
+
+func (r *Router) getWebhooks(c *gin.Context) {
+	subs, err := r.app.GetWebhooks(c, r.GetUserSession(c).Username)
+	if err != nil {
+		r.fail(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, subs)
+}
+
+func (r *Router) deleteWebhook(c *gin.Context) {
+	id, ok := r.pathID(c)
+	if !ok {
+		return
+	}
+	if err := r.app.DeleteWebhook(c, r.GetUserSession(c).Username, id); err != nil {
+		r.fail(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, statusOK)
+}
+
+func (r *Router) testWebhook(c *gin.Context) {
+	id, ok := r.pathID(c)
+	if !ok {
+		return
+	}
+	d, err := r.app.TestWebhook(c, r.GetUserSession(c).Username, id)
+	if err != nil {
+		r.fail(c, err)
+		return
+	}
+	c.JSON(http.StatusAccepted, d)
+}
+
+func (r *Router) webhookDeliveries(c *gin.Context) {
+	id, ok := r.pathID(c)
+	if !ok {
+		return
+	}
+	deliveries, err := r.app.GetWebhookDeliveries(c, r.GetUserSession(c).Username, id, c.Query("status"))
+	if err != nil {
+		r.fail(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, deliveries)
+}
+
+func (r *Router) webhookAttempts(c *gin.Context) {
+	id, ok := r.pathID(c)
+	if !ok {
+		return
+	}
+	attempts, err := r.app.GetWebhookAttempts(c, r.GetUserSession(c).Username, id)
+	if err != nil {
+		r.fail(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, attempts)
+}
+
+func (r *Router) redeliverWebhook(c *gin.Context) {
+	id, ok := r.pathID(c)
+	if !ok {
+		return
+	}
+	d, err := r.app.RedeliverWebhook(c, r.GetUserSession(c).Username, id)
+	if err != nil {
+		r.fail(c, err)
+		return
+	}
+	c.JSON(http.StatusAccepted, d)
+}
@@ -0,0 +1,73 @@
+package internal
+
+import (
+	"context"
+	"fmt"
+
+	"EWallet/pkg/repository"
+	"EWallet/pkg/screening"
+	"EWallet/pkg/tracing"
+
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// screen runs the operation through the screener. Held operations are stored for review and reported
+// with screening.ErrPendingReview, denied ones with screening.ErrTransactionDenied.
+func (s *App) screen(ctx context.Context, operation string, id int, request *repository.FinRequest) (err error) {
+	if s.screener == nil {
+		return nil
+	}
+	ctx, span := tracing.Start(ctx, "App.screen", attribute.String("operation", operation))
+	defer tracing.End(span, &err)
+	decision, err := s.screener.Screen(ctx, screening.Operation{
+		Kind:     operation,
+		WalletID: id,
+		TargetID: request.WalletTarget,
+		Sum:      request.Sum,
+		UUID:     request.UUID,
+	})
+	if err != nil {
+		return fmt.Errorf("err screening the %s: %w", operation, err)
+	}
+	switch decision.Verdict {
+	case screening.Deny:
+		return fmt.Errorf("%s: %w", decision.Reason, screening.ErrTransactionDenied)
+	case screening.Review:
+		if _, err = s.store.HoldTransaction(ctx, operation, id, request, decision.Rule, decision.Reason); err != nil {
+			return fmt.Errorf("err holding the %s: %w", operation, err)
+		}
+		return screening.ErrPendingReview
+	}
+	return nil
+}
+
+func (s *App) GetReviews(ctx context.Context, status string) (reviews []repository.Review, err error) {
+	ctx, span := tracing.Start(ctx, "App.GetReviews", attribute.String("status", status))
+	defer tracing.End(span, &err)
+	reviews, err = s.store.GetReviews(ctx, status)
+	if err != nil {
+		return nil, fmt.Errorf("err getting reviews: %w", err)
+	}
+	return reviews, nil
+}
+
+// ApproveReview completes the pending transaction held by the review. The review is resolved in the
+// DB transaction moving the money, so a concurrent approval or rejection can't act on the same
+// transaction and a failed approval can be retried.
+func (s *App) ApproveReview(ctx context.Context, id int, reviewer string) (err error) {
+	ctx, span := tracing.Start(ctx, "App.ApproveReview", attribute.Int("review.id", id))
+	defer tracing.End(span, &err)
+	if err = s.store.ApproveReview(ctx, id, reviewer); err != nil {
+		return fmt.Errorf("err approving review: %w", err)
+	}
+	return nil
+}
+
+func (s *App) RejectReview(ctx context.Context, id int, reviewer string) (err error) {
+	ctx, span := tracing.Start(ctx, "App.RejectReview", attribute.Int("review.id", id))
+	defer tracing.End(span, &err)
+	if err = s.store.RejectReview(ctx, id, reviewer); err != nil {
+		return fmt.Errorf("err rejecting review: %w", err)
+	}
+	return nil
+}
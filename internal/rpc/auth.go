@@ -0,0 +1,103 @@
+package rpc
+
+import (
+	"context"
+	"crypto/subtle"
+	"strings"
+
+	"EWallet/pkg/auth"
+	"EWallet/pkg/repository"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+const apiKeyHeader = "x-api-key"
+
+type usernameKey struct{}
+
+// Username returns the authenticated caller of the call.
+func Username(ctx context.Context) string {
+	username, _ := ctx.Value(usernameKey{}).(string)
+	return username
+}
+
+type authenticator struct {
+	secret  []byte
+	apiKeys map[string]string
+}
+
+func newAuthenticator(secret string, apiKeys map[string]string) *authenticator {
+	return &authenticator{secret: []byte(secret), apiKeys: apiKeys}
+}
+
+func (a *authenticator) unary(ctx context.Context, req interface{}, _ *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	ctx, err := a.authenticate(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return handler(ctx, req)
+}
+
+func (a *authenticator) stream(srv interface{}, ss grpc.ServerStream, _ *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	ctx, err := a.authenticate(ss.Context())
+	if err != nil {
+		return err
+	}
+	return handler(srv, &contextStream{ServerStream: ss, ctx: ctx})
+}
+
+// authenticate accepts "authorization: Bearer <jwt>" or "x-api-key: <key>" metadata.
+func (a *authenticator) authenticate(ctx context.Context) (context.Context, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	if keys := md.Get(apiKeyHeader); len(keys) > 0 {
+		for key, username := range a.apiKeys {
+			if subtle.ConstantTimeCompare([]byte(key), []byte(keys[0])) == 1 {
+				return context.WithValue(ctx, usernameKey{}, username), nil
+			}
+		}
+		return nil, status.Error(codes.Unauthenticated, "invalid api key")
+	}
+	values := md.Get("authorization")
+	if len(values) == 0 {
+		return nil, status.Error(codes.Unauthenticated, "Unauthorized")
+	}
+	parts := strings.Split(values[0], " ")
+	if !(len(parts) == 2 && parts[0] == "Bearer") {
+		return nil, status.Error(codes.Unauthenticated, "Unauthorized")
+	}
+	claims, err := auth.ParseToken(a.secret, parts[1])
+	if err != nil {
+		return nil, status.Error(codes.Unauthenticated, "invalid token")
+	}
+	return context.WithValue(ctx, usernameKey{}, claims.Username), nil
+}
+
+// contextStream replaces the context of the stream with the one an interceptor derived from it.
+type contextStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *contextStream) Context() context.Context {
+	return s.ctx
+}
+
+// authorize lets the owner of wallet and admins through.
+func (s *Server) authorize(ctx context.Context, wallet repository.Wallet) error {
+	if username := Username(ctx); wallet.Owner != username && !s.admins[username] {
+		return status.Error(codes.PermissionDenied, "only the wallet owner can use it")
+	}
+	return nil
+}
+
+// authorizeWallet lets the owner of wallet id and admins through.
+func (s *Server) authorizeWallet(ctx context.Context, id int) error {
+	wallet, err := s.app.GetWallet(ctx, id, "")
+	if err != nil {
+		return s.statusError(ctx, "failed to get wallet", err)
+	}
+	return s.authorize(ctx, wallet)
+}
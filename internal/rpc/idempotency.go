@@ -0,0 +1,88 @@
+package rpc
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+
+	"EWallet/pkg/ctxutil"
+	"EWallet/pkg/repository"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+)
+
+// idempotencyHeader is the metadata carrying the idempotency key of calls without a uuid field.
+const idempotencyHeader = "idempotency-key"
+
+// metadataKey returns the idempotency key sent in the metadata of the call.
+func metadataKey(ctx context.Context) string {
+	md, _ := metadata.FromIncomingContext(ctx)
+	if keys := md.Get(idempotencyHeader); len(keys) > 0 {
+		return keys[0]
+	}
+	return ""
+}
+
+// idempotent executes fn, which fills res, once per key: a retry of the same call gets the stored res
+// back and a call with the same key but another request fails. The keys are scoped by the caller, the
+// operation and the wallet like in the REST API, but they are per API: the call is fingerprinted and
+// stored in its gRPC form, so a key already used with the REST API fails as another request.
+// Without a key fn just runs.
+func (s *Server) idempotent(ctx context.Context, operation string, walletID int, key string, req, res proto.Message, fn func() error) error {
+	if key == "" {
+		return fn()
+	}
+	scope := repository.IdempotencyScope{
+		Username:  Username(ctx),
+		Operation: operation,
+		WalletID:  walletID,
+	}
+	fingerprint, err := callFingerprint(ctx, req)
+	if err != nil {
+		return s.statusError(ctx, "failed to fingerprint call", err)
+	}
+	record, token, err := s.app.BeginIdempotent(ctx, scope, key, fingerprint)
+	if err != nil {
+		return s.statusError(ctx, "failed to reserve idempotency key", err)
+	}
+	if record != nil {
+		if err = protojson.Unmarshal(record.Response, res); err != nil {
+			return s.statusError(ctx, "failed to replay call", err)
+		}
+		_ = grpc.SetHeader(ctx, metadata.Pairs("idempotent-replayed", "true"))
+		return nil
+	}
+	// Any outcome other than a stored response frees the key, so the call can be retried.
+	statusCode, response := http.StatusInternalServerError, []byte(nil)
+	err = fn()
+	if err == nil {
+		if response, err = protojson.Marshal(res); err == nil {
+			statusCode = http.StatusOK
+		} else {
+			s.log.WithContext(ctx).Errorf("failed to encode the response of %s: %v", operation, err)
+			err = nil
+		}
+	}
+	// The key is settled even when the client is gone, or it stays reserved until its lease ends.
+	if finishErr := s.app.FinishIdempotent(ctxutil.Detach(ctx), scope, key, token, statusCode, response); finishErr != nil {
+		s.log.WithContext(ctx).Errorf("failed to finish idempotent call: %v", finishErr)
+	}
+	return err
+}
+
+// callFingerprint hashes the method and the deterministic encoding of the request.
+func callFingerprint(ctx context.Context, req proto.Message) (string, error) {
+	body, err := proto.MarshalOptions{Deterministic: true}.Marshal(req)
+	if err != nil {
+		return "", err
+	}
+	method, _ := grpc.Method(ctx)
+	h := sha256.New()
+	h.Write([]byte("grpc " + method + "\n"))
+	h.Write(body)
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
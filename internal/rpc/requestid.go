@@ -0,0 +1,42 @@
+package rpc
+
+import (
+	"context"
+
+	"EWallet/pkg/logger"
+
+	"github.com/google/uuid"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+const requestIDHeader = "x-request-id"
+
+// requestContext tags the call with the x-request-id metadata of the client, or a generated ID when it
+// sent none or one unfit for the logs, and returns it in the x-request-id header. As for the REST API
+// the context carries it to the log lines of the App and the store and to the span of the call.
+func requestContext(ctx context.Context) (context.Context, metadata.MD) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	var id string
+	if ids := md.Get(requestIDHeader); len(ids) > 0 && logger.ValidRequestID(ids[0]) {
+		id = ids[0]
+	} else {
+		id = uuid.New().String()
+	}
+	trace.SpanFromContext(ctx).SetAttributes(attribute.String("request.id", id))
+	return logger.WithRequestID(ctx, id), metadata.Pairs(requestIDHeader, id)
+}
+
+func unaryRequestID(ctx context.Context, req interface{}, _ *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	ctx, header := requestContext(ctx)
+	_ = grpc.SetHeader(ctx, header)
+	return handler(ctx, req)
+}
+
+func streamRequestID(srv interface{}, ss grpc.ServerStream, _ *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	ctx, header := requestContext(ss.Context())
+	_ = ss.SetHeader(header)
+	return handler(srv, &contextStream{ServerStream: ss, ctx: ctx})
+}
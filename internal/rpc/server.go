@@ -0,0 +1,330 @@
+package rpc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"EWallet/pkg/models"
+	"EWallet/pkg/pb"
+	"EWallet/pkg/repository"
+	"EWallet/pkg/screening"
+	"EWallet/pkg/validation"
+
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// streamPageSize is the page size GetTransactions reads the history with.
+const streamPageSize = 100
+
+// DefaultShutdownTimeout is how long Run waits for the in-flight calls when ctx is done.
+const DefaultShutdownTimeout = 30 * time.Second
+
+type App interface {
+	CreateWallet(ctx context.Context, wallet repository.Wallet) (int, error)
+	GetWallet(ctx context.Context, id int, currency string) (repository.Wallet, error)
+	Deposit(ctx context.Context, id int, request *repository.FinRequest) error
+	Withdrawal(ctx context.Context, id int, request *repository.FinRequest) error
+	Transfer(ctx context.Context, id int, request *repository.FinRequest) error
+	GetTransactions(ctx context.Context, id int, params *models.TransactionQueryParams) (repository.TransactionPage, error)
+	Freeze(ctx context.Context, id int) error
+	BeginIdempotent(ctx context.Context, scope repository.IdempotencyScope, key, fingerprint string) (*repository.IdempotencyKey, string, error)
+	FinishIdempotent(ctx context.Context, scope repository.IdempotencyScope, key, token string, statusCode int, response []byte) error
+}
+
+type Server struct {
+	pb.UnimplementedEWalletServer
+	log             *logrus.Entry
+	app             App
+	server          *grpc.Server
+	shutdownTimeout time.Duration
+	admins          map[string]bool
+}
+
+// NewServer creates the gRPC server. Callers authenticate with a JWT issued by the REST API or
+// with one of apiKeys, which maps keys to the usernames they act as. Callers work with their own
+// wallets, admins with every wallet. Every call is tagged with its x-request-id, see requestContext.
+func NewServer(log *logrus.Logger, app App, secret string, apiKeys map[string]string, admins ...string) *Server {
+	a := newAuthenticator(secret, apiKeys)
+	s := &Server{
+		log:             log.WithField("component", "grpc"),
+		app:             app,
+		shutdownTimeout: DefaultShutdownTimeout,
+		admins:          make(map[string]bool, len(admins)),
+	}
+	for _, admin := range admins {
+		s.admins[admin] = true
+	}
+	s.server = grpc.NewServer(
+		grpc.ChainUnaryInterceptor(unaryRequestID, a.unary),
+		grpc.ChainStreamInterceptor(streamRequestID, a.stream),
+	)
+	pb.RegisterEWalletServer(s.server, s)
+	return s
+}
+
+// SetShutdownTimeout replaces DefaultShutdownTimeout, it is called before Run.
+func (s *Server) SetShutdownTimeout(d time.Duration) {
+	s.shutdownTimeout = d
+}
+
+// Run serves on addr until ctx is done, then stops accepting calls and waits for the in-flight ones
+// up to the shutdown timeout; the calls still running after it are cancelled.
+func (s *Server) Run(ctx context.Context, addr string) error {
+	lis, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("err listening on %s: %w", addr, err)
+	}
+	served := make(chan error, 1)
+	go func() {
+		served <- s.server.Serve(lis)
+	}()
+	select {
+	case err = <-served:
+		return err
+	case <-ctx.Done():
+	}
+	stopped := make(chan struct{})
+	go func() {
+		s.server.GracefulStop()
+		close(stopped)
+	}()
+	timer := time.NewTimer(s.shutdownTimeout)
+	defer timer.Stop()
+	select {
+	case <-stopped:
+	case <-timer.C:
+		s.server.Stop()
+		<-stopped
+		err = fmt.Errorf("err draining grpc calls: %w", context.DeadlineExceeded)
+	}
+	if serveErr := <-served; serveErr != nil {
+		return serveErr
+	}
+	return err
+}
+
+func (s *Server) CreateWallet(ctx context.Context, req *pb.CreateWalletRequest) (*pb.CreateWalletResponse, error) {
+	input := models.WalletRequest{Owner: req.GetOwner(), Balance: req.GetBalance()}
+	if fields := validation.Validate(&input); len(fields) > 0 {
+		return nil, invalidArgument(fields)
+	}
+	wallet := repository.Wallet{Owner: input.Owner, Balance: input.Balance}
+	if username := Username(ctx); wallet.Owner != username && !s.admins[username] {
+		return nil, status.Error(codes.PermissionDenied, "only admins can create wallets of other users")
+	}
+	res := &pb.CreateWalletResponse{}
+	err := s.idempotent(ctx, "create_wallet", 0, metadataKey(ctx), req, res, func() error {
+		id, err := s.app.CreateWallet(ctx, wallet)
+		if err != nil {
+			return s.statusError(ctx, "failed to create wallet", err)
+		}
+		res.Id = int64(id)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+func (s *Server) GetWallet(ctx context.Context, req *pb.GetWalletRequest) (*pb.Wallet, error) {
+	w, err := s.app.GetWallet(ctx, int(req.GetId()), req.GetCurrency())
+	if err != nil {
+		return nil, s.statusError(ctx, "failed to get wallet", err)
+	}
+	if err = s.authorize(ctx, w); err != nil {
+		return nil, err
+	}
+	return &pb.Wallet{
+		Id:        req.GetId(),
+		Owner:     w.Owner,
+		Balance:   w.Balance,
+		CreatedAt: timestamppb.New(w.CreatedAt),
+		UpdatedAt: timestamppb.New(w.UpdatedAt),
+		Frozen:    w.Frozen,
+	}, nil
+}
+
+func (s *Server) Deposit(ctx context.Context, req *pb.OperationRequest) (*pb.OperationResponse, error) {
+	return s.operation(ctx, "deposit", req, s.app.Deposit)
+}
+
+func (s *Server) Withdrawal(ctx context.Context, req *pb.OperationRequest) (*pb.OperationResponse, error) {
+	return s.operation(ctx, "withdraw", req, s.app.Withdrawal)
+}
+
+func (s *Server) Transfer(ctx context.Context, req *pb.OperationRequest) (*pb.OperationResponse, error) {
+	return s.operation(ctx, "transfer", req, s.app.Transfer)
+}
+
+// operation executes a money operation once per uuid: a retry gets the response of the first call.
+func (s *Server) operation(ctx context.Context, operation string, req *pb.OperationRequest, fn func(ctx context.Context, id int, request *repository.FinRequest) error) (*pb.OperationResponse, error) {
+	if _, err := uuid.Parse(req.GetUuid()); err != nil {
+		return nil, status.Error(codes.InvalidArgument, "incorrect format of uuid")
+	}
+	if key := metadataKey(ctx); key != "" && key != req.GetUuid() {
+		return nil, status.Error(codes.InvalidArgument, "uuid: does not match "+idempotencyHeader)
+	}
+	id := int(req.GetWalletId())
+	input := models.OperationRequest{
+		Sum:          req.GetSum(),
+		WalletTarget: int(req.GetWalletTarget()),
+		UUID:         req.GetUuid(),
+	}
+	if fields := validation.ValidateOperation(operation, id, &input); len(fields) > 0 {
+		return nil, invalidArgument(fields)
+	}
+	request := &repository.FinRequest{Sum: input.Sum, WalletTarget: input.WalletTarget, UUID: input.UUID}
+	if err := s.authorizeWallet(ctx, id); err != nil {
+		return nil, err
+	}
+	res := &pb.OperationResponse{}
+	err := s.idempotent(ctx, operation, id, req.GetUuid(), req, res, func() error {
+		err := fn(ctx, id, request)
+		switch {
+		case err == nil:
+			res.Status = pb.OperationResponse_STATUS_COMPLETED
+		case errors.Is(err, screening.ErrPendingReview):
+			res.Status = pb.OperationResponse_STATUS_PENDING_REVIEW
+		default:
+			return s.statusError(ctx, "failed to execute operation", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// GetTransactions streams the matching transactions, walking the history page by page.
+func (s *Server) GetTransactions(req *pb.GetTransactionsRequest, stream pb.EWallet_GetTransactionsServer) error {
+	params := &models.TransactionQueryParams{
+		Sort:         req.GetSort(),
+		Desc:         req.GetDesc(),
+		Status:       req.GetStatus(),
+		Operation:    req.GetOperation(),
+		Direction:    req.GetDirection(),
+		MinSum:       req.GetMinSum(),
+		MaxSum:       req.GetMaxSum(),
+		Counterparty: int(req.GetCounterparty()),
+	}
+	if req.From != nil {
+		from := req.GetFrom().AsTime()
+		params.From = &from
+	}
+	if req.To != nil {
+		to := req.GetTo().AsTime()
+		params.To = &to
+	}
+	if fields := validation.Validate(params); len(fields) > 0 {
+		return invalidArgument(fields)
+	}
+	if req.GetLimit() < 0 {
+		return status.Error(codes.InvalidArgument, "limit: must be at least 0")
+	}
+	if err := s.authorizeWallet(stream.Context(), int(req.GetWalletId())); err != nil {
+		return err
+	}
+	remaining := int(req.GetLimit())
+	for {
+		params.Limit = streamPageSize
+		if remaining > 0 && remaining < streamPageSize {
+			params.Limit = remaining
+		}
+		page, err := s.app.GetTransactions(stream.Context(), int(req.GetWalletId()), params)
+		if err != nil {
+			return s.statusError(stream.Context(), "failed to get transactions", err)
+		}
+		for _, t := range page.Transactions {
+			if err = stream.Send(transactionToPB(t)); err != nil {
+				return err
+			}
+		}
+		if remaining > 0 {
+			if remaining -= len(page.Transactions); remaining <= 0 {
+				return nil
+			}
+		}
+		if page.NextCursor == "" {
+			return nil
+		}
+		params.Cursor = page.NextCursor
+	}
+}
+
+func (s *Server) Freeze(ctx context.Context, req *pb.FreezeRequest) (*pb.FreezeResponse, error) {
+	if err := s.authorizeWallet(ctx, int(req.GetId())); err != nil {
+		return nil, err
+	}
+	if err := s.app.Freeze(ctx, int(req.GetId())); err != nil {
+		return nil, s.statusError(ctx, "failed to freeze wallet", err)
+	}
+	return &pb.FreezeResponse{}, nil
+}
+
+func transactionToPB(t repository.Transaction) *pb.Transaction {
+	res := &pb.Transaction{
+		Id:        int64(t.Id),
+		Uuid:      t.UUID,
+		FromId:    int64(t.FromId),
+		Sum:       t.Sum,
+		Operation: t.Operation,
+		Date:      timestamppb.New(t.Date),
+		Status:    t.Status,
+	}
+	if t.ToId != nil {
+		toID := int64(*t.ToId)
+		res.ToId = &toID
+	}
+	if t.Reason != nil {
+		res.Reason = *t.Reason
+	}
+	return res
+}
+
+// statusError maps repository and screening errors to gRPC status codes.
+func (s *Server) statusError(ctx context.Context, msg string, err error) error {
+	switch {
+	case errors.Is(err, repository.ErrWalletNotFound),
+		errors.Is(err, repository.ErrWalletTargetNotFound),
+		errors.Is(err, repository.ErrTransactionNotFound):
+		return status.Error(codes.NotFound, err.Error())
+	case errors.Is(err, repository.ErrInsufficientFunds),
+		errors.Is(err, repository.ErrWalletFrozen):
+		return status.Error(codes.FailedPrecondition, err.Error())
+	case errors.Is(err, repository.ErrDuplicateKey):
+		return status.Error(codes.AlreadyExists, err.Error())
+	case errors.Is(err, repository.ErrInvalidCursor),
+		errors.Is(err, repository.ErrIdempotencyMismatch):
+		return status.Error(codes.InvalidArgument, err.Error())
+	case errors.Is(err, repository.ErrIdempotencyInProgress):
+		return status.Error(codes.Aborted, err.Error())
+	case errors.Is(err, screening.ErrTransactionDenied):
+		return status.Error(codes.PermissionDenied, err.Error())
+	case errors.Is(err, context.Canceled):
+		return status.Error(codes.Canceled, err.Error())
+	case errors.Is(err, context.DeadlineExceeded):
+		return status.Error(codes.DeadlineExceeded, err.Error())
+	}
+	s.log.WithContext(ctx).Errorf("%s: %v", msg, err)
+	return status.Error(codes.Internal, "internal error")
+}
+
+// invalidArgument reports the invalid fields of a request.
+func invalidArgument(fields []validation.FieldError) error {
+	msgs := make([]string, 0, len(fields))
+	for _, f := range fields {
+		msgs = append(msgs, f.Field+": "+f.Message)
+	}
+	return status.Error(codes.InvalidArgument, strings.Join(msgs, "; "))
+}
@@ -3,12 +3,17 @@ package internal
 import (
 	"context"
 	"fmt"
+	"time"
 
+	"EWallet/pkg/events"
 	"EWallet/pkg/models"
 
 	"EWallet/pkg/repository"
+	"EWallet/pkg/screening"
+	"EWallet/pkg/tracing"
 
 	"github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/attribute"
 )
 
 type CheckingAccount struct {
@@ -27,37 +32,95 @@ type Storage interface {
 	Deposit(ctx context.Context, id int, request *repository.FinRequest) error
 	Withdrawal(ctx context.Context, id int, request *repository.FinRequest) error
 	Transfer(ctx context.Context, id int, request *repository.FinRequest) error
-	GetTransactions(ctx context.Context, id int, params *models.TransactionQueryParams) ([]repository.Transaction, error)
+	GetTransactions(ctx context.Context, id int, params *models.TransactionQueryParams) (repository.TransactionPage, error)
+	GetStatement(ctx context.Context, id int, from, to time.Time) (repository.Statement, error)
+	WalkStatement(ctx context.Context, id int, from, to time.Time, begin func(repository.Statement) error, entry func(repository.StatementEntry) error) error
+	SaveStatementDocument(ctx context.Context, doc repository.StatementDocument) error
+	GetStatementDocuments(ctx context.Context, walletID int) ([]repository.StatementDocument, error)
+	GetStatementDocument(ctx context.Context, walletID int, periodStart time.Time) (repository.StatementDocument, error)
+	GetWalletsWithoutStatement(ctx context.Context, periodStart, periodEnd time.Time, afterID, limit int) ([]int, error)
 	Freeze(ctx context.Context, id int) error
+	HoldTransaction(ctx context.Context, operation string, id int, request *repository.FinRequest, rule, reason string) (int, error)
+	GetReview(ctx context.Context, id int) (repository.Review, error)
+	GetReviews(ctx context.Context, status string) ([]repository.Review, error)
+	ApproveReview(ctx context.Context, id int, reviewer string) error
+	RejectReview(ctx context.Context, id int, reviewer string) error
+	ReverseTransaction(ctx context.Context, id int, reason string) error
+	GetTransactionStatuses(ctx context.Context, id int) ([]repository.TransactionStatus, error)
+	GetTransactionByUUID(ctx context.Context, fromID int, operation, uuid string) (repository.Transaction, error)
+	CreatePayout(ctx context.Context, username string, p repository.Payout) (repository.Payout, error)
+	GetPayout(ctx context.Context, id int) (repository.Payout, error)
+	SetPayoutItemStatus(ctx context.Context, id, position int, status string, reason *string) error
+	FinishPayout(ctx context.Context, id int) error
+	ExecuteAtomicPayout(ctx context.Context, id int) error
+	EnqueueJob(ctx context.Context, job repository.Job) (repository.Job, error)
+	GetJob(ctx context.Context, id int64) (repository.Job, error)
+	GetJobs(ctx context.Context, status, jobType string, limit int) ([]repository.Job, error)
+	GetJobOutput(ctx context.Context, id int64) ([]byte, string, error)
+	ReserveIdempotencyKey(ctx context.Context, scope repository.IdempotencyScope, key, fingerprint, token string, lockedUntil, expiresAt time.Time) (repository.IdempotencyKey, bool, error)
+	SaveIdempotentResponse(ctx context.Context, scope repository.IdempotencyScope, key, token string, statusCode int, response []byte) error
+	ReleaseIdempotencyKey(ctx context.Context, scope repository.IdempotencyScope, key, token string) error
+	DeleteExpiredIdempotencyKeys(ctx context.Context) (int64, error)
+	CreateWebhook(ctx context.Context, sub repository.WebhookSubscription) (repository.WebhookSubscription, error)
+	GetWebhooks(ctx context.Context, username string) ([]repository.WebhookSubscription, error)
+	GetWebhook(ctx context.Context, username string, id int) (repository.WebhookSubscription, error)
+	DeleteWebhook(ctx context.Context, username string, id int) error
+	CreateWebhookDelivery(ctx context.Context, subscriptionID int, eventType string, payload []byte) (repository.WebhookDelivery, error)
+	GetWebhookDeliveries(ctx context.Context, subscriptionID int, status string) ([]repository.WebhookDelivery, error)
+	GetWebhookAttempts(ctx context.Context, username string, deliveryID int) ([]repository.WebhookAttempt, error)
+	RedeliverWebhook(ctx context.Context, username string, deliveryID int) (repository.WebhookDelivery, error)
+	GetWalletEvents(ctx context.Context, walletID int, afterID int64, limit int) ([]events.Event, error)
+	ListenEvents(ctx context.Context, notify func(id int64)) error
+	GetEvent(ctx context.Context, id int64) (events.Event, error)
 }
 type Exchange interface {
 	GetRate(ctx context.Context, currency string, amount float64) (float64, error)
 }
+type Screener interface {
+	Screen(ctx context.Context, op screening.Operation) (screening.Decision, error)
+}
 
 type App struct {
-	log      *logrus.Entry
-	store    Storage
-	exchange Exchange
+	log                  *logrus.Entry
+	store                Storage
+	exchange             Exchange
+	screener             Screener
+	idempotencyRetention time.Duration
+	idempotencyLease     time.Duration
+	statementKey         []byte
+	hub                  *events.Hub
 }
 
-func NewApp(log *logrus.Logger, store Storage, exchange Exchange) *App {
+// NewApp creates the service. A nil screener lets every operation through,
+// idempotency keys expire after idempotencyRetention, PDF statements are signed with statementKey
+// (unsigned when it is empty).
+func NewApp(log *logrus.Logger, store Storage, exchange Exchange, screener Screener, idempotencyRetention time.Duration, statementKey []byte) *App {
 	return &App{
-		log:      log.WithField("component", "ewallet"),
-		store:    store,
-		exchange: exchange,
+		log:                  log.WithField("component", "ewallet"),
+		store:                store,
+		exchange:             exchange,
+		screener:             screener,
+		idempotencyRetention: idempotencyRetention,
+		idempotencyLease:     DefaultIdempotencyLease,
+		statementKey:         statementKey,
+		hub:                  events.NewHub(log, store),
 	}
 }
 
-func (s *App) CreateWallet(ctx context.Context, wallet repository.Wallet) (int, error) {
-	id, err := s.store.CreateWallet(ctx, wallet)
+func (s *App) CreateWallet(ctx context.Context, wallet repository.Wallet) (id int, err error) {
+	ctx, span := tracing.Start(ctx, "App.CreateWallet")
+	defer tracing.End(span, &err)
+	id, err = s.store.CreateWallet(ctx, wallet)
 	if err != nil {
 		return 0, fmt.Errorf("err inserting last_visit: %w", err)
 	}
 	return id, nil
 }
 
-func (s *App) GetWallet(ctx context.Context, id int, currency string) (repository.Wallet, error) {
-	wal, err := s.store.GetWallet(ctx, id)
+func (s *App) GetWallet(ctx context.Context, id int, currency string) (wal repository.Wallet, err error) {
+	ctx, span := tracing.Start(ctx, "App.GetWallet", walletID(id), attribute.String("currency", currency))
+	defer tracing.End(span, &err)
+	wal, err = s.store.GetWallet(ctx, id)
 	if err != nil {
 		return repository.Wallet{}, fmt.Errorf("err getting wallet : %w", err)
 	}
@@ -70,61 +133,130 @@ func (s *App) GetWallet(ctx context.Context, id int, currency string) (repositor
 	return wal, nil
 }
 
-func (s *App) GetRate(ctx context.Context, currency string) (float64, error) {
+func (s *App) GetRate(ctx context.Context, currency string) (rate float64, err error) {
+	ctx, span := tracing.Start(ctx, "App.GetRate", attribute.String("currency", currency))
+	defer tracing.End(span, &err)
 	return s.exchange.GetRate(ctx, currency, 1)
 }
 
-func (s *App) DeleteWallet(ctx context.Context, id int) error {
-	err := s.store.DeleteWallet(ctx, id)
+func (s *App) DeleteWallet(ctx context.Context, id int) (err error) {
+	ctx, span := tracing.Start(ctx, "App.DeleteWallet", walletID(id))
+	defer tracing.End(span, &err)
+	err = s.store.DeleteWallet(ctx, id)
 	if err != nil {
 		return fmt.Errorf("err deleting wallet : %w", err)
 	}
 	return nil
 }
 
-func (s *App) UpdateWallet(ctx context.Context, id int, wallet repository.Wallet) (repository.Wallet, error) {
-	wal, err := s.store.UpdateWallet(ctx, id, wallet)
+func (s *App) UpdateWallet(ctx context.Context, id int, wallet repository.Wallet) (wal repository.Wallet, err error) {
+	ctx, span := tracing.Start(ctx, "App.UpdateWallet", walletID(id))
+	defer tracing.End(span, &err)
+	wal, err = s.store.UpdateWallet(ctx, id, wallet)
 	if err != nil {
 		return repository.Wallet{}, fmt.Errorf("err updating the Wallet: %w", err)
 	}
 	return wal, nil
 }
 
-func (s *App) Deposit(ctx context.Context, id int, request *repository.FinRequest) error {
-	err := s.store.Deposit(ctx, id, request)
+func (s *App) Deposit(ctx context.Context, id int, request *repository.FinRequest) (err error) {
+	ctx, span := tracing.Start(ctx, "App.Deposit", walletID(id))
+	defer tracing.End(span, &err)
+	if err = s.screen(ctx, "deposit", id, request); err != nil {
+		return err
+	}
+	err = s.store.Deposit(ctx, id, request)
 	if err != nil {
 		return fmt.Errorf("err depositing the Wallet: %w", err)
 	}
 	return nil
 }
 
-func (s *App) Withdrawal(ctx context.Context, id int, request *repository.FinRequest) error {
-	if err := s.store.Withdrawal(ctx, id, request); err != nil {
+func (s *App) Withdrawal(ctx context.Context, id int, request *repository.FinRequest) (err error) {
+	ctx, span := tracing.Start(ctx, "App.Withdrawal", walletID(id))
+	defer tracing.End(span, &err)
+	if err = s.screen(ctx, "withdraw", id, request); err != nil {
+		return err
+	}
+	if err = s.store.Withdrawal(ctx, id, request); err != nil {
 		return fmt.Errorf("err withdrawing from the wallet: %w", err)
 	}
 	return nil
 }
 
-func (s *App) Freeze(ctx context.Context, id int) error {
-	err := s.store.Freeze(ctx, id)
+func (s *App) Freeze(ctx context.Context, id int) (err error) {
+	ctx, span := tracing.Start(ctx, "App.Freeze", walletID(id))
+	defer tracing.End(span, &err)
+	err = s.store.Freeze(ctx, id)
 	if err != nil {
 		return fmt.Errorf("err freeze the Wallet: %w", err)
 	}
 	return nil
 }
 
-func (s *App) Transfer(ctx context.Context, id int, request *repository.FinRequest) error {
-	err := s.store.Transfer(ctx, id, request)
+func (s *App) Transfer(ctx context.Context, id int, request *repository.FinRequest) (err error) {
+	ctx, span := tracing.Start(ctx, "App.Transfer", walletID(id), attribute.Int("wallet.target", request.WalletTarget))
+	defer tracing.End(span, &err)
+	if err = s.screen(ctx, "transfer", id, request); err != nil {
+		return err
+	}
+	err = s.store.Transfer(ctx, id, request)
 	if err != nil {
 		return fmt.Errorf("err transferring the wallet: %w", err)
 	}
 	return nil
 }
 
-func (s *App) GetTransactions(ctx context.Context, id int, params *models.TransactionQueryParams) ([]repository.Transaction, error) {
-	trans, err := s.store.GetTransactions(ctx, id, params)
+func (s *App) GetTransactions(ctx context.Context, id int, params *models.TransactionQueryParams) (page repository.TransactionPage, err error) {
+	ctx, span := tracing.Start(ctx, "App.GetTransactions", walletID(id))
+	defer tracing.End(span, &err)
+	page, err = s.store.GetTransactions(ctx, id, params)
+	if err != nil {
+		return repository.TransactionPage{}, fmt.Errorf("err getting the transactions: %w", err)
+	}
+	return page, nil
+}
+
+func (s *App) GetStatement(ctx context.Context, id int, from, to time.Time) (statement repository.Statement, err error) {
+	ctx, span := tracing.Start(ctx, "App.GetStatement", walletID(id))
+	defer tracing.End(span, &err)
+	statement, err = s.store.GetStatement(ctx, id, from, to)
+	if err != nil {
+		return repository.Statement{}, fmt.Errorf("err getting the statement: %w", err)
+	}
+	return statement, nil
+}
+
+// WalkStatement streams the statement, see repository.PG.WalkStatement.
+func (s *App) WalkStatement(ctx context.Context, id int, from, to time.Time, begin func(repository.Statement) error, entry func(repository.StatementEntry) error) (err error) {
+	ctx, span := tracing.Start(ctx, "App.WalkStatement", walletID(id))
+	defer tracing.End(span, &err)
+	if err = s.store.WalkStatement(ctx, id, from, to, begin, entry); err != nil {
+		return fmt.Errorf("err walking the statement: %w", err)
+	}
+	return nil
+}
+
+func (s *App) GetTransactionStatuses(ctx context.Context, id int) (statuses []repository.TransactionStatus, err error) {
+	ctx, span := tracing.Start(ctx, "App.GetTransactionStatuses", attribute.Int("transaction.id", id))
+	defer tracing.End(span, &err)
+	statuses, err = s.store.GetTransactionStatuses(ctx, id)
 	if err != nil {
-		return nil, fmt.Errorf("err getting the transactions: %w", err)
+		return nil, fmt.Errorf("err getting the transaction statuses: %w", err)
 	}
-	return trans, nil
+	return statuses, nil
+}
+
+func (s *App) ReverseTransaction(ctx context.Context, id int, reason string) (err error) {
+	ctx, span := tracing.Start(ctx, "App.ReverseTransaction", attribute.Int("transaction.id", id))
+	defer tracing.End(span, &err)
+	if err = s.store.ReverseTransaction(ctx, id, reason); err != nil {
+		return fmt.Errorf("err reversing the transaction: %w", err)
+	}
+	return nil
+}
+
+// walletID is the span attribute of the wallet an operation is on.
+func walletID(id int) attribute.KeyValue {
+	return attribute.Int("wallet.id", id)
 }
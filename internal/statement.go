@@ -0,0 +1,144 @@
+package internal
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"time"
+
+	"EWallet/pkg/export"
+	"EWallet/pkg/repository"
+	"EWallet/pkg/tracing"
+
+	"go.opentelemetry.io/otel/attribute"
+)
+
+const monthlyStatementBatch = 100
+
+// NewStatementWriter returns the export writer of the format, PDF statements are signed with the
+// statement key.
+func (s *App) NewStatementWriter(format string, w io.Writer, columns []string) (export.Writer, error) {
+	return export.New(format, w, columns, s.statementKey)
+}
+
+// RenderStatement renders the PDF statement of the wallet for [from, to) and returns it with its signature.
+func (s *App) RenderStatement(ctx context.Context, id int, from, to time.Time) (_ []byte, _ string, err error) {
+	ctx, span := tracing.Start(ctx, "App.RenderStatement", walletID(id))
+	defer tracing.End(span, &err)
+	var buf bytes.Buffer
+	w := export.NewPDF(&buf, s.statementKey)
+	if err = s.store.WalkStatement(ctx, id, from, to, w.Begin, w.Entry); err != nil {
+		return nil, "", fmt.Errorf("err walking the statement: %w", err)
+	}
+	if err = w.End(); err != nil {
+		return nil, "", fmt.Errorf("err rendering the statement: %w", err)
+	}
+	return buf.Bytes(), w.Signature(), nil
+}
+
+func (s *App) GetStatementDocuments(ctx context.Context, id int) (docs []repository.StatementDocument, err error) {
+	ctx, span := tracing.Start(ctx, "App.GetStatementDocuments", walletID(id))
+	defer tracing.End(span, &err)
+	if _, err = s.store.GetWallet(ctx, id); err != nil {
+		return nil, fmt.Errorf("err getting wallet: %w", err)
+	}
+	docs, err = s.store.GetStatementDocuments(ctx, id)
+	if err != nil {
+		return nil, fmt.Errorf("err getting the statements: %w", err)
+	}
+	return docs, nil
+}
+
+// GetStatementDocument returns the monthly statement of the wallet for the month starting at month.
+func (s *App) GetStatementDocument(ctx context.Context, id int, month time.Time) (doc repository.StatementDocument, err error) {
+	ctx, span := tracing.Start(ctx, "App.GetStatementDocument", walletID(id))
+	defer tracing.End(span, &err)
+	doc, err = s.store.GetStatementDocument(ctx, id, month)
+	if err != nil {
+		return repository.StatementDocument{}, fmt.Errorf("err getting the statement: %w", err)
+	}
+	return doc, nil
+}
+
+// GenerateMonthlyStatements renders and stores the statements of the month starting at month for
+// every wallet that has none yet, so an interrupted run picks up where it stopped. A wallet that fails
+// is logged and skipped until the next run. It returns the number of statements stored.
+func (s *App) GenerateMonthlyStatements(ctx context.Context, month time.Time) (_ int, err error) {
+	ctx, span := tracing.Start(ctx, "App.GenerateMonthlyStatements", attribute.String("month", month.Format("2006-01")))
+	defer tracing.End(span, &err)
+	end := month.AddDate(0, 1, 0)
+	cnt, afterID := 0, 0
+	for {
+		ids, err := s.store.GetWalletsWithoutStatement(ctx, month, end, afterID, monthlyStatementBatch)
+		if err != nil {
+			return cnt, fmt.Errorf("err getting wallets without statement: %w", err)
+		}
+		for _, id := range ids {
+			afterID = id
+			pdf, signature, err := s.RenderStatement(ctx, id, month, end)
+			if err == nil {
+				err = s.store.SaveStatementDocument(ctx, repository.StatementDocument{
+					WalletId:    id,
+					PeriodStart: month,
+					PeriodEnd:   end,
+					Signature:   signature,
+					Pdf:         pdf,
+				})
+			}
+			if err != nil {
+				if ctx.Err() != nil {
+					return cnt, ctx.Err()
+				}
+				s.log.WithContext(ctx).Errorf("err generating statement of wallet %d for %s: %v", id, month.Format("2006-01"), err)
+				continue
+			}
+			cnt++
+		}
+		if len(ids) < monthlyStatementBatch {
+			return cnt, nil
+		}
+	}
+}
+
+// RunMonthlyStatements queues the generation of the statements of the previous month on start and
+// every interval until ctx is done, as long as a wallet has no statement of the month. Months are UTC
+// calendar months; while a generation of the month is queued or running no other one is queued.
+func (s *App) RunMonthlyStatements(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		now := time.Now().UTC()
+		month := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC).AddDate(0, -1, 0)
+		if err := s.queueMonthlyStatements(ctx, month); err != nil && ctx.Err() == nil {
+			s.log.Errorf("err queueing monthly statements: %v", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// queueMonthlyStatements queues the generation of the statements of the month starting at month unless
+// every wallet has its statement already.
+func (s *App) queueMonthlyStatements(ctx context.Context, month time.Time) error {
+	ids, err := s.store.GetWalletsWithoutStatement(ctx, month, month.AddDate(0, 1, 0), 0, 1)
+	if err != nil {
+		return fmt.Errorf("err getting wallets without statement: %w", err)
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+	_, err = s.EnqueueMonthlyStatements(ctx, month)
+	return err
+}
+
+// EnqueueMonthlyStatements queues the generation of the statements of the month starting at month,
+// unless one is queued or running already.
+func (s *App) EnqueueMonthlyStatements(ctx context.Context, month time.Time) (repository.Job, error) {
+	name := month.Format("2006-01")
+	key := repository.JobMonthlyStatements + ":" + name
+	return s.enqueue(ctx, repository.Job{Type: repository.JobMonthlyStatements, Key: &key}, statementsJob{Month: name})
+}
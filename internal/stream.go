@@ -0,0 +1,33 @@
+package internal
+
+import (
+	"context"
+	"fmt"
+
+	"EWallet/pkg/events"
+	"EWallet/pkg/tracing"
+)
+
+// RunEventStream feeds wallet subscriptions with committed events until ctx is done.
+func (s *App) RunEventStream(ctx context.Context) {
+	s.hub.Run(ctx)
+}
+
+// SubscribeWallet starts receiving live events of the wallet. The subscription must be released with UnsubscribeWallet.
+func (s *App) SubscribeWallet(id int) *events.Subscription {
+	return s.hub.Subscribe(id)
+}
+
+func (s *App) UnsubscribeWallet(sub *events.Subscription) {
+	s.hub.Unsubscribe(sub)
+}
+
+func (s *App) GetWalletEvents(ctx context.Context, id int, afterID int64, limit int) (batch []events.Event, err error) {
+	ctx, span := tracing.Start(ctx, "App.GetWalletEvents", walletID(id))
+	defer tracing.End(span, &err)
+	batch, err = s.store.GetWalletEvents(ctx, id, afterID, limit)
+	if err != nil {
+		return nil, fmt.Errorf("err getting wallet events: %w", err)
+	}
+	return batch, nil
+}
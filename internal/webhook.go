@@ -0,0 +1,109 @@
+package internal
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"EWallet/pkg/repository"
+	"EWallet/pkg/tracing"
+	"EWallet/pkg/webhooks"
+
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// CreateWebhook subscribes the user to events of the wallet. The returned subscription carries
+// the signing secret, which is not shown again.
+func (s *App) CreateWebhook(ctx context.Context, sub repository.WebhookSubscription) (_ repository.WebhookSubscription, err error) {
+	ctx, span := tracing.Start(ctx, "App.CreateWebhook", walletID(sub.WalletId))
+	defer tracing.End(span, &err)
+	if _, err = s.store.GetWallet(ctx, sub.WalletId); err != nil {
+		return repository.WebhookSubscription{}, fmt.Errorf("err getting the wallet: %w", err)
+	}
+	secret, err := webhooks.NewSecret()
+	if err != nil {
+		return repository.WebhookSubscription{}, err
+	}
+	sub.Secret = secret
+	sub, err = s.store.CreateWebhook(ctx, sub)
+	if err != nil {
+		return repository.WebhookSubscription{}, fmt.Errorf("err creating the webhook: %w", err)
+	}
+	return sub, nil
+}
+
+func (s *App) GetWebhooks(ctx context.Context, username string) (subs []repository.WebhookSubscription, err error) {
+	ctx, span := tracing.Start(ctx, "App.GetWebhooks")
+	defer tracing.End(span, &err)
+	subs, err = s.store.GetWebhooks(ctx, username)
+	if err != nil {
+		return nil, fmt.Errorf("err getting webhooks: %w", err)
+	}
+	return subs, nil
+}
+
+func (s *App) DeleteWebhook(ctx context.Context, username string, id int) (err error) {
+	ctx, span := tracing.Start(ctx, "App.DeleteWebhook", attribute.Int("webhook.id", id))
+	defer tracing.End(span, &err)
+	if err = s.store.DeleteWebhook(ctx, username, id); err != nil {
+		return fmt.Errorf("err deleting the webhook: %w", err)
+	}
+	return nil
+}
+
+// TestWebhook queues a WebhookTest delivery that the worker sends right away.
+func (s *App) TestWebhook(ctx context.Context, username string, id int) (_ repository.WebhookDelivery, err error) {
+	ctx, span := tracing.Start(ctx, "App.TestWebhook", attribute.Int("webhook.id", id))
+	defer tracing.End(span, &err)
+	sub, err := s.store.GetWebhook(ctx, username, id)
+	if err != nil {
+		return repository.WebhookDelivery{}, fmt.Errorf("err getting the webhook: %w", err)
+	}
+	payload, err := json.Marshal(map[string]interface{}{
+		"type":       webhooks.TestEvent,
+		"wallet_id":  sub.WalletId,
+		"created_at": time.Now(),
+	})
+	if err != nil {
+		return repository.WebhookDelivery{}, fmt.Errorf("err encoding the test event: %w", err)
+	}
+	d, err := s.store.CreateWebhookDelivery(ctx, sub.Id, webhooks.TestEvent, payload)
+	if err != nil {
+		return repository.WebhookDelivery{}, fmt.Errorf("err firing the webhook: %w", err)
+	}
+	return d, nil
+}
+
+func (s *App) GetWebhookDeliveries(ctx context.Context, username string, id int, status string) (deliveries []repository.WebhookDelivery, err error) {
+	ctx, span := tracing.Start(ctx, "App.GetWebhookDeliveries", attribute.Int("webhook.id", id))
+	defer tracing.End(span, &err)
+	if _, err = s.store.GetWebhook(ctx, username, id); err != nil {
+		return nil, fmt.Errorf("err getting the webhook: %w", err)
+	}
+	deliveries, err = s.store.GetWebhookDeliveries(ctx, id, status)
+	if err != nil {
+		return nil, fmt.Errorf("err getting webhook deliveries: %w", err)
+	}
+	return deliveries, nil
+}
+
+func (s *App) GetWebhookAttempts(ctx context.Context, username string, deliveryID int) (attempts []repository.WebhookAttempt, err error) {
+	ctx, span := tracing.Start(ctx, "App.GetWebhookAttempts", attribute.Int("webhook.delivery_id", deliveryID))
+	defer tracing.End(span, &err)
+	attempts, err = s.store.GetWebhookAttempts(ctx, username, deliveryID)
+	if err != nil {
+		return nil, fmt.Errorf("err getting webhook attempts: %w", err)
+	}
+	return attempts, nil
+}
+
+func (s *App) RedeliverWebhook(ctx context.Context, username string, deliveryID int) (d repository.WebhookDelivery, err error) {
+	ctx, span := tracing.Start(ctx, "App.RedeliverWebhook", attribute.Int("webhook.delivery_id", deliveryID))
+	defer tracing.End(span, &err)
+	d, err = s.store.RedeliverWebhook(ctx, username, deliveryID)
+	if err != nil {
+		return repository.WebhookDelivery{}, fmt.Errorf("err redelivering the webhook: %w", err)
+	}
+	return d, nil
+}
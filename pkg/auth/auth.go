@@ -0,0 +1,43 @@
+package auth
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+)
+
+const TokenExpireDuration = time.Hour * 1000000
+
+// Claims are the claims of the tokens accepted by the REST and gRPC APIs.
+type Claims struct {
+	Username string `json:"username"`
+	jwt.StandardClaims
+}
+
+// GenToken issues a token for username signed with secret.
+func GenToken(secret []byte, username string) (string, error) {
+	c := Claims{
+		username,
+		jwt.StandardClaims{
+			ExpiresAt: time.Now().Add(TokenExpireDuration).Unix(),
+			Issuer:    "e-wallet",
+		},
+	}
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, c)
+	return token.SignedString(secret)
+}
+
+// ParseToken checks the signature and the expiry of tokenString and returns its claims.
+func ParseToken(secret []byte, tokenString string) (*Claims, error) {
+	token, err := jwt.ParseWithClaims(tokenString, &Claims{}, func(token *jwt.Token) (i interface{}, err error) {
+		return secret, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if claims, ok := token.Claims.(*Claims); ok && token.Valid {
+		return claims, nil
+	}
+	return nil, fmt.Errorf("invalid token: %w", err)
+}
@@ -0,0 +1,454 @@
+// Package config loads the service settings. Every setting has a default and can be set in the YAML
+// file, in the environment and with a command line flag; later sources win.
+package config
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v3"
+)
+
+// redacted replaces the secrets in the printed configuration.
+const redacted = "xxxxx"
+
+var currencyCode = regexp.MustCompile(`^[A-Z]{3}$`)
+
+// Fields are tagged with their name in the YAML file, the environment variable and the flag usage. The
+// flag is named after the YAML path with dashes, e.g. -http.read-header-timeout. Secrets are tagged
+// with redact:"all", DSNs with redact:"password" to hide only the password.
+type Config struct {
+	HTTP        HTTP        `yaml:"http"`
+	GRPC        GRPC        `yaml:"grpc"`
+	DB          DB          `yaml:"db"`
+	Wallet      Wallet      `yaml:"wallet"`
+	Exchange    Exchange    `yaml:"exchange"`
+	Auth        Auth        `yaml:"auth"`
+	Log         Log         `yaml:"log"`
+	Tracing     Tracing     `yaml:"tracing"`
+	Idempotency Idempotency `yaml:"idempotency"`
+	Jobs        Jobs        `yaml:"jobs"`
+	Events      Events      `yaml:"events"`
+	Statements  Statements  `yaml:"statements"`
+	Health      Health      `yaml:"health"`
+	Features    Features    `yaml:"features"`
+}
+
+type HTTP struct {
+	Addr              string        `yaml:"addr" env:"HTTP_ADDR" usage:"listen address of the REST API"`
+	ReadHeaderTimeout time.Duration `yaml:"read_header_timeout" env:"HTTP_READ_HEADER_TIMEOUT" usage:"time to read the request headers"`
+	ReadTimeout       time.Duration `yaml:"read_timeout" env:"HTTP_READ_TIMEOUT" usage:"time to read the whole request"`
+	WriteTimeout      time.Duration `yaml:"write_timeout" env:"HTTP_WRITE_TIMEOUT" usage:"time to write the response, 0 for none (streams and exports are long)"`
+	IdleTimeout       time.Duration `yaml:"idle_timeout" env:"HTTP_IDLE_TIMEOUT" usage:"keep-alive time of idle connections"`
+	ShutdownTimeout   time.Duration `yaml:"shutdown_timeout" env:"HTTP_SHUTDOWN_TIMEOUT" usage:"time the in-flight requests are waited for on shutdown"`
+	ShutdownDelay     time.Duration `yaml:"shutdown_delay" env:"HTTP_SHUTDOWN_DELAY" usage:"time /readyz fails before the server stops accepting connections"`
+}
+
+type GRPC struct {
+	Addr            string        `yaml:"addr" env:"GRPC_ADDR" usage:"listen address of the gRPC API"`
+	APIKeys         string        `yaml:"api_keys" env:"GRPC_API_KEYS" redact:"all" usage:"username:key pairs separated by commas"`
+	ShutdownTimeout time.Duration `yaml:"shutdown_timeout" env:"GRPC_SHUTDOWN_TIMEOUT" usage:"time the in-flight calls are waited for on shutdown"`
+}
+
+type DB struct {
+	DSN             string        `yaml:"dsn" env:"PG_DSN" redact:"password" usage:"Postgres connection string"`
+	MaxOpenConns    int           `yaml:"max_open_conns" env:"DB_MAX_OPEN_CONNS" usage:"connections in the pool, 0 for no limit"`
+	MaxIdleConns    int           `yaml:"max_idle_conns" env:"DB_MAX_IDLE_CONNS" usage:"idle connections kept in the pool"`
+	ConnMaxLifetime time.Duration `yaml:"conn_max_lifetime" env:"DB_CONN_MAX_LIFETIME" usage:"time a connection is reused for, 0 for ever"`
+	ConnMaxIdleTime time.Duration `yaml:"conn_max_idle_time" env:"DB_CONN_MAX_IDLE_TIME" usage:"time an idle connection is kept, 0 for ever"`
+}
+
+type Wallet struct {
+	Currency string `yaml:"currency" env:"WALLET_CURRENCY" usage:"ISO 4217 code of the currency the balances are kept in"`
+}
+
+type Exchange struct {
+	Host    string        `yaml:"host" env:"XR_HOST" usage:"url of the exchange rate API the currency is appended to"`
+	APIKey  string        `yaml:"api_key" env:"API_KEY" redact:"all" usage:"key of the exchange rate API"`
+	Timeout time.Duration `yaml:"timeout" env:"EXCHANGE_TIMEOUT" usage:"timeout of the exchange rate requests"`
+}
+
+type Auth struct {
+	JWTSecret string   `yaml:"jwt_secret" env:"SECRET_JWT" redact:"all" usage:"key the JWT tokens are signed with"`
+	Admins    []string `yaml:"admins" env:"ADMIN_USERS" usage:"administrators, separated by commas"`
+}
+
+type Log struct {
+	Level  string `yaml:"level" env:"LOG_LEVEL" usage:"panic, fatal, error, warn, info, debug or trace"`
+	Format string `yaml:"format" env:"LOG_FORMAT" usage:"text or json"`
+}
+
+type Tracing struct {
+	Exporter    string  `yaml:"exporter" env:"TRACING_EXPORTER" usage:"none, stdout or otlp"`
+	Endpoint    string  `yaml:"endpoint" env:"TRACING_ENDPOINT" usage:"host:port of the OTLP gRPC collector"`
+	Insecure    bool    `yaml:"insecure" env:"TRACING_INSECURE" usage:"connect to the collector without TLS"`
+	SampleRatio float64 `yaml:"sample_ratio" env:"TRACING_SAMPLE_RATIO" usage:"share of the traces started here that are recorded, from 0 to 1"`
+}
+
+type Idempotency struct {
+	Retention time.Duration `yaml:"retention" env:"IDEMPOTENCY_RETENTION" usage:"time idempotency keys are kept"`
+	Lease     time.Duration `yaml:"lease" env:"IDEMPOTENCY_LEASE" usage:"time a request holds its idempotency key before a retry may take it over"`
+}
+
+type Jobs struct {
+	Concurrency       int           `yaml:"concurrency" env:"JOBS_CONCURRENCY" usage:"jobs run at once"`
+	PollInterval      time.Duration `yaml:"poll_interval" env:"JOBS_POLL_INTERVAL" usage:"interval the queue is polled at"`
+	VisibilityTimeout time.Duration `yaml:"visibility_timeout" env:"JOBS_VISIBILITY_TIMEOUT" usage:"time a claimed job is hidden from other workers"`
+	DrainTimeout      time.Duration `yaml:"drain_timeout" env:"JOBS_DRAIN_TIMEOUT" usage:"time the running jobs are waited for on shutdown"`
+	Retention         time.Duration `yaml:"retention" env:"JOBS_RETENTION" usage:"time finished jobs and their outputs are kept, 0 for ever"`
+}
+
+type Events struct {
+	Sink string `yaml:"sink" env:"EVENTS_SINK" usage:"stdout, file:<path> or an http(s) url"`
+}
+
+type Statements struct {
+	SigningKey string `yaml:"signing_key" env:"STATEMENT_KEY" redact:"all" usage:"key PDF statements are signed with, required for the monthly statements"`
+}
+
+type Health struct {
+	Timeout     time.Duration `yaml:"timeout" env:"HEALTH_TIMEOUT" usage:"timeout of each readiness check"`
+	ExchangeTTL time.Duration `yaml:"exchange_ttl" env:"HEALTH_EXCHANGE_TTL" usage:"time the exchange API check is reused for, it is a paid call"`
+}
+
+// Features switch parts of the service off.
+type Features struct {
+	GRPC              bool `yaml:"grpc" env:"FEATURE_GRPC" usage:"serve the gRPC API"`
+	Screening         bool `yaml:"screening" env:"FEATURE_SCREENING" usage:"screen operations with the fraud rules"`
+	Webhooks          bool `yaml:"webhooks" env:"FEATURE_WEBHOOKS" usage:"deliver events to webhooks"`
+	MonthlyStatements bool `yaml:"monthly_statements" env:"FEATURE_MONTHLY_STATEMENTS" usage:"generate the monthly PDF statements"`
+}
+
+// Default returns the settings used when no source sets them.
+func Default() Config {
+	return Config{
+		HTTP: HTTP{
+			Addr:              ":3000",
+			ReadHeaderTimeout: 5 * time.Second,
+			ReadTimeout:       time.Minute,
+			IdleTimeout:       2 * time.Minute,
+			ShutdownTimeout:   30 * time.Second,
+			ShutdownDelay:     5 * time.Second,
+		},
+		GRPC: GRPC{
+			Addr:            ":9090",
+			ShutdownTimeout: 30 * time.Second,
+		},
+		DB: DB{
+			MaxOpenConns:    20,
+			MaxIdleConns:    10,
+			ConnMaxLifetime: 30 * time.Minute,
+			ConnMaxIdleTime: 5 * time.Minute,
+		},
+		Wallet:      Wallet{Currency: "RUB"},
+		Exchange:    Exchange{Timeout: 10 * time.Second},
+		Log:         Log{Level: "info", Format: "json"},
+		Tracing:     Tracing{Exporter: "none", Endpoint: "localhost:4317", SampleRatio: 1},
+		Idempotency: Idempotency{Retention: 24 * time.Hour, Lease: time.Minute},
+		Jobs: Jobs{
+			Concurrency:       4,
+			PollInterval:      time.Second,
+			VisibilityTimeout: 5 * time.Minute,
+			DrainTimeout:      30 * time.Second,
+			Retention:         7 * 24 * time.Hour,
+		},
+		Events: Events{Sink: "stdout"},
+		Health: Health{Timeout: 2 * time.Second, ExchangeTTL: time.Minute},
+		Features: Features{
+			GRPC:              true,
+			Screening:         true,
+			Webhooks:          true,
+			MonthlyStatements: true,
+		},
+	}
+}
+
+// Load reads the settings: the defaults, the YAML file given by -config or CONFIG_FILE, the environment
+// and then the flags in args, which are parsed with flags. Empty environment variables are ignored.
+// The result is validated.
+func Load(flags *flag.FlagSet, args []string, getenv func(string) string) (Config, error) {
+	cfg := Default()
+	settings := fields(&cfg)
+	var set []flagValue
+	file := flags.String("config", "", "YAML configuration file, CONFIG_FILE by default")
+	for _, f := range settings {
+		flags.Var(&flagValue{field: f, set: &set, def: f.String()}, f.flag(), f.usage+" ($"+f.env+")")
+	}
+	if err := flags.Parse(args); err != nil {
+		return Config{}, err
+	}
+	if *file == "" {
+		*file = getenv("CONFIG_FILE")
+	}
+	if *file != "" {
+		if err := cfg.readFile(*file); err != nil {
+			return Config{}, err
+		}
+	}
+	for _, f := range settings {
+		if val := getenv(f.env); val != "" {
+			if err := f.set(val); err != nil {
+				return Config{}, fmt.Errorf("err parsing %s: %w", f.env, err)
+			}
+		}
+	}
+	for _, v := range set {
+		if err := v.field.set(v.value); err != nil {
+			return Config{}, fmt.Errorf("err parsing -%s: %w", v.field.flag(), err)
+		}
+	}
+	if err := cfg.Validate(); err != nil {
+		return Config{}, err
+	}
+	return cfg, nil
+}
+
+func (c *Config) readFile(name string) error {
+	data, err := os.ReadFile(name)
+	if err != nil {
+		return fmt.Errorf("err reading config file: %w", err)
+	}
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err = dec.Decode(c); err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("err parsing config file %s: %w", name, err)
+	}
+	return nil
+}
+
+// Validate checks the settings and reports every invalid one.
+func (c Config) Validate() error {
+	var problems []string
+	check := func(ok bool, format string, args ...interface{}) {
+		if !ok {
+			problems = append(problems, fmt.Sprintf(format, args...))
+		}
+	}
+	check(validAddr(c.HTTP.Addr), "http.addr: must be host:port")
+	check(c.HTTP.ReadHeaderTimeout >= 0 && c.HTTP.ReadTimeout >= 0 && c.HTTP.WriteTimeout >= 0 && c.HTTP.IdleTimeout >= 0,
+		"http: timeouts must not be negative")
+	check(c.HTTP.ShutdownTimeout > 0, "http.shutdown_timeout: must be positive")
+	check(c.HTTP.ShutdownDelay >= 0, "http.shutdown_delay: must not be negative")
+	if c.Features.GRPC {
+		check(validAddr(c.GRPC.Addr), "grpc.addr: must be host:port")
+		check(c.GRPC.ShutdownTimeout > 0, "grpc.shutdown_timeout: must be positive")
+		_, err := c.GRPC.Keys()
+		check(err == nil, "grpc.api_keys: %v", err)
+	}
+	check(c.DB.DSN != "", "db.dsn: is required")
+	check(c.DB.MaxOpenConns >= 0, "db.max_open_conns: must not be negative")
+	check(c.DB.MaxIdleConns >= 0, "db.max_idle_conns: must not be negative")
+	check(c.DB.MaxOpenConns == 0 || c.DB.MaxIdleConns <= c.DB.MaxOpenConns, "db.max_idle_conns: must not exceed db.max_open_conns")
+	check(c.DB.ConnMaxLifetime >= 0 && c.DB.ConnMaxIdleTime >= 0, "db: connection lifetimes must not be negative")
+	check(currencyCode.MatchString(c.Wallet.Currency), "wallet.currency: must be an ISO 4217 code, e.g. RUB")
+	if c.Exchange.Host != "" {
+		u, err := url.Parse(c.Exchange.Host)
+		check(err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != "", "exchange.host: must be an http(s) url")
+	}
+	check(c.Exchange.Timeout > 0, "exchange.timeout: must be positive")
+	check(c.Auth.JWTSecret != "", "auth.jwt_secret: is required")
+	_, err := logrus.ParseLevel(c.Log.Level)
+	check(err == nil, "log.level: must be one of panic, fatal, error, warn, info, debug, trace")
+	check(c.Log.Format == "text" || c.Log.Format == "json", "log.format: must be text or json")
+	check(c.Tracing.Exporter == "none" || c.Tracing.Exporter == "stdout" || c.Tracing.Exporter == "otlp",
+		"tracing.exporter: must be none, stdout or otlp")
+	if c.Tracing.Exporter == "otlp" {
+		check(validAddr(c.Tracing.Endpoint), "tracing.endpoint: must be host:port")
+	}
+	check(c.Tracing.SampleRatio >= 0 && c.Tracing.SampleRatio <= 1, "tracing.sample_ratio: must be from 0 to 1")
+	check(c.Idempotency.Retention > 0, "idempotency.retention: must be positive")
+	check(c.Idempotency.Lease > 0 && c.Idempotency.Lease <= c.Idempotency.Retention, "idempotency.lease: must be positive and not longer than the retention")
+	check(c.Jobs.Concurrency > 0, "jobs.concurrency: must be positive")
+	check(c.Jobs.PollInterval > 0 && c.Jobs.VisibilityTimeout > 0 && c.Jobs.DrainTimeout > 0, "jobs: intervals and timeouts must be positive")
+	check(c.Jobs.Retention >= 0, "jobs.retention: must not be negative")
+	if c.Features.MonthlyStatements {
+		check(c.Statements.SigningKey != "", "statements.signing_key: is required for the monthly statements")
+	}
+	check(c.Health.Timeout > 0, "health.timeout: must be positive")
+	check(c.Health.ExchangeTTL >= 0, "health.exchange_ttl: must not be negative")
+	sink := c.Events.Sink
+	check(sink == "stdout" || (strings.HasPrefix(sink, "file:") && len(sink) > len("file:")) ||
+		strings.HasPrefix(sink, "http://") || strings.HasPrefix(sink, "https://"),
+		"events.sink: must be stdout, file:<path> or an http(s) url")
+	if len(problems) > 0 {
+		return fmt.Errorf("invalid config: %s", strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+func validAddr(addr string) bool {
+	_, port, err := net.SplitHostPort(addr)
+	return err == nil && port != ""
+}
+
+// Keys parses the "username:key" pairs into a key to username map.
+func (g GRPC) Keys() (map[string]string, error) {
+	keys := make(map[string]string)
+	for _, pair := range strings.Split(g.APIKeys, ",") {
+		if pair == "" {
+			continue
+		}
+		parts := strings.SplitN(pair, ":", 2)
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			return nil, fmt.Errorf("invalid api key of %q", parts[0])
+		}
+		keys[parts[1]] = parts[0]
+	}
+	return keys, nil
+}
+
+var dsnPassword = regexp.MustCompile(`password=\S+`)
+
+// Redacted returns the settings as YAML with the secrets replaced, for printing.
+func (c Config) Redacted() string {
+	cp := c
+	for _, f := range fields(&cp) {
+		if f.value.Kind() != reflect.String || f.value.String() == "" {
+			continue
+		}
+		switch f.redact {
+		case "all":
+			f.value.SetString(redacted)
+		case "password":
+			if u, err := url.Parse(f.value.String()); err == nil && u.User != nil {
+				f.value.SetString(u.Redacted())
+			} else {
+				f.value.SetString(dsnPassword.ReplaceAllString(f.value.String(), "password="+redacted))
+			}
+		}
+	}
+	out, err := yaml.Marshal(cp)
+	if err != nil {
+		return fmt.Sprintf("err printing config: %v", err)
+	}
+	return string(out)
+}
+
+// field is a setting of Config.
+type field struct {
+	path   string
+	env    string
+	usage  string
+	redact string
+	value  reflect.Value
+}
+
+// fields lists the settings of c, values point into c.
+func fields(c *Config) []field {
+	var list []field
+	sections := reflect.ValueOf(c).Elem()
+	for i := 0; i < sections.NumField(); i++ {
+		section, st := sections.Field(i), sections.Type().Field(i)
+		for j := 0; j < section.NumField(); j++ {
+			sf := section.Type().Field(j)
+			list = append(list, field{
+				path:   st.Tag.Get("yaml") + "." + sf.Tag.Get("yaml"),
+				env:    sf.Tag.Get("env"),
+				usage:  sf.Tag.Get("usage"),
+				redact: sf.Tag.Get("redact"),
+				value:  section.Field(j),
+			})
+		}
+	}
+	return list
+}
+
+func (f field) flag() string {
+	return strings.ReplaceAll(f.path, "_", "-")
+}
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+func (f field) set(s string) error {
+	v := f.value
+	switch {
+	case v.Type() == durationType:
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			return err
+		}
+		v.SetInt(int64(d))
+	case v.Kind() == reflect.String:
+		v.SetString(s)
+	case v.Kind() == reflect.Int:
+		n, err := strconv.Atoi(s)
+		if err != nil {
+			return fmt.Errorf("%q is not an integer", s)
+		}
+		v.SetInt(int64(n))
+	case v.Kind() == reflect.Float64:
+		n, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return fmt.Errorf("%q is not a number", s)
+		}
+		v.SetFloat(n)
+	case v.Kind() == reflect.Bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return fmt.Errorf("%q is not a boolean", s)
+		}
+		v.SetBool(b)
+	case v.Kind() == reflect.Slice:
+		list := make([]string, 0)
+		for _, item := range strings.Split(s, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				list = append(list, item)
+			}
+		}
+		v.Set(reflect.ValueOf(list))
+	default:
+		return fmt.Errorf("unsupported setting type %s", v.Type())
+	}
+	return nil
+}
+
+// String formats the value the way set parses it.
+func (f field) String() string {
+	v := f.value
+	switch {
+	case f.redact != "":
+		return ""
+	case v.Type() == durationType:
+		return time.Duration(v.Int()).String()
+	case v.Kind() == reflect.Slice:
+		return strings.Join(v.Interface().([]string), ",")
+	}
+	return fmt.Sprint(v.Interface())
+}
+
+// flagValue records the flag to apply it after the file and the environment.
+type flagValue struct {
+	field field
+	set   *[]flagValue
+	def   string
+	value string
+}
+
+func (v *flagValue) String() string {
+	if v == nil {
+		return ""
+	}
+	return v.def
+}
+
+func (v *flagValue) Set(s string) error {
+	*v.set = append(*v.set, flagValue{field: v.field, value: s})
+	return nil
+}
+
+// IsBoolFlag lets the toggles be passed as -features.grpc=false or just -features.grpc.
+func (v *flagValue) IsBoolFlag() bool {
+	return v.field.value.Kind() == reflect.Bool
+}
@@ -0,0 +1,29 @@
+// Package ctxutil holds helpers for contexts shared by the APIs.
+package ctxutil
+
+import (
+	"context"
+	"time"
+)
+
+// Detach returns a context with the values of ctx but without its deadline and cancellation,
+// for the work that has to finish after the client is gone.
+func Detach(ctx context.Context) context.Context {
+	return detached{ctx}
+}
+
+type detached struct {
+	context.Context
+}
+
+func (detached) Deadline() (time.Time, bool) {
+	return time.Time{}, false
+}
+
+func (detached) Done() <-chan struct{} {
+	return nil
+}
+
+func (detached) Err() error {
+	return nil
+}
@@ -0,0 +1,84 @@
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	WalletCreated       = "WalletCreated"
+	WalletUpdated       = "WalletUpdated"
+	WalletFrozen        = "WalletFrozen"
+	WalletDeleted       = "WalletDeleted"
+	Deposited           = "Deposited"
+	Withdrawn           = "Withdrawn"
+	Transferred         = "Transferred"
+	TransactionReversed = "TransactionReversed"
+)
+
+// Event is a domain event stored in the outbox in the same DB transaction as the change it describes.
+// TargetId is the other wallet of a transfer.
+type Event struct {
+	Id        int64           `json:"id" db:"id"`
+	Type      string          `json:"type" db:"type"`
+	WalletId  int             `json:"wallet_id" db:"wallet_id"`
+	TargetId  *int            `json:"target_id,omitempty" db:"target_id"`
+	Payload   json.RawMessage `json:"payload" db:"payload"`
+	CreatedAt time.Time       `json:"created_at" db:"created_at"`
+}
+
+// Sink receives events in the txid order of the transactions writing them. An error makes the dispatcher redeliver the whole batch later.
+type Sink interface {
+	Publish(ctx context.Context, events []Event) error
+}
+
+type Outbox interface {
+	// DispatchEvents passes up to limit unpublished events to publish and marks them published when it succeeds.
+	// An event is never passed after an event written after it.
+	DispatchEvents(ctx context.Context, limit int, publish func(ctx context.Context, events []Event) error) (int, error)
+}
+
+type Dispatcher struct {
+	log      *logrus.Entry
+	outbox   Outbox
+	sink     Sink
+	interval time.Duration
+	batch    int
+}
+
+func NewDispatcher(log *logrus.Logger, outbox Outbox, sink Sink, interval time.Duration, batch int) *Dispatcher {
+	return &Dispatcher{
+		log:      log.WithField("component", "dispatcher"),
+		outbox:   outbox,
+		sink:     sink,
+		interval: interval,
+		batch:    batch,
+	}
+}
+
+// Run publishes outbox events until ctx is done. Delivery is at least once: a batch is marked
+// published only after the sink accepted it.
+func (d *Dispatcher) Run(ctx context.Context) {
+	ticker := time.NewTicker(d.interval)
+	defer ticker.Stop()
+	for {
+		for {
+			cnt, err := d.outbox.DispatchEvents(ctx, d.batch, d.sink.Publish)
+			if err != nil {
+				d.log.Errorf("err dispatching events: %v", err)
+				break
+			}
+			if cnt < d.batch {
+				break
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,127 @@
+package events
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// WriterSink writes events as JSON lines, e.g. to stdout or a file.
+type WriterSink struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func NewWriterSink(w io.Writer) *WriterSink {
+	return &WriterSink{w: w}
+}
+
+// NewFileSink appends events to the file at path.
+func NewFileSink(path string) (*WriterSink, error) {
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("err opening events file: %w", err)
+	}
+	return NewWriterSink(f), nil
+}
+
+func (s *WriterSink) Publish(_ context.Context, events []Event) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	enc := json.NewEncoder(s.w)
+	for _, e := range events {
+		if err := enc.Encode(e); err != nil {
+			return fmt.Errorf("err writing event %d: %w", e.Id, err)
+		}
+	}
+	return nil
+}
+
+// HTTPSink posts every batch as a JSON array to url.
+type HTTPSink struct {
+	url    string
+	client *http.Client
+}
+
+func NewHTTPSink(url string) *HTTPSink {
+	return &HTTPSink{
+		url:    url,
+		client: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+func (s *HTTPSink) Publish(ctx context.Context, events []Event) error {
+	body, err := json.Marshal(events)
+	if err != nil {
+		return fmt.Errorf("err encoding events: %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.url, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("err creating request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	res, err := s.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("err posting events: %w", err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status code: %d", res.StatusCode)
+	}
+	return nil
+}
+
+// Publisher is the subset of a NATS or Kafka client the BrokerSink needs.
+// NATS clients can ignore the key, Kafka clients use it for partitioning.
+type Publisher interface {
+	Publish(ctx context.Context, topic string, key, value []byte) error
+}
+
+// BrokerSink publishes every event to topic prefix.<event type> keyed by the wallet id,
+// so events of one wallet keep their order within a partition.
+type BrokerSink struct {
+	publisher Publisher
+	prefix    string
+}
+
+func NewBrokerSink(publisher Publisher, prefix string) *BrokerSink {
+	return &BrokerSink{publisher: publisher, prefix: prefix}
+}
+
+func (s *BrokerSink) Publish(ctx context.Context, events []Event) error {
+	for _, e := range events {
+		value, err := json.Marshal(e)
+		if err != nil {
+			return fmt.Errorf("err encoding event %d: %w", e.Id, err)
+		}
+		key := []byte(fmt.Sprint(e.WalletId))
+		if err = s.publisher.Publish(ctx, s.prefix+"."+e.Type, key, value); err != nil {
+			return fmt.Errorf("err publishing event %d: %w", e.Id, err)
+		}
+	}
+	return nil
+}
+
+// MultiSink publishes every batch to all sinks in order.
+type MultiSink struct {
+	sinks []Sink
+}
+
+func NewMultiSink(sinks ...Sink) *MultiSink {
+	return &MultiSink{sinks: sinks}
+}
+
+func (s *MultiSink) Publish(ctx context.Context, events []Event) error {
+	for _, sink := range s.sinks {
+		if err := sink.Publish(ctx, events); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,124 @@
+package events
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	subscriptionBuffer = 64
+	reconnectDelay     = time.Second
+)
+
+// Feed is the source of committed events.
+type Feed interface {
+	// ListenEvents calls notify with the id of every event committed while it runs.
+	ListenEvents(ctx context.Context, notify func(id int64)) error
+	GetEvent(ctx context.Context, id int64) (Event, error)
+}
+
+// Touches reports whether the event concerns the wallet, either as its subject or as a transfer target.
+func (e Event) Touches(walletID int) bool {
+	return e.WalletId == walletID || e.TargetId != nil && *e.TargetId == walletID
+}
+
+// Subscription receives live events of one wallet. C is closed when the subscriber falls behind
+// or the hub stops; the client is expected to resume from the last event it saw.
+type Subscription struct {
+	C        <-chan Event
+	c        chan Event
+	walletID int
+}
+
+// Hub fans committed events out to wallet subscriptions.
+type Hub struct {
+	log  *logrus.Entry
+	feed Feed
+	mu   sync.Mutex
+	subs map[*Subscription]struct{}
+}
+
+func NewHub(log *logrus.Logger, feed Feed) *Hub {
+	return &Hub{
+		log:  log.WithField("component", "stream"),
+		feed: feed,
+		subs: make(map[*Subscription]struct{}),
+	}
+}
+
+// Run listens to the feed until ctx is done, reconnecting when the listener fails.
+func (h *Hub) Run(ctx context.Context) {
+	defer h.closeAll()
+	for {
+		if err := h.feed.ListenEvents(ctx, func(id int64) { h.notify(ctx, id) }); err != nil {
+			h.log.Errorf("err listening to events: %v", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(reconnectDelay):
+		}
+	}
+}
+
+func (h *Hub) Subscribe(walletID int) *Subscription {
+	c := make(chan Event, subscriptionBuffer)
+	sub := &Subscription{C: c, c: c, walletID: walletID}
+	h.mu.Lock()
+	h.subs[sub] = struct{}{}
+	h.mu.Unlock()
+	return sub
+}
+
+func (h *Hub) Unsubscribe(sub *Subscription) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if _, ok := h.subs[sub]; ok {
+		delete(h.subs, sub)
+		close(sub.c)
+	}
+}
+
+func (h *Hub) notify(ctx context.Context, id int64) {
+	h.mu.Lock()
+	empty := len(h.subs) == 0
+	h.mu.Unlock()
+	if empty {
+		return
+	}
+	e, err := h.feed.GetEvent(ctx, id)
+	if err != nil {
+		h.log.Errorf("err getting event: %v", err)
+		return
+	}
+	h.Broadcast(e)
+}
+
+// Broadcast passes the event to every subscription of the wallets it touches.
+func (h *Hub) Broadcast(e Event) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for sub := range h.subs {
+		if !e.Touches(sub.walletID) {
+			continue
+		}
+		select {
+		case sub.c <- e:
+		default:
+			delete(h.subs, sub)
+			close(sub.c)
+		}
+	}
+}
+
+func (h *Hub) closeAll() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for sub := range h.subs {
+		delete(h.subs, sub)
+		close(sub.c)
+	}
+}
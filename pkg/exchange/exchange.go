@@ -6,20 +6,29 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"net"
 	"net/http"
+	"strings"
 	"time"
 
+	"EWallet/pkg/logger"
 	"EWallet/pkg/metrics"
 
 	"github.com/sirupsen/logrus"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
 )
 
 var ErrCurrencyNotFound = errors.New("err currency not found")
 
+// DefaultBaseCurrency is the currency amounts are converted from unless set otherwise.
+const DefaultBaseCurrency = "RUB"
+
 type Rate struct {
 	log    *logrus.Entry
 	xrHost string
 	apiKey string
+	base   string
+	client *http.Client
 }
 type Resp struct {
 	Success bool `json:"success"`
@@ -40,30 +49,46 @@ type Resp struct {
 	} `json:"error,omitempty"`
 }
 
-func NewExchangeRate(log *logrus.Logger, xrHost string, apiKey string) *Rate {
+// NewExchangeRate creates the exchange rate client, requests taking longer than timeout fail. Requests
+// are traced and carry the trace context to the provider.
+func NewExchangeRate(log *logrus.Logger, xrHost string, apiKey string, timeout time.Duration) *Rate {
+	transport := otelhttp.NewTransport(http.DefaultTransport, otelhttp.WithSpanNameFormatter(func(_ string, r *http.Request) string {
+		return "exchange " + r.Method
+	}))
 	return &Rate{
 		log:    log.WithField("component", "exchange"),
 		xrHost: xrHost,
 		apiKey: apiKey,
+		base:   DefaultBaseCurrency,
+		client: &http.Client{Timeout: timeout, Transport: transport},
 	}
 }
 
+// SetBaseCurrency replaces DefaultBaseCurrency, it is the currency the wallet balances are kept in.
+func (e *Rate) SetBaseCurrency(currency string) {
+	e.base = currency
+}
+
 func (e *Rate) GetRate(ctx context.Context, currency string, amount float64) (float64, error) {
 	started := time.Now()
+	outcome := "error"
 	defer func() {
-		metrics.MetricHTTPRequestDuration.Observe(time.Since(started).Seconds())
+		metrics.MetricExchangeRequestDuration.WithLabelValues("GetRate", outcome).Observe(time.Since(started).Seconds())
 	}()
 	amountStr := fmt.Sprintf("%v", amount)
-	url := e.xrHost + currency + "&from=rub&amount=" + amountStr
-	fmt.Println(url)
-	client := &http.Client{}
+	url := e.xrHost + currency + "&from=" + strings.ToLower(e.base) + "&amount=" + amountStr
+	e.log.WithContext(ctx).Debugf("requesting rate: %s", url)
 	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
-	req.Header.Set("apikey", e.apiKey)
 	if err != nil {
-		fmt.Println(err)
+		return 0, fmt.Errorf("err creating request: %w", err)
+	}
+	req.Header.Set("apikey", e.apiKey)
+	if id := logger.RequestID(ctx); id != "" {
+		req.Header.Set("X-Request-ID", id)
 	}
-	res, err := client.Do(req)
+	res, err := e.client.Do(req)
 	if err != nil {
+		metrics.MetricExchangeErrors.WithLabelValues("GetRate", failureReason(err)).Inc()
 		return 1.0, fmt.Errorf("exchange api internal srver error: %w", err)
 	}
 	if res.Body != nil {
@@ -73,9 +98,10 @@ func (e *Rate) GetRate(ctx context.Context, currency string, amount float64) (fl
 	switch res.StatusCode {
 	case http.StatusOK:
 	case http.StatusNotFound:
+		outcome = "not_found"
 		return 0, fmt.Errorf("%s: %w", currency, ErrCurrencyNotFound)
 	default:
-		metrics.MetricErrCount.WithLabelValues("GetRate").Inc()
+		metrics.MetricExchangeErrors.WithLabelValues("GetRate", "status").Inc()
 		body, err := io.ReadAll(res.Body)
 		if err != nil {
 			return 0, fmt.Errorf("err handling another error (unexpected status code: %d),fail to read response body: %w", res.StatusCode, err)
@@ -85,7 +111,45 @@ func (e *Rate) GetRate(ctx context.Context, currency string, amount float64) (fl
 	var result Resp
 	err = json.NewDecoder(res.Body).Decode(&result)
 	if err != nil {
+		metrics.MetricExchangeErrors.WithLabelValues("GetRate", "decode").Inc()
 		return 0, fmt.Errorf("err decoding response: %w", err)
 	}
+	outcome = "ok"
 	return result.Result, nil
 }
+
+// Ping checks the provider answers. Any response but a server error counts, an invalid key is reported
+// by the requests themselves.
+func (e *Rate) Ping(ctx context.Context) error {
+	started := time.Now()
+	outcome := "error"
+	defer func() {
+		metrics.MetricExchangeRequestDuration.WithLabelValues("Ping", outcome).Observe(time.Since(started).Seconds())
+	}()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, e.xrHost+"usd&from="+strings.ToLower(e.base)+"&amount=1", nil)
+	if err != nil {
+		return fmt.Errorf("err creating request: %w", err)
+	}
+	req.Header.Set("apikey", e.apiKey)
+	res, err := e.client.Do(req)
+	if err != nil {
+		metrics.MetricExchangeErrors.WithLabelValues("Ping", failureReason(err)).Inc()
+		return fmt.Errorf("exchange api unreachable: %w", err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode >= http.StatusInternalServerError {
+		metrics.MetricExchangeErrors.WithLabelValues("Ping", "status").Inc()
+		return fmt.Errorf("exchange api unavailable: status code %d", res.StatusCode)
+	}
+	outcome = "ok"
+	return nil
+}
+
+// failureReason is the metric label of a request that got no response.
+func failureReason(err error) string {
+	var netErr net.Error
+	if errors.Is(err, context.DeadlineExceeded) || errors.As(err, &netErr) && netErr.Timeout() {
+		return "timeout"
+	}
+	return "transport"
+}
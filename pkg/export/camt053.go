@@ -0,0 +1,165 @@
+package export
+
+import (
+	"encoding/xml"
+	"io"
+	"math"
+	"strconv"
+	"time"
+
+	"EWallet/pkg/repository"
+)
+
+const camt053Namespace = "urn:iso:std:iso:20022:tech:xsd:camt.053.001.02"
+
+type camtAmount struct {
+	Ccy   string `xml:"Ccy,attr"`
+	Value string `xml:",chardata"`
+}
+
+type camtDateTime struct {
+	DtTm string `xml:"DtTm"`
+}
+
+type camtGroupHeader struct {
+	XMLName xml.Name `xml:"GrpHdr"`
+	MsgId   string   `xml:"MsgId"`
+	CreDtTm string   `xml:"CreDtTm"`
+}
+
+type camtPeriod struct {
+	XMLName xml.Name `xml:"FrToDt"`
+	FrDtTm  string   `xml:"FrDtTm"`
+	ToDtTm  string   `xml:"ToDtTm"`
+}
+
+type camtAccount struct {
+	XMLName  xml.Name `xml:"Acct"`
+	Id       string   `xml:"Id>Othr>Id"`
+	Ccy      string   `xml:"Ccy"`
+	Owner    string   `xml:"Ownr>Nm,omitempty"`
+	Servicer string   `xml:"Svcr>FinInstnId>Nm"`
+}
+
+type camtBalance struct {
+	XMLName   xml.Name     `xml:"Bal"`
+	Code      string       `xml:"Tp>CdOrPrtry>Cd"`
+	Amt       camtAmount   `xml:"Amt"`
+	CdtDbtInd string       `xml:"CdtDbtInd"`
+	Dt        camtDateTime `xml:"Dt"`
+}
+
+type camtEntry struct {
+	XMLName     xml.Name     `xml:"Ntry"`
+	Amt         camtAmount   `xml:"Amt"`
+	CdtDbtInd   string       `xml:"CdtDbtInd"`
+	RvslInd     bool         `xml:"RvslInd,omitempty"`
+	Sts         string       `xml:"Sts"`
+	BookgDt     camtDateTime `xml:"BookgDt"`
+	ValDt       camtDateTime `xml:"ValDt"`
+	AcctSvcrRef string       `xml:"AcctSvcrRef"`
+	Code        string       `xml:"BkTxCd>Prtry>Cd"`
+	Issuer      string       `xml:"BkTxCd>Prtry>Issr"`
+	EndToEndId  string       `xml:"NtryDtls>TxDtls>Refs>EndToEndId"`
+}
+
+// Camt053 writes an ISO 20022 camt.053.001.02 bank to customer statement for banking systems.
+type Camt053 struct {
+	enc *xml.Encoder
+	w   io.Writer
+	ccy string
+}
+
+func NewCamt053(w io.Writer) *Camt053 {
+	return &Camt053{enc: xml.NewEncoder(w), w: w}
+}
+
+func (c *Camt053) Begin(s repository.Statement) error {
+	c.ccy = s.Currency
+	if _, err := io.WriteString(c.w, xml.Header); err != nil {
+		return err
+	}
+	err := c.enc.EncodeToken(xml.StartElement{
+		Name: xml.Name{Local: "Document"},
+		Attr: []xml.Attr{{Name: xml.Name{Local: "xmlns"}, Value: camt053Namespace}},
+	})
+	if err != nil {
+		return err
+	}
+	if err = c.start("BkToCstmrStmt"); err != nil {
+		return err
+	}
+	now := time.Now().UTC()
+	id := strconv.Itoa(s.WalletId) + "-" + s.From.UTC().Format("20060102") + "-" + s.To.UTC().Format("20060102")
+	err = c.enc.Encode(camtGroupHeader{MsgId: bankID + "-" + id + "-" + now.Format("20060102150405"), CreDtTm: camtTime(now)})
+	if err != nil {
+		return err
+	}
+	if err = c.start("Stmt"); err != nil {
+		return err
+	}
+	for _, el := range []struct{ name, value string }{{"Id", id}, {"CreDtTm", camtTime(now)}} {
+		if err = c.enc.EncodeElement(el.value, xml.StartElement{Name: xml.Name{Local: el.name}}); err != nil {
+			return err
+		}
+	}
+	if err = c.enc.Encode(camtPeriod{FrDtTm: camtTime(s.From), ToDtTm: camtTime(s.To)}); err != nil {
+		return err
+	}
+	err = c.enc.Encode(camtAccount{Id: strconv.Itoa(s.WalletId), Ccy: s.Currency, Owner: s.Owner, Servicer: bankID})
+	if err != nil {
+		return err
+	}
+	if err = c.enc.Encode(c.balance("OPBD", s.OpeningBalance, s.From)); err != nil {
+		return err
+	}
+	return c.enc.Encode(c.balance("CLBD", s.ClosingBalance, s.To))
+}
+
+func (c *Camt053) Entry(e repository.StatementEntry) error {
+	return c.enc.Encode(camtEntry{
+		Amt:         camtAmount{Ccy: c.ccy, Value: formatAmount(math.Abs(e.Amount))},
+		CdtDbtInd:   creditDebit(e.Amount),
+		RvslInd:     e.Status == repository.StatusReversed,
+		Sts:         "BOOK",
+		BookgDt:     camtDateTime{DtTm: camtTime(e.Date)},
+		ValDt:       camtDateTime{DtTm: camtTime(e.Date)},
+		AcctSvcrRef: strconv.Itoa(e.TransactionId) + "-" + e.Status,
+		Code:        e.Operation,
+		Issuer:      bankID,
+		EndToEndId:  e.UUID,
+	})
+}
+
+func (c *Camt053) End() error {
+	for _, name := range []string{"Stmt", "BkToCstmrStmt", "Document"} {
+		if err := c.enc.EncodeToken(xml.EndElement{Name: xml.Name{Local: name}}); err != nil {
+			return err
+		}
+	}
+	return c.enc.Flush()
+}
+
+func (c *Camt053) start(name string) error {
+	return c.enc.EncodeToken(xml.StartElement{Name: xml.Name{Local: name}})
+}
+
+func (c *Camt053) balance(code string, amount float64, at time.Time) camtBalance {
+	return camtBalance{
+		Code:      code,
+		Amt:       camtAmount{Ccy: c.ccy, Value: formatAmount(math.Abs(amount))},
+		CdtDbtInd: creditDebit(amount),
+		Dt:        camtDateTime{DtTm: camtTime(at)},
+	}
+}
+
+func creditDebit(amount float64) string {
+	if amount < 0 {
+		return "DBIT"
+	}
+	return "CRDT"
+}
+
+func camtTime(t time.Time) string {
+	return t.UTC().Format("2006-01-02T15:04:05Z")
+}
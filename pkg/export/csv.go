@@ -0,0 +1,70 @@
+package export
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+
+	"EWallet/pkg/repository"
+)
+
+// Columns lists the CSV columns in their default order.
+var Columns = []string{"date", "transaction_id", "uuid", "operation", "status", "counterparty", "amount", "balance"}
+
+var columnValues = map[string]func(e repository.StatementEntry) string{
+	"date":           func(e repository.StatementEntry) string { return e.Date.UTC().Format(time.RFC3339) },
+	"transaction_id": func(e repository.StatementEntry) string { return strconv.Itoa(e.TransactionId) },
+	"uuid":           func(e repository.StatementEntry) string { return e.UUID },
+	"operation":      func(e repository.StatementEntry) string { return e.Operation },
+	"status":         func(e repository.StatementEntry) string { return e.Status },
+	"counterparty": func(e repository.StatementEntry) string {
+		if e.Counterparty == nil {
+			return ""
+		}
+		return strconv.Itoa(*e.Counterparty)
+	},
+	"amount":  func(e repository.StatementEntry) string { return formatAmount(e.Amount) },
+	"balance": func(e repository.StatementEntry) string { return formatAmount(e.Balance) },
+}
+
+// CSV writes a header row and a row per entry.
+type CSV struct {
+	w       *csv.Writer
+	columns []string
+}
+
+func NewCSV(w io.Writer, columns []string) (*CSV, error) {
+	if len(columns) == 0 {
+		columns = Columns
+	}
+	for _, c := range columns {
+		if _, ok := columnValues[c]; !ok {
+			return nil, fmt.Errorf("%s: %w", c, ErrUnknownColumn)
+		}
+	}
+	return &CSV{w: csv.NewWriter(w), columns: columns}, nil
+}
+
+func (c *CSV) Begin(_ repository.Statement) error {
+	return c.w.Write(c.columns)
+}
+
+func (c *CSV) Entry(e repository.StatementEntry) error {
+	record := make([]string, len(c.columns))
+	for i, column := range c.columns {
+		record[i] = columnValues[column](e)
+	}
+	// The csv writer flushes its buffer as it fills up, so rows are streamed.
+	return c.w.Write(record)
+}
+
+func (c *CSV) End() error {
+	c.w.Flush()
+	return c.w.Error()
+}
+
+func formatAmount(amount float64) string {
+	return strconv.FormatFloat(amount, 'f', 2, 64)
+}
@@ -0,0 +1,103 @@
+// Package export writes wallet statements in the formats accounting and banking tools import.
+// Writers receive the statement header first and then the entries one by one, so a history of
+// any length is written without being held in memory; only the PDF is assembled before it is written.
+package export
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"strings"
+
+	"EWallet/pkg/repository"
+)
+
+const (
+	FormatCSV     = "csv"
+	FormatOFX     = "ofx"
+	FormatCamt053 = "camt053"
+	FormatPDF     = "pdf"
+)
+
+var (
+	ErrUnknownFormat = errors.New("err unknown export format")
+	ErrUnknownColumn = errors.New("err unknown export column")
+)
+
+// Writer writes a statement. Begin is called once before the entries and End once after them.
+type Writer interface {
+	Begin(s repository.Statement) error
+	Entry(e repository.StatementEntry) error
+	End() error
+}
+
+type format struct {
+	contentType string
+	extension   string
+}
+
+var formats = map[string]format{
+	FormatCSV:     {"text/csv; charset=utf-8", "csv"},
+	FormatOFX:     {"application/x-ofx", "ofx"},
+	FormatCamt053: {"application/xml", "xml"},
+	FormatPDF:     {"application/pdf", "pdf"},
+}
+
+// mediaTypes maps the media types of the Accept header to the formats.
+var mediaTypes = map[string]string{
+	"text/csv":                              FormatCSV,
+	"application/x-ofx":                     FormatOFX,
+	"application/vnd.intu.ofx":              FormatOFX,
+	"application/xml":                       FormatCamt053,
+	"text/xml":                              FormatCamt053,
+	"application/vnd.iso20022.camt.053+xml": FormatCamt053,
+	"application/pdf":                       FormatPDF,
+}
+
+// Negotiate picks the format from the explicit format name or, when it is empty, from the Accept header.
+// CSV is the default.
+func Negotiate(name, accept string) (string, error) {
+	if name != "" {
+		if _, ok := formats[name]; !ok {
+			return "", fmt.Errorf("%s: %w", name, ErrUnknownFormat)
+		}
+		return name, nil
+	}
+	for _, part := range strings.Split(accept, ",") {
+		mediaType, _, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		if f, ok := mediaTypes[mediaType]; ok {
+			return f, nil
+		}
+	}
+	return FormatCSV, nil
+}
+
+// ContentType is the Content-Type of the format.
+func ContentType(name string) string {
+	return formats[name].contentType
+}
+
+// Filename is the attachment name of the statement of the wallet in the format.
+func Filename(name string, walletID int) string {
+	return fmt.Sprintf("wallet-%d-statement.%s", walletID, formats[name].extension)
+}
+
+// New returns the writer of the format. Columns select and order the CSV columns, all columns are
+// written when there are none; other formats ignore them. Key signs PDF statements.
+func New(name string, w io.Writer, columns []string, key []byte) (Writer, error) {
+	switch name {
+	case FormatCSV:
+		return NewCSV(w, columns)
+	case FormatOFX:
+		return NewOFX(w), nil
+	case FormatCamt053:
+		return NewCamt053(w), nil
+	case FormatPDF:
+		return NewPDF(w, key), nil
+	}
+	return nil, fmt.Errorf("%s: %w", name, ErrUnknownFormat)
+}
@@ -0,0 +1,158 @@
+package export
+
+import (
+	"encoding/xml"
+	"io"
+	"strconv"
+	"time"
+
+	"EWallet/pkg/repository"
+)
+
+const (
+	ofxHeader     = `<?OFX OFXHEADER="200" VERSION="211" SECURITY="NONE" OLDFILEUID="NONE" NEWFILEUID="NONE"?>`
+	ofxTimeFormat = "20060102150405"
+	// bankID identifies the service as the account servicer in OFX and camt.053.
+	bankID = "EWALLET"
+)
+
+type ofxStatus struct {
+	Code     int    `xml:"CODE"`
+	Severity string `xml:"SEVERITY"`
+}
+
+type ofxSignOn struct {
+	XMLName  xml.Name  `xml:"SIGNONMSGSRSV1"`
+	Status   ofxStatus `xml:"SONRS>STATUS"`
+	DTServer string    `xml:"SONRS>DTSERVER"`
+	Language string    `xml:"SONRS>LANGUAGE"`
+}
+
+type ofxAccount struct {
+	XMLName  xml.Name `xml:"BANKACCTFROM"`
+	BankID   string   `xml:"BANKID"`
+	AcctID   string   `xml:"ACCTID"`
+	AcctType string   `xml:"ACCTTYPE"`
+}
+
+type ofxTransaction struct {
+	XMLName  xml.Name `xml:"STMTTRN"`
+	TrnType  string   `xml:"TRNTYPE"`
+	DTPosted string   `xml:"DTPOSTED"`
+	TrnAmt   string   `xml:"TRNAMT"`
+	FitID    string   `xml:"FITID"`
+	Name     string   `xml:"NAME"`
+	Memo     string   `xml:"MEMO,omitempty"`
+}
+
+type ofxBalance struct {
+	XMLName xml.Name `xml:"LEDGERBAL"`
+	BalAmt  string   `xml:"BALAMT"`
+	DTAsOf  string   `xml:"DTASOF"`
+}
+
+// OFX writes an OFX 2.1.1 bank statement for personal finance apps.
+type OFX struct {
+	enc       *xml.Encoder
+	w         io.Writer
+	statement repository.Statement
+}
+
+func NewOFX(w io.Writer) *OFX {
+	return &OFX{enc: xml.NewEncoder(w), w: w}
+}
+
+func (o *OFX) Begin(s repository.Statement) error {
+	o.statement = s
+	if _, err := io.WriteString(o.w, xml.Header+ofxHeader+"\n"); err != nil {
+		return err
+	}
+	if err := o.start("OFX"); err != nil {
+		return err
+	}
+	err := o.enc.Encode(ofxSignOn{
+		Status:   ofxStatus{Code: 0, Severity: "INFO"},
+		DTServer: time.Now().UTC().Format(ofxTimeFormat),
+		Language: "ENG",
+	})
+	if err != nil {
+		return err
+	}
+	for _, name := range []string{"BANKMSGSRSV1", "STMTTRNRS"} {
+		if err = o.start(name); err != nil {
+			return err
+		}
+	}
+	if err = o.enc.EncodeElement("0", xml.StartElement{Name: xml.Name{Local: "TRNUID"}}); err != nil {
+		return err
+	}
+	if err = o.enc.EncodeElement(ofxStatus{Code: 0, Severity: "INFO"}, xml.StartElement{Name: xml.Name{Local: "STATUS"}}); err != nil {
+		return err
+	}
+	if err = o.start("STMTRS"); err != nil {
+		return err
+	}
+	if err = o.enc.EncodeElement(s.Currency, xml.StartElement{Name: xml.Name{Local: "CURDEF"}}); err != nil {
+		return err
+	}
+	if err = o.enc.Encode(ofxAccount{BankID: bankID, AcctID: strconv.Itoa(s.WalletId), AcctType: "CHECKING"}); err != nil {
+		return err
+	}
+	if err = o.start("BANKTRANLIST"); err != nil {
+		return err
+	}
+	if err = o.enc.EncodeElement(s.From.UTC().Format(ofxTimeFormat), xml.StartElement{Name: xml.Name{Local: "DTSTART"}}); err != nil {
+		return err
+	}
+	return o.enc.EncodeElement(s.To.UTC().Format(ofxTimeFormat), xml.StartElement{Name: xml.Name{Local: "DTEND"}})
+}
+
+func (o *OFX) Entry(e repository.StatementEntry) error {
+	trnType := "CREDIT"
+	if e.Amount < 0 {
+		trnType = "DEBIT"
+	}
+	if e.Operation == "transfer" {
+		trnType = "XFER"
+	}
+	memo := e.UUID
+	if e.Status == repository.StatusReversed {
+		memo = "reversal of " + e.UUID
+	}
+	return o.enc.Encode(ofxTransaction{
+		TrnType:  trnType,
+		DTPosted: e.Date.UTC().Format(ofxTimeFormat),
+		TrnAmt:   formatAmount(e.Amount),
+		// A reversed transaction has two entries, the status keeps their ids apart.
+		FitID: strconv.Itoa(e.TransactionId) + "-" + e.Status,
+		Name:  e.Operation,
+		Memo:  memo,
+	})
+}
+
+func (o *OFX) End() error {
+	if err := o.end("BANKTRANLIST"); err != nil {
+		return err
+	}
+	err := o.enc.Encode(ofxBalance{
+		BalAmt: formatAmount(o.statement.ClosingBalance),
+		DTAsOf: o.statement.To.UTC().Format(ofxTimeFormat),
+	})
+	if err != nil {
+		return err
+	}
+	for _, name := range []string{"STMTRS", "STMTTRNRS", "BANKMSGSRSV1", "OFX"} {
+		if err = o.end(name); err != nil {
+			return err
+		}
+	}
+	return o.enc.Flush()
+}
+
+func (o *OFX) start(name string) error {
+	return o.enc.EncodeToken(xml.StartElement{Name: xml.Name{Local: name}})
+}
+
+func (o *OFX) end(name string) error {
+	return o.enc.EncodeToken(xml.EndElement{Name: xml.Name{Local: name}})
+}
@@ -0,0 +1,205 @@
+package export
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"strconv"
+	"time"
+
+	"EWallet/pkg/repository"
+
+	"github.com/go-pdf/fpdf"
+	"golang.org/x/image/font/gofont/gobold"
+	"golang.org/x/image/font/gofont/goregular"
+)
+
+const (
+	pdfFont      = "go"
+	pdfDateTime  = "2006-01-02 15:04"
+	pdfDate      = "2006-01-02"
+	pdfRowHeight = 6.0
+)
+
+var pdfColumns = []struct {
+	title string
+	width float64
+	align string
+}{
+	{"Date", 30, "L"},
+	{"Operation", 24, "L"},
+	{"Reference", 54, "L"},
+	{"Counterparty", 24, "L"},
+	{"Amount", 24, "R"},
+	{"Balance", 24, "R"},
+}
+
+// PDF renders a printable statement: a header with the owner and the wallet, the entries with the
+// running balance, the totals and a signature. The document is assembled in memory and written out
+// by End. The signature is the HMAC-SHA256 of everything printed but the generation time with the key
+// (see Sign), it is left out without a key.
+type PDF struct {
+	w         io.Writer
+	doc       *fpdf.Fpdf
+	mac       hash.Hash
+	statement repository.Statement
+	generated time.Time
+}
+
+func NewPDF(w io.Writer, key []byte) *PDF {
+	p := &PDF{w: w, generated: time.Now().UTC()}
+	if len(key) > 0 {
+		p.mac = hmac.New(sha256.New, key)
+	}
+	return p
+}
+
+func (p *PDF) Begin(s repository.Statement) error {
+	p.statement = s
+	p.digest(statementLine(s))
+
+	doc := fpdf.New("P", "mm", "A4", "")
+	doc.SetMargins(15, 15, 15)
+	doc.SetAutoPageBreak(true, 20)
+	doc.AddUTF8FontFromBytes(pdfFont, "", goregular.TTF)
+	doc.AddUTF8FontFromBytes(pdfFont, "B", gobold.TTF)
+	doc.SetTitle(fmt.Sprintf("Statement of wallet %d", s.WalletId), true)
+	doc.SetCreator("EWallet", true)
+	doc.SetCreationDate(p.generated)
+	doc.AliasNbPages("")
+	doc.SetFooterFunc(func() {
+		doc.SetY(-15)
+		doc.SetFont(pdfFont, "", 8)
+		doc.CellFormat(90, 5, "Generated "+p.generated.Format(pdfDateTime)+" UTC", "", 0, "L", false, 0, "")
+		doc.CellFormat(90, 5, fmt.Sprintf("Page %d of {nb}", doc.PageNo()), "", 0, "R", false, 0, "")
+	})
+	doc.SetHeaderFunc(func() {
+		if doc.PageNo() > 1 {
+			p.tableHeader()
+		}
+	})
+	doc.AddPage()
+	p.doc = doc
+
+	doc.SetFont(pdfFont, "B", 16)
+	doc.CellFormat(0, 10, "Account statement", "", 1, "L", false, 0, "")
+	doc.SetFont(pdfFont, "", 10)
+	for _, line := range [][2]string{
+		{"Owner", s.Owner},
+		{"Wallet", strconv.Itoa(s.WalletId)},
+		{"Currency", s.Currency},
+		{"Period", s.From.UTC().Format(pdfDate) + " – " + s.To.UTC().Format(pdfDate)},
+	} {
+		doc.CellFormat(30, pdfRowHeight, line[0], "", 0, "L", false, 0, "")
+		doc.CellFormat(0, pdfRowHeight, line[1], "", 1, "L", false, 0, "")
+	}
+	doc.Ln(4)
+	p.tableHeader()
+	p.summaryRow("Opening balance", s.OpeningBalance)
+	return doc.Error()
+}
+
+func (p *PDF) Entry(e repository.StatementEntry) error {
+	p.digest(entryLine(e))
+	counterparty := ""
+	if e.Counterparty != nil {
+		counterparty = strconv.Itoa(*e.Counterparty)
+	}
+	operation := e.Operation
+	if e.Status == repository.StatusReversed {
+		operation += " (reversed)"
+	}
+	p.doc.SetFont(pdfFont, "", 8)
+	for i, value := range []string{
+		e.Date.UTC().Format(pdfDateTime), operation, e.UUID, counterparty, formatAmount(e.Amount), formatAmount(e.Balance),
+	} {
+		c := pdfColumns[i]
+		p.doc.CellFormat(c.width, pdfRowHeight, value, "B", 0, c.align, false, 0, "")
+	}
+	p.doc.Ln(-1)
+	return p.doc.Error()
+}
+
+func (p *PDF) End() error {
+	s := p.statement
+	p.summaryRow("Closing balance", s.ClosingBalance)
+	p.doc.Ln(4)
+	p.doc.SetFont(pdfFont, "", 10)
+	for _, line := range [][2]string{
+		{"Total credits", formatAmount(s.TotalCredits) + " " + s.Currency},
+		{"Total debits", formatAmount(s.TotalDebits) + " " + s.Currency},
+	} {
+		p.doc.CellFormat(40, pdfRowHeight, line[0], "", 0, "L", false, 0, "")
+		p.doc.CellFormat(0, pdfRowHeight, line[1], "", 1, "L", false, 0, "")
+	}
+	if signature := p.Signature(); signature != "" {
+		p.doc.Ln(6)
+		p.doc.SetFont(pdfFont, "", 8)
+		p.doc.MultiCell(0, 4, "Signature (HMAC-SHA256): "+signature, "", "L", false)
+	}
+	return p.doc.Output(p.w)
+}
+
+// Signature is the signature of the statement written so far, empty without a key.
+func (p *PDF) Signature() string {
+	if p.mac == nil {
+		return ""
+	}
+	return hex.EncodeToString(p.mac.Sum(nil))
+}
+
+func (p *PDF) digest(line string) {
+	if p.mac != nil {
+		p.mac.Write([]byte(line))
+	}
+}
+
+func (p *PDF) tableHeader() {
+	p.doc.SetFont(pdfFont, "B", 9)
+	p.doc.SetFillColor(230, 230, 230)
+	for _, c := range pdfColumns {
+		p.doc.CellFormat(c.width, pdfRowHeight+1, c.title, "1", 0, c.align, true, 0, "")
+	}
+	p.doc.Ln(-1)
+}
+
+func (p *PDF) summaryRow(title string, amount float64) {
+	p.doc.SetFont(pdfFont, "B", 9)
+	var width float64
+	for _, c := range pdfColumns[:len(pdfColumns)-1] {
+		width += c.width
+	}
+	p.doc.CellFormat(width, pdfRowHeight, title, "B", 0, "L", false, 0, "")
+	p.doc.CellFormat(pdfColumns[len(pdfColumns)-1].width, pdfRowHeight, formatAmount(amount), "B", 1, "R", false, 0, "")
+}
+
+// Sign returns the signature of the statement with its entries, the one the PDF carries. The signed
+// contents are every field the PDF shows but the generation time: a line with the owner, the wallet,
+// the period, the balances and the totals followed by a line per entry.
+func Sign(key []byte, s repository.Statement) string {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(statementLine(s)))
+	for _, e := range s.Entries {
+		mac.Write([]byte(entryLine(e)))
+	}
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// statementLine and entryLine quote the free text, so a separator in it can't shift the other fields.
+func statementLine(s repository.Statement) string {
+	return fmt.Sprintf("%d|%q|%s|%s|%s|%s|%s|%s|%s\n", s.WalletId, s.Owner, s.From.UTC().Format(time.RFC3339),
+		s.To.UTC().Format(time.RFC3339), s.Currency, formatAmount(s.OpeningBalance), formatAmount(s.TotalCredits),
+		formatAmount(s.TotalDebits), formatAmount(s.ClosingBalance))
+}
+
+func entryLine(e repository.StatementEntry) string {
+	counterparty := ""
+	if e.Counterparty != nil {
+		counterparty = strconv.Itoa(*e.Counterparty)
+	}
+	return fmt.Sprintf("%d|%q|%s|%s|%s|%s|%s|%s\n", e.TransactionId, e.UUID, e.Operation, e.Status, counterparty,
+		e.Date.UTC().Format(time.RFC3339Nano), formatAmount(e.Amount), formatAmount(e.Balance))
+}
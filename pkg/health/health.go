@@ -0,0 +1,124 @@
+// Package health checks the dependencies of the service for the readiness probe. A critical check that
+// fails makes the service not ready, any other one only degrades it.
+package health
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	StatusUp   = "up"
+	StatusDown = "down"
+
+	StatusReady        = "ready"
+	StatusDegraded     = "degraded"
+	StatusNotReady     = "not_ready"
+	StatusShuttingDown = "shutting_down"
+)
+
+type Check struct {
+	Name string
+	// Critical checks make the service not ready when they fail.
+	Critical bool
+	// TTL keeps the result for checks too costly to run on every probe, like calls to paid APIs.
+	TTL time.Duration
+	// Run checks the dependency, details are reported along with the status even when it fails.
+	Run func(ctx context.Context) (details map[string]interface{}, err error)
+}
+
+type Result struct {
+	Status    string                 `json:"status"`
+	Critical  bool                   `json:"critical"`
+	LatencyMs float64                `json:"latency_ms"`
+	Error     string                 `json:"error,omitempty"`
+	Details   map[string]interface{} `json:"details,omitempty"`
+	CheckedAt time.Time              `json:"checked_at"`
+}
+
+type Report struct {
+	Status string            `json:"status"`
+	Checks map[string]Result `json:"checks"`
+}
+
+type Checker struct {
+	log     *logrus.Entry
+	timeout time.Duration
+	checks  []Check
+
+	mu     sync.Mutex
+	cached map[string]Result
+}
+
+// NewChecker creates the checker, a check running longer than timeout fails.
+func NewChecker(log *logrus.Logger, timeout time.Duration, checks ...Check) *Checker {
+	return &Checker{
+		log:     log.WithField("component", "health"),
+		timeout: timeout,
+		checks:  checks,
+		cached:  make(map[string]Result),
+	}
+}
+
+// Check runs the checks concurrently and sums them up.
+func (c *Checker) Check(ctx context.Context) Report {
+	report := Report{Status: StatusReady, Checks: make(map[string]Result, len(c.checks))}
+	results := make([]Result, len(c.checks))
+	var wg sync.WaitGroup
+	for i, check := range c.checks {
+		wg.Add(1)
+		go func(i int, check Check) {
+			defer wg.Done()
+			results[i] = c.run(ctx, check)
+		}(i, check)
+	}
+	wg.Wait()
+	for i, check := range c.checks {
+		res := results[i]
+		report.Checks[check.Name] = res
+		if res.Status == StatusUp {
+			continue
+		}
+		if check.Critical {
+			report.Status = StatusNotReady
+		} else if report.Status == StatusReady {
+			report.Status = StatusDegraded
+		}
+	}
+	return report
+}
+
+func (c *Checker) run(ctx context.Context, check Check) Result {
+	if check.TTL > 0 {
+		c.mu.Lock()
+		res, ok := c.cached[check.Name]
+		c.mu.Unlock()
+		if ok && time.Since(res.CheckedAt) < check.TTL {
+			return res
+		}
+	}
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+	started := time.Now()
+	details, err := check.Run(ctx)
+	res := Result{
+		Status:    StatusUp,
+		Critical:  check.Critical,
+		LatencyMs: float64(time.Since(started).Microseconds()) / 1000,
+		Details:   details,
+		CheckedAt: started,
+	}
+	if err != nil {
+		res.Status, res.Error = StatusDown, err.Error()
+		c.log.Warnf("%s check failed: %v", check.Name, err)
+	}
+	if check.TTL > 0 {
+		c.mu.Lock()
+		c.cached[check.Name] = res
+		c.mu.Unlock()
+	}
+	return res
+}
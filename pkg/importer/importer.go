@@ -0,0 +1,275 @@
+// Package importer reads the transaction files of the bulk import: CSV with a header row or JSON Lines,
+// one deposit, withdrawal or transfer per row. Rows are read one at a time, a malformed row is reported
+// with its line number and reading goes on with the next one.
+package importer
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"mime"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+const (
+	FormatCSV   = "csv"
+	FormatJSONL = "jsonl"
+)
+
+// maxSum is the largest amount a wallet balance holds, numeric(10, 2).
+const maxSum = 99999999.99
+
+var (
+	ErrUnknownFormat = errors.New("err unknown import format")
+	ErrUnknownColumn = errors.New("err unknown import column")
+	ErrMissingColumn = errors.New("err missing import column")
+)
+
+// mediaTypes maps the request content types to the formats.
+var mediaTypes = map[string]string{
+	"text/csv":             FormatCSV,
+	"application/x-ndjson": FormatJSONL,
+}
+
+// Row is a single operation of the file, Line is its line number.
+type Row struct {
+	Line         int     `json:"-"`
+	Operation    string  `json:"operation"`
+	WalletId     int     `json:"wallet_id"`
+	WalletTarget int     `json:"wallet_target,omitempty"`
+	Sum          float64 `json:"sum"`
+	UUID         string  `json:"uuid"`
+}
+
+// RowError describes why a row was not imported.
+type RowError struct {
+	Line    int    `json:"line"`
+	UUID    string `json:"uuid,omitempty"`
+	Field   string `json:"field,omitempty"`
+	Message string `json:"message"`
+}
+
+func (e *RowError) Error() string {
+	if e.Field != "" {
+		return fmt.Sprintf("line %d: %s %s", e.Line, e.Field, e.Message)
+	}
+	return fmt.Sprintf("line %d: %s", e.Line, e.Message)
+}
+
+// Report is the outcome of an import. Applied counts the rows that moved money, or would have in a
+// dry run; Skipped the rows imported before, recognized by their uuid.
+type Report struct {
+	DryRun  bool       `json:"dry_run"`
+	Total   int        `json:"total"`
+	Applied int        `json:"applied"`
+	Skipped int        `json:"skipped"`
+	Failed  int        `json:"failed"`
+	Errors  []RowError `json:"errors"`
+}
+
+// Fail counts a failed row with its errors.
+func (r *Report) Fail(errs ...RowError) {
+	r.Failed++
+	r.Errors = append(r.Errors, errs...)
+}
+
+// Reader reads the rows of a file. Next returns io.EOF after the last row and a *RowError for a
+// malformed row; reading may go on after a *RowError but not after any other error.
+type Reader interface {
+	Next() (Row, error)
+}
+
+// FormatOf returns the format of the content type.
+func FormatOf(contentType string) (string, error) {
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	if name, ok := mediaTypes[mediaType]; ok {
+		return name, nil
+	}
+	return "", fmt.Errorf("%q: %w", contentType, ErrUnknownFormat)
+}
+
+// NewReader returns the reader of the format.
+func NewReader(name string, r io.Reader) (Reader, error) {
+	switch name {
+	case FormatCSV:
+		return NewCSVReader(r)
+	case FormatJSONL:
+		return NewJSONLReader(r), nil
+	}
+	return nil, fmt.Errorf("%q: %w", name, ErrUnknownFormat)
+}
+
+// Validate checks the row before it reaches the wallets.
+func (row Row) Validate() []RowError {
+	var errs []RowError
+	fail := func(field, message string) {
+		errs = append(errs, RowError{Line: row.Line, UUID: row.UUID, Field: field, Message: message})
+	}
+	switch row.Operation {
+	case "deposit", "withdraw":
+		if row.WalletTarget != 0 {
+			fail("wallet_target", "must be empty for a "+row.Operation)
+		}
+	case "transfer":
+		if row.WalletTarget <= 0 {
+			fail("wallet_target", "is required")
+		} else if row.WalletTarget == row.WalletId {
+			fail("wallet_target", "must differ from the source wallet")
+		}
+	default:
+		fail("operation", "must be one of: deposit, withdraw, transfer")
+	}
+	if row.WalletId <= 0 {
+		fail("wallet_id", "must be greater than 0")
+	}
+	scaled := row.Sum * 100
+	switch {
+	case row.Sum <= 0:
+		fail("sum", "must be greater than 0")
+	case row.Sum > maxSum:
+		fail("sum", "must be at most 99999999.99")
+	case math.Abs(scaled-math.Round(scaled)) > 1e-6:
+		fail("sum", "must have at most 2 decimal places")
+	}
+	if row.UUID == "" {
+		fail("uuid", "is required")
+	} else if _, err := uuid.Parse(row.UUID); err != nil {
+		fail("uuid", "must be a UUID")
+	}
+	return errs
+}
+
+// Same reports whether the rows describe the same operation.
+func (row Row) Same(other Row) bool {
+	return row.Operation == other.Operation && row.WalletId == other.WalletId &&
+		row.WalletTarget == other.WalletTarget && math.Abs(row.Sum-other.Sum) < 0.005 && row.UUID == other.UUID
+}
+
+var csvColumns = map[string]bool{"operation": true, "wallet_id": true, "wallet_target": false, "sum": true, "uuid": true}
+
+// CSVReader reads CSV with a header naming the columns operation, wallet_id, wallet_target, sum and
+// uuid in any order; wallet_target may be left out.
+type CSVReader struct {
+	r       *csv.Reader
+	columns map[string]int
+}
+
+func NewCSVReader(r io.Reader) (*CSVReader, error) {
+	cr := csv.NewReader(r)
+	cr.ReuseRecord = true
+	header, err := cr.Read()
+	if err != nil {
+		return nil, fmt.Errorf("err reading csv header: %w", err)
+	}
+	columns := make(map[string]int, len(header))
+	for i, name := range header {
+		name = strings.TrimSpace(name)
+		if _, ok := csvColumns[name]; !ok {
+			return nil, fmt.Errorf("%q: %w", name, ErrUnknownColumn)
+		}
+		columns[name] = i
+	}
+	for name, required := range csvColumns {
+		if _, ok := columns[name]; required && !ok {
+			return nil, fmt.Errorf("%q: %w", name, ErrMissingColumn)
+		}
+	}
+	cr.FieldsPerRecord = len(header)
+	return &CSVReader{r: cr, columns: columns}, nil
+}
+
+func (c *CSVReader) Next() (Row, error) {
+	record, err := c.r.Read()
+	if err != nil {
+		var parseErr *csv.ParseError
+		if errors.As(err, &parseErr) {
+			return Row{}, &RowError{Line: parseErr.StartLine, Message: parseErr.Err.Error()}
+		}
+		return Row{}, err
+	}
+	line, _ := c.r.FieldPos(0)
+	row := Row{Line: line}
+	value := func(name string) string {
+		if i, ok := c.columns[name]; ok {
+			return strings.TrimSpace(record[i])
+		}
+		return ""
+	}
+	row.Operation = value("operation")
+	row.UUID = value("uuid")
+	for _, field := range []struct {
+		name  string
+		parse func(string) error
+		msg   string
+	}{
+		{"wallet_id", func(v string) (err error) { row.WalletId, err = strconv.Atoi(v); return }, "must be an integer"},
+		{"wallet_target", func(v string) (err error) { row.WalletTarget, err = strconv.Atoi(v); return }, "must be an integer"},
+		{"sum", func(v string) (err error) { row.Sum, err = strconv.ParseFloat(v, 64); return }, "must be a number"},
+	} {
+		if val := value(field.name); val != "" {
+			if err = field.parse(val); err != nil {
+				return Row{}, &RowError{Line: line, UUID: row.UUID, Field: field.name, Message: field.msg}
+			}
+		}
+	}
+	return row, nil
+}
+
+// JSONLReader reads one JSON object per line, blank lines are skipped.
+type JSONLReader struct {
+	r    *bufio.Reader
+	line int
+}
+
+func NewJSONLReader(r io.Reader) *JSONLReader {
+	return &JSONLReader{r: bufio.NewReader(r)}
+}
+
+func (j *JSONLReader) Next() (Row, error) {
+	for {
+		data, err := j.r.ReadBytes('\n')
+		if err != nil && !errors.Is(err, io.EOF) {
+			return Row{}, err
+		}
+		if len(data) == 0 && errors.Is(err, io.EOF) {
+			return Row{}, io.EOF
+		}
+		j.line++
+		data = bytes.TrimSpace(data)
+		if len(data) == 0 {
+			continue
+		}
+		row := Row{Line: j.line}
+		dec := json.NewDecoder(bytes.NewReader(data))
+		dec.DisallowUnknownFields()
+		if err = dec.Decode(&row); err != nil {
+			rowErr := &RowError{Line: j.line, Message: "invalid JSON: " + err.Error()}
+			var typeErr *json.UnmarshalTypeError
+			if errors.As(err, &typeErr) {
+				rowErr.Field, rowErr.Message = typeErr.Field, typeMessage(typeErr.Type.Kind())
+			}
+			return Row{}, rowErr
+		}
+		row.Line = j.line
+		return row, nil
+	}
+}
+
+func typeMessage(kind reflect.Kind) string {
+	switch kind {
+	case reflect.Int:
+		return "must be an integer"
+	case reflect.Float64:
+		return "must be a number"
+	}
+	return "must be a string"
+}
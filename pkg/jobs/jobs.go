@@ -0,0 +1,364 @@
+// Package jobs runs the background jobs queued in Postgres. Workers claim due jobs with SKIP LOCKED,
+// keep them hidden from other workers while they run and retry failures with exponential backoff
+// until the job runs out of attempts.
+package jobs
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+
+	"EWallet/pkg/metrics"
+	"EWallet/pkg/repository"
+	"EWallet/pkg/tracing"
+
+	"github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+const (
+	baseBackoff = 5 * time.Second
+	maxBackoff  = 10 * time.Minute
+	// statsInterval is how often the queue depth gauges are refreshed.
+	statsInterval = 15 * time.Second
+)
+
+// Backoff is the delay before retrying a job that failed attempts times.
+func Backoff(attempts int) time.Duration {
+	if attempts < 1 {
+		return 0
+	}
+	delay := baseBackoff
+	for i := 1; i < attempts; i++ {
+		delay *= 2
+		if delay >= maxBackoff {
+			return maxBackoff
+		}
+	}
+	return delay
+}
+
+// Result is what a job produced: Value is stored as its JSON result, Output is an optional file
+// served with ContentType.
+type Result struct {
+	Value       interface{}
+	Output      []byte
+	ContentType string
+}
+
+// Handler runs a claimed job. A returned error is retried unless it is Permanent.
+type Handler func(ctx context.Context, job repository.Job) (Result, error)
+
+type permanentError struct {
+	err error
+}
+
+func (e *permanentError) Error() string {
+	return e.err.Error()
+}
+
+func (e *permanentError) Unwrap() error {
+	return e.err
+}
+
+// Permanent marks an error that retrying the job won't fix, the job fails right away.
+func Permanent(err error) error {
+	return &permanentError{err: err}
+}
+
+func IsPermanent(err error) bool {
+	var p *permanentError
+	return errors.As(err, &p)
+}
+
+type Store interface {
+	ClaimJobs(ctx context.Context, types []string, limit int, visibility time.Duration) ([]repository.Job, error)
+	ExtendJob(ctx context.Context, job repository.Job, visibility time.Duration) (bool, error)
+	CompleteJob(ctx context.Context, job repository.Job, result json.RawMessage, output []byte, contentType string) (bool, error)
+	RetryJob(ctx context.Context, job repository.Job, reason string, runAt time.Time) (bool, error)
+	ReleaseJob(ctx context.Context, job repository.Job, reason string) (bool, error)
+	FailJob(ctx context.Context, job repository.Job, reason string) (bool, error)
+	FailExpiredJobs(ctx context.Context) (int64, error)
+	DeleteFinishedJobs(ctx context.Context, before time.Time) (int64, error)
+	GetJobStats(ctx context.Context) ([]repository.JobStat, error)
+}
+
+// Worker runs up to concurrency jobs at a time. A running job is hidden from other workers for
+// visibility; the lock is extended while the handler runs, so only a dead worker lets it expire.
+type Worker struct {
+	log         *logrus.Entry
+	store       Store
+	handlers    map[string]Handler
+	interval    time.Duration
+	concurrency int
+	visibility  time.Duration
+	// retention is how long finished jobs are kept, 0 keeps them for ever.
+	retention time.Duration
+
+	// ctx is the context of the running jobs, cancelled when Shutdown gives up waiting for them.
+	ctx      context.Context
+	cancel   context.CancelFunc
+	mu       sync.Mutex
+	stopped  bool
+	inFlight int
+	stop     chan struct{}
+	// freed wakes Run when a job finishes, so the slot is filled without waiting for the ticker.
+	freed   chan struct{}
+	running sync.WaitGroup
+}
+
+func NewWorker(log *logrus.Logger, store Store, interval time.Duration, concurrency int, visibility time.Duration) *Worker {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Worker{
+		log:         log.WithField("component", "jobs"),
+		store:       store,
+		handlers:    make(map[string]Handler),
+		interval:    interval,
+		concurrency: concurrency,
+		visibility:  visibility,
+		ctx:         ctx,
+		cancel:      cancel,
+		stop:        make(chan struct{}),
+		freed:       make(chan struct{}, 1),
+	}
+}
+
+// SetRetention makes the worker delete the jobs finished longer than retention ago, outputs included.
+func (w *Worker) SetRetention(retention time.Duration) {
+	w.retention = retention
+}
+
+// Register makes the worker run the jobs of jobType with h. Handlers are registered before Run.
+func (w *Worker) Register(jobType string, h Handler) {
+	w.handlers[jobType] = h
+}
+
+// Run claims and starts due jobs until ctx is done or Shutdown is called. It returns without waiting
+// for the running jobs, Shutdown drains them.
+func (w *Worker) Run(ctx context.Context) {
+	types := make([]string, 0, len(w.handlers))
+	for t := range w.handlers {
+		types = append(types, t)
+	}
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+	var lastStats time.Time
+	for {
+		if time.Since(lastStats) >= statsInterval {
+			w.refreshStats(ctx)
+			lastStats = time.Now()
+		}
+		if err := w.claim(ctx, types); err != nil && ctx.Err() == nil {
+			w.log.Errorf("err claiming jobs: %v", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-w.stop:
+			return
+		case <-ticker.C:
+		case <-w.freed:
+		}
+	}
+}
+
+// claim starts as many due jobs as there are free slots.
+func (w *Worker) claim(ctx context.Context, types []string) error {
+	w.mu.Lock()
+	free := w.concurrency - w.inFlight
+	w.mu.Unlock()
+	if free <= 0 || len(types) == 0 {
+		return nil
+	}
+	claimed, err := w.store.ClaimJobs(ctx, types, free, w.visibility)
+	if err != nil {
+		return err
+	}
+	for _, job := range claimed {
+		w.mu.Lock()
+		if w.stopped {
+			w.mu.Unlock()
+			// Claimed while shutting down, hand it back right away.
+			w.release(job)
+			continue
+		}
+		w.running.Add(1)
+		w.inFlight++
+		w.mu.Unlock()
+		metrics.MetricJobsWaitDuration.WithLabelValues(job.Type).Observe(time.Since(job.RunAt).Seconds())
+		go func(job repository.Job) {
+			defer func() {
+				w.mu.Lock()
+				w.inFlight--
+				w.mu.Unlock()
+				w.running.Done()
+				select {
+				case w.freed <- struct{}{}:
+				default:
+				}
+			}()
+			w.execute(job)
+		}(job)
+	}
+	return nil
+}
+
+func (w *Worker) execute(job repository.Job) {
+	started := time.Now()
+	ctx, cancel := context.WithCancel(w.ctx)
+	defer cancel()
+	go w.heartbeat(ctx, cancel, job)
+	result, err := w.call(ctx, job)
+	outcome := w.finish(job, result, err)
+	metrics.MetricJobsRunDuration.WithLabelValues(job.Type, outcome).Observe(time.Since(started).Seconds())
+}
+
+// call runs the handler of the job, a panic fails the attempt.
+func (w *Worker) call(ctx context.Context, job repository.Job) (result Result, err error) {
+	ctx, span := tracing.Start(ctx, "job "+job.Type, attribute.Int64("job.id", job.Id), attribute.Int("job.attempts", job.Attempts))
+	defer tracing.End(span, &err)
+	defer func() {
+		if p := recover(); p != nil {
+			err = fmt.Errorf("panic: %v", p)
+		}
+	}()
+	return w.handlers[job.Type](ctx, job)
+}
+
+// heartbeat extends the lock of the job until ctx is done. When another worker took the job over the
+// handler is cancelled, its outcome would be discarded anyway.
+func (w *Worker) heartbeat(ctx context.Context, cancel context.CancelFunc, job repository.Job) {
+	ticker := time.NewTicker(w.visibility / 3)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		ok, err := w.store.ExtendJob(ctx, job, w.visibility)
+		if err != nil {
+			if ctx.Err() == nil {
+				w.log.Errorf("err extending the lock of job %d: %v", job.Id, err)
+			}
+			continue
+		}
+		if !ok {
+			w.log.Warnf("job %d was taken over by another worker, cancelling", job.Id)
+			cancel()
+			return
+		}
+	}
+}
+
+// finish records the outcome of the attempt and returns it. The store is updated with a fresh context
+// so that a cancelled job is still handed back.
+func (w *Worker) finish(job repository.Job, result Result, err error) string {
+	ctx := context.Background()
+	if err == nil {
+		var value json.RawMessage
+		if result.Value != nil {
+			if value, err = json.Marshal(result.Value); err != nil {
+				err = Permanent(fmt.Errorf("err encoding the result: %w", err))
+			}
+		}
+		if err == nil {
+			ok, err := w.store.CompleteJob(ctx, job, value, result.Output, result.ContentType)
+			w.recorded(job, ok, err)
+			return repository.JobSucceeded
+		}
+	}
+	switch {
+	case w.ctx.Err() != nil:
+		w.release(job)
+		return "interrupted"
+	case IsPermanent(err) || job.Attempts >= job.MaxAttempts:
+		w.log.Errorf("job %d (%s) failed after %d attempts: %v", job.Id, job.Type, job.Attempts, err)
+		ok, err := w.store.FailJob(ctx, job, err.Error())
+		w.recorded(job, ok, err)
+		return repository.JobFailed
+	default:
+		delay := Backoff(job.Attempts)
+		w.log.Warnf("job %d (%s) attempt %d failed, retrying in %s: %v", job.Id, job.Type, job.Attempts, delay, err)
+		ok, err := w.store.RetryJob(ctx, job, err.Error(), time.Now().Add(delay+time.Duration(rand.Int63n(int64(delay)/10+1))))
+		w.recorded(job, ok, err)
+		return "retried"
+	}
+}
+
+// release queues a job that didn't get to finish to run again right away, the attempt isn't counted.
+func (w *Worker) release(job repository.Job) {
+	ok, err := w.store.ReleaseJob(context.Background(), job, "interrupted by worker shutdown")
+	w.recorded(job, ok, err)
+}
+
+func (w *Worker) recorded(job repository.Job, ok bool, err error) {
+	switch {
+	case err != nil:
+		w.log.Errorf("err recording the outcome of job %d: %v", job.Id, err)
+	case !ok:
+		w.log.Warnf("job %d was taken over by another worker, its outcome is discarded", job.Id)
+	}
+}
+
+// refreshStats fails the jobs abandoned on their last attempt, deletes the jobs past the retention and
+// updates the queue gauges.
+func (w *Worker) refreshStats(ctx context.Context) {
+	if cnt, err := w.store.FailExpiredJobs(ctx); err != nil {
+		w.log.Errorf("err failing expired jobs: %v", err)
+	} else if cnt > 0 {
+		w.log.Warnf("failed %d jobs abandoned on their last attempt", cnt)
+	}
+	if w.retention > 0 {
+		if cnt, err := w.store.DeleteFinishedJobs(ctx, time.Now().Add(-w.retention)); err != nil {
+			w.log.Errorf("err deleting finished jobs: %v", err)
+		} else if cnt > 0 {
+			w.log.Infof("deleted %d jobs finished more than %s ago", cnt, w.retention)
+		}
+	}
+	stats, err := w.store.GetJobStats(ctx)
+	if err != nil {
+		w.log.Errorf("err getting job stats: %v", err)
+		return
+	}
+	metrics.MetricJobsQueueDepth.Reset()
+	metrics.MetricJobsOldestAge.Reset()
+	for _, st := range stats {
+		metrics.MetricJobsQueueDepth.WithLabelValues(st.Type, st.Status).Set(float64(st.Count))
+		if st.Status == repository.JobQueued {
+			age := time.Since(st.Oldest).Seconds()
+			if age < 0 {
+				age = 0
+			}
+			metrics.MetricJobsOldestAge.WithLabelValues(st.Type).Set(age)
+		}
+	}
+}
+
+// Shutdown stops claiming jobs and waits for the running ones to finish. When ctx is done first the
+// running jobs are cancelled and queued again, and ctx.Err() is returned.
+func (w *Worker) Shutdown(ctx context.Context) error {
+	w.mu.Lock()
+	if !w.stopped {
+		w.stopped = true
+		close(w.stop)
+	}
+	w.mu.Unlock()
+	drained := make(chan struct{})
+	go func() {
+		w.running.Wait()
+		close(drained)
+	}()
+	select {
+	case <-drained:
+		return nil
+	case <-ctx.Done():
+		w.cancel()
+		<-drained
+		return ctx.Err()
+	}
+}
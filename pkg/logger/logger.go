@@ -1,9 +1,88 @@
 package logger
 
-import "github.com/sirupsen/logrus"
+import (
+	"context"
+	"fmt"
+
+	"github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/trace"
+)
+
+type ctxKey int
+
+const requestIDKey ctxKey = iota
+
+// maxRequestIDLength limits the request IDs taken from the clients.
+const maxRequestIDLength = 128
 
 func NewLogger() *logrus.Logger {
 	log := logrus.New()
 	log.SetLevel(logrus.DebugLevel)
+	log.AddHook(contextHook{})
 	return log
 }
+
+// New creates the logger of the service with the level and the format, "text" or "json". Entries
+// logged WithContext carry the request ID and the trace of the context.
+func New(level, format string) (*logrus.Logger, error) {
+	log := logrus.New()
+	lvl, err := logrus.ParseLevel(level)
+	if err != nil {
+		return nil, fmt.Errorf("err parsing log level: %w", err)
+	}
+	log.SetLevel(lvl)
+	switch format {
+	case "text":
+	case "json":
+		log.SetFormatter(&logrus.JSONFormatter{})
+	default:
+		return nil, fmt.Errorf("unknown log format %q", format)
+	}
+	log.AddHook(contextHook{})
+	return log, nil
+}
+
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey, id)
+}
+
+// ValidRequestID reports whether the request ID a client sent is fit for the logs: printable ASCII of
+// at most 128 characters.
+func ValidRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for _, ch := range id {
+		if ch < ' ' || ch > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+// RequestID returns the ID of the request ctx belongs to, empty outside requests.
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey).(string)
+	return id
+}
+
+// contextHook adds the request ID and the span of the entry context to the entry.
+type contextHook struct{}
+
+func (contextHook) Levels() []logrus.Level {
+	return logrus.AllLevels
+}
+
+func (contextHook) Fire(e *logrus.Entry) error {
+	if e.Context == nil {
+		return nil
+	}
+	if id := RequestID(e.Context); id != "" {
+		e.Data["request_id"] = id
+	}
+	if sc := trace.SpanContextFromContext(e.Context); sc.IsValid() {
+		e.Data["trace_id"] = sc.TraceID().String()
+		e.Data["span_id"] = sc.SpanID().String()
+	}
+	return nil
+}
@@ -1,24 +1,113 @@
+// Package metrics holds the Prometheus metrics of the service. They are registered on Registry rather
+// than the global registry of the prometheus package, so /metrics exposes only them, the Go runtime and
+// the process metrics and what is registered explicitly, like the connection pool stats.
 package metrics
 
 import (
 	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
 	"github.com/prometheus/client_golang/prometheus/promauto"
 )
 
+var Registry = prometheus.NewRegistry()
+
+var factory = promauto.With(Registry)
+
+func init() {
+	Registry.MustRegister(collectors.NewGoCollector(), collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}))
+}
+
 var (
-	MetricDBRequestsDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+	MetricDBRequestsDuration = factory.NewHistogramVec(prometheus.HistogramOpts{
 		Namespace: "ewallet",
 		Subsystem: "generic",
 		Name:      "db_duration",
 	}, []string{"method"})
-	MetricErrCount = promauto.NewCounterVec(prometheus.CounterOpts{
+	MetricErrCount = factory.NewCounterVec(prometheus.CounterOpts{
 		Namespace: "ewallet",
 		Subsystem: "generic",
 		Name:      "err_count",
 	}, []string{"method"})
-	MetricHTTPRequestDuration = promauto.NewHistogram(prometheus.HistogramOpts{
+	MetricHTTPRequests = factory.NewCounterVec(prometheus.CounterOpts{
 		Namespace: "ewallet",
-		Subsystem: "generic",
-		Name:      "http_request_duration",
+		Subsystem: "http",
+		Name:      "requests_total",
+		Help:      "Requests served by the REST API, route is the route pattern.",
+	}, []string{"route", "method", "status"})
+	MetricHTTPRequestDuration = factory.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "ewallet",
+		Subsystem: "http",
+		Name:      "request_duration_seconds",
+		Help:      "Time spent serving requests of the REST API.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"route", "method", "status"})
+	MetricHTTPRequestsInFlight = factory.NewGauge(prometheus.GaugeOpts{
+		Namespace: "ewallet",
+		Subsystem: "http",
+		Name:      "requests_in_flight",
+		Help:      "Requests of the REST API being served, streams included.",
 	})
+	MetricExchangeRequestDuration = factory.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "ewallet",
+		Subsystem: "exchange",
+		Name:      "request_duration_seconds",
+		Help:      "Time of the calls to the exchange rate provider by outcome: ok, not_found or error.",
+		Buckets:   []float64{.05, .1, .25, .5, 1, 2.5, 5, 10},
+	}, []string{"operation", "outcome"})
+	MetricExchangeErrors = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "ewallet",
+		Subsystem: "exchange",
+		Name:      "errors_total",
+		Help:      "Failed calls to the exchange rate provider by reason: timeout, transport, status or decode.",
+	}, []string{"operation", "reason"})
+	MetricOperations = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "ewallet",
+		Subsystem: "wallet",
+		Name:      "operations_total",
+		Help:      "Deposits, withdrawals and transfers that moved money.",
+	}, []string{"operation", "currency"})
+	MetricOperationVolume = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "ewallet",
+		Subsystem: "wallet",
+		Name:      "operation_volume_total",
+		Help:      "Money moved by deposits, withdrawals and transfers, in the wallet currency.",
+	}, []string{"operation", "currency"})
+	MetricInsufficientFunds = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "ewallet",
+		Subsystem: "wallet",
+		Name:      "insufficient_funds_total",
+		Help:      "Operations rejected for insufficient funds.",
+	}, []string{"operation"})
+	MetricIdempotentReplays = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "ewallet",
+		Subsystem: "idempotency",
+		Name:      "replays_total",
+		Help:      "Requests answered with the stored response of their idempotency key.",
+	}, []string{"operation"})
+	MetricJobsQueueDepth = factory.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "ewallet",
+		Subsystem: "jobs",
+		Name:      "queue_depth",
+		Help:      "Queued and running jobs.",
+	}, []string{"type", "status"})
+	MetricJobsOldestAge = factory.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "ewallet",
+		Subsystem: "jobs",
+		Name:      "oldest_queued_seconds",
+		Help:      "Age of the oldest due job waiting for a worker.",
+	}, []string{"type"})
+	MetricJobsWaitDuration = factory.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "ewallet",
+		Subsystem: "jobs",
+		Name:      "wait_duration",
+		Help:      "Time from a job being due to a worker claiming it.",
+		Buckets:   []float64{.1, .5, 1, 5, 15, 60, 300, 900, 3600},
+	}, []string{"type"})
+	MetricJobsRunDuration = factory.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "ewallet",
+		Subsystem: "jobs",
+		Name:      "run_duration",
+		Help:      "Time spent running an attempt of a job.",
+		Buckets:   []float64{.1, .5, 1, 5, 15, 60, 300, 900, 3600},
+	}, []string{"type", "outcome"})
 )
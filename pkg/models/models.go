@@ -1,8 +1,45 @@
 package models
 
+import "time"
+
+// TransactionQueryParams filters and pages the transaction history of a wallet. Zero values
+// leave the filter out. Pages are addressed by the opaque Cursor of the previous page.
 type TransactionQueryParams struct {
-	Offset int    `json:"offset"`
-	Limit  int    `json:"limit"`
-	Sort   string `json:"sort"`
-	Desc   bool   `json:"desc"`
+	Limit        int        `json:"limit" binding:"gte=0,lte=1000"`
+	Sort         string     `json:"sort" binding:"omitempty,oneof=date sum"`
+	Desc         bool       `json:"desc"`
+	Cursor       string     `json:"cursor"`
+	Status       string     `json:"status" binding:"omitempty,status"`
+	From         *time.Time `json:"from"`
+	To           *time.Time `json:"to"`
+	Operation    string     `json:"operation" binding:"omitempty,oneof=deposit withdraw transfer"`
+	Direction    string     `json:"direction" binding:"omitempty,oneof=incoming outgoing"`
+	MinSum       float64    `json:"min_sum" binding:"gte=0"`
+	MaxSum       float64    `json:"max_sum" binding:"gte=0"`
+	Counterparty int        `json:"counterparty" binding:"gte=0"`
+}
+
+// WalletRequest is the body creating a wallet.
+type WalletRequest struct {
+	Owner   string  `json:"owner" binding:"notblank,max=255"`
+	Balance float64 `json:"balance" binding:"gte=0,lte=99999999.99,precision"`
+}
+
+// OperationRequest is the body of a deposit, a withdrawal or a transfer to WalletTarget.
+type OperationRequest struct {
+	Sum          float64 `json:"sum" binding:"gt=0,lte=99999999.99,precision"`
+	WalletTarget int     `json:"walletTarget" binding:"gte=0"`
+	UUID         string  `json:"uuid"`
+}
+
+// PayoutRequest is the body of a payout, see repository.PayoutRequest.
+type PayoutRequest struct {
+	Atomic bool         `json:"atomic"`
+	Items  []PayoutItem `json:"items" binding:"required,min=1,max=1000,dive"`
+}
+
+type PayoutItem struct {
+	WalletTarget int     `json:"walletTarget" binding:"gt=0"`
+	Sum          float64 `json:"sum" binding:"gt=0,lte=99999999.99,precision"`
+	UUID         string  `json:"uuid" binding:"required,uuid"`
 }
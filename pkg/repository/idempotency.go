@@ -0,0 +1,130 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"EWallet/pkg/metrics"
+)
+
+var (
+	ErrIdempotencyMismatch   = fmt.Errorf("err idempotency key reused with a different request")
+	ErrIdempotencyInProgress = fmt.Errorf("err request with this idempotency key is in progress")
+	ErrIdempotencyKeyLost    = fmt.Errorf("err idempotency key was taken over by another request")
+)
+
+// IdempotencyScope limits an idempotency key to a caller, an operation and a wallet,
+// WalletID is 0 for operations not bound to a wallet.
+type IdempotencyScope struct {
+	Username  string
+	Operation string
+	WalletID  int
+}
+
+// IdempotencyKey is a request reserved by its key. StatusCode and Response are set once the request finished,
+// until then the request holds the key until LockedUntil. Token identifies the reservation: a request
+// taking the key over after LockedUntil replaces it, so the request it took over from can no longer
+// settle the key.
+type IdempotencyKey struct {
+	Key         string    `db:"key"`
+	Token       string    `db:"token"`
+	Fingerprint string    `db:"fingerprint"`
+	StatusCode  *int      `db:"status_code"`
+	Response    []byte    `db:"response"`
+	CreatedAt   time.Time `db:"created_at"`
+	LockedUntil time.Time `db:"locked_until"`
+	ExpiresAt   time.Time `db:"expires_at"`
+}
+
+// ReserveIdempotencyKey reserves the key for a new request with the token until expiresAt, the request
+// holds it until lockedUntil. A key that expired, or whose request didn't finish in time and is retried
+// with the same fingerprint, is reserved again. Otherwise the existing record is returned with reserved
+// set to false.
+func (pg *PG) ReserveIdempotencyKey(ctx context.Context, scope IdempotencyScope, key, fingerprint, token string, lockedUntil, expiresAt time.Time) (IdempotencyKey, bool, error) {
+	started := time.Now()
+	defer func() {
+		metrics.MetricDBRequestsDuration.WithLabelValues("ReserveIdempotencyKey").Observe(time.Since(started).Seconds())
+	}()
+	var record IdempotencyKey
+	query := `
+INSERT INTO idempotency_key (username, operation, wallet_id, key, fingerprint, token, locked_until, expires_at)
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+ON CONFLICT (username, operation, wallet_id, key) DO UPDATE
+    SET fingerprint  = excluded.fingerprint,
+        token        = excluded.token,
+        status_code  = NULL,
+        response     = NULL,
+        created_at   = now(),
+        locked_until = excluded.locked_until,
+        expires_at   = excluded.expires_at
+WHERE idempotency_key.expires_at < now()
+   OR (idempotency_key.status_code IS NULL AND idempotency_key.locked_until < now() AND
+       idempotency_key.fingerprint = excluded.fingerprint)
+RETURNING key, token, fingerprint, status_code, response, created_at, locked_until, expires_at`
+	err := pg.db.GetContext(ctx, &record, query, scope.Username, scope.Operation, scope.WalletID, key, fingerprint, token, lockedUntil, expiresAt)
+	if err == nil {
+		return record, true, nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		metrics.MetricErrCount.WithLabelValues("ReserveIdempotencyKey").Inc()
+		return IdempotencyKey{}, false, fmt.Errorf("err reserving idempotency key: %w", err)
+	}
+	query = `
+SELECT key, token, fingerprint, status_code, response, created_at, locked_until, expires_at
+FROM idempotency_key
+WHERE username = $1 AND operation = $2 AND wallet_id = $3 AND key = $4`
+	if err = pg.db.GetContext(ctx, &record, query, scope.Username, scope.Operation, scope.WalletID, key); err != nil {
+		metrics.MetricErrCount.WithLabelValues("ReserveIdempotencyKey").Inc()
+		return IdempotencyKey{}, false, fmt.Errorf("err getting idempotency key: %w", err)
+	}
+	return record, false, nil
+}
+
+// SaveIdempotentResponse stores the response of the request holding the reservation of token,
+// ErrIdempotencyKeyLost means another request took the key over.
+func (pg *PG) SaveIdempotentResponse(ctx context.Context, scope IdempotencyScope, key, token string, statusCode int, response []byte) error {
+	query := `
+UPDATE idempotency_key
+SET status_code = $1, response = $2
+WHERE username = $3 AND operation = $4 AND wallet_id = $5 AND key = $6 AND token = $7 AND status_code IS NULL`
+	res, err := pg.db.ExecContext(ctx, query, statusCode, response, scope.Username, scope.Operation, scope.WalletID, key, token)
+	if err != nil {
+		metrics.MetricErrCount.WithLabelValues("SaveIdempotentResponse").Inc()
+		return fmt.Errorf("err saving idempotent response: %w", err)
+	}
+	if cnt, _ := res.RowsAffected(); cnt == 0 {
+		return ErrIdempotencyKeyLost
+	}
+	return nil
+}
+
+// ReleaseIdempotencyKey frees the key reserved with token, ErrIdempotencyKeyLost means another request
+// took the key over.
+func (pg *PG) ReleaseIdempotencyKey(ctx context.Context, scope IdempotencyScope, key, token string) error {
+	query := `
+DELETE FROM idempotency_key
+WHERE username = $1 AND operation = $2 AND wallet_id = $3 AND key = $4 AND token = $5 AND status_code IS NULL`
+	res, err := pg.db.ExecContext(ctx, query, scope.Username, scope.Operation, scope.WalletID, key, token)
+	if err != nil {
+		metrics.MetricErrCount.WithLabelValues("ReleaseIdempotencyKey").Inc()
+		return fmt.Errorf("err releasing idempotency key: %w", err)
+	}
+	if cnt, _ := res.RowsAffected(); cnt == 0 {
+		return ErrIdempotencyKeyLost
+	}
+	return nil
+}
+
+func (pg *PG) DeleteExpiredIdempotencyKeys(ctx context.Context) (int64, error) {
+	query := `DELETE FROM idempotency_key WHERE expires_at < now()`
+	res, err := pg.db.ExecContext(ctx, query)
+	if err != nil {
+		metrics.MetricErrCount.WithLabelValues("DeleteExpiredIdempotencyKeys").Inc()
+		return 0, fmt.Errorf("err deleting expired idempotency keys: %w", err)
+	}
+	cnt, _ := res.RowsAffected()
+	return cnt, nil
+}
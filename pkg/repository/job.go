@@ -0,0 +1,307 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"EWallet/pkg/metrics"
+
+	"github.com/jmoiron/sqlx"
+)
+
+const (
+	JobQueued    = "queued"
+	JobRunning   = "running"
+	JobSucceeded = "succeeded"
+	JobFailed    = "failed"
+
+	JobPayout            = "payout"
+	JobImport            = "import"
+	JobExport            = "export"
+	JobMonthlyStatements = "monthly_statements"
+)
+
+var (
+	ErrJobNotFound       = fmt.Errorf("err job not found")
+	ErrJobOutputNotFound = fmt.Errorf("err job output not found")
+)
+
+// Job is a unit of background work of Type. A queued job runs once RunAt is due; a running job is
+// hidden from other workers until LockedUntil, when it becomes due again unless its worker extended
+// the lock. Key, when set, allows a single queued or running job with that key.
+type Job struct {
+	Id          int64           `json:"id" db:"id"`
+	Type        string          `json:"type" db:"type"`
+	Key         *string         `json:"key,omitempty" db:"key"`
+	Username    string          `json:"username,omitempty" db:"username"`
+	Payload     json.RawMessage `json:"-" db:"payload"`
+	Status      string          `json:"status" db:"status"`
+	Attempts    int             `json:"attempts" db:"attempts"`
+	MaxAttempts int             `json:"max_attempts" db:"max_attempts"`
+	RunAt       time.Time       `json:"run_at" db:"run_at"`
+	LockedUntil *time.Time      `json:"-" db:"locked_until"`
+	LastError   *string         `json:"last_error" db:"last_error"`
+	Result      json.RawMessage `json:"result,omitempty" db:"result"`
+	ContentType *string         `json:"content_type,omitempty" db:"content_type"`
+	CreatedAt   time.Time       `json:"created_at" db:"created_at"`
+	StartedAt   *time.Time      `json:"started_at" db:"started_at"`
+	FinishedAt  *time.Time      `json:"finished_at" db:"finished_at"`
+}
+
+// JobStat counts the jobs of a type in a status, Oldest is the earliest run_at among them.
+type JobStat struct {
+	Type   string    `db:"type"`
+	Status string    `db:"status"`
+	Count  int       `db:"count"`
+	Oldest time.Time `db:"oldest"`
+}
+
+const jobColumns = `id, type, key, username, status, attempts, max_attempts, run_at, locked_until, last_error,
+       result, content_type, created_at, started_at, finished_at`
+
+// EnqueueJob queues the job to run now. When a queued or running job has the same key, that job is
+// returned instead. MaxAttempts defaults to 5.
+func (pg *PG) EnqueueJob(ctx context.Context, job Job) (Job, error) {
+	j, err := pg.insertJob(ctx, pg.db, job)
+	if err != nil {
+		metrics.MetricErrCount.WithLabelValues("EnqueueJob").Inc()
+		return Job{}, err
+	}
+	return j, nil
+}
+
+func (pg *PG) insertJob(ctx context.Context, q sqlx.QueryerContext, job Job) (Job, error) {
+	if job.MaxAttempts == 0 {
+		job.MaxAttempts = 5
+	}
+	if job.Payload == nil {
+		job.Payload = json.RawMessage(`{}`)
+	}
+	query := `
+INSERT INTO job (type, key, username, payload, max_attempts)
+VALUES ($1, $2, $3, $4, $5)
+ON CONFLICT (key) WHERE status IN ('queued', 'running') DO NOTHING
+RETURNING ` + jobColumns
+	var j Job
+	err := sqlx.GetContext(ctx, q, &j, query, job.Type, job.Key, job.Username, []byte(job.Payload), job.MaxAttempts)
+	if errors.Is(err, sql.ErrNoRows) {
+		query = `SELECT ` + jobColumns + ` FROM job WHERE key = $1 AND status IN ('queued', 'running')`
+		err = sqlx.GetContext(ctx, q, &j, query, job.Key)
+	}
+	if err != nil {
+		return Job{}, fmt.Errorf("err enqueueing %s job: %w", job.Type, err)
+	}
+	return j, nil
+}
+
+func (pg *PG) GetJob(ctx context.Context, id int64) (Job, error) {
+	var j Job
+	if err := pg.db.GetContext(ctx, &j, `SELECT `+jobColumns+` FROM job WHERE id = $1`, id); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return Job{}, ErrJobNotFound
+		}
+		metrics.MetricErrCount.WithLabelValues("GetJob").Inc()
+		return Job{}, fmt.Errorf("err getting job: %w", err)
+	}
+	return j, nil
+}
+
+// GetJobs returns up to limit of the latest jobs, optionally of a status and a type.
+func (pg *PG) GetJobs(ctx context.Context, status, jobType string, limit int) ([]Job, error) {
+	jobs := make([]Job, 0)
+	query := `SELECT ` + jobColumns + ` FROM job WHERE ($1 = '' OR status = $1) AND ($2 = '' OR type = $2) ORDER BY id DESC LIMIT $3`
+	if err := pg.db.SelectContext(ctx, &jobs, query, status, jobType, limit); err != nil {
+		metrics.MetricErrCount.WithLabelValues("GetJobs").Inc()
+		return nil, fmt.Errorf("err getting jobs: %w", err)
+	}
+	return jobs, nil
+}
+
+// GetJobOutput returns the file a succeeded job produced with its content type.
+func (pg *PG) GetJobOutput(ctx context.Context, id int64) ([]byte, string, error) {
+	var out struct {
+		Output      []byte  `db:"output"`
+		ContentType *string `db:"content_type"`
+	}
+	query := `SELECT output, content_type FROM job WHERE id = $1 AND status = 'succeeded'`
+	if err := pg.db.GetContext(ctx, &out, query, id); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, "", ErrJobOutputNotFound
+		}
+		metrics.MetricErrCount.WithLabelValues("GetJobOutput").Inc()
+		return nil, "", fmt.Errorf("err getting job output: %w", err)
+	}
+	if out.Output == nil || out.ContentType == nil {
+		return nil, "", ErrJobOutputNotFound
+	}
+	return out.Output, *out.ContentType, nil
+}
+
+// ClaimJobs takes up to limit due jobs of the types and hides them from other workers for visibility.
+// A running job whose lock expired is due again while it has attempts left. Every claim is an attempt.
+func (pg *PG) ClaimJobs(ctx context.Context, types []string, limit int, visibility time.Duration) ([]Job, error) {
+	started := time.Now()
+	defer func() {
+		metrics.MetricDBRequestsDuration.WithLabelValues("ClaimJobs").Observe(time.Since(started).Seconds())
+	}()
+	query := `
+WITH due AS (SELECT id
+             FROM job
+             WHERE type = ANY ($1)
+               AND (status = 'queued' AND run_at <= now()
+                 OR status = 'running' AND locked_until <= now() AND attempts < max_attempts)
+             ORDER BY run_at, id
+             LIMIT $2 FOR UPDATE SKIP LOCKED)
+UPDATE job j
+SET status       = 'running',
+    attempts     = j.attempts + 1,
+    locked_until = now() + make_interval(secs => $3),
+    started_at   = coalesce(j.started_at, now())
+FROM due
+WHERE j.id = due.id
+RETURNING j.id, j.type, j.key, j.username, j.payload, j.status, j.attempts, j.max_attempts, j.run_at,
+          j.locked_until, j.last_error, j.result, j.content_type, j.created_at, j.started_at, j.finished_at`
+	jobs := make([]Job, 0, limit)
+	if err := pg.db.SelectContext(ctx, &jobs, query, types, limit, visibility.Seconds()); err != nil {
+		metrics.MetricErrCount.WithLabelValues("ClaimJobs").Inc()
+		return nil, fmt.Errorf("err claiming jobs: %w", err)
+	}
+	return jobs, nil
+}
+
+// ExtendJob keeps a running job hidden for visibility from now. It returns false when the job was
+// claimed again by another worker meanwhile.
+func (pg *PG) ExtendJob(ctx context.Context, job Job, visibility time.Duration) (bool, error) {
+	query := `
+UPDATE job
+SET locked_until = now() + make_interval(secs => $1)
+WHERE id = $2
+  AND status = 'running'
+  AND attempts = $3`
+	return pg.updateJob(ctx, "ExtendJob", query, visibility.Seconds(), job.Id, job.Attempts)
+}
+
+// CompleteJob marks the job succeeded with its result and an optional output file. It returns false
+// when the job was claimed again by another worker meanwhile.
+func (pg *PG) CompleteJob(ctx context.Context, job Job, result json.RawMessage, output []byte, contentType string) (bool, error) {
+	var ct *string
+	if output != nil {
+		ct = &contentType
+	}
+	query := `
+UPDATE job
+SET status       = 'succeeded',
+    result       = $1,
+    output       = $2,
+    content_type = $3,
+    locked_until = NULL,
+    last_error   = NULL,
+    finished_at  = now()
+WHERE id = $4
+  AND status = 'running'
+  AND attempts = $5`
+	return pg.updateJob(ctx, "CompleteJob", query, []byte(result), output, ct, job.Id, job.Attempts)
+}
+
+// RetryJob queues the failed job again to run at runAt.
+func (pg *PG) RetryJob(ctx context.Context, job Job, reason string, runAt time.Time) (bool, error) {
+	query := `
+UPDATE job
+SET status       = 'queued',
+    run_at       = $1,
+    last_error   = $2,
+    locked_until = NULL
+WHERE id = $3
+  AND status = 'running'
+  AND attempts = $4`
+	return pg.updateJob(ctx, "RetryJob", query, runAt, reason, job.Id, job.Attempts)
+}
+
+// ReleaseJob queues the job that didn't get to finish to run again right away and gives its attempt back.
+func (pg *PG) ReleaseJob(ctx context.Context, job Job, reason string) (bool, error) {
+	query := `
+UPDATE job
+SET status       = 'queued',
+    run_at       = now(),
+    attempts     = attempts - 1,
+    last_error   = $1,
+    locked_until = NULL
+WHERE id = $2
+  AND status = 'running'
+  AND attempts = $3`
+	return pg.updateJob(ctx, "ReleaseJob", query, reason, job.Id, job.Attempts)
+}
+
+// FailJob marks the job failed for good.
+func (pg *PG) FailJob(ctx context.Context, job Job, reason string) (bool, error) {
+	query := `
+UPDATE job
+SET status       = 'failed',
+    last_error   = $1,
+    locked_until = NULL,
+    finished_at  = now()
+WHERE id = $2
+  AND status = 'running'
+  AND attempts = $3`
+	return pg.updateJob(ctx, "FailJob", query, reason, job.Id, job.Attempts)
+}
+
+func (pg *PG) updateJob(ctx context.Context, method, query string, args ...interface{}) (bool, error) {
+	res, err := pg.db.ExecContext(ctx, query, args...)
+	if err != nil {
+		metrics.MetricErrCount.WithLabelValues(method).Inc()
+		return false, fmt.Errorf("err updating job: %w", err)
+	}
+	cnt, _ := res.RowsAffected()
+	return cnt > 0, nil
+}
+
+// FailExpiredJobs fails the running jobs whose lock expired on their last attempt, their worker is
+// gone and nobody would claim them again.
+func (pg *PG) FailExpiredJobs(ctx context.Context) (int64, error) {
+	query := `
+UPDATE job
+SET status       = 'failed',
+    last_error   = 'visibility timeout expired on the last attempt',
+    locked_until = NULL,
+    finished_at  = now()
+WHERE status = 'running'
+  AND locked_until <= now()
+  AND attempts >= max_attempts`
+	res, err := pg.db.ExecContext(ctx, query)
+	if err != nil {
+		metrics.MetricErrCount.WithLabelValues("FailExpiredJobs").Inc()
+		return 0, fmt.Errorf("err failing expired jobs: %w", err)
+	}
+	return res.RowsAffected()
+}
+
+// DeleteFinishedJobs deletes the succeeded and failed jobs finished before the time, outputs included.
+func (pg *PG) DeleteFinishedJobs(ctx context.Context, before time.Time) (int64, error) {
+	query := `DELETE FROM job WHERE status IN ('succeeded', 'failed') AND finished_at < $1`
+	res, err := pg.db.ExecContext(ctx, query, before)
+	if err != nil {
+		metrics.MetricErrCount.WithLabelValues("DeleteFinishedJobs").Inc()
+		return 0, fmt.Errorf("err deleting finished jobs: %w", err)
+	}
+	return res.RowsAffected()
+}
+
+// GetJobStats counts the queued and running jobs by type.
+func (pg *PG) GetJobStats(ctx context.Context) ([]JobStat, error) {
+	stats := make([]JobStat, 0)
+	query := `
+SELECT type, status, count(*) AS count, min(run_at) AS oldest
+FROM job
+WHERE status IN ('queued', 'running')
+GROUP BY type, status`
+	if err := pg.db.SelectContext(ctx, &stats, query); err != nil {
+		metrics.MetricErrCount.WithLabelValues("GetJobStats").Inc()
+		return nil, fmt.Errorf("err getting job stats: %w", err)
+	}
+	return stats, nil
+}
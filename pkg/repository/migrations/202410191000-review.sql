@@ -0,0 +1,23 @@
+-- noinspection SqlNoDataSourceInspectionForFile
+
+-- +migrate Up
+ALTER TABLE wallet ADD COLUMN IF NOT EXISTS owner_changed_at timestamptz DEFAULT NULL;
+CREATE TABLE IF NOT EXISTS review
+(
+    id          bigserial PRIMARY KEY,
+    uuid        text UNIQUE    NOT NULL,
+    wallet_id   integer        NOT NULL,
+    target_id   integer     DEFAULT NULL,
+    operation   varchar        NOT NULL,
+    sum         numeric(10, 2) NOT NULL,
+    rule        varchar        NOT NULL,
+    reason      varchar        NOT NULL,
+    status      varchar        NOT NULL DEFAULT 'pending',
+    reviewer    varchar     DEFAULT NULL,
+    created_at  timestamptz    NOT NULL DEFAULT now(),
+    resolved_at timestamptz DEFAULT NULL
+);
+CREATE INDEX IF NOT EXISTS review_status_idx ON review (status);
+-- +migrate Down
+DROP TABLE review;
+ALTER TABLE wallet DROP COLUMN owner_changed_at;
@@ -0,0 +1,33 @@
+-- noinspection SqlNoDataSourceInspectionForFile
+
+-- +migrate Up
+ALTER TABLE transaction ADD COLUMN IF NOT EXISTS status varchar NOT NULL DEFAULT 'completed';
+ALTER TABLE transaction ADD COLUMN IF NOT EXISTS reason varchar DEFAULT NULL;
+ALTER TABLE transaction ADD COLUMN IF NOT EXISTS updated_at timestamptz NOT NULL DEFAULT now();
+UPDATE transaction SET updated_at = date;
+-- failed attempts may reuse the uuid of the request, only one live transaction per uuid is allowed
+ALTER TABLE transaction DROP CONSTRAINT IF EXISTS transaction_uuid_key;
+CREATE UNIQUE INDEX IF NOT EXISTS transaction_uuid_idx ON transaction (uuid) WHERE status <> 'failed';
+CREATE INDEX IF NOT EXISTS transaction_status_idx ON transaction (status);
+CREATE TABLE IF NOT EXISTS transaction_status
+(
+    id             bigserial PRIMARY KEY,
+    transaction_id integer     NOT NULL REFERENCES transaction (id) ON DELETE CASCADE,
+    status         varchar     NOT NULL,
+    reason         varchar  DEFAULT NULL,
+    changed_at     timestamptz NOT NULL DEFAULT now()
+);
+CREATE INDEX IF NOT EXISTS transaction_status_transaction_idx ON transaction_status (transaction_id);
+INSERT INTO transaction_status (transaction_id, status, changed_at)
+SELECT id, status, date
+FROM transaction;
+ALTER TABLE review ADD COLUMN IF NOT EXISTS transaction_id integer DEFAULT NULL REFERENCES transaction (id);
+-- +migrate Down
+ALTER TABLE review DROP COLUMN transaction_id;
+DROP TABLE transaction_status;
+DELETE FROM transaction WHERE status = 'failed';
+DROP INDEX transaction_uuid_idx;
+ALTER TABLE transaction ADD CONSTRAINT transaction_uuid_key UNIQUE (uuid);
+ALTER TABLE transaction DROP COLUMN status;
+ALTER TABLE transaction DROP COLUMN reason;
+ALTER TABLE transaction DROP COLUMN updated_at;
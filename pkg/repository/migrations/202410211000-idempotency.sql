@@ -0,0 +1,13 @@
+-- noinspection SqlNoDataSourceInspectionForFile
+
+-- +migrate Up
+CREATE TABLE IF NOT EXISTS idempotency_key
+(
+    key         text PRIMARY KEY,
+    fingerprint text        NOT NULL,
+    status_code integer  DEFAULT NULL,
+    response    bytea    DEFAULT NULL,
+    created_at  timestamptz NOT NULL DEFAULT now()
+);
+-- +migrate Down
+DROP TABLE idempotency_key;
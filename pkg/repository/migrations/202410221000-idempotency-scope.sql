@@ -0,0 +1,36 @@
+-- noinspection SqlNoDataSourceInspectionForFile
+
+-- +migrate Up
+DROP TABLE IF EXISTS idempotency_key;
+CREATE TABLE IF NOT EXISTS idempotency_key
+(
+    username    varchar     NOT NULL,
+    operation   varchar     NOT NULL,
+    wallet_id   integer     NOT NULL DEFAULT 0,
+    key         text        NOT NULL,
+    fingerprint text        NOT NULL,
+    status_code integer  DEFAULT NULL,
+    response    bytea    DEFAULT NULL,
+    created_at  timestamptz NOT NULL DEFAULT now(),
+    expires_at  timestamptz NOT NULL,
+    PRIMARY KEY (username, operation, wallet_id, key)
+);
+CREATE INDEX IF NOT EXISTS idempotency_key_expires_at_idx ON idempotency_key (expires_at);
+DROP INDEX IF EXISTS transaction_uuid_idx;
+CREATE UNIQUE INDEX IF NOT EXISTS transaction_uuid_idx ON transaction (from_id, operation, uuid) WHERE status <> 'failed';
+ALTER TABLE review DROP CONSTRAINT IF EXISTS review_uuid_key;
+CREATE UNIQUE INDEX IF NOT EXISTS review_uuid_idx ON review (wallet_id, operation, uuid);
+-- +migrate Down
+DROP INDEX review_uuid_idx;
+ALTER TABLE review ADD CONSTRAINT review_uuid_key UNIQUE (uuid);
+DROP INDEX transaction_uuid_idx;
+CREATE UNIQUE INDEX IF NOT EXISTS transaction_uuid_idx ON transaction (uuid) WHERE status <> 'failed';
+DROP TABLE idempotency_key;
+CREATE TABLE IF NOT EXISTS idempotency_key
+(
+    key         text PRIMARY KEY,
+    fingerprint text        NOT NULL,
+    status_code integer  DEFAULT NULL,
+    response    bytea    DEFAULT NULL,
+    created_at  timestamptz NOT NULL DEFAULT now()
+);
@@ -0,0 +1,15 @@
+-- noinspection SqlNoDataSourceInspectionForFile
+
+-- +migrate Up
+CREATE TABLE IF NOT EXISTS outbox
+(
+    id           bigserial PRIMARY KEY,
+    type         varchar     NOT NULL,
+    wallet_id    integer     NOT NULL,
+    payload      jsonb       NOT NULL,
+    created_at   timestamptz NOT NULL DEFAULT now(),
+    published_at timestamptz DEFAULT NULL
+);
+CREATE INDEX IF NOT EXISTS outbox_unpublished_idx ON outbox (id) WHERE published_at IS NULL;
+-- +migrate Down
+DROP TABLE outbox;
@@ -0,0 +1,45 @@
+-- noinspection SqlNoDataSourceInspectionForFile
+
+-- +migrate Up
+CREATE TABLE IF NOT EXISTS webhook_subscription
+(
+    id         bigserial PRIMARY KEY,
+    username   varchar     NOT NULL,
+    wallet_id  integer     NOT NULL,
+    event_type varchar     NOT NULL,
+    url        varchar     NOT NULL,
+    secret     varchar     NOT NULL,
+    created_at timestamptz NOT NULL DEFAULT now()
+);
+CREATE INDEX IF NOT EXISTS webhook_subscription_wallet_idx ON webhook_subscription (wallet_id);
+CREATE TABLE IF NOT EXISTS webhook_delivery
+(
+    id               bigserial PRIMARY KEY,
+    subscription_id  integer     NOT NULL REFERENCES webhook_subscription (id) ON DELETE CASCADE,
+    event_id         bigint   DEFAULT NULL,
+    event_type       varchar     NOT NULL,
+    payload          jsonb       NOT NULL,
+    status           varchar     NOT NULL DEFAULT 'pending',
+    attempts         integer     NOT NULL DEFAULT 0,
+    next_attempt_at  timestamptz NOT NULL DEFAULT now(),
+    last_status_code integer  DEFAULT NULL,
+    last_error       varchar  DEFAULT NULL,
+    created_at       timestamptz NOT NULL DEFAULT now(),
+    delivered_at     timestamptz DEFAULT NULL,
+    UNIQUE (subscription_id, event_id)
+);
+CREATE INDEX IF NOT EXISTS webhook_delivery_due_idx ON webhook_delivery (next_attempt_at) WHERE status = 'pending';
+CREATE TABLE IF NOT EXISTS webhook_attempt
+(
+    id           bigserial PRIMARY KEY,
+    delivery_id  integer     NOT NULL REFERENCES webhook_delivery (id) ON DELETE CASCADE,
+    status_code  integer  DEFAULT NULL,
+    error        varchar  DEFAULT NULL,
+    duration_ms  integer     NOT NULL,
+    attempted_at timestamptz NOT NULL DEFAULT now()
+);
+CREATE INDEX IF NOT EXISTS webhook_attempt_delivery_idx ON webhook_attempt (delivery_id);
+-- +migrate Down
+DROP TABLE webhook_attempt;
+DROP TABLE webhook_delivery;
+DROP TABLE webhook_subscription;
@@ -0,0 +1,22 @@
+-- noinspection SqlNoDataSourceInspectionForFile
+
+-- +migrate Up
+-- +migrate StatementBegin
+CREATE OR REPLACE FUNCTION notify_outbox() RETURNS trigger AS
+$$
+BEGIN
+    PERFORM pg_notify('outbox', NEW.id::text);
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+-- +migrate StatementEnd
+CREATE TRIGGER outbox_notify
+    AFTER INSERT
+    ON outbox
+    FOR EACH ROW
+EXECUTE FUNCTION notify_outbox();
+CREATE INDEX IF NOT EXISTS outbox_wallet_idx ON outbox (wallet_id, id);
+-- +migrate Down
+DROP INDEX outbox_wallet_idx;
+DROP TRIGGER outbox_notify ON outbox;
+DROP FUNCTION notify_outbox();
@@ -0,0 +1,11 @@
+-- +migrate Up
+-- keyset pagination of the wallet history walks (date, id) and (sum, id) of either side of a transaction
+CREATE INDEX IF NOT EXISTS transaction_from_date_idx ON transaction (from_id, date, id);
+CREATE INDEX IF NOT EXISTS transaction_to_date_idx ON transaction (to_id, date, id) WHERE to_id IS NOT NULL;
+CREATE INDEX IF NOT EXISTS transaction_from_sum_idx ON transaction (from_id, sum, id);
+CREATE INDEX IF NOT EXISTS transaction_to_sum_idx ON transaction (to_id, sum, id) WHERE to_id IS NOT NULL;
+-- +migrate Down
+DROP INDEX IF EXISTS transaction_from_date_idx;
+DROP INDEX IF EXISTS transaction_to_date_idx;
+DROP INDEX IF EXISTS transaction_from_sum_idx;
+DROP INDEX IF EXISTS transaction_to_sum_idx;
@@ -0,0 +1,16 @@
+-- noinspection SqlNoDataSourceInspectionForFile
+
+-- +migrate Up
+CREATE TABLE IF NOT EXISTS statement_document
+(
+    id           bigserial PRIMARY KEY,
+    wallet_id    integer     NOT NULL REFERENCES wallet (id) ON DELETE CASCADE,
+    period_start timestamptz NOT NULL,
+    period_end   timestamptz NOT NULL,
+    pdf          bytea       NOT NULL,
+    signature    varchar     NOT NULL,
+    created_at   timestamptz NOT NULL DEFAULT now(),
+    UNIQUE (wallet_id, period_start)
+);
+-- +migrate Down
+DROP TABLE statement_document;
@@ -0,0 +1,29 @@
+-- noinspection SqlNoDataSourceInspectionForFile
+
+-- +migrate Up
+CREATE TABLE IF NOT EXISTS payout
+(
+    id          bigserial PRIMARY KEY,
+    wallet_id   integer        NOT NULL,
+    atomic      boolean        NOT NULL,
+    status      varchar        NOT NULL DEFAULT 'processing',
+    total       numeric(12, 2) NOT NULL,
+    created_at  timestamptz    NOT NULL DEFAULT now(),
+    finished_at timestamptz DEFAULT NULL
+);
+CREATE INDEX IF NOT EXISTS payout_wallet_idx ON payout (wallet_id);
+CREATE TABLE IF NOT EXISTS payout_item
+(
+    id        bigserial PRIMARY KEY,
+    payout_id integer        NOT NULL REFERENCES payout (id) ON DELETE CASCADE,
+    position  integer        NOT NULL,
+    target_id integer        NOT NULL,
+    sum       numeric(10, 2) NOT NULL,
+    uuid      text           NOT NULL,
+    status    varchar        NOT NULL DEFAULT 'pending',
+    error     varchar     DEFAULT NULL,
+    UNIQUE (payout_id, position)
+);
+-- +migrate Down
+DROP TABLE payout_item;
+DROP TABLE payout;
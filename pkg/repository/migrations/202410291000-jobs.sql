@@ -0,0 +1,31 @@
+-- noinspection SqlNoDataSourceInspectionForFile
+
+-- +migrate Up
+CREATE TABLE IF NOT EXISTS job
+(
+    id           bigserial PRIMARY KEY,
+    type         varchar     NOT NULL,
+    key          varchar   DEFAULT NULL,
+    username     varchar     NOT NULL DEFAULT '',
+    payload      jsonb       NOT NULL DEFAULT '{}',
+    status       varchar     NOT NULL DEFAULT 'queued',
+    attempts     integer     NOT NULL DEFAULT 0,
+    max_attempts integer     NOT NULL DEFAULT 5,
+    run_at       timestamptz NOT NULL DEFAULT now(),
+    locked_until timestamptz DEFAULT NULL,
+    last_error   varchar   DEFAULT NULL,
+    result       jsonb     DEFAULT NULL,
+    output       bytea     DEFAULT NULL,
+    content_type varchar   DEFAULT NULL,
+    created_at   timestamptz NOT NULL DEFAULT now(),
+    started_at   timestamptz DEFAULT NULL,
+    finished_at  timestamptz DEFAULT NULL
+);
+CREATE INDEX IF NOT EXISTS job_due_idx ON job (run_at) WHERE status IN ('queued', 'running');
+CREATE UNIQUE INDEX IF NOT EXISTS job_key_idx ON job (key) WHERE status IN ('queued', 'running');
+ALTER TABLE payout
+    ADD COLUMN IF NOT EXISTS job_id bigint DEFAULT NULL;
+-- +migrate Down
+ALTER TABLE payout
+    DROP COLUMN job_id;
+DROP TABLE job;
@@ -0,0 +1,9 @@
+-- noinspection SqlNoDataSourceInspectionForFile
+
+-- +migrate Up
+-- a request holds its key until locked_until, then a retry of the same request may take the key over
+ALTER TABLE idempotency_key
+    ADD COLUMN IF NOT EXISTS locked_until timestamptz NOT NULL DEFAULT now();
+-- +migrate Down
+ALTER TABLE idempotency_key
+    DROP COLUMN IF EXISTS locked_until;
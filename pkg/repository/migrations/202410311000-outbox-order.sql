@@ -0,0 +1,24 @@
+-- noinspection SqlNoDataSourceInspectionForFile
+
+-- +migrate Up
+-- Transfers are keyed by both wallets. txid is the id of the transaction writing the event: the dispatcher
+-- publishes only the events of finished transactions, in txid order.
+ALTER TABLE outbox
+    ADD COLUMN IF NOT EXISTS target_id integer DEFAULT NULL;
+ALTER TABLE outbox
+    ADD COLUMN IF NOT EXISTS txid bigint NOT NULL DEFAULT txid_current();
+UPDATE outbox
+SET target_id = (payload ->> 'to_id')::integer
+WHERE target_id IS NULL
+  AND payload ->> 'to_id' IS NOT NULL;
+CREATE INDEX IF NOT EXISTS outbox_target_idx ON outbox (target_id, id) WHERE target_id IS NOT NULL;
+DROP INDEX IF EXISTS outbox_unpublished_idx;
+CREATE INDEX IF NOT EXISTS outbox_unpublished_idx ON outbox (txid, id) WHERE published_at IS NULL;
+-- +migrate Down
+DROP INDEX IF EXISTS outbox_unpublished_idx;
+CREATE INDEX IF NOT EXISTS outbox_unpublished_idx ON outbox (id) WHERE published_at IS NULL;
+DROP INDEX IF EXISTS outbox_target_idx;
+ALTER TABLE outbox
+    DROP COLUMN IF EXISTS txid;
+ALTER TABLE outbox
+    DROP COLUMN IF EXISTS target_id;
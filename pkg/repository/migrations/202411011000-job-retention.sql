@@ -0,0 +1,7 @@
+-- noinspection SqlNoDataSourceInspectionForFile
+
+-- +migrate Up
+-- Finished jobs are deleted once they are older than the retention.
+CREATE INDEX IF NOT EXISTS job_finished_idx ON job (finished_at) WHERE status IN ('succeeded', 'failed');
+-- +migrate Down
+DROP INDEX IF EXISTS job_finished_idx;
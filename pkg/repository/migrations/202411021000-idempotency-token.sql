@@ -0,0 +1,9 @@
+-- noinspection SqlNoDataSourceInspectionForFile
+
+-- +migrate Up
+-- the token of the reservation, only the request holding it may settle the key
+ALTER TABLE idempotency_key
+    ADD COLUMN IF NOT EXISTS token text NOT NULL DEFAULT '';
+-- +migrate Down
+ALTER TABLE idempotency_key
+    DROP COLUMN IF EXISTS token;
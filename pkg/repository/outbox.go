@@ -0,0 +1,168 @@
+package repository
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	"EWallet/pkg/events"
+	"EWallet/pkg/metrics"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/jmoiron/sqlx"
+)
+
+// outboxLockID is the advisory lock making a single dispatcher publish at a time, which keeps the order.
+const outboxLockID = 727031
+
+type walletEvent struct {
+	WalletId int     `json:"wallet_id"`
+	Owner    string  `json:"owner,omitempty"`
+	Balance  float64 `json:"balance"`
+}
+
+// insertEvent records the event of the wallet, targetID is the other wallet of a transfer.
+func (pg *PG) insertEvent(ctx context.Context, tx *sqlx.Tx, eventType string, walletID int, targetID *int, payload interface{}) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("err encoding %s event: %w", eventType, err)
+	}
+	query := `INSERT INTO outbox (type, wallet_id, target_id, payload) VALUES ($1, $2, $3, $4)`
+	if _, err = tx.ExecContext(ctx, query, eventType, walletID, targetID, body); err != nil {
+		return fmt.Errorf("err inserting %s event: %w", eventType, err)
+	}
+	return nil
+}
+
+// insertTransactionEvent records the event of a completed transaction.
+func (pg *PG) insertTransactionEvent(ctx context.Context, tx *sqlx.Tx, t *Transaction) error {
+	switch t.Operation {
+	case "deposit":
+		return pg.insertEvent(ctx, tx, events.Deposited, t.FromId, nil, t)
+	case "withdraw":
+		return pg.insertEvent(ctx, tx, events.Withdrawn, t.FromId, nil, t)
+	case "transfer":
+		return pg.insertEvent(ctx, tx, events.Transferred, t.FromId, t.ToId, t)
+	}
+	return fmt.Errorf("unknown operation %q", t.Operation)
+}
+
+// DispatchEvents publishes the events of finished transactions in txid order, the order the transactions
+// writing them got their ids, and never past the oldest transaction still running: its events are
+// published before the events of later txids. This is not the commit order, a transaction committing
+// after a later one still has its events published first.
+func (pg *PG) DispatchEvents(ctx context.Context, limit int, publish func(ctx context.Context, events []events.Event) error) (int, error) {
+	started := time.Now()
+	defer func() {
+		metrics.MetricDBRequestsDuration.WithLabelValues("DispatchEvents").Observe(time.Since(started).Seconds())
+	}()
+	var cnt int
+	err := pg.runTx(ctx, nil, func(tx *sqlx.Tx) error {
+		var locked bool
+		if err := tx.GetContext(ctx, &locked, `SELECT pg_try_advisory_xact_lock($1)`, outboxLockID); err != nil {
+			return fmt.Errorf("err locking outbox: %w", err)
+		}
+		if !locked {
+			return nil
+		}
+		batch := make([]events.Event, 0, limit)
+		query := `
+SELECT id, type, wallet_id, target_id, payload, created_at
+FROM outbox
+WHERE published_at IS NULL
+  AND txid < txid_snapshot_xmin(txid_current_snapshot())
+ORDER BY txid, id
+LIMIT $1`
+		if err := tx.SelectContext(ctx, &batch, query, limit); err != nil {
+			return fmt.Errorf("err getting events: %w", err)
+		}
+		if len(batch) == 0 {
+			return nil
+		}
+		if err := publish(ctx, batch); err != nil {
+			return fmt.Errorf("err publishing events: %w", err)
+		}
+		ids := make([]int64, 0, len(batch))
+		for _, e := range batch {
+			ids = append(ids, e.Id)
+		}
+		query = `UPDATE outbox SET published_at = now() WHERE id = ANY ($1)`
+		if _, err := tx.ExecContext(ctx, query, ids); err != nil {
+			return fmt.Errorf("err marking events published: %w", err)
+		}
+		cnt = len(batch)
+		return nil
+	})
+	if err != nil {
+		metrics.MetricErrCount.WithLabelValues("DispatchEvents").Inc()
+		return 0, err
+	}
+	return cnt, nil
+}
+
+// outboxChannel is notified with the id of every committed outbox event.
+const outboxChannel = "outbox"
+
+func (pg *PG) GetEvent(ctx context.Context, id int64) (events.Event, error) {
+	query := `SELECT id, type, wallet_id, target_id, payload, created_at FROM outbox WHERE id = $1`
+	var e events.Event
+	if err := pg.db.GetContext(ctx, &e, query, id); err != nil {
+		return events.Event{}, fmt.Errorf("err getting event %d: %w", id, err)
+	}
+	return e, nil
+}
+
+// GetWalletEvents returns up to limit events after afterID touching the wallet, including incoming transfers.
+func (pg *PG) GetWalletEvents(ctx context.Context, walletID int, afterID int64, limit int) ([]events.Event, error) {
+	started := time.Now()
+	defer func() {
+		metrics.MetricDBRequestsDuration.WithLabelValues("GetWalletEvents").Observe(time.Since(started).Seconds())
+	}()
+	batch := make([]events.Event, 0)
+	query := `
+SELECT id, type, wallet_id, target_id, payload, created_at
+FROM outbox
+WHERE id > $1
+  AND (wallet_id = $2 OR target_id = $2)
+ORDER BY id
+LIMIT $3`
+	if err := pg.db.SelectContext(ctx, &batch, query, afterID, walletID, limit); err != nil {
+		metrics.MetricErrCount.WithLabelValues("GetWalletEvents").Inc()
+		return nil, fmt.Errorf("err getting wallet events: %w", err)
+	}
+	return batch, nil
+}
+
+// ListenEvents calls notify with the id of every outbox event committed while it runs.
+// It blocks until ctx is done or the connection fails.
+func (pg *PG) ListenEvents(ctx context.Context, notify func(id int64)) error {
+	conn, err := pgx.Connect(ctx, pg.dsn)
+	if err != nil {
+		return fmt.Errorf("err connecting listener: %w", err)
+	}
+	defer func() {
+		if err := conn.Close(context.Background()); err != nil {
+			pg.log.Errorf("err closing listener connection: %v", err)
+		}
+	}()
+	if _, err = conn.Exec(ctx, "LISTEN "+outboxChannel); err != nil {
+		return fmt.Errorf("err listening to outbox: %w", err)
+	}
+	for {
+		n, err := conn.WaitForNotification(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return fmt.Errorf("err waiting for notification: %w", err)
+		}
+		id, err := strconv.ParseInt(n.Payload, 10, 64)
+		if err != nil {
+			pg.log.Errorf("err parsing outbox notification %q: %v", n.Payload, err)
+			continue
+		}
+		notify(id)
+	}
+}
@@ -0,0 +1,185 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	"EWallet/pkg/metrics"
+	"EWallet/pkg/models"
+)
+
+const (
+	DirectionIncoming = "incoming"
+	DirectionOutgoing = "outgoing"
+
+	defaultPageSize = 100
+)
+
+var ErrInvalidCursor = fmt.Errorf("err invalid cursor")
+
+// TransactionPage is a page of the transaction history of a wallet. Total counts every transaction
+// matching the filters, NextCursor and PrevCursor are empty on the last and the first page.
+type TransactionPage struct {
+	Transactions []Transaction `json:"transactions"`
+	Total        int           `json:"total"`
+	NextCursor   string        `json:"next_cursor,omitempty"`
+	PrevCursor   string        `json:"prev_cursor,omitempty"`
+}
+
+// cursor points right after (or, with Backward, right before) the transaction Id whose sort key is
+// Key. Sort and Desc pin the order the cursor was issued for.
+type cursor struct {
+	Sort     string `json:"s"`
+	Desc     bool   `json:"d"`
+	Key      string `json:"k"`
+	Id       int    `json:"i"`
+	Backward bool   `json:"b,omitempty"`
+}
+
+func (c cursor) encode() string {
+	data, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeCursor(s string, params *models.TransactionQueryParams) (*cursor, error) {
+	if s == "" {
+		return nil, nil
+	}
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	var c cursor
+	if err = json.Unmarshal(data, &c); err != nil {
+		return nil, ErrInvalidCursor
+	}
+	if c.Sort != sortColumn(params.Sort) || c.Desc != params.Desc {
+		return nil, ErrInvalidCursor
+	}
+	return &c, nil
+}
+
+func sortColumn(sort string) string {
+	if sort == "sum" {
+		return "sum"
+	}
+	return "date"
+}
+
+func sortKey(column string, t Transaction) string {
+	if column == "sum" {
+		return strconv.FormatFloat(t.Sum, 'f', 2, 64)
+	}
+	return t.Date.Format(time.RFC3339Nano)
+}
+
+// transactionFilter selects the transactions of wallet $1 matching the filters $2-$9. Unset filters
+// are passed as zero values or NULL so that the query text never depends on the input.
+const transactionFilter = `
+FROM transaction
+WHERE (from_id = $1 OR to_id = $1)
+  AND ($2 = '' OR status = $2)
+  AND ($3::timestamptz IS NULL OR date >= $3)
+  AND ($4::timestamptz IS NULL OR date < $4)
+  AND ($5 = '' OR operation = $5)
+  AND ($6 = '' OR ($6 = 'incoming') = (operation = 'deposit' OR coalesce(to_id = $1, false)))
+  AND ($7::numeric = 0 OR sum >= $7)
+  AND ($8::numeric = 0 OR sum <= $8)
+  AND ($9::integer = 0 OR (from_id = $9 AND to_id = $1) OR (from_id = $1 AND to_id = $9))`
+
+// GetTransactions returns a page of the wallet transactions. Pages are keyset paginated by the sort
+// column and the id, so transactions inserted meanwhile neither shift nor repeat the rows of the
+// following pages. The page and the total are read from the same snapshot.
+func (pg *PG) GetTransactions(ctx context.Context, id int, params *models.TransactionQueryParams) (TransactionPage, error) {
+	started := time.Now()
+	defer func() {
+		metrics.MetricDBRequestsDuration.WithLabelValues("GetTransactions").Observe(time.Since(started).Seconds())
+	}()
+	if params == nil {
+		params = &models.TransactionQueryParams{Desc: true}
+	}
+	after, err := decodeCursor(params.Cursor, params)
+	if err != nil {
+		return TransactionPage{}, err
+	}
+	if _, err = pg.GetWallet(ctx, id); err != nil {
+		return TransactionPage{}, err
+	}
+	limit := params.Limit
+	if limit == 0 {
+		limit = defaultPageSize
+	}
+	column := sortColumn(params.Sort)
+	cast := "timestamptz"
+	if column == "sum" {
+		cast = "numeric"
+	}
+	desc := params.Desc
+	var key *string
+	var keyId int
+	if after != nil {
+		key, keyId = &after.Key, after.Id
+		desc = desc != after.Backward
+	}
+	op, order := ">", "ASC"
+	if desc {
+		op, order = "<", "DESC"
+	}
+	// Only whitelisted column names and operators are formatted into the query.
+	query := fmt.Sprintf(`
+SELECT id, uuid, from_id, to_id, operation, sum, date, status, reason, updated_at
+%s
+  AND ($10::text IS NULL OR (%s, id) %s ($10::text::%s, $11))
+ORDER BY %s %s, id %s
+LIMIT $12`, transactionFilter, column, op, cast, column, order, order)
+	args := []interface{}{id, params.Status, params.From, params.To, params.Operation, params.Direction,
+		params.MinSum, params.MaxSum, params.Counterparty}
+
+	tx, err := pg.db.BeginTxx(ctx, &sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: true})
+	if err != nil {
+		metrics.MetricErrCount.WithLabelValues("GetTransactions").Inc()
+		return TransactionPage{}, fmt.Errorf("err starting transaction: %w", err)
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+	page := TransactionPage{Transactions: []Transaction{}}
+	if err = tx.SelectContext(ctx, &page.Transactions, query, append(args, key, keyId, limit+1)...); err != nil {
+		metrics.MetricErrCount.WithLabelValues("GetTransactions").Inc()
+		return TransactionPage{}, fmt.Errorf("err getting transactions: %w", err)
+	}
+	if err = tx.GetContext(ctx, &page.Total, "SELECT count(*) "+transactionFilter, args...); err != nil {
+		metrics.MetricErrCount.WithLabelValues("GetTransactions").Inc()
+		return TransactionPage{}, fmt.Errorf("err counting transactions: %w", err)
+	}
+	if err = tx.Commit(); err != nil {
+		return TransactionPage{}, fmt.Errorf("err committing transaction: %w", err)
+	}
+
+	more := len(page.Transactions) > limit
+	if more {
+		page.Transactions = page.Transactions[:limit]
+	}
+	backward := after != nil && after.Backward
+	if backward {
+		for i, j := 0, len(page.Transactions)-1; i < j; i, j = i+1, j-1 {
+			page.Transactions[i], page.Transactions[j] = page.Transactions[j], page.Transactions[i]
+		}
+	}
+	if len(page.Transactions) == 0 {
+		return page, nil
+	}
+	first, last := page.Transactions[0], page.Transactions[len(page.Transactions)-1]
+	if backward || more {
+		page.NextCursor = cursor{Sort: column, Desc: params.Desc, Key: sortKey(column, last), Id: last.Id}.encode()
+	}
+	if (backward && more) || (!backward && after != nil) {
+		page.PrevCursor = cursor{Sort: column, Desc: params.Desc, Key: sortKey(column, first), Id: first.Id, Backward: true}.encode()
+	}
+	return page, nil
+}
@@ -0,0 +1,265 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"EWallet/pkg/metrics"
+
+	"github.com/jmoiron/sqlx"
+)
+
+const (
+	PayoutProcessing         = "processing"
+	PayoutCompleted          = "completed"
+	PayoutPartiallyCompleted = "partially_completed"
+	PayoutFailed             = "failed"
+
+	PayoutItemPending       = "pending"
+	PayoutItemCompleted     = "completed"
+	PayoutItemPendingReview = "pending_review"
+	PayoutItemFailed        = "failed"
+)
+
+var ErrPayoutNotFound = fmt.Errorf("err payout not found")
+
+// PayoutRequest pays the items from one wallet. An atomic payout moves the money of every item or of
+// none, otherwise each item succeeds or fails on its own.
+type PayoutRequest struct {
+	Atomic bool         `json:"atomic"`
+	Items  []PayoutItem `json:"items"`
+}
+
+type PayoutItem struct {
+	Position     int     `json:"position" db:"position"`
+	WalletTarget int     `json:"walletTarget" db:"target_id"`
+	Sum          float64 `json:"sum" db:"sum"`
+	UUID         string  `json:"uuid" db:"uuid"`
+	Status       string  `json:"status" db:"status"`
+	Error        *string `json:"error,omitempty" db:"error"`
+}
+
+// Payout is a batch of transfers from WalletId, its items are executed in position order. Pending
+// counts the items not executed yet and the ones held for review.
+type Payout struct {
+	Id         int          `json:"id" db:"id"`
+	WalletId   int          `json:"wallet_id" db:"wallet_id"`
+	Atomic     bool         `json:"atomic" db:"atomic"`
+	Status     string       `json:"status" db:"status"`
+	Total      float64      `json:"total" db:"total"`
+	Pending    int          `json:"pending" db:"-"`
+	Completed  int          `json:"completed" db:"-"`
+	Failed     int          `json:"failed" db:"-"`
+	JobId      *int64       `json:"job_id" db:"job_id"`
+	CreatedAt  time.Time    `json:"created_at" db:"created_at"`
+	FinishedAt *time.Time   `json:"finished_at" db:"finished_at"`
+	Items      []PayoutItem `json:"items" db:"-"`
+}
+
+// PayoutJob is the payload of the job executing a payout.
+type PayoutJob struct {
+	PayoutId int `json:"payout_id"`
+}
+
+// PayoutItemError reports the item an atomic payout failed on.
+type PayoutItemError struct {
+	Position int
+	Err      error
+}
+
+func (e *PayoutItemError) Error() string {
+	return fmt.Sprintf("item %d: %v", e.Position, e.Err)
+}
+
+func (e *PayoutItemError) Unwrap() error {
+	return e.Err
+}
+
+// CreatePayout stores the payout with its items pending and queues the job executing it for the user.
+func (pg *PG) CreatePayout(ctx context.Context, username string, p Payout) (Payout, error) {
+	err := pg.runTx(ctx, nil, func(tx *sqlx.Tx) error {
+		query := `INSERT INTO payout (wallet_id, atomic, total) VALUES ($1, $2, $3) RETURNING id, status, created_at`
+		if err := tx.QueryRowxContext(ctx, query, p.WalletId, p.Atomic, p.Total).Scan(&p.Id, &p.Status, &p.CreatedAt); err != nil {
+			return fmt.Errorf("err creating payout: %w", err)
+		}
+		query = `INSERT INTO payout_item (payout_id, position, target_id, sum, uuid) VALUES ($1, $2, $3, $4, $5)`
+		for i := range p.Items {
+			p.Items[i].Position = i
+			p.Items[i].Status = PayoutItemPending
+			item := p.Items[i]
+			if _, err := tx.ExecContext(ctx, query, p.Id, item.Position, item.WalletTarget, item.Sum, item.UUID); err != nil {
+				return fmt.Errorf("err creating payout item: %w", err)
+			}
+		}
+		payload, err := json.Marshal(PayoutJob{PayoutId: p.Id})
+		if err != nil {
+			return fmt.Errorf("err encoding payout job: %w", err)
+		}
+		job, err := pg.insertJob(ctx, tx, Job{Type: JobPayout, Username: username, Payload: payload})
+		if err != nil {
+			return err
+		}
+		p.JobId = &job.Id
+		if _, err = tx.ExecContext(ctx, `UPDATE payout SET job_id = $1 WHERE id = $2`, job.Id, p.Id); err != nil {
+			return fmt.Errorf("err updating payout: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		metrics.MetricErrCount.WithLabelValues("CreatePayout").Inc()
+		return Payout{}, err
+	}
+	p.Pending = len(p.Items)
+	return p, nil
+}
+
+func (pg *PG) GetPayout(ctx context.Context, id int) (Payout, error) {
+	var p Payout
+	query := `SELECT id, wallet_id, atomic, status, total, job_id, created_at, finished_at FROM payout WHERE id = $1`
+	if err := pg.db.GetContext(ctx, &p, query, id); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return Payout{}, ErrPayoutNotFound
+		}
+		metrics.MetricErrCount.WithLabelValues("GetPayout").Inc()
+		return Payout{}, fmt.Errorf("err getting payout: %w", err)
+	}
+	p.Items = make([]PayoutItem, 0)
+	query = `SELECT position, target_id, sum, uuid, status, error FROM payout_item WHERE payout_id = $1 ORDER BY position`
+	if err := pg.db.SelectContext(ctx, &p.Items, query, id); err != nil {
+		metrics.MetricErrCount.WithLabelValues("GetPayout").Inc()
+		return Payout{}, fmt.Errorf("err getting payout items: %w", err)
+	}
+	for _, item := range p.Items {
+		switch item.Status {
+		case PayoutItemCompleted:
+			p.Completed++
+		case PayoutItemFailed:
+			p.Failed++
+		default:
+			p.Pending++
+		}
+	}
+	return p, nil
+}
+
+// SetPayoutItemStatus records the outcome of an item.
+func (pg *PG) SetPayoutItemStatus(ctx context.Context, id, position int, status string, reason *string) error {
+	query := `UPDATE payout_item SET status = $1, error = $2 WHERE payout_id = $3 AND position = $4`
+	if _, err := pg.db.ExecContext(ctx, query, status, reason, id, position); err != nil {
+		metrics.MetricErrCount.WithLabelValues("SetPayoutItemStatus").Inc()
+		return fmt.Errorf("err updating payout item: %w", err)
+	}
+	return nil
+}
+
+// FinishPayout sets the final status of the payout from the outcomes of its items. A payout with items
+// held for review stays processing, resolving the last review finishes it.
+func (pg *PG) FinishPayout(ctx context.Context, id int) error {
+	if err := pg.finishPayout(ctx, pg.db, id); err != nil {
+		metrics.MetricErrCount.WithLabelValues("FinishPayout").Inc()
+		return err
+	}
+	return nil
+}
+
+func (pg *PG) finishPayout(ctx context.Context, db sqlx.ExecerContext, id int) error {
+	query := `
+UPDATE payout
+SET status      = CASE
+                      WHEN NOT EXISTS(SELECT 1 FROM payout_item WHERE payout_id = $1 AND status <> 'completed') THEN 'completed'
+                      WHEN EXISTS(SELECT 1 FROM payout_item WHERE payout_id = $1 AND status <> 'failed') THEN 'partially_completed'
+                      ELSE 'failed' END,
+    finished_at = now()
+WHERE id = $1
+  AND status = 'processing'
+  AND NOT EXISTS(SELECT 1 FROM payout_item WHERE payout_id = $1 AND status = 'pending_review')`
+	if _, err := db.ExecContext(ctx, query, id); err != nil {
+		return fmt.Errorf("err finishing payout: %w", err)
+	}
+	return nil
+}
+
+// resolvePayoutItem records the outcome of the reviewed transfer t on the payout item held with it, if
+// any, and finishes the payout when it was the last item held.
+func (pg *PG) resolvePayoutItem(ctx context.Context, tx *sqlx.Tx, t *Transaction, status string, reason *string) error {
+	if t.Operation != "transfer" {
+		return nil
+	}
+	query := `
+UPDATE payout_item i
+SET status = $1, error = $2
+FROM payout p
+WHERE p.id = i.payout_id AND p.wallet_id = $3 AND i.uuid = $4 AND i.status = $5
+RETURNING i.payout_id`
+	var payoutID int
+	err := tx.GetContext(ctx, &payoutID, query, status, reason, t.FromId, t.UUID, PayoutItemPendingReview)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("err updating payout item: %w", err)
+	}
+	return pg.finishPayout(ctx, tx, payoutID)
+}
+
+// ExecuteAtomicPayout moves the money of every item of the payout in one DB transaction. When an item
+// can't be paid nothing is moved and the error is a *PayoutItemError; unlike single transfers the
+// attempt is not recorded, so the items may be paid later with the same uuids.
+func (pg *PG) ExecuteAtomicPayout(ctx context.Context, id int) error {
+	started := time.Now()
+	defer func() {
+		metrics.MetricDBRequestsDuration.WithLabelValues("ExecuteAtomicPayout").Observe(time.Since(started).Seconds())
+	}()
+	p, err := pg.GetPayout(ctx, id)
+	if err != nil {
+		return err
+	}
+	err = pg.withTx(ctx, "ExecuteAtomicPayout", func(tx *sqlx.Tx) error {
+		// Every wallet is locked up front in id order, so concurrent payouts can't deadlock.
+		ids := []int{p.WalletId}
+		for _, item := range p.Items {
+			ids = append(ids, item.WalletTarget)
+		}
+		if _, err := pg.lockWallets(ctx, tx, ids...); err != nil {
+			return err
+		}
+		for _, item := range p.Items {
+			target := item.WalletTarget
+			t := &Transaction{UUID: item.UUID, FromId: p.WalletId, ToId: &target, Operation: "transfer", Sum: item.Sum}
+			err := pg.insertTransaction(ctx, tx, t, StatusCompleted, nil)
+			if err == nil {
+				err = pg.apply(ctx, tx, t)
+			}
+			if err == nil {
+				err = pg.insertTransactionEvent(ctx, tx, t)
+			}
+			if err != nil {
+				if isRejection(err) || errors.Is(err, ErrDuplicateKey) {
+					return &PayoutItemError{Position: item.Position, Err: err}
+				}
+				return err
+			}
+		}
+		query := `UPDATE payout_item SET status = 'completed' WHERE payout_id = $1`
+		if _, err := tx.ExecContext(ctx, query, id); err != nil {
+			return fmt.Errorf("err updating payout items: %w", err)
+		}
+		return nil
+	})
+	var itemErr *PayoutItemError
+	if errors.As(err, &itemErr) {
+		pg.recordOutcome(&Transaction{Operation: "transfer"}, itemErr.Err)
+	}
+	if err != nil {
+		metrics.MetricErrCount.WithLabelValues("ExecuteAtomicPayout").Inc()
+		return err
+	}
+	for _, item := range p.Items {
+		pg.recordOutcome(&Transaction{Operation: "transfer", Sum: item.Sum}, nil)
+	}
+	return nil
+}
@@ -6,25 +6,28 @@ import (
 	"embed"
 	"errors"
 	"fmt"
-	"strconv"
 	"time"
 
-	"EWallet/pkg/models"
-
+	"EWallet/pkg/events"
 	"EWallet/pkg/metrics"
 
-	"github.com/jackc/pgconn"
-	"github.com/jackc/pgerrcode"
-
 	migrate "github.com/rubenv/sql-migrate"
 
+	"github.com/XSAM/otelsql"
+	"github.com/google/uuid"
 	"github.com/jmoiron/sqlx"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
 	"github.com/sirupsen/logrus"
+	semconv "go.opentelemetry.io/otel/semconv/v1.17.0"
 )
 
 //go:embed migrations
 var migrations embed.FS
 
+// AdjustmentReason is the reason of the transactions recording a balance set by CreateWallet or UpdateWallet.
+const AdjustmentReason = "balance adjustment"
+
 type Wallet struct {
 	Owner     string    `json:"owner" db:"owner"`
 	Balance   float64   `json:"balance" db:"balance"`
@@ -45,11 +48,15 @@ type Transaction struct {
 	Sum       float64   `json:"sum" db:"sum"`
 	Operation string    `json:"operation" db:"operation"`
 	Date      time.Time `json:"date" db:"date"`
+	Status    string    `json:"status" db:"status"`
+	Reason    *string   `json:"reason,omitempty" db:"reason"`
+	UpdatedAt time.Time `json:"updated_at" db:"updated_at"`
 }
 type PG struct {
-	log *logrus.Entry
-	db  *sqlx.DB
-	dsn string
+	log      *logrus.Entry
+	db       *sqlx.DB
+	dsn      string
+	currency string
 }
 
 var (
@@ -61,23 +68,57 @@ var (
 	ErrWalletFrozen         = fmt.Errorf("err wallet is frozen")
 )
 
+// NewRepo connects to the database. Every query is traced as a span of the operation it belongs to.
 func NewRepo(ctx context.Context, log *logrus.Logger, dsn string) (*PG, error) {
-	db, err := sqlx.ConnectContext(ctx, "pgx", dsn)
+	conn, err := otelsql.Open("pgx", dsn,
+		otelsql.WithAttributes(semconv.DBSystemPostgreSQL),
+		otelsql.WithSpanOptions(otelsql.SpanOptions{OmitConnResetSession: true, OmitRows: true}),
+	)
 	if err != nil {
 		return nil, fmt.Errorf("err connecting to PG : %w", err)
 	}
+	db := sqlx.NewDb(conn, "pgx")
 	err = db.PingContext(ctx)
 	if err != nil {
+		_ = db.Close()
 		return nil, fmt.Errorf("err pinging pg after initing connection: %w", err)
 	}
 	pg := &PG{
-		log: log.WithField("component", "pgstore"),
-		db:  db,
-		dsn: dsn,
+		log:      log.WithField("component", "pgstore"),
+		db:       db,
+		dsn:      dsn,
+		currency: DefaultCurrency,
 	}
 	return pg, nil
 }
 
+// StatsCollector exposes the stats of the connection pool as metrics.
+func (pg *PG) StatsCollector() prometheus.Collector {
+	return collectors.NewDBStatsCollector(pg.db.DB, "ewallet")
+}
+
+// PoolConfig sizes the connection pool, zero values keep the database/sql defaults.
+type PoolConfig struct {
+	MaxOpenConns    int
+	MaxIdleConns    int
+	ConnMaxLifetime time.Duration
+	ConnMaxIdleTime time.Duration
+}
+
+// SetCurrency replaces DefaultCurrency as the currency statements are issued and operations are counted in.
+func (pg *PG) SetCurrency(currency string) {
+	pg.currency = currency
+}
+
+func (pg *PG) SetPool(c PoolConfig) {
+	pg.db.SetMaxOpenConns(c.MaxOpenConns)
+	if c.MaxIdleConns > 0 {
+		pg.db.SetMaxIdleConns(c.MaxIdleConns)
+	}
+	pg.db.SetConnMaxLifetime(c.ConnMaxLifetime)
+	pg.db.SetConnMaxIdleTime(c.ConnMaxIdleTime)
+}
+
 func (pg *PG) Migrate(direction migrate.MigrationDirection) error {
 	conn, err := sql.Open("pgx", pg.dsn)
 	if err != nil {
@@ -88,6 +129,11 @@ func (pg *PG) Migrate(direction migrate.MigrationDirection) error {
 			pg.log.Error("err closing migration connection")
 		}
 	}()
+	_, err = migrate.Exec(conn, "postgres", migrationSource(), direction)
+	return err
+}
+
+func migrationSource() migrate.MigrationSource {
 	assetDir := func() func(string) ([]string, error) {
 		return func(path string) ([]string, error) {
 			dirEntry, er := migrations.ReadDir(path)
@@ -102,13 +148,42 @@ func (pg *PG) Migrate(direction migrate.MigrationDirection) error {
 			return entries, nil
 		}
 	}()
-	asset := migrate.AssetMigrationSource{
+	return migrate.AssetMigrationSource{
 		Asset:    migrations.ReadFile,
 		AssetDir: assetDir,
 		Dir:      "migrations",
 	}
-	_, err = migrate.Exec(conn, "postgres", asset, direction)
-	return err
+}
+
+// PendingMigrations returns the embedded migrations not applied to the database. Applied migrations
+// unknown to this build, added by a newer version, are not counted.
+func (pg *PG) PendingMigrations(ctx context.Context) ([]string, error) {
+	all, err := migrationSource().FindMigrations()
+	if err != nil {
+		return nil, fmt.Errorf("err reading migrations: %w", err)
+	}
+	var applied []string
+	if err = pg.db.SelectContext(ctx, &applied, `SELECT id FROM gorp_migrations`); err != nil {
+		return nil, fmt.Errorf("err getting applied migrations: %w", err)
+	}
+	done := make(map[string]bool, len(applied))
+	for _, id := range applied {
+		done[id] = true
+	}
+	pending := make([]string, 0)
+	for _, m := range all {
+		if !done[m.Id] {
+			pending = append(pending, m.Id)
+		}
+	}
+	return pending, nil
+}
+
+func (pg *PG) Ping(ctx context.Context) error {
+	if err := pg.db.PingContext(ctx); err != nil {
+		return fmt.Errorf("err pinging pg: %w", err)
+	}
+	return nil
 }
 
 func (pg *PG) Close() {
@@ -124,10 +199,19 @@ func (pg *PG) CreateWallet(ctx context.Context, wallet Wallet) (int, error) {
 	}()
 	query := `INSERT INTO wallet (owner, balance, updated_at) VALUES ($1,$2,$3) RETURNING id`
 	var id int
-	row := pg.db.QueryRowContext(ctx, query, wallet.Owner, wallet.Balance, time.Now())
-	if err := row.Scan(&id); err != nil {
+	err := pg.withTx(ctx, "CreateWallet", func(tx *sqlx.Tx) error {
+		row := tx.QueryRowContext(ctx, query, wallet.Owner, wallet.Balance, time.Now())
+		if err := row.Scan(&id); err != nil {
+			return fmt.Errorf("err creating wallet: %w", err)
+		}
+		if err := pg.insertEvent(ctx, tx, events.WalletCreated, id, nil, walletEvent{WalletId: id, Owner: wallet.Owner, Balance: wallet.Balance}); err != nil {
+			return err
+		}
+		return pg.adjustBalance(ctx, tx, id, cents(wallet.Balance))
+	})
+	if err != nil {
 		metrics.MetricErrCount.WithLabelValues("CreateWallet").Inc()
-		return 0, fmt.Errorf("err creating wallet: %w", err)
+		return 0, err
 	}
 	return id, nil
 }
@@ -137,7 +221,7 @@ func (pg *PG) GetWallet(ctx context.Context, id int) (Wallet, error) {
 	defer func() {
 		metrics.MetricDBRequestsDuration.WithLabelValues("GetWallet").Observe(time.Since(started).Seconds())
 	}()
-	query := `SELECT owner, balance, created_at, updated_at FROM Wallet WHERE id = $1`
+	query := `SELECT owner, balance, created_at, updated_at, frozen FROM Wallet WHERE id = $1`
 	var wallet Wallet
 	if err := pg.db.GetContext(ctx, &wallet, query, id); err != nil {
 		metrics.MetricErrCount.WithLabelValues("GetWallet").Inc()
@@ -154,35 +238,76 @@ func (pg *PG) UpdateWallet(ctx context.Context, id int, wallet Wallet) (Wallet,
 	defer func() {
 		metrics.MetricDBRequestsDuration.WithLabelValues("UpdateWallet").Observe(time.Since(started).Seconds())
 	}()
-	query := `UPDATE wallet SET owner = $1, balance = $2,updated_at = $3 WHERE id = $4 RETURNING owner, balance, created_at, updated_at`
-	row := pg.db.QueryRowxContext(ctx, query, wallet.Owner, wallet.Balance, time.Now(), id)
-	err := row.StructScan(&wallet)
+	query := `
+UPDATE wallet
+SET owner            = $1,
+    balance          = $2,
+    updated_at       = $3,
+    owner_changed_at = CASE WHEN owner <> $1 THEN $3 ELSE owner_changed_at END
+WHERE id = $4
+RETURNING owner, balance, created_at, updated_at`
+	err := pg.withTx(ctx, "UpdateWallet", func(tx *sqlx.Tx) error {
+		wallets, err := pg.lockWallets(ctx, tx, id)
+		if err != nil {
+			return err
+		}
+		current, ok := wallets[id]
+		if !ok {
+			return ErrWalletNotFound
+		}
+		row := tx.QueryRowxContext(ctx, query, wallet.Owner, wallet.Balance, time.Now(), id)
+		if err = row.StructScan(&wallet); err != nil {
+			return fmt.Errorf("err updating the Wallet: %w", err)
+		}
+		if err = pg.adjustBalance(ctx, tx, id, cents(wallet.Balance)-cents(current.Balance)); err != nil {
+			return err
+		}
+		return pg.insertEvent(ctx, tx, events.WalletUpdated, id, nil, walletEvent{WalletId: id, Owner: wallet.Owner, Balance: wallet.Balance})
+	})
 	if err != nil {
 		metrics.MetricErrCount.WithLabelValues("UpdateWallet").Inc()
-		if errors.Is(err, sql.ErrNoRows) {
-			return Wallet{}, ErrWalletNotFound
-		}
-		return Wallet{}, fmt.Errorf("err updating the Wallet: %w", err)
+		return Wallet{}, err
 	}
 	return wallet, nil
 }
 
+// adjustBalance records a balance set directly by CreateWallet or UpdateWallet as a completed deposit or
+// withdrawal of the difference, so statements and exports add up to the new balance.
+func (pg *PG) adjustBalance(ctx context.Context, tx *sqlx.Tx, id int, diff int64) error {
+	if diff == 0 {
+		return nil
+	}
+	t := Transaction{UUID: uuid.New().String(), FromId: id, Operation: "deposit", Sum: float64(diff) / 100}
+	if diff < 0 {
+		t.Operation, t.Sum = "withdraw", -t.Sum
+	}
+	reason := AdjustmentReason
+	if err := pg.insertTransaction(ctx, tx, &t, StatusCompleted, &reason); err != nil {
+		return err
+	}
+	return pg.insertTransactionEvent(ctx, tx, &t)
+}
+
 func (pg *PG) DeleteWallet(ctx context.Context, id int) error {
 	started := time.Now()
 	defer func() {
 		metrics.MetricDBRequestsDuration.WithLabelValues("DeleteWallet").Observe(time.Since(started).Seconds())
 	}()
 	query := `DELETE FROM wallet WHERE id = $1`
-	res, err := pg.db.ExecContext(ctx, query, id)
-	cnt, _ := res.RowsAffected()
-	if cnt == 0 {
-		return ErrWalletNotFound
-	}
-	if err != nil {
+	err := pg.withTx(ctx, "DeleteWallet", func(tx *sqlx.Tx) error {
+		res, err := tx.ExecContext(ctx, query, id)
+		if err != nil {
+			return fmt.Errorf("err deleting wallet : %w", err)
+		}
+		if cnt, _ := res.RowsAffected(); cnt == 0 {
+			return ErrWalletNotFound
+		}
+		return pg.insertEvent(ctx, tx, events.WalletDeleted, id, nil, walletEvent{WalletId: id})
+	})
+	if err != nil && !errors.Is(err, ErrWalletNotFound) {
 		metrics.MetricErrCount.WithLabelValues("DeleteWallet").Inc()
-		return fmt.Errorf("err deleting wallet : %w", err)
 	}
-	return nil
+	return err
 }
 
 func (pg *PG) Deposit(ctx context.Context, id int, request *FinRequest) error {
@@ -190,46 +315,11 @@ func (pg *PG) Deposit(ctx context.Context, id int, request *FinRequest) error {
 	defer func() {
 		metrics.MetricDBRequestsDuration.WithLabelValues("Deposit").Observe(time.Since(started).Seconds())
 	}()
-	tx, err := pg.db.BeginTxx(ctx, nil)
-	if err != nil {
+	t := &Transaction{UUID: request.UUID, FromId: id, Operation: "deposit", Sum: request.Sum}
+	if err := pg.execute(ctx, t); err != nil {
 		metrics.MetricErrCount.WithLabelValues("Deposit").Inc()
-		return fmt.Errorf("err starting transaction: %w", err)
-	}
-	defer func() {
-		if err = tx.Rollback(); err != nil {
-			pg.log.Error("err rolling back transaction")
-		}
-	}()
-	status, err := pg.IsFrozen(ctx, id)
-	if err != nil {
 		return err
 	}
-	if status == false {
-		return ErrWalletFrozen
-	}
-	query := `INSERT INTO transaction (uuid,from_id,operation,sum) VALUES ($1,$2,$3,$4)`
-	_, err = tx.ExecContext(ctx, query, request.UUID, id, "deposit", request.Sum)
-	var pgErr *pgconn.PgError
-	if errors.As(err, &pgErr) {
-		if pgErr.Code == pgerrcode.UniqueViolation {
-			return ErrDuplicateKey
-		}
-	}
-
-	query = `UPDATE wallet SET balance = balance + $1 WHERE id = $2`
-	res, err := tx.ExecContext(ctx, query, request.Sum, id)
-	cnt, _ := res.RowsAffected()
-	if cnt == 0 {
-		return ErrWalletNotFound
-	}
-	if err != nil {
-		metrics.MetricErrCount.WithLabelValues("Deposit").Inc()
-		return fmt.Errorf("err depositing the Wallet: %w", err)
-	}
-	if err = tx.Commit(); err != nil {
-		metrics.MetricErrCount.WithLabelValues("Deposit").Inc()
-		return fmt.Errorf("err committing transaction: %w", err)
-	}
 	return nil
 }
 
@@ -238,49 +328,11 @@ func (pg *PG) Withdrawal(ctx context.Context, id int, request *FinRequest) error
 	defer func() {
 		metrics.MetricDBRequestsDuration.WithLabelValues("Withdrawal").Observe(time.Since(started).Seconds())
 	}()
-	tx, err := pg.db.BeginTx(ctx, nil)
-	if err != nil {
+	t := &Transaction{UUID: request.UUID, FromId: id, Operation: "withdraw", Sum: request.Sum}
+	if err := pg.execute(ctx, t); err != nil {
 		metrics.MetricErrCount.WithLabelValues("Withdrawal").Inc()
-		return fmt.Errorf("err trasnfering the wallet: %w", err)
-	}
-	defer func() {
-		if err = tx.Rollback(); err != nil && !errors.Is(err, sql.ErrTxDone) {
-			metrics.MetricErrCount.WithLabelValues("Withdrawal").Inc()
-			pg.log.Error("err rolling back transfer transaction")
-		}
-	}()
-	status, err := pg.IsFrozen(ctx, id)
-	if err != nil {
 		return err
 	}
-	if status == false {
-		return ErrWalletFrozen
-	}
-	query := `INSERT INTO transaction (uuid,from_id,operation,sum) VALUES ($1,$2,$3,$4)`
-	_, err = tx.ExecContext(ctx, query, request.UUID, id, "withdraw", request.Sum)
-	var pgErr *pgconn.PgError
-	if errors.As(err, &pgErr) {
-		if pgErr.Code == pgerrcode.UniqueViolation {
-			return ErrDuplicateKey
-		}
-	}
-	if err = pg.checkBalance(ctx, tx, id, request.Sum); err != nil {
-		return err
-	}
-	query = `UPDATE wallet SET balance = balance - $1 WHERE id = $2 `
-	res, err := tx.ExecContext(ctx, query, request.Sum, id)
-	cnt, _ := res.RowsAffected()
-	if cnt == 0 {
-		return ErrWalletNotFound
-	}
-	if err != nil {
-		metrics.MetricErrCount.WithLabelValues("Withdrawal").Inc()
-		return fmt.Errorf("err withdrawing the Wallet: %w", err)
-	}
-	if err = tx.Commit(); err != nil {
-		metrics.MetricErrCount.WithLabelValues("Withdrawal").Inc()
-		return fmt.Errorf("err committing the transaction: %w", err)
-	}
 	return nil
 }
 
@@ -289,92 +341,26 @@ func (pg *PG) Transfer(ctx context.Context, id int, request *FinRequest) error {
 	defer func() {
 		metrics.MetricDBRequestsDuration.WithLabelValues("Transfer").Observe(time.Since(started).Seconds())
 	}()
-	tx, err := pg.db.BeginTx(ctx, nil)
-	if err != nil {
+	t := &Transaction{UUID: request.UUID, FromId: id, ToId: &request.WalletTarget, Operation: "transfer", Sum: request.Sum}
+	if err := pg.execute(ctx, t); err != nil {
 		metrics.MetricErrCount.WithLabelValues("Transfer").Inc()
-		return fmt.Errorf("err trasnfering the wallet: %w", err)
-	}
-	defer func() {
-		if err = tx.Rollback(); err != nil && !errors.Is(err, sql.ErrTxDone) {
-			metrics.MetricErrCount.WithLabelValues("Transfer").Inc()
-			pg.log.Error("err rolling back transfer transaction")
-		}
-	}()
-	status, err := pg.IsFrozen(ctx, id)
-	if err != nil {
 		return err
 	}
-	if status == false {
-		return ErrWalletFrozen
-	}
-
-	query := `INSERT INTO transaction (uuid,from_id,to_id,operation,sum) VALUES ($1,$2,$3,$4,$5)`
-	_, err = tx.ExecContext(ctx, query, request.UUID, id, request.WalletTarget, "transfer", request.Sum)
-	var pgErr *pgconn.PgError
-	if errors.As(err, &pgErr) {
-		if pgErr.Code == pgerrcode.UniqueViolation {
-			return ErrDuplicateKey
-		}
-	}
-	if err = pg.checkBalance(ctx, tx, id, request.Sum); err != nil {
-		return err
-	}
-	query = `UPDATE wallet SET balance = balance - $1 WHERE id = $2 RETURNING balance`
-	if res, err := tx.ExecContext(ctx, query, request.Sum, id); err != nil {
-		metrics.MetricErrCount.WithLabelValues("Transfer").Inc()
-		cnt, _ := res.RowsAffected()
-		if cnt == 0 {
-			return ErrWalletNotFound
-		}
-		return fmt.Errorf("err withdrawaling the Wallet: %w", err)
-	}
-	query = `UPDATE wallet SET balance = balance + $1 WHERE id = $2 RETURNING balance`
-	if res, err := tx.ExecContext(ctx, query, request.Sum, request.WalletTarget); err != nil {
-		cnt, _ := res.RowsAffected()
-		if cnt == 0 {
-			return ErrWalletTargetNotFound
-		}
-		metrics.MetricErrCount.WithLabelValues("Transfer").Inc()
-		return fmt.Errorf("err depositing the Wallet: %w", err)
-	}
-	if err = tx.Commit(); err != nil {
-		metrics.MetricErrCount.WithLabelValues("Transfer").Inc()
-		return fmt.Errorf("err trasnfering the wallet: %w", err)
-	}
-	return nil
-}
-
-type querier interface {
-	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
-}
-
-func (pg *PG) checkBalance(ctx context.Context, querier querier, id int, sum float64) error {
-	started := time.Now()
-	defer func() {
-		metrics.MetricDBRequestsDuration.WithLabelValues("checkBalance").Observe(time.Since(started).Seconds())
-	}()
-	var balance float64
-	query := `SELECT balance FROM wallet WHERE id = $1 FOR UPDATE`
-	row := querier.QueryRowContext(ctx, query, id)
-	if err := row.Scan(&balance); err != nil {
-		metrics.MetricErrCount.WithLabelValues("checkBalance").Inc()
-		if errors.Is(err, sql.ErrNoRows) {
-			return ErrWalletNotFound
-		}
-		return fmt.Errorf("err checking balance: %w", err)
-	}
-	if balance < sum {
-		return ErrInsufficientFunds
-	}
 	return nil
 }
 
 func (pg *PG) Freeze(ctx context.Context, id int) error {
 	query := "UPDATE wallet SET frozen = true WHERE id = $1"
-	if _, err := pg.db.ExecContext(ctx, query, id); err != nil {
-		return fmt.Errorf("error while freezing account: %w", err)
-	}
-	return nil
+	return pg.withTx(ctx, "Freeze", func(tx *sqlx.Tx) error {
+		res, err := tx.ExecContext(ctx, query, id)
+		if err != nil {
+			return fmt.Errorf("error while freezing account: %w", err)
+		}
+		if cnt, _ := res.RowsAffected(); cnt == 0 {
+			return ErrWalletNotFound
+		}
+		return pg.insertEvent(ctx, tx, events.WalletFrozen, id, nil, walletEvent{WalletId: id})
+	})
 }
 
 func (pg *PG) IsFrozen(ctx context.Context, id int) (bool, error) {
@@ -384,49 +370,3 @@ func (pg *PG) IsFrozen(ctx context.Context, id int) (bool, error) {
 	}
 	return wal.Frozen, nil
 }
-
-func (pg *PG) GetTransactions(ctx context.Context, id int, params *models.TransactionQueryParams) ([]Transaction, error) {
-	var ans []Transaction
-	query := `
-SELECT id,
-       uuid,
-       from_id,
-       to_id,
-       operation,
-       sum,
-       date 
-FROM transaction
-WHERE from_id=$1 OR to_id=$1 `
-	if params != nil {
-		switch params.Sort {
-		case "sum":
-			query += " ORDER BY SUM"
-		default:
-			query += " ORDER BY DATE"
-		}
-		switch params.Desc {
-		case false:
-			query += " ASC"
-		default:
-			query += " DESC"
-		}
-		if params.Limit != 0 {
-			query += " LIMIT " + strconv.Itoa(params.Limit)
-		} else {
-			query += " LIMIT 100"
-		}
-		if params.Offset != 0 {
-			query += " OFFSET " + strconv.Itoa(params.Offset)
-		}
-	} else {
-		query += "ORDER BY date DESC"
-	}
-	_, err := pg.GetWallet(ctx, id)
-	if errors.Is(err, ErrWalletNotFound) {
-		return nil, ErrWalletNotFound
-	}
-	if err := pg.db.SelectContext(ctx, &ans, query, id); err != nil {
-		return nil, fmt.Errorf("err getting transaction : %w", err)
-	}
-	return ans, nil
-}
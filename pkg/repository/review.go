@@ -0,0 +1,240 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"EWallet/pkg/metrics"
+
+	"github.com/jackc/pgconn"
+	"github.com/jackc/pgerrcode"
+	"github.com/jmoiron/sqlx"
+)
+
+const (
+	ReviewPending  = "pending"
+	ReviewApproved = "approved"
+	ReviewRejected = "rejected"
+)
+
+var (
+	ErrReviewNotFound   = fmt.Errorf("err review not found")
+	ErrReviewNotPending = fmt.Errorf("err review is not pending")
+)
+
+type Review struct {
+	Id            int        `json:"id" db:"id"`
+	TransactionId *int       `json:"transaction_id" db:"transaction_id"`
+	UUID          string     `json:"uuid" db:"uuid"`
+	WalletId      int        `json:"wallet_id" db:"wallet_id"`
+	TargetId      *int       `json:"target_id" db:"target_id"`
+	Operation     string     `json:"operation" db:"operation"`
+	Sum           float64    `json:"sum" db:"sum"`
+	Rule          string     `json:"rule" db:"rule"`
+	Reason        string     `json:"reason" db:"reason"`
+	Status        string     `json:"status" db:"status"`
+	Reviewer      *string    `json:"reviewer" db:"reviewer"`
+	CreatedAt     time.Time  `json:"created_at" db:"created_at"`
+	ResolvedAt    *time.Time `json:"resolved_at" db:"resolved_at"`
+}
+
+// HoldTransaction stores the operation as a pending transaction together with the review deciding its fate.
+func (pg *PG) HoldTransaction(ctx context.Context, operation string, id int, request *FinRequest, rule, reason string) (int, error) {
+	started := time.Now()
+	defer func() {
+		metrics.MetricDBRequestsDuration.WithLabelValues("HoldTransaction").Observe(time.Since(started).Seconds())
+	}()
+	t := &Transaction{UUID: request.UUID, FromId: id, Operation: operation, Sum: request.Sum}
+	if operation == "transfer" {
+		t.ToId = &request.WalletTarget
+	}
+	tx, err := pg.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("err starting transaction: %w", err)
+	}
+	defer func() {
+		if err = tx.Rollback(); err != nil && !errors.Is(err, sql.ErrTxDone) {
+			pg.log.WithContext(ctx).Errorf("err rolling back hold: %v", err)
+		}
+	}()
+	if err = pg.insertTransaction(ctx, tx, t, StatusPending, &reason); err != nil {
+		metrics.MetricErrCount.WithLabelValues("HoldTransaction").Inc()
+		return 0, err
+	}
+	query := `
+INSERT INTO review (transaction_id, uuid, wallet_id, target_id, operation, sum, rule, reason)
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+RETURNING id`
+	var reviewID int
+	row := tx.QueryRowContext(ctx, query, t.Id, t.UUID, t.FromId, t.ToId, t.Operation, t.Sum, rule, reason)
+	if err = row.Scan(&reviewID); err != nil {
+		metrics.MetricErrCount.WithLabelValues("HoldTransaction").Inc()
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == pgerrcode.UniqueViolation {
+			return 0, ErrDuplicateKey
+		}
+		return 0, fmt.Errorf("err holding transaction: %w", err)
+	}
+	if err = tx.Commit(); err != nil {
+		return 0, fmt.Errorf("err committing hold: %w", err)
+	}
+	return reviewID, nil
+}
+
+func (pg *PG) GetReview(ctx context.Context, id int) (Review, error) {
+	query := `SELECT * FROM review WHERE id = $1`
+	var review Review
+	if err := pg.db.GetContext(ctx, &review, query, id); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return Review{}, ErrReviewNotFound
+		}
+		return Review{}, fmt.Errorf("err getting review: %w", err)
+	}
+	return review, nil
+}
+
+func (pg *PG) GetReviews(ctx context.Context, status string) ([]Review, error) {
+	reviews := make([]Review, 0)
+	query := `SELECT * FROM review WHERE $1 = '' OR status = $1 ORDER BY created_at`
+	if err := pg.db.SelectContext(ctx, &reviews, query, status); err != nil {
+		return nil, fmt.Errorf("err getting reviews: %w", err)
+	}
+	return reviews, nil
+}
+
+// ApproveReview approves the pending review and completes its transaction in one DB transaction, so an
+// approval failing half-way leaves both pending and can be retried. When the money can't be moved the
+// review is still approved, the transaction fails and the rejection is returned. A payout item held with
+// the transaction takes its outcome.
+func (pg *PG) ApproveReview(ctx context.Context, id int, reviewer string) error {
+	started := time.Now()
+	defer func() {
+		metrics.MetricDBRequestsDuration.WithLabelValues("ApproveReview").Observe(time.Since(started).Seconds())
+	}()
+	var pending Transaction
+	var rejected error
+	err := pg.withTx(ctx, "ApproveReview", func(tx *sqlx.Tx) error {
+		pending, rejected = Transaction{}, nil
+		transactionID, err := pg.resolveReview(ctx, tx, id, ReviewApproved, reviewer)
+		if err != nil {
+			return err
+		}
+		if transactionID == nil {
+			return fmt.Errorf("review %d holds no transaction", id)
+		}
+		t, err := pg.lockTransaction(ctx, tx, *transactionID, StatusPending)
+		if err != nil {
+			return err
+		}
+		pending = t
+		if err = pg.apply(ctx, tx, &t); isRejection(err) {
+			rejected = err
+			reason := err.Error()
+			if err = pg.setStatus(ctx, tx, t.Id, StatusFailed, &reason); err != nil {
+				return err
+			}
+			return pg.resolvePayoutItem(ctx, tx, &t, PayoutItemFailed, &reason)
+		} else if err != nil {
+			return err
+		}
+		if err = pg.setStatus(ctx, tx, t.Id, StatusCompleted, nil); err != nil {
+			return err
+		}
+		t.Status = StatusCompleted
+		if err = pg.insertTransactionEvent(ctx, tx, &t); err != nil {
+			return err
+		}
+		return pg.resolvePayoutItem(ctx, tx, &t, PayoutItemCompleted, nil)
+	})
+	if err == nil {
+		err = rejected
+	}
+	if pending.Id != 0 {
+		pg.recordOutcome(&pending, err)
+	}
+	if err != nil {
+		metrics.MetricErrCount.WithLabelValues("ApproveReview").Inc()
+	}
+	return err
+}
+
+// RejectReview rejects the pending review and fails its transaction, and the payout item held with it,
+// in one DB transaction.
+func (pg *PG) RejectReview(ctx context.Context, id int, reviewer string) error {
+	started := time.Now()
+	defer func() {
+		metrics.MetricDBRequestsDuration.WithLabelValues("RejectReview").Observe(time.Since(started).Seconds())
+	}()
+	err := pg.withTx(ctx, "RejectReview", func(tx *sqlx.Tx) error {
+		transactionID, err := pg.resolveReview(ctx, tx, id, ReviewRejected, reviewer)
+		if err != nil || transactionID == nil {
+			return err
+		}
+		t, err := pg.lockTransaction(ctx, tx, *transactionID, StatusPending)
+		if err != nil {
+			return err
+		}
+		reason := "rejected by " + reviewer
+		if err = pg.setStatus(ctx, tx, t.Id, StatusFailed, &reason); err != nil {
+			return err
+		}
+		return pg.resolvePayoutItem(ctx, tx, &t, PayoutItemFailed, &reason)
+	})
+	if err != nil {
+		metrics.MetricErrCount.WithLabelValues("RejectReview").Inc()
+	}
+	return err
+}
+
+// resolveReview moves a pending review into the given status and returns the id of the transaction it holds.
+func (pg *PG) resolveReview(ctx context.Context, tx *sqlx.Tx, id int, status, reviewer string) (*int, error) {
+	query := `UPDATE review SET status = $1, reviewer = $2, resolved_at = now() WHERE id = $3 AND status = $4 RETURNING transaction_id`
+	var transactionID *int
+	if err := tx.GetContext(ctx, &transactionID, query, status, reviewer, id, ReviewPending); err != nil {
+		if !errors.Is(err, sql.ErrNoRows) {
+			return nil, fmt.Errorf("err resolving review: %w", err)
+		}
+		var exists bool
+		if err = tx.GetContext(ctx, &exists, `SELECT EXISTS (SELECT 1 FROM review WHERE id = $1)`, id); err != nil {
+			return nil, fmt.Errorf("err getting review: %w", err)
+		}
+		if !exists {
+			return nil, ErrReviewNotFound
+		}
+		return nil, ErrReviewNotPending
+	}
+	return transactionID, nil
+}
+
+func (pg *PG) HasTransferred(ctx context.Context, from, to int) (bool, error) {
+	query := `SELECT EXISTS (SELECT 1 FROM transaction WHERE operation = 'transfer' AND status = 'completed' AND from_id = $1 AND to_id = $2)`
+	var exists bool
+	if err := pg.db.GetContext(ctx, &exists, query, from, to); err != nil {
+		return false, fmt.Errorf("err checking transfer history: %w", err)
+	}
+	return exists, nil
+}
+
+func (pg *PG) CountTransfers(ctx context.Context, from int, since time.Time, maxSum float64) (int, error) {
+	query := `SELECT count(*) FROM transaction WHERE operation = 'transfer' AND status <> 'failed' AND from_id = $1 AND date >= $2 AND sum <= $3`
+	var cnt int
+	if err := pg.db.GetContext(ctx, &cnt, query, from, since, maxSum); err != nil {
+		return 0, fmt.Errorf("err counting transfers: %w", err)
+	}
+	return cnt, nil
+}
+
+func (pg *PG) OwnerChangedAt(ctx context.Context, id int) (*time.Time, error) {
+	query := `SELECT owner_changed_at FROM wallet WHERE id = $1`
+	var changedAt *time.Time
+	if err := pg.db.GetContext(ctx, &changedAt, query, id); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrWalletNotFound
+		}
+		return nil, fmt.Errorf("err getting owner change: %w", err)
+	}
+	return changedAt, nil
+}
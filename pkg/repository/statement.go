@@ -0,0 +1,171 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"math"
+	"time"
+
+	"EWallet/pkg/metrics"
+)
+
+// DefaultCurrency is the currency wallet balances are kept in unless set otherwise.
+const DefaultCurrency = "RUB"
+
+// Statement is the account statement of a wallet for the period [From, To). Entries are the balance
+// movements of the period: a transaction moves the money when it completes and moves it back when
+// it is reversed, so a reversed transaction appears twice.
+type Statement struct {
+	WalletId       int              `json:"wallet_id"`
+	Owner          string           `json:"owner"`
+	Currency       string           `json:"currency"`
+	From           time.Time        `json:"from"`
+	To             time.Time        `json:"to"`
+	OpeningBalance float64          `json:"opening_balance"`
+	TotalCredits   float64          `json:"total_credits"`
+	TotalDebits    float64          `json:"total_debits"`
+	ClosingBalance float64          `json:"closing_balance"`
+	Entries        []StatementEntry `json:"entries"`
+}
+
+// StatementEntry is a single balance movement. Amount is positive for credits and negative for debits,
+// Balance is the balance right after the movement.
+type StatementEntry struct {
+	TransactionId int       `json:"transaction_id" db:"transaction_id"`
+	UUID          string    `json:"uuid" db:"uuid"`
+	Operation     string    `json:"operation" db:"operation"`
+	Status        string    `json:"status" db:"status"`
+	Counterparty  *int      `json:"counterparty,omitempty" db:"counterparty"`
+	Date          time.Time `json:"date" db:"changed_at"`
+	Amount        float64   `json:"amount" db:"amount"`
+	Balance       float64   `json:"balance"`
+}
+
+// GetStatement builds the statement of the wallet from its transaction history.
+func (pg *PG) GetStatement(ctx context.Context, id int, from, to time.Time) (Statement, error) {
+	var statement Statement
+	err := pg.WalkStatement(ctx, id, from, to, func(s Statement) error {
+		statement = s
+		statement.Entries = []StatementEntry{}
+		return nil
+	}, func(e StatementEntry) error {
+		statement.Entries = append(statement.Entries, e)
+		return nil
+	})
+	if err != nil {
+		return Statement{}, err
+	}
+	return statement, nil
+}
+
+// WalkStatement passes the statement without its entries to begin and then streams the entries one
+// by one to entry, so large histories are never loaded at once. The opening balance is the current
+// balance minus every movement since from; the balance, the totals and the entries are read from the
+// same snapshot, so the statement adds up even while new transactions arrive.
+func (pg *PG) WalkStatement(ctx context.Context, id int, from, to time.Time, begin func(Statement) error, entry func(StatementEntry) error) error {
+	started := time.Now()
+	defer func() {
+		metrics.MetricDBRequestsDuration.WithLabelValues("WalkStatement").Observe(time.Since(started).Seconds())
+	}()
+	tx, err := pg.db.BeginTxx(ctx, &sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: true})
+	if err != nil {
+		metrics.MetricErrCount.WithLabelValues("WalkStatement").Inc()
+		return fmt.Errorf("err starting transaction: %w", err)
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+	var wallet Wallet
+	err = tx.GetContext(ctx, &wallet, `SELECT owner, balance, created_at, updated_at, frozen FROM wallet WHERE id = $1`, id)
+	if err != nil {
+		metrics.MetricErrCount.WithLabelValues("WalkStatement").Inc()
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrWalletNotFound
+		}
+		return fmt.Errorf("err getting wallet: %w", err)
+	}
+	// Amounts are summed as numeric and returned in cents, floats would drift over a long history.
+	var totals struct {
+		Since   int64 `db:"since"`
+		Credits int64 `db:"credits"`
+		Debits  int64 `db:"debits"`
+	}
+	query := `
+SELECT (coalesce(sum(amount), 0) * 100)::bigint                                           AS since,
+       (coalesce(sum(amount) FILTER (WHERE changed_at < $3 AND amount > 0), 0) * 100)::bigint AS credits,
+       (coalesce(-sum(amount) FILTER (WHERE changed_at < $3 AND amount < 0), 0) * 100)::bigint AS debits
+` + statementMovements
+	if err = tx.GetContext(ctx, &totals, query, id, from, to); err != nil {
+		metrics.MetricErrCount.WithLabelValues("WalkStatement").Inc()
+		return fmt.Errorf("err getting statement totals: %w", err)
+	}
+	opening := cents(wallet.Balance) - totals.Since
+	closing := opening + totals.Credits - totals.Debits
+	err = begin(Statement{
+		WalletId:       id,
+		Owner:          wallet.Owner,
+		Currency:       pg.currency,
+		From:           from,
+		To:             to,
+		OpeningBalance: float64(opening) / 100,
+		TotalCredits:   float64(totals.Credits) / 100,
+		TotalDebits:    float64(totals.Debits) / 100,
+		ClosingBalance: float64(closing) / 100,
+	})
+	if err != nil {
+		return err
+	}
+
+	query = `
+SELECT transaction_id, uuid, operation, status, counterparty, changed_at, amount
+` + statementMovements + `
+  AND changed_at < $3
+ORDER BY changed_at, id`
+	rows, err := tx.QueryxContext(ctx, query, id, from, to)
+	if err != nil {
+		metrics.MetricErrCount.WithLabelValues("WalkStatement").Inc()
+		return fmt.Errorf("err getting statement entries: %w", err)
+	}
+	defer rows.Close()
+	balance := opening
+	for rows.Next() {
+		var e StatementEntry
+		if err = rows.StructScan(&e); err != nil {
+			return fmt.Errorf("err scanning statement entry: %w", err)
+		}
+		balance += cents(e.Amount)
+		e.Balance = float64(balance) / 100
+		if err = entry(e); err != nil {
+			return err
+		}
+	}
+	if err = rows.Err(); err != nil {
+		metrics.MetricErrCount.WithLabelValues("WalkStatement").Inc()
+		return fmt.Errorf("err getting statement entries: %w", err)
+	}
+	return nil
+}
+
+// statementMovements selects the balance movements of wallet $1 since $2.
+const statementMovements = `
+FROM (SELECT s.id,
+             t.id                                                                AS transaction_id,
+             t.uuid,
+             t.operation,
+             s.status,
+             CASE WHEN t.from_id = $1 THEN t.to_id ELSE t.from_id END            AS counterparty,
+             s.changed_at,
+             CASE WHEN t.operation = 'deposit' OR t.to_id = $1 THEN t.sum ELSE -t.sum END
+                 * CASE WHEN s.status = 'reversed' THEN -1 ELSE 1 END            AS amount
+      FROM transaction_status s
+               JOIN transaction t ON t.id = s.transaction_id
+      WHERE (t.from_id = $1 OR t.to_id = $1)
+        AND s.status IN ('completed', 'reversed')
+        AND s.changed_at >= $2) movement
+WHERE true`
+
+func cents(amount float64) int64 {
+	return int64(math.Round(amount * 100))
+}
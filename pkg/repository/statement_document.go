@@ -0,0 +1,94 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"EWallet/pkg/metrics"
+)
+
+var ErrStatementNotFound = fmt.Errorf("err statement not found")
+
+// StatementDocument is a rendered monthly statement. Pdf is only loaded by GetStatementDocument.
+type StatementDocument struct {
+	Id          int       `json:"id" db:"id"`
+	WalletId    int       `json:"wallet_id" db:"wallet_id"`
+	PeriodStart time.Time `json:"period_start" db:"period_start"`
+	PeriodEnd   time.Time `json:"period_end" db:"period_end"`
+	Signature   string    `json:"signature" db:"signature"`
+	Size        int       `json:"size" db:"size"`
+	CreatedAt   time.Time `json:"created_at" db:"created_at"`
+	Pdf         []byte    `json:"-" db:"pdf"`
+}
+
+// SaveStatementDocument stores the statement, saving the statement of a period twice is a no-op.
+func (pg *PG) SaveStatementDocument(ctx context.Context, doc StatementDocument) error {
+	query := `
+INSERT INTO statement_document (wallet_id, period_start, period_end, pdf, signature)
+VALUES ($1, $2, $3, $4, $5)
+ON CONFLICT (wallet_id, period_start) DO NOTHING`
+	if _, err := pg.db.ExecContext(ctx, query, doc.WalletId, doc.PeriodStart, doc.PeriodEnd, doc.Pdf, doc.Signature); err != nil {
+		metrics.MetricErrCount.WithLabelValues("SaveStatementDocument").Inc()
+		return fmt.Errorf("err saving statement document: %w", err)
+	}
+	return nil
+}
+
+// GetStatementDocuments lists the stored statements of the wallet, latest first.
+func (pg *PG) GetStatementDocuments(ctx context.Context, walletID int) ([]StatementDocument, error) {
+	docs := make([]StatementDocument, 0)
+	query := `
+SELECT id, wallet_id, period_start, period_end, signature, octet_length(pdf) AS size, created_at
+FROM statement_document
+WHERE wallet_id = $1
+ORDER BY period_start DESC`
+	if err := pg.db.SelectContext(ctx, &docs, query, walletID); err != nil {
+		metrics.MetricErrCount.WithLabelValues("GetStatementDocuments").Inc()
+		return nil, fmt.Errorf("err getting statement documents: %w", err)
+	}
+	return docs, nil
+}
+
+// GetStatementDocument returns the statement of the period starting at periodStart with its PDF.
+func (pg *PG) GetStatementDocument(ctx context.Context, walletID int, periodStart time.Time) (StatementDocument, error) {
+	query := `
+SELECT id, wallet_id, period_start, period_end, signature, octet_length(pdf) AS size, created_at, pdf
+FROM statement_document
+WHERE wallet_id = $1
+  AND period_start = $2`
+	var doc StatementDocument
+	if err := pg.db.GetContext(ctx, &doc, query, walletID, periodStart); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return StatementDocument{}, ErrStatementNotFound
+		}
+		metrics.MetricErrCount.WithLabelValues("GetStatementDocument").Inc()
+		return StatementDocument{}, fmt.Errorf("err getting statement document: %w", err)
+	}
+	return doc, nil
+}
+
+// GetWalletsWithoutStatement returns up to limit ids greater than afterID of the wallets that existed
+// before periodEnd and have no statement for the period starting at periodStart.
+func (pg *PG) GetWalletsWithoutStatement(ctx context.Context, periodStart, periodEnd time.Time, afterID, limit int) ([]int, error) {
+	started := time.Now()
+	defer func() {
+		metrics.MetricDBRequestsDuration.WithLabelValues("GetWalletsWithoutStatement").Observe(time.Since(started).Seconds())
+	}()
+	ids := make([]int, 0)
+	query := `
+SELECT w.id
+FROM wallet w
+WHERE w.id > $3
+  AND w.created_at < $2
+  AND NOT EXISTS(SELECT 1 FROM statement_document d WHERE d.wallet_id = w.id AND d.period_start = $1)
+ORDER BY w.id
+LIMIT $4`
+	if err := pg.db.SelectContext(ctx, &ids, query, periodStart, periodEnd, afterID, limit); err != nil {
+		metrics.MetricErrCount.WithLabelValues("GetWalletsWithoutStatement").Inc()
+		return nil, fmt.Errorf("err getting wallets without statement: %w", err)
+	}
+	return ids, nil
+}
@@ -0,0 +1,369 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"math/rand"
+	"sort"
+	"time"
+
+	"EWallet/pkg/events"
+	"EWallet/pkg/metrics"
+
+	"github.com/jackc/pgconn"
+	"github.com/jackc/pgerrcode"
+	"github.com/jmoiron/sqlx"
+)
+
+const (
+	StatusPending   = "pending"
+	StatusCompleted = "completed"
+	StatusFailed    = "failed"
+	StatusReversed  = "reversed"
+)
+
+const (
+	txMaxAttempts = 10
+	txBaseBackoff = 5 * time.Millisecond
+	txMaxBackoff  = 500 * time.Millisecond
+)
+
+var ErrTransactionStatus = fmt.Errorf("err transaction status does not allow this operation")
+
+// TransactionStatus is a single transition in the lifecycle of a transaction.
+type TransactionStatus struct {
+	TransactionId int       `json:"transaction_id" db:"transaction_id"`
+	Status        string    `json:"status" db:"status"`
+	Reason        *string   `json:"reason,omitempty" db:"reason"`
+	ChangedAt     time.Time `json:"changed_at" db:"changed_at"`
+}
+
+type walletState struct {
+	Id      int     `db:"id"`
+	Balance float64 `db:"balance"`
+	Frozen  bool    `db:"frozen"`
+}
+
+// Statuses lists the statuses of a transaction.
+var Statuses = []string{StatusPending, StatusCompleted, StatusFailed, StatusReversed}
+
+func IsValidStatus(status string) bool {
+	for _, s := range Statuses {
+		if s == status {
+			return true
+		}
+	}
+	return false
+}
+
+// withTx runs fn in a serializable transaction. Serialization failures and deadlocks
+// are retried with exponential backoff and jitter, so contending requests on a hot wallet spread out.
+func (pg *PG) withTx(ctx context.Context, method string, fn func(tx *sqlx.Tx) error) error {
+	backoff := txBaseBackoff
+	for attempt := 1; ; attempt++ {
+		err := pg.runTx(ctx, &sql.TxOptions{Isolation: sql.LevelSerializable}, fn)
+		if err == nil || !isRetryable(err) || attempt == txMaxAttempts {
+			return err
+		}
+		metrics.MetricErrCount.WithLabelValues(method + "Retry").Inc()
+		pg.log.WithContext(ctx).Warnf("retrying %s after attempt %d: %v", method, attempt, err)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(backoff + time.Duration(rand.Int63n(int64(backoff)))):
+		}
+		if backoff *= 2; backoff > txMaxBackoff {
+			backoff = txMaxBackoff
+		}
+	}
+}
+
+func (pg *PG) runTx(ctx context.Context, opts *sql.TxOptions, fn func(tx *sqlx.Tx) error) error {
+	tx, err := pg.db.BeginTxx(ctx, opts)
+	if err != nil {
+		return fmt.Errorf("err starting transaction: %w", err)
+	}
+	defer func() {
+		if err = tx.Rollback(); err != nil && !errors.Is(err, sql.ErrTxDone) {
+			pg.log.WithContext(ctx).Errorf("err rolling back transaction: %v", err)
+		}
+	}()
+	if err = fn(tx); err != nil {
+		return err
+	}
+	if err = tx.Commit(); err != nil {
+		return fmt.Errorf("err committing transaction: %w", err)
+	}
+	return nil
+}
+
+func isRetryable(err error) bool {
+	var pgErr *pgconn.PgError
+	if !errors.As(err, &pgErr) {
+		return false
+	}
+	return pgErr.Code == pgerrcode.SerializationFailure || pgErr.Code == pgerrcode.DeadlockDetected
+}
+
+// isRejection reports whether the money couldn't be moved because of the state of the wallets.
+func isRejection(err error) bool {
+	return errors.Is(err, ErrInsufficientFunds) ||
+		errors.Is(err, ErrWalletFrozen) ||
+		errors.Is(err, ErrWalletNotFound) ||
+		errors.Is(err, ErrWalletTargetNotFound)
+}
+
+// execute inserts the transaction as completed and moves the money in one DB transaction.
+// When the money can't be moved the attempt is recorded as failed.
+func (pg *PG) execute(ctx context.Context, t *Transaction) error {
+	err := pg.withTx(ctx, "execute", func(tx *sqlx.Tx) error {
+		if err := pg.insertTransaction(ctx, tx, t, StatusCompleted, nil); err != nil {
+			return err
+		}
+		if err := pg.apply(ctx, tx, t); err != nil {
+			return err
+		}
+		return pg.insertTransactionEvent(ctx, tx, t)
+	})
+	pg.recordOutcome(t, err)
+	if isRejection(err) {
+		pg.recordFailure(ctx, t, err)
+	}
+	return err
+}
+
+// recordOutcome counts the money moved by a committed transaction, in the wallet currency, and the
+// rejections for insufficient funds.
+func (pg *PG) recordOutcome(t *Transaction, err error) {
+	switch {
+	case err == nil:
+		metrics.MetricOperations.WithLabelValues(t.Operation, pg.currency).Inc()
+		metrics.MetricOperationVolume.WithLabelValues(t.Operation, pg.currency).Add(t.Sum)
+	case errors.Is(err, ErrInsufficientFunds):
+		metrics.MetricInsufficientFunds.WithLabelValues(t.Operation).Inc()
+	}
+}
+
+// apply moves the money of the transaction. The wallets are locked in id order,
+// so concurrent transfers in opposite directions can't deadlock.
+func (pg *PG) apply(ctx context.Context, tx *sqlx.Tx, t *Transaction) error {
+	ids := []int{t.FromId}
+	if t.Operation == "transfer" {
+		ids = append(ids, *t.ToId)
+	}
+	wallets, err := pg.lockWallets(ctx, tx, ids...)
+	if err != nil {
+		return err
+	}
+	source, ok := wallets[t.FromId]
+	if !ok {
+		return ErrWalletNotFound
+	}
+	if source.Frozen {
+		return ErrWalletFrozen
+	}
+	switch t.Operation {
+	case "deposit":
+		return pg.addBalance(ctx, tx, t.FromId, t.Sum)
+	case "withdraw":
+		if source.Balance < t.Sum {
+			return ErrInsufficientFunds
+		}
+		return pg.addBalance(ctx, tx, t.FromId, -t.Sum)
+	case "transfer":
+		target, ok := wallets[*t.ToId]
+		if !ok {
+			return ErrWalletTargetNotFound
+		}
+		if target.Frozen {
+			return ErrWalletFrozen
+		}
+		if source.Balance < t.Sum {
+			return ErrInsufficientFunds
+		}
+		if err = pg.addBalance(ctx, tx, t.FromId, -t.Sum); err != nil {
+			return err
+		}
+		return pg.addBalance(ctx, tx, *t.ToId, t.Sum)
+	}
+	return fmt.Errorf("unknown operation %q", t.Operation)
+}
+
+// inverse returns the transaction moving the money of t back.
+func inverse(t Transaction) Transaction {
+	switch t.Operation {
+	case "deposit":
+		t.Operation = "withdraw"
+	case "withdraw":
+		t.Operation = "deposit"
+	case "transfer":
+		to := t.FromId
+		t.FromId = *t.ToId
+		t.ToId = &to
+	}
+	return t
+}
+
+func (pg *PG) lockWallets(ctx context.Context, tx *sqlx.Tx, ids ...int) (map[int]walletState, error) {
+	started := time.Now()
+	defer func() {
+		metrics.MetricDBRequestsDuration.WithLabelValues("lockWallets").Observe(time.Since(started).Seconds())
+	}()
+	sorted := append([]int(nil), ids...)
+	sort.Ints(sorted)
+	wallets := make(map[int]walletState, len(ids))
+	query := `SELECT id, balance, frozen FROM wallet WHERE id = $1 FOR UPDATE`
+	for _, id := range sorted {
+		if _, ok := wallets[id]; ok {
+			continue
+		}
+		var w walletState
+		if err := tx.GetContext(ctx, &w, query, id); err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				continue
+			}
+			metrics.MetricErrCount.WithLabelValues("lockWallets").Inc()
+			return nil, fmt.Errorf("err locking wallet: %w", err)
+		}
+		wallets[id] = w
+	}
+	return wallets, nil
+}
+
+func (pg *PG) addBalance(ctx context.Context, tx *sqlx.Tx, id int, sum float64) error {
+	query := `UPDATE wallet SET balance = balance + $1 WHERE id = $2`
+	if _, err := tx.ExecContext(ctx, query, sum, id); err != nil {
+		return fmt.Errorf("err updating the balance: %w", err)
+	}
+	return nil
+}
+
+func (pg *PG) insertTransaction(ctx context.Context, tx *sqlx.Tx, t *Transaction, status string, reason *string) error {
+	query := `
+INSERT INTO transaction (uuid, from_id, to_id, operation, sum, status, reason)
+VALUES ($1, $2, $3, $4, $5, $6, $7)
+RETURNING id, date, updated_at`
+	row := tx.QueryRowContext(ctx, query, t.UUID, t.FromId, t.ToId, t.Operation, t.Sum, status, reason)
+	if err := row.Scan(&t.Id, &t.Date, &t.UpdatedAt); err != nil {
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == pgerrcode.UniqueViolation {
+			return ErrDuplicateKey
+		}
+		return fmt.Errorf("err inserting transaction: %w", err)
+	}
+	t.Status = status
+	t.Reason = reason
+	return pg.logStatus(ctx, tx, t.Id, status, reason)
+}
+
+func (pg *PG) setStatus(ctx context.Context, tx *sqlx.Tx, id int, status string, reason *string) error {
+	query := `UPDATE transaction SET status = $1, reason = $2, updated_at = now() WHERE id = $3`
+	if _, err := tx.ExecContext(ctx, query, status, reason, id); err != nil {
+		return fmt.Errorf("err updating transaction status: %w", err)
+	}
+	return pg.logStatus(ctx, tx, id, status, reason)
+}
+
+func (pg *PG) logStatus(ctx context.Context, tx *sqlx.Tx, id int, status string, reason *string) error {
+	query := `INSERT INTO transaction_status (transaction_id, status, reason) VALUES ($1, $2, $3)`
+	if _, err := tx.ExecContext(ctx, query, id, status, reason); err != nil {
+		return fmt.Errorf("err logging transaction status: %w", err)
+	}
+	return nil
+}
+
+// recordFailure stores a failed attempt for support investigations, it never fails the caller.
+func (pg *PG) recordFailure(ctx context.Context, t *Transaction, cause error) {
+	reason := cause.Error()
+	err := pg.withTx(ctx, "recordFailure", func(tx *sqlx.Tx) error {
+		return pg.insertTransaction(ctx, tx, t, StatusFailed, &reason)
+	})
+	if err != nil {
+		pg.log.WithContext(ctx).Errorf("err recording failed %s: %v", t.Operation, err)
+	}
+}
+
+// lockTransaction selects the transaction for update and checks it is in the expected status.
+func (pg *PG) lockTransaction(ctx context.Context, tx *sqlx.Tx, id int, status string) (Transaction, error) {
+	query := `
+SELECT id, uuid, from_id, to_id, operation, sum, date, status, reason, updated_at
+FROM transaction
+WHERE id = $1
+FOR UPDATE`
+	var t Transaction
+	if err := tx.GetContext(ctx, &t, query, id); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return Transaction{}, ErrTransactionNotFound
+		}
+		return Transaction{}, fmt.Errorf("err getting transaction: %w", err)
+	}
+	if t.Status != status {
+		return Transaction{}, fmt.Errorf("%s transaction: %w", t.Status, ErrTransactionStatus)
+	}
+	return t, nil
+}
+
+// ReverseTransaction moves the money of a completed transaction back.
+func (pg *PG) ReverseTransaction(ctx context.Context, id int, reason string) error {
+	started := time.Now()
+	defer func() {
+		metrics.MetricDBRequestsDuration.WithLabelValues("ReverseTransaction").Observe(time.Since(started).Seconds())
+	}()
+	err := pg.withTx(ctx, "ReverseTransaction", func(tx *sqlx.Tx) error {
+		t, err := pg.lockTransaction(ctx, tx, id, StatusCompleted)
+		if err != nil {
+			return err
+		}
+		reversal := inverse(t)
+		if err = pg.apply(ctx, tx, &reversal); err != nil {
+			return err
+		}
+		if err = pg.setStatus(ctx, tx, id, StatusReversed, &reason); err != nil {
+			return err
+		}
+		t.Status = StatusReversed
+		t.Reason = &reason
+		return pg.insertEvent(ctx, tx, events.TransactionReversed, t.FromId, t.ToId, t)
+	})
+	if err != nil {
+		metrics.MetricErrCount.WithLabelValues("ReverseTransaction").Inc()
+	}
+	return err
+}
+
+func (pg *PG) GetTransactionStatuses(ctx context.Context, id int) ([]TransactionStatus, error) {
+	statuses := make([]TransactionStatus, 0)
+	query := `SELECT transaction_id, status, reason, changed_at FROM transaction_status WHERE transaction_id = $1 ORDER BY id`
+	if err := pg.db.SelectContext(ctx, &statuses, query, id); err != nil {
+		return nil, fmt.Errorf("err getting transaction statuses: %w", err)
+	}
+	if len(statuses) == 0 {
+		return nil, ErrTransactionNotFound
+	}
+	return statuses, nil
+}
+
+// GetTransactionByUUID returns the operation of wallet fromID recorded with the uuid, the scope uuids
+// are unique in. At most one transaction of the scope did not fail, it is preferred over the failed
+// attempts, the latest of which is returned otherwise.
+func (pg *PG) GetTransactionByUUID(ctx context.Context, fromID int, operation, uuid string) (Transaction, error) {
+	query := `
+SELECT id, uuid, from_id, to_id, operation, sum, date, status, reason, updated_at
+FROM transaction
+WHERE from_id = $1
+  AND operation = $2
+  AND uuid = $3
+ORDER BY status = 'failed', id DESC
+LIMIT 1`
+	var t Transaction
+	if err := pg.db.GetContext(ctx, &t, query, fromID, operation, uuid); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return Transaction{}, ErrTransactionNotFound
+		}
+		metrics.MetricErrCount.WithLabelValues("GetTransactionByUUID").Inc()
+		return Transaction{}, fmt.Errorf("err getting transaction: %w", err)
+	}
+	return t, nil
+}
@@ -0,0 +1,269 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"EWallet/pkg/events"
+	"EWallet/pkg/metrics"
+
+	"github.com/jmoiron/sqlx"
+)
+
+const (
+	WebhookPending   = "pending"
+	WebhookDelivered = "delivered"
+	WebhookDead      = "dead"
+)
+
+var (
+	ErrWebhookNotFound         = fmt.Errorf("err webhook not found")
+	ErrWebhookDeliveryNotFound = fmt.Errorf("err webhook delivery not found")
+)
+
+// WebhookSubscription delivers events of EventType ("*" for all) touching WalletId to Url.
+type WebhookSubscription struct {
+	Id        int       `json:"id" db:"id"`
+	Username  string    `json:"username" db:"username"`
+	WalletId  int       `json:"wallet_id" db:"wallet_id"`
+	EventType string    `json:"event_type" db:"event_type"`
+	Url       string    `json:"url" db:"url"`
+	Secret    string    `json:"secret,omitempty" db:"secret"`
+	CreatedAt time.Time `json:"created_at" db:"created_at"`
+}
+
+type WebhookDelivery struct {
+	Id             int             `json:"id" db:"id"`
+	SubscriptionId int             `json:"subscription_id" db:"subscription_id"`
+	EventId        *int64          `json:"event_id" db:"event_id"`
+	EventType      string          `json:"event_type" db:"event_type"`
+	Payload        json.RawMessage `json:"payload" db:"payload"`
+	Status         string          `json:"status" db:"status"`
+	Attempts       int             `json:"attempts" db:"attempts"`
+	NextAttemptAt  time.Time       `json:"next_attempt_at" db:"next_attempt_at"`
+	LastStatusCode *int            `json:"last_status_code" db:"last_status_code"`
+	LastError      *string         `json:"last_error" db:"last_error"`
+	CreatedAt      time.Time       `json:"created_at" db:"created_at"`
+	DeliveredAt    *time.Time      `json:"delivered_at" db:"delivered_at"`
+	// Url and Secret are filled for claimed deliveries only.
+	Url    string `json:"-" db:"url"`
+	Secret string `json:"-" db:"secret"`
+}
+
+type WebhookAttempt struct {
+	Id          int       `json:"id" db:"id"`
+	DeliveryId  int       `json:"delivery_id" db:"delivery_id"`
+	StatusCode  *int      `json:"status_code" db:"status_code"`
+	Error       *string   `json:"error" db:"error"`
+	DurationMs  int       `json:"duration_ms" db:"duration_ms"`
+	AttemptedAt time.Time `json:"attempted_at" db:"attempted_at"`
+}
+
+const deliveryColumns = `d.id, d.subscription_id, d.event_id, d.event_type, d.payload, d.status, d.attempts,
+       d.next_attempt_at, d.last_status_code, d.last_error, d.created_at, d.delivered_at`
+
+func (pg *PG) CreateWebhook(ctx context.Context, sub WebhookSubscription) (WebhookSubscription, error) {
+	query := `
+INSERT INTO webhook_subscription (username, wallet_id, event_type, url, secret)
+VALUES ($1, $2, $3, $4, $5)
+RETURNING *`
+	row := pg.db.QueryRowxContext(ctx, query, sub.Username, sub.WalletId, sub.EventType, sub.Url, sub.Secret)
+	if err := row.StructScan(&sub); err != nil {
+		metrics.MetricErrCount.WithLabelValues("CreateWebhook").Inc()
+		return WebhookSubscription{}, fmt.Errorf("err creating webhook: %w", err)
+	}
+	return sub, nil
+}
+
+func (pg *PG) GetWebhooks(ctx context.Context, username string) ([]WebhookSubscription, error) {
+	subs := make([]WebhookSubscription, 0)
+	query := `SELECT id, username, wallet_id, event_type, url, created_at FROM webhook_subscription WHERE username = $1 ORDER BY id`
+	if err := pg.db.SelectContext(ctx, &subs, query, username); err != nil {
+		return nil, fmt.Errorf("err getting webhooks: %w", err)
+	}
+	return subs, nil
+}
+
+func (pg *PG) GetWebhook(ctx context.Context, username string, id int) (WebhookSubscription, error) {
+	query := `SELECT id, username, wallet_id, event_type, url, created_at FROM webhook_subscription WHERE id = $1 AND username = $2`
+	var sub WebhookSubscription
+	if err := pg.db.GetContext(ctx, &sub, query, id, username); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return WebhookSubscription{}, ErrWebhookNotFound
+		}
+		return WebhookSubscription{}, fmt.Errorf("err getting webhook: %w", err)
+	}
+	return sub, nil
+}
+
+func (pg *PG) DeleteWebhook(ctx context.Context, username string, id int) error {
+	query := `DELETE FROM webhook_subscription WHERE id = $1 AND username = $2`
+	res, err := pg.db.ExecContext(ctx, query, id, username)
+	if err != nil {
+		return fmt.Errorf("err deleting webhook: %w", err)
+	}
+	if cnt, _ := res.RowsAffected(); cnt == 0 {
+		return ErrWebhookNotFound
+	}
+	return nil
+}
+
+// EnqueueWebhookDeliveries creates a delivery of the event for every matching subscription. The event
+// matches subscriptions of both the wallet and the transfer target. Enqueueing an event twice is a no-op.
+func (pg *PG) EnqueueWebhookDeliveries(ctx context.Context, e events.Event) error {
+	started := time.Now()
+	defer func() {
+		metrics.MetricDBRequestsDuration.WithLabelValues("EnqueueWebhookDeliveries").Observe(time.Since(started).Seconds())
+	}()
+	body, err := json.Marshal(e)
+	if err != nil {
+		return fmt.Errorf("err encoding event %d: %w", e.Id, err)
+	}
+	targetID := e.WalletId
+	if e.TargetId != nil {
+		targetID = *e.TargetId
+	}
+	query := `
+INSERT INTO webhook_delivery (subscription_id, event_id, event_type, payload)
+SELECT id, $1, $2, $3
+FROM webhook_subscription
+WHERE wallet_id IN ($4, $5)
+  AND (event_type = '*' OR event_type = $2)
+ON CONFLICT (subscription_id, event_id) DO NOTHING`
+	if _, err = pg.db.ExecContext(ctx, query, e.Id, e.Type, body, e.WalletId, targetID); err != nil {
+		metrics.MetricErrCount.WithLabelValues("EnqueueWebhookDeliveries").Inc()
+		return fmt.Errorf("err enqueueing webhook deliveries: %w", err)
+	}
+	return nil
+}
+
+// CreateWebhookDelivery enqueues a delivery that is not backed by an outbox event, e.g. a test ping.
+func (pg *PG) CreateWebhookDelivery(ctx context.Context, subscriptionID int, eventType string, payload []byte) (WebhookDelivery, error) {
+	query := `
+INSERT INTO webhook_delivery AS d (subscription_id, event_type, payload)
+VALUES ($1, $2, $3)
+RETURNING ` + deliveryColumns
+	var d WebhookDelivery
+	if err := pg.db.QueryRowxContext(ctx, query, subscriptionID, eventType, payload).StructScan(&d); err != nil {
+		return WebhookDelivery{}, fmt.Errorf("err creating webhook delivery: %w", err)
+	}
+	return d, nil
+}
+
+func (pg *PG) GetWebhookDeliveries(ctx context.Context, subscriptionID int, status string) ([]WebhookDelivery, error) {
+	deliveries := make([]WebhookDelivery, 0)
+	query := `SELECT ` + deliveryColumns + ` FROM webhook_delivery d WHERE d.subscription_id = $1 AND ($2 = '' OR d.status = $2) ORDER BY d.id DESC`
+	if err := pg.db.SelectContext(ctx, &deliveries, query, subscriptionID, status); err != nil {
+		return nil, fmt.Errorf("err getting webhook deliveries: %w", err)
+	}
+	return deliveries, nil
+}
+
+func (pg *PG) GetWebhookAttempts(ctx context.Context, username string, deliveryID int) ([]WebhookAttempt, error) {
+	query := `
+SELECT EXISTS (SELECT 1
+               FROM webhook_delivery d
+                        JOIN webhook_subscription s ON s.id = d.subscription_id
+               WHERE d.id = $1
+                 AND s.username = $2)`
+	var exists bool
+	if err := pg.db.GetContext(ctx, &exists, query, deliveryID, username); err != nil {
+		return nil, fmt.Errorf("err getting webhook delivery: %w", err)
+	}
+	if !exists {
+		return nil, ErrWebhookDeliveryNotFound
+	}
+	attempts := make([]WebhookAttempt, 0)
+	query = `SELECT * FROM webhook_attempt WHERE delivery_id = $1 ORDER BY id`
+	if err := pg.db.SelectContext(ctx, &attempts, query, deliveryID); err != nil {
+		return nil, fmt.Errorf("err getting webhook attempts: %w", err)
+	}
+	return attempts, nil
+}
+
+// RedeliverWebhook schedules the delivery for an immediate new round of attempts, whatever its status.
+func (pg *PG) RedeliverWebhook(ctx context.Context, username string, deliveryID int) (WebhookDelivery, error) {
+	query := `
+UPDATE webhook_delivery d
+SET status          = $1,
+    attempts        = 0,
+    next_attempt_at = now()
+FROM webhook_subscription s
+WHERE d.id = $2
+  AND s.id = d.subscription_id
+  AND s.username = $3
+RETURNING ` + deliveryColumns
+	var d WebhookDelivery
+	if err := pg.db.QueryRowxContext(ctx, query, WebhookPending, deliveryID, username).StructScan(&d); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return WebhookDelivery{}, ErrWebhookDeliveryNotFound
+		}
+		return WebhookDelivery{}, fmt.Errorf("err redelivering webhook: %w", err)
+	}
+	return d, nil
+}
+
+// ClaimWebhookDeliveries takes up to limit due deliveries and hides them from other workers for lease.
+// A delivery whose worker died becomes due again once the lease expires.
+func (pg *PG) ClaimWebhookDeliveries(ctx context.Context, limit int, lease time.Duration) ([]WebhookDelivery, error) {
+	started := time.Now()
+	defer func() {
+		metrics.MetricDBRequestsDuration.WithLabelValues("ClaimWebhookDeliveries").Observe(time.Since(started).Seconds())
+	}()
+	query := `
+WITH due AS (SELECT id
+             FROM webhook_delivery
+             WHERE status = $1
+               AND next_attempt_at <= now()
+             ORDER BY next_attempt_at
+             LIMIT $2 FOR UPDATE SKIP LOCKED)
+UPDATE webhook_delivery d
+SET next_attempt_at = now() + make_interval(secs => $3)
+FROM due,
+     webhook_subscription s
+WHERE d.id = due.id
+  AND s.id = d.subscription_id
+RETURNING ` + deliveryColumns + `, s.url, s.secret`
+	deliveries := make([]WebhookDelivery, 0, limit)
+	if err := pg.db.SelectContext(ctx, &deliveries, query, WebhookPending, limit, lease.Seconds()); err != nil {
+		metrics.MetricErrCount.WithLabelValues("ClaimWebhookDeliveries").Inc()
+		return nil, fmt.Errorf("err claiming webhook deliveries: %w", err)
+	}
+	return deliveries, nil
+}
+
+// RecordWebhookAttempt logs the attempt and moves the delivery into status. Pending deliveries are
+// retried at nextAttemptAt.
+func (pg *PG) RecordWebhookAttempt(ctx context.Context, attempt WebhookAttempt, status string, nextAttemptAt time.Time) error {
+	started := time.Now()
+	defer func() {
+		metrics.MetricDBRequestsDuration.WithLabelValues("RecordWebhookAttempt").Observe(time.Since(started).Seconds())
+	}()
+	err := pg.runTx(ctx, nil, func(tx *sqlx.Tx) error {
+		query := `INSERT INTO webhook_attempt (delivery_id, status_code, error, duration_ms) VALUES ($1, $2, $3, $4)`
+		if _, err := tx.ExecContext(ctx, query, attempt.DeliveryId, attempt.StatusCode, attempt.Error, attempt.DurationMs); err != nil {
+			return fmt.Errorf("err logging webhook attempt: %w", err)
+		}
+		query = `
+UPDATE webhook_delivery
+SET status           = $1,
+    attempts         = attempts + 1,
+    next_attempt_at  = $2,
+    last_status_code = $3,
+    last_error       = $4,
+    delivered_at     = CASE WHEN $1 = 'delivered' THEN now() END
+WHERE id = $5`
+		if _, err := tx.ExecContext(ctx, query, status, nextAttemptAt, attempt.StatusCode, attempt.Error, attempt.DeliveryId); err != nil {
+			return fmt.Errorf("err updating webhook delivery: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		metrics.MetricErrCount.WithLabelValues("RecordWebhookAttempt").Inc()
+	}
+	return err
+}
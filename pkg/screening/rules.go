@@ -0,0 +1,122 @@
+package screening
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// History is the transaction history the rules are evaluated against.
+type History interface {
+	HasTransferred(ctx context.Context, from, to int) (bool, error)
+	CountTransfers(ctx context.Context, from int, since time.Time, maxSum float64) (int, error)
+	OwnerChangedAt(ctx context.Context, id int) (*time.Time, error)
+}
+
+const (
+	DefaultMaxAmount         = 1000000
+	DefaultNewTargetAmount   = 10000
+	DefaultBurstWindow       = 10 * time.Minute
+	DefaultBurstMaxSum       = 100
+	DefaultBurstLimit        = 5
+	DefaultOwnerChangeWindow = 24 * time.Hour
+)
+
+func DefaultRules(history History) []Rule {
+	return []Rule{
+		&MaxAmount{Max: DefaultMaxAmount},
+		&NewTargetLargeAmount{History: history, Threshold: DefaultNewTargetAmount},
+		&SmallTransfersBurst{History: history, Window: DefaultBurstWindow, MaxSum: DefaultBurstMaxSum, Limit: DefaultBurstLimit},
+		&RecentOwnerChange{History: history, Window: DefaultOwnerChangeWindow},
+	}
+}
+
+// MaxAmount denies any operation above Max.
+type MaxAmount struct {
+	Max float64
+}
+
+func (r *MaxAmount) Name() string {
+	return "max_amount"
+}
+
+func (r *MaxAmount) Evaluate(_ context.Context, op Operation) (Verdict, string, error) {
+	if op.Sum > r.Max {
+		return Deny, fmt.Sprintf("sum %v exceeds the limit of %v", op.Sum, r.Max), nil
+	}
+	return Allow, "", nil
+}
+
+// NewTargetLargeAmount holds transfers of at least Threshold to a wallet the source never transferred to.
+type NewTargetLargeAmount struct {
+	History   History
+	Threshold float64
+}
+
+func (r *NewTargetLargeAmount) Name() string {
+	return "new_target_large_amount"
+}
+
+func (r *NewTargetLargeAmount) Evaluate(ctx context.Context, op Operation) (Verdict, string, error) {
+	if op.Kind != "transfer" || op.Sum < r.Threshold {
+		return Allow, "", nil
+	}
+	known, err := r.History.HasTransferred(ctx, op.WalletID, op.TargetID)
+	if err != nil {
+		return Allow, "", err
+	}
+	if known {
+		return Allow, "", nil
+	}
+	return Review, fmt.Sprintf("first transfer to wallet %d with sum %v", op.TargetID, op.Sum), nil
+}
+
+// SmallTransfersBurst holds a small transfer when the source already made Limit small transfers within Window.
+type SmallTransfersBurst struct {
+	History History
+	Window  time.Duration
+	MaxSum  float64
+	Limit   int
+}
+
+func (r *SmallTransfersBurst) Name() string {
+	return "small_transfers_burst"
+}
+
+func (r *SmallTransfersBurst) Evaluate(ctx context.Context, op Operation) (Verdict, string, error) {
+	if op.Kind != "transfer" || op.Sum > r.MaxSum {
+		return Allow, "", nil
+	}
+	cnt, err := r.History.CountTransfers(ctx, op.WalletID, time.Now().Add(-r.Window), r.MaxSum)
+	if err != nil {
+		return Allow, "", err
+	}
+	if cnt < r.Limit {
+		return Allow, "", nil
+	}
+	return Review, fmt.Sprintf("%d transfers up to %v within %v", cnt, r.MaxSum, r.Window), nil
+}
+
+// RecentOwnerChange holds money leaving a wallet whose owner changed less than Window ago.
+type RecentOwnerChange struct {
+	History History
+	Window  time.Duration
+}
+
+func (r *RecentOwnerChange) Name() string {
+	return "recent_owner_change"
+}
+
+func (r *RecentOwnerChange) Evaluate(ctx context.Context, op Operation) (Verdict, string, error) {
+	if op.Kind != "transfer" && op.Kind != "withdraw" {
+		return Allow, "", nil
+	}
+	changedAt, err := r.History.OwnerChangedAt(ctx, op.WalletID)
+	if err != nil {
+		return Allow, "", err
+	}
+	if changedAt == nil || time.Since(*changedAt) > r.Window {
+		return Allow, "", nil
+	}
+	return Review, fmt.Sprintf("owner changed at %s", changedAt.Format(time.RFC3339)), nil
+}
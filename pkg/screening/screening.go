@@ -0,0 +1,87 @@
+package screening
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/sirupsen/logrus"
+)
+
+type Verdict int
+
+const (
+	Allow Verdict = iota
+	Review
+	Deny
+)
+
+func (v Verdict) String() string {
+	switch v {
+	case Review:
+		return "review"
+	case Deny:
+		return "deny"
+	default:
+		return "allow"
+	}
+}
+
+var (
+	ErrTransactionDenied = errors.New("err transaction denied by screening")
+	ErrPendingReview     = errors.New("err transaction is pending review")
+)
+
+// Operation describes a money movement before it reaches the storage.
+type Operation struct {
+	Kind     string
+	WalletID int
+	TargetID int
+	Sum      float64
+	UUID     string
+}
+
+// Decision is the strictest verdict returned by the rules together with the rule that produced it.
+type Decision struct {
+	Verdict Verdict
+	Rule    string
+	Reason  string
+}
+
+type Rule interface {
+	Name() string
+	Evaluate(ctx context.Context, op Operation) (Verdict, string, error)
+}
+
+type Screener struct {
+	log   *logrus.Entry
+	rules []Rule
+}
+
+func NewScreener(log *logrus.Logger, rules ...Rule) *Screener {
+	return &Screener{
+		log:   log.WithField("component", "screening"),
+		rules: rules,
+	}
+}
+
+// Screen runs every rule against the operation. Deny wins over Review, Review wins over Allow.
+func (s *Screener) Screen(ctx context.Context, op Operation) (Decision, error) {
+	decision := Decision{Verdict: Allow}
+	for _, rule := range s.rules {
+		verdict, reason, err := rule.Evaluate(ctx, op)
+		if err != nil {
+			return Decision{}, fmt.Errorf("err evaluating rule %s: %w", rule.Name(), err)
+		}
+		if verdict > decision.Verdict {
+			decision = Decision{Verdict: verdict, Rule: rule.Name(), Reason: reason}
+		}
+		if decision.Verdict == Deny {
+			break
+		}
+	}
+	if decision.Verdict != Allow {
+		s.log.WithContext(ctx).Infof("%s %s of wallet %d: %s", decision.Verdict, op.Kind, op.WalletID, decision.Reason)
+	}
+	return decision, nil
+}
@@ -0,0 +1,164 @@
+// Package validation checks the requests of the REST and gRPC APIs against the rules in the binding
+// tags of their types.
+package validation
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"strings"
+	"sync"
+
+	"EWallet/pkg/models"
+	"EWallet/pkg/repository"
+
+	"github.com/gin-gonic/gin/binding"
+	"github.com/go-playground/validator/v10"
+)
+
+// amountPrecision is the number of decimal places amounts are stored with, numeric(10, 2).
+const amountPrecision = 2
+
+var registerOnce sync.Once
+
+// FieldError describes an invalid field of the request.
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// Register adds the custom rules used in the binding tags of the request types to the validator gin
+// binds requests with.
+func Register() {
+	registerOnce.Do(func() {
+		v, ok := binding.Validator.Engine().(*validator.Validate)
+		if !ok {
+			return
+		}
+		v.RegisterTagNameFunc(func(f reflect.StructField) string {
+			for _, tag := range []string{"json", "form"} {
+				if name := strings.Split(f.Tag.Get(tag), ",")[0]; name != "" && name != "-" {
+					return name
+				}
+			}
+			return f.Name
+		})
+		_ = v.RegisterValidation("notblank", func(fl validator.FieldLevel) bool {
+			return strings.TrimSpace(fl.Field().String()) != ""
+		})
+		_ = v.RegisterValidation("status", func(fl validator.FieldLevel) bool {
+			return repository.IsValidStatus(fl.Field().String())
+		})
+		_ = v.RegisterValidation("precision", func(fl validator.FieldLevel) bool {
+			scaled := fl.Field().Float() * math.Pow10(amountPrecision)
+			return math.Abs(scaled-math.Round(scaled)) < 1e-6
+		})
+		v.RegisterStructValidation(func(sl validator.StructLevel) {
+			params := sl.Current().Interface().(models.TransactionQueryParams)
+			if params.From != nil && params.To != nil && !params.To.After(*params.From) {
+				sl.ReportError(params.To, "to", "To", "after", "from")
+			}
+			if params.MaxSum != 0 && params.MaxSum < params.MinSum {
+				sl.ReportError(params.MaxSum, "max_sum", "MaxSum", "gtefield", "min_sum")
+			}
+		}, models.TransactionQueryParams{})
+	})
+}
+
+// Validate checks v against the rules in its binding tags.
+func Validate(v interface{}) []FieldError {
+	Register()
+	if err := binding.Validator.ValidateStruct(v); err != nil {
+		return Errors(err)
+	}
+	return nil
+}
+
+// ValidateOperation checks the rules of a money operation on wallet id that the tags of
+// models.OperationRequest cannot express.
+func ValidateOperation(operation string, id int, request *models.OperationRequest) []FieldError {
+	fields := Validate(request)
+	if operation == "transfer" {
+		switch request.WalletTarget {
+		case 0:
+			fields = append(fields, FieldError{Field: "walletTarget", Message: "is required"})
+		case id:
+			fields = append(fields, FieldError{Field: "walletTarget", Message: "must differ from the source wallet"})
+		}
+	}
+	return fields
+}
+
+// ValidatePayout checks the payout from wallet id: the rules in the tags of the items, every target
+// differs from the source and every uuid is used once.
+func ValidatePayout(id int, request *models.PayoutRequest) []FieldError {
+	fields := Validate(request)
+	uuids := make(map[string]int, len(request.Items))
+	for i, item := range request.Items {
+		if item.WalletTarget == id {
+			fields = append(fields, FieldError{Field: fmt.Sprintf("items[%d].walletTarget", i), Message: "must differ from the source wallet"})
+		}
+		if j, ok := uuids[item.UUID]; ok && item.UUID != "" {
+			fields = append(fields, FieldError{Field: fmt.Sprintf("items[%d].uuid", i), Message: fmt.Sprintf("is used by item %d", j)})
+		}
+		uuids[item.UUID] = i
+	}
+	return fields
+}
+
+// Errors lists the fields failing the rules of err, nil when err isn't a validation error.
+func Errors(err error) []FieldError {
+	var errs validator.ValidationErrors
+	if !errors.As(err, &errs) {
+		return nil
+	}
+	fields := make([]FieldError, 0, len(errs))
+	for _, e := range errs {
+		// The namespace names nested fields like items[2].sum, without the name of the top struct.
+		field := e.Namespace()
+		if i := strings.Index(field, "."); i >= 0 {
+			field = field[i+1:]
+		}
+		fields = append(fields, FieldError{Field: field, Message: ruleMessage(e)})
+	}
+	return fields
+}
+
+func ruleMessage(e validator.FieldError) string {
+	switch e.Tag() {
+	case "required", "notblank":
+		return "is required"
+	case "gt":
+		return "must be greater than " + e.Param()
+	case "gte", "min":
+		switch e.Kind() {
+		case reflect.String:
+			return fmt.Sprintf("must be at least %s characters long", e.Param())
+		case reflect.Slice:
+			return fmt.Sprintf("must have at least %s items", e.Param())
+		}
+		return "must be at least " + e.Param()
+	case "lte", "max":
+		switch e.Kind() {
+		case reflect.String:
+			return fmt.Sprintf("must be at most %s characters long", e.Param())
+		case reflect.Slice:
+			return fmt.Sprintf("must have at most %s items", e.Param())
+		}
+		return "must be at most " + e.Param()
+	case "after":
+		return "must be after " + e.Param()
+	case "gtefield":
+		return "must be at least " + e.Param()
+	case "oneof":
+		return "must be one of: " + strings.ReplaceAll(e.Param(), " ", ", ")
+	case "status":
+		return "must be one of: " + strings.Join(repository.Statuses, ", ")
+	case "uuid":
+		return "must be a UUID"
+	case "precision":
+		return fmt.Sprintf("must have at most %d decimal places", amountPrecision)
+	}
+	return "is invalid"
+}
@@ -0,0 +1,225 @@
+package webhooks
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"EWallet/pkg/events"
+	"EWallet/pkg/repository"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	HeaderDelivery  = "X-Webhook-Delivery"
+	HeaderEvent     = "X-Webhook-Event"
+	HeaderTimestamp = "X-Webhook-Timestamp"
+	HeaderSignature = "X-Webhook-Signature"
+
+	// AllEvents subscribes to every event type.
+	AllEvents = "*"
+	// TestEvent is the type of deliveries fired from the test endpoint.
+	TestEvent = "WebhookTest"
+
+	// MaxAttempts is the number of failed attempts after which a delivery is dead.
+	MaxAttempts = 10
+	baseBackoff = 30 * time.Second
+	maxBackoff  = 6 * time.Hour
+)
+
+var eventTypes = map[string]bool{
+	AllEvents:                  true,
+	events.WalletCreated:       true,
+	events.WalletUpdated:       true,
+	events.WalletFrozen:        true,
+	events.WalletDeleted:       true,
+	events.Deposited:           true,
+	events.Withdrawn:           true,
+	events.Transferred:         true,
+	events.TransactionReversed: true,
+}
+
+func IsValidEventType(eventType string) bool {
+	return eventTypes[eventType]
+}
+
+// NewSecret generates the signing secret of a subscription.
+func NewSecret() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("err generating webhook secret: %w", err)
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// Sign returns the signature of a payload sent at timestamp: "sha256=" followed by the hex HMAC-SHA256
+// of "<timestamp>.<body>" keyed with the subscription secret.
+func Sign(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10)))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verify checks a signature the way receivers are expected to.
+func Verify(secret string, timestamp int64, body []byte, signature string) bool {
+	return hmac.Equal([]byte(Sign(secret, timestamp, body)), []byte(signature))
+}
+
+// Backoff is the delay before retrying a delivery that failed attempts times.
+func Backoff(attempts int) time.Duration {
+	if attempts < 1 {
+		return 0
+	}
+	delay := baseBackoff
+	for i := 1; i < attempts; i++ {
+		delay *= 2
+		if delay >= maxBackoff {
+			return maxBackoff
+		}
+	}
+	return delay
+}
+
+type Store interface {
+	EnqueueWebhookDeliveries(ctx context.Context, e events.Event) error
+	ClaimWebhookDeliveries(ctx context.Context, limit int, lease time.Duration) ([]repository.WebhookDelivery, error)
+	RecordWebhookAttempt(ctx context.Context, attempt repository.WebhookAttempt, status string, nextAttemptAt time.Time) error
+}
+
+// Sink turns outbox events into webhook deliveries.
+type Sink struct {
+	store Store
+}
+
+func NewSink(store Store) *Sink {
+	return &Sink{store: store}
+}
+
+func (s *Sink) Publish(ctx context.Context, batch []events.Event) error {
+	for _, e := range batch {
+		if err := s.store.EnqueueWebhookDeliveries(ctx, e); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Worker sends due deliveries, retrying failures with exponential backoff until MaxAttempts.
+type Worker struct {
+	log      *logrus.Entry
+	store    Store
+	client   *http.Client
+	interval time.Duration
+	batch    int
+	lease    time.Duration
+}
+
+// NewWorker creates the delivery worker. A nil client is replaced by NewClient, which refuses
+// non-public addresses.
+func NewWorker(log *logrus.Logger, store Store, client *http.Client, interval time.Duration, batch int) *Worker {
+	if client == nil {
+		client = NewClient(10 * time.Second)
+	}
+	return &Worker{
+		log:      log.WithField("component", "webhooks"),
+		store:    store,
+		client:   client,
+		interval: interval,
+		batch:    batch,
+		lease:    client.Timeout + time.Minute,
+	}
+}
+
+func (w *Worker) Run(ctx context.Context) {
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+	for {
+		for {
+			cnt, err := w.Deliver(ctx)
+			if err != nil {
+				w.log.Errorf("err delivering webhooks: %v", err)
+				break
+			}
+			if cnt < w.batch {
+				break
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Deliver makes one attempt for every claimed delivery and returns how many were claimed.
+func (w *Worker) Deliver(ctx context.Context) (int, error) {
+	deliveries, err := w.store.ClaimWebhookDeliveries(ctx, w.batch, w.lease)
+	if err != nil {
+		return 0, err
+	}
+	for _, d := range deliveries {
+		attempt := w.send(ctx, d)
+		status, next := repository.WebhookDelivered, time.Now()
+		if attempt.Error != nil {
+			status = repository.WebhookPending
+			next = next.Add(Backoff(d.Attempts + 1))
+			if d.Attempts+1 >= MaxAttempts {
+				status = repository.WebhookDead
+			}
+		}
+		if err = w.store.RecordWebhookAttempt(ctx, attempt, status, next); err != nil {
+			return 0, err
+		}
+	}
+	return len(deliveries), nil
+}
+
+func (w *Worker) send(ctx context.Context, d repository.WebhookDelivery) repository.WebhookAttempt {
+	attempt := repository.WebhookAttempt{DeliveryId: d.Id}
+	started := time.Now()
+	statusCode, err := w.post(ctx, d)
+	attempt.DurationMs = int(time.Since(started).Milliseconds())
+	if statusCode != 0 {
+		attempt.StatusCode = &statusCode
+	}
+	if err != nil {
+		msg := err.Error()
+		attempt.Error = &msg
+	}
+	return attempt
+}
+
+func (w *Worker) post(ctx context.Context, d repository.WebhookDelivery) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, d.Url, bytes.NewReader(d.Payload))
+	if err != nil {
+		return 0, fmt.Errorf("err creating request: %w", err)
+	}
+	timestamp := time.Now().Unix()
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(HeaderDelivery, strconv.Itoa(d.Id))
+	req.Header.Set(HeaderEvent, d.EventType)
+	req.Header.Set(HeaderTimestamp, strconv.FormatInt(timestamp, 10))
+	req.Header.Set(HeaderSignature, Sign(d.Secret, timestamp, d.Payload))
+	res, err := w.client.Do(req)
+	if err != nil {
+		return 0, fmt.Errorf("err posting webhook: %w", err)
+	}
+	defer res.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(res.Body, 1<<16))
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		return res.StatusCode, fmt.Errorf("unexpected status code: %d", res.StatusCode)
+	}
+	return res.StatusCode, nil
+}
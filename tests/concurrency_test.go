@@ -0,0 +1,103 @@
+package tests
+
+import (
+	"context"
+	"sync"
+
+	"EWallet/pkg/repository"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+const concurrentWorkers = 20
+
+func (s *IntegrationTestSuite) TestConcurrentOppositeTransfers() {
+	ctx := context.Background()
+	first, err := s.store.CreateWallet(ctx, repository.Wallet{Owner: "test1", Balance: 1000})
+	require.NoError(s.T(), err)
+	second, err := s.store.CreateWallet(ctx, repository.Wallet{Owner: "test2", Balance: 1000})
+	require.NoError(s.T(), err)
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 2*concurrentWorkers)
+	for i := 0; i < concurrentWorkers; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			errs <- s.store.Transfer(ctx, first, &repository.FinRequest{Sum: 10, WalletTarget: second, UUID: uuid.New().String()})
+		}()
+		go func() {
+			defer wg.Done()
+			errs <- s.store.Transfer(ctx, second, &repository.FinRequest{Sum: 10, WalletTarget: first, UUID: uuid.New().String()})
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err = range errs {
+		require.NoError(s.T(), err)
+	}
+
+	wallet, err := s.store.GetWallet(ctx, first)
+	require.NoError(s.T(), err)
+	require.Equal(s.T(), 1000.0, wallet.Balance)
+	wallet, err = s.store.GetWallet(ctx, second)
+	require.NoError(s.T(), err)
+	require.Equal(s.T(), 1000.0, wallet.Balance)
+}
+
+func (s *IntegrationTestSuite) TestConcurrentDepositsNoLostUpdates() {
+	ctx := context.Background()
+	id, err := s.store.CreateWallet(ctx, repository.Wallet{Owner: "test1", Balance: 0})
+	require.NoError(s.T(), err)
+
+	var wg sync.WaitGroup
+	errs := make(chan error, concurrentWorkers)
+	for i := 0; i < concurrentWorkers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs <- s.store.Deposit(ctx, id, &repository.FinRequest{Sum: 1, UUID: uuid.New().String()})
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err = range errs {
+		require.NoError(s.T(), err)
+	}
+
+	wallet, err := s.store.GetWallet(ctx, id)
+	require.NoError(s.T(), err)
+	require.Equal(s.T(), float64(concurrentWorkers), wallet.Balance)
+}
+
+func (s *IntegrationTestSuite) TestConcurrentWithdrawalsNeverOverdraw() {
+	ctx := context.Background()
+	id, err := s.store.CreateWallet(ctx, repository.Wallet{Owner: "test1", Balance: 100})
+	require.NoError(s.T(), err)
+
+	var wg sync.WaitGroup
+	errs := make(chan error, concurrentWorkers)
+	for i := 0; i < concurrentWorkers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs <- s.store.Withdrawal(ctx, id, &repository.FinRequest{Sum: 10, UUID: uuid.New().String()})
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	succeeded := 0
+	for err = range errs {
+		if err == nil {
+			succeeded++
+			continue
+		}
+		require.ErrorIs(s.T(), err, repository.ErrInsufficientFunds)
+	}
+	require.Equal(s.T(), 10, succeeded)
+
+	wallet, err := s.store.GetWallet(ctx, id)
+	require.NoError(s.T(), err)
+	require.Equal(s.T(), 0.0, wallet.Balance)
+}
@@ -0,0 +1,123 @@
+package tests
+
+import (
+	"flag"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"EWallet/pkg/config"
+
+	"github.com/stretchr/testify/require"
+)
+
+func loadConfig(args []string, env map[string]string) (config.Config, error) {
+	flags := flag.NewFlagSet("ewallet", flag.ContinueOnError)
+	flags.SetOutput(io.Discard)
+	return config.Load(flags, args, func(name string) string { return env[name] })
+}
+
+func TestConfigPrecedence(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "config.yaml")
+	require.NoError(t, os.WriteFile(file, []byte(`
+http:
+  addr: ":4000"
+  idle_timeout: 1m
+db:
+  dsn: postgres://file
+  max_open_conns: 5
+  max_idle_conns: 5
+auth:
+  jwt_secret: from-file
+  admins: [root]
+statements:
+  signing_key: from-file
+log:
+  level: debug
+`), 0o600))
+
+	// the file overrides the defaults, the environment the file and the flags the environment
+	cfg, err := loadConfig([]string{"-http.addr", ":6000", "-features.grpc=false", "-jobs.concurrency", "8", "-tracing.sample-ratio", "0.25"}, map[string]string{
+		"CONFIG_FILE": file,
+		"HTTP_ADDR":   ":5000",
+		"PG_DSN":      "postgres://env",
+		"ADMIN_USERS": "alice, bob",
+	})
+	require.NoError(t, err)
+	require.Equal(t, ":6000", cfg.HTTP.Addr)
+	require.Equal(t, time.Minute, cfg.HTTP.IdleTimeout)
+	require.Equal(t, 5*time.Second, cfg.HTTP.ReadHeaderTimeout)
+	require.Equal(t, "postgres://env", cfg.DB.DSN)
+	require.Equal(t, 5, cfg.DB.MaxOpenConns)
+	require.Equal(t, "from-file", cfg.Auth.JWTSecret)
+	require.Equal(t, []string{"alice", "bob"}, cfg.Auth.Admins)
+	require.Equal(t, "debug", cfg.Log.Level)
+	require.Equal(t, 8, cfg.Jobs.Concurrency)
+	require.Equal(t, 7*24*time.Hour, cfg.Jobs.Retention)
+	require.Equal(t, 0.25, cfg.Tracing.SampleRatio)
+	require.False(t, cfg.Features.GRPC)
+	require.True(t, cfg.Features.Webhooks)
+
+	// -config wins over CONFIG_FILE, unknown keys in the file are errors
+	bad := filepath.Join(t.TempDir(), "bad.yaml")
+	require.NoError(t, os.WriteFile(bad, []byte("http:\n  adress: \":4000\"\n"), 0o600))
+	_, err = loadConfig([]string{"-config", bad}, map[string]string{"CONFIG_FILE": file})
+	require.ErrorContains(t, err, "adress")
+
+	_, err = loadConfig([]string{"-http.idle-timeout", "soon"}, map[string]string{"PG_DSN": "x", "SECRET_JWT": "s"})
+	require.ErrorContains(t, err, "-http.idle-timeout")
+	_, err = loadConfig(nil, map[string]string{"PG_DSN": "x", "SECRET_JWT": "s", "DB_MAX_OPEN_CONNS": "many"})
+	require.ErrorContains(t, err, "DB_MAX_OPEN_CONNS")
+}
+
+func TestConfigValidate(t *testing.T) {
+	_, err := loadConfig(nil, nil)
+	require.EqualError(t, err, "invalid config: db.dsn: is required; auth.jwt_secret: is required; "+
+		"statements.signing_key: is required for the monthly statements")
+	_, err = loadConfig([]string{"-features.monthly-statements=false"}, map[string]string{"PG_DSN": "x", "SECRET_JWT": "s"})
+	require.NoError(t, err)
+
+	cfg := config.Default()
+	cfg.DB.DSN, cfg.Auth.JWTSecret, cfg.Statements.SigningKey = "postgres://localhost/db", "secret", "statementkey"
+	require.NoError(t, cfg.Validate())
+	cfg.HTTP.Addr = "3000"
+	cfg.DB.MaxIdleConns = 50
+	cfg.Exchange.Host = "api.example.com"
+	cfg.Wallet.Currency = "rubles"
+	cfg.Log.Level = "loud"
+	cfg.Log.Format = "xml"
+	cfg.GRPC.APIKeys = "alice"
+	cfg.Events.Sink = "kafka://events"
+	cfg.Tracing.Exporter, cfg.Tracing.Endpoint, cfg.Tracing.SampleRatio = "otlp", "collector", 2
+	err = cfg.Validate()
+	for _, setting := range []string{"http.addr", "db.max_idle_conns", "exchange.host", "wallet.currency", "log.level", "log.format", "grpc.api_keys",
+		"events.sink", "tracing.endpoint", "tracing.sample_ratio"} {
+		require.ErrorContains(t, err, setting+":")
+	}
+
+	// the gRPC settings are not checked when it is switched off
+	cfg = config.Default()
+	cfg.DB.DSN, cfg.Auth.JWTSecret, cfg.Statements.SigningKey = "postgres://localhost/db", "secret", "statementkey"
+	cfg.Features.GRPC, cfg.GRPC.Addr = false, ""
+	require.NoError(t, cfg.Validate())
+}
+
+func TestConfigRedacted(t *testing.T) {
+	cfg := config.Default()
+	cfg.DB.DSN = "postgres://wallet:hunter2@db:5432/wallet"
+	cfg.Auth.JWTSecret = "jwt-secret"
+	cfg.Exchange.APIKey = "exchange-key"
+	cfg.GRPC.APIKeys = "alice:grpc-key"
+	out := cfg.Redacted()
+	for _, secret := range []string{"hunter2", "jwt-secret", "exchange-key", "grpc-key"} {
+		require.NotContains(t, out, secret)
+	}
+	require.Contains(t, out, "dsn: postgres://wallet:xxxxx@db:5432/wallet")
+	require.Contains(t, out, "signing_key: \"\"")
+	require.Equal(t, "jwt-secret", cfg.Auth.JWTSecret, "the config itself is not changed")
+
+	cfg.DB.DSN = "host=db user=wallet password=hunter2 dbname=wallet"
+	require.Contains(t, cfg.Redacted(), "dsn: host=db user=wallet password=xxxxx dbname=wallet")
+}
@@ -0,0 +1,63 @@
+package tests
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"EWallet/internal/rest"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/require"
+)
+
+func TestProblemDetails(t *testing.T) {
+	router := rest.NewRouter(logrus.New(), nil, "testsecret")
+	jwtToken, err := router.GenToken("aspan")
+	require.NoError(t, err)
+	addr := freePort(t)
+	go func() {
+		_ = router.Run(context.Background(), addr)
+	}()
+	time.Sleep(100 * time.Millisecond)
+
+	for _, tc := range []struct {
+		name, method, path, token, body string
+		status                          int
+		code, field                     string
+	}{
+		{"no token", http.MethodGet, "/api/v1/wallet/1", "", "", http.StatusUnauthorized, rest.CodeUnauthorized, ""},
+		{"bad token", http.MethodGet, "/api/v1/wallet/1", "garbage", "", http.StatusUnauthorized, rest.CodeUnauthorized, ""},
+		{"bad id", http.MethodGet, "/api/v1/wallet/abc", jwtToken, "", http.StatusBadRequest, rest.CodeValidationFailed, "id"},
+		{"bad sum", http.MethodPut, "/api/v1/wallet/1/deposit", jwtToken, `{"sum": "ten"}`, http.StatusBadRequest, rest.CodeValidationFailed, "sum"},
+		{"bad query", http.MethodGet, "/api/v1/wallet/1/transactions?limit=many", jwtToken, "", http.StatusBadRequest, rest.CodeValidationFailed, "limit"},
+		{"offset paging", http.MethodGet, "/api/v1/wallet/1/transactions?offset=20", jwtToken, "", http.StatusBadRequest, rest.CodeValidationFailed, "offset"},
+		{"not admin", http.MethodGet, "/api/v1/admin/reviews", jwtToken, "", http.StatusForbidden, rest.CodeForbidden, ""},
+		{"unknown route", http.MethodGet, "/api/v2/wallet", jwtToken, "", http.StatusNotFound, rest.CodeNotFound, ""},
+	} {
+		req, err := http.NewRequest(tc.method, "http://"+addr+tc.path, bytes.NewBufferString(tc.body))
+		require.NoError(t, err)
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-Request-ID", "req-"+tc.name)
+		if tc.token != "" {
+			req.Header.Set("Authorization", "Bearer "+tc.token)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		var problem rest.Problem
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&problem), tc.name)
+		require.NoError(t, resp.Body.Close())
+		require.Equal(t, tc.status, resp.StatusCode, tc.name)
+		require.Equal(t, "application/problem+json", resp.Header.Get("Content-Type"), tc.name)
+		require.Equal(t, tc.status, problem.Status, tc.name)
+		require.Equal(t, tc.code, problem.Code, tc.name)
+		require.Equal(t, "req-"+tc.name, problem.RequestID, tc.name)
+		if tc.field != "" {
+			require.Len(t, problem.Errors, 1, tc.name)
+			require.Equal(t, tc.field, problem.Errors[0].Field, tc.name)
+		}
+	}
+}
@@ -0,0 +1,131 @@
+package tests
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"testing"
+
+	"EWallet/pkg/events"
+	"EWallet/pkg/repository"
+
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/stretchr/testify/require"
+)
+
+type MockPublisher struct {
+	topics []string
+	keys   []string
+}
+
+func (m *MockPublisher) Publish(ctx context.Context, topic string, key, value []byte) error {
+	m.topics = append(m.topics, topic)
+	m.keys = append(m.keys, string(key))
+	return nil
+}
+
+func TestWriterSink(t *testing.T) {
+	var buf bytes.Buffer
+	sink := events.NewWriterSink(&buf)
+	err := sink.Publish(context.Background(), []events.Event{
+		{Id: 1, Type: events.WalletCreated, WalletId: 7, Payload: json.RawMessage(`{"wallet_id":7}`)},
+		{Id: 2, Type: events.Deposited, WalletId: 7, Payload: json.RawMessage(`{"sum":10}`)},
+	})
+	require.NoError(t, err)
+	dec := json.NewDecoder(&buf)
+	var e events.Event
+	require.NoError(t, dec.Decode(&e))
+	require.Equal(t, events.WalletCreated, e.Type)
+	require.NoError(t, dec.Decode(&e))
+	require.Equal(t, events.Deposited, e.Type)
+}
+
+func TestBrokerSink(t *testing.T) {
+	publisher := &MockPublisher{}
+	sink := events.NewBrokerSink(publisher, "ewallet")
+	err := sink.Publish(context.Background(), []events.Event{
+		{Id: 1, Type: events.Transferred, WalletId: 3, Payload: json.RawMessage(`{}`)},
+	})
+	require.NoError(t, err)
+	require.Equal(t, []string{"ewallet.Transferred"}, publisher.topics)
+	require.Equal(t, []string{"3"}, publisher.keys)
+}
+
+func (s *IntegrationTestSuite) TestOutboxEvents() {
+	ctx := context.Background()
+	id, err := s.store.CreateWallet(ctx, repository.Wallet{Owner: "test1", Balance: 100})
+	require.NoError(s.T(), err)
+	err = s.store.Deposit(ctx, id, &repository.FinRequest{Sum: 50, UUID: uuid.New().String()})
+	require.NoError(s.T(), err)
+	err = s.store.Withdrawal(ctx, id, &repository.FinRequest{Sum: 500, UUID: uuid.New().String()})
+	require.ErrorIs(s.T(), err, repository.ErrInsufficientFunds)
+	require.NoError(s.T(), s.store.Freeze(ctx, id))
+	require.NoError(s.T(), s.store.DeleteWallet(ctx, id))
+
+	var types []string
+	publish := func(ctx context.Context, batch []events.Event) error {
+		for _, e := range batch {
+			if e.WalletId == id {
+				types = append(types, e.Type)
+			}
+		}
+		return nil
+	}
+	for {
+		cnt, err := s.store.DispatchEvents(ctx, 100, publish)
+		require.NoError(s.T(), err)
+		if cnt == 0 {
+			break
+		}
+	}
+	require.Equal(s.T(), []string{events.WalletCreated, events.Deposited, events.Deposited, events.WalletFrozen, events.WalletDeleted}, types)
+}
+
+func (s *IntegrationTestSuite) TestOutboxCommitOrder() {
+	ctx := context.Background()
+	id, err := s.store.CreateWallet(ctx, repository.Wallet{Owner: "test1", Balance: 100})
+	require.NoError(s.T(), err)
+	target, err := s.store.CreateWallet(ctx, repository.Wallet{Owner: "test2", Balance: 0})
+	require.NoError(s.T(), err)
+	var published []events.Event
+	dispatch := func() {
+		for {
+			cnt, err := s.store.DispatchEvents(ctx, 100, func(ctx context.Context, batch []events.Event) error {
+				published = append(published, batch...)
+				return nil
+			})
+			require.NoError(s.T(), err)
+			if cnt == 0 {
+				return
+			}
+		}
+	}
+	dispatch()
+
+	// an event written first and committed last is still published first
+	db, err := sqlx.Open("pgx", pgDSN)
+	require.NoError(s.T(), err)
+	defer db.Close()
+	tx, err := db.BeginTxx(ctx, nil)
+	require.NoError(s.T(), err)
+	_, err = tx.ExecContext(ctx, `INSERT INTO outbox (type, wallet_id, payload) VALUES ('Slow', $1, '{}')`, id)
+	require.NoError(s.T(), err)
+	err = s.store.Transfer(ctx, id, &repository.FinRequest{Sum: 10, WalletTarget: target, UUID: uuid.New().String()})
+	require.NoError(s.T(), err)
+	published = nil
+	dispatch()
+	require.Empty(s.T(), published, "events after a running transaction wait for it")
+	require.NoError(s.T(), tx.Commit())
+	dispatch()
+	require.Len(s.T(), published, 2)
+	require.Equal(s.T(), "Slow", published[0].Type)
+	require.Equal(s.T(), events.Transferred, published[1].Type)
+	require.Equal(s.T(), target, *published[1].TargetId)
+
+	// the transfer is an event of the target wallet too
+	batch, err := s.store.GetWalletEvents(ctx, target, published[0].Id, 10)
+	require.NoError(s.T(), err)
+	require.Len(s.T(), batch, 1)
+	require.Equal(s.T(), events.Transferred, batch[0].Type)
+}
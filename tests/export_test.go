@@ -0,0 +1,227 @@
+package tests
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/xml"
+	"errors"
+	"io"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"EWallet/pkg/export"
+	"EWallet/pkg/repository"
+
+	"github.com/stretchr/testify/require"
+)
+
+func testStatement() (repository.Statement, []repository.StatementEntry) {
+	other := 7
+	at := time.Date(2024, 10, 5, 10, 0, 0, 0, time.UTC)
+	return repository.Statement{
+		WalletId:       3,
+		Owner:          "aspan & co",
+		Currency:       repository.DefaultCurrency,
+		From:           time.Date(2024, 10, 1, 0, 0, 0, 0, time.UTC),
+		To:             time.Date(2024, 11, 1, 0, 0, 0, 0, time.UTC),
+		OpeningBalance: 100,
+		TotalCredits:   50.25,
+		TotalDebits:    20,
+		ClosingBalance: 130.25,
+	}, []repository.StatementEntry{
+		{TransactionId: 1, UUID: "u1", Operation: "deposit", Status: repository.StatusCompleted, Date: at, Amount: 50.25, Balance: 150.25},
+		{TransactionId: 2, UUID: "u2", Operation: "transfer", Status: repository.StatusCompleted, Counterparty: &other, Date: at.Add(time.Hour), Amount: -20, Balance: 130.25},
+	}
+}
+
+func writeStatement(t *testing.T, w export.Writer) {
+	t.Helper()
+	statement, entries := testStatement()
+	require.NoError(t, w.Begin(statement))
+	for _, e := range entries {
+		require.NoError(t, w.Entry(e))
+	}
+	require.NoError(t, w.End())
+}
+
+func TestExportNegotiate(t *testing.T) {
+	for _, tc := range []struct {
+		name, accept, format string
+	}{
+		{"", "", export.FormatCSV},
+		{"", "*/*", export.FormatCSV},
+		{"", "application/x-ofx", export.FormatOFX},
+		{"", "text/html, application/xml;q=0.9", export.FormatCamt053},
+		{"ofx", "text/csv", export.FormatOFX},
+		{"", "application/pdf", export.FormatPDF},
+	} {
+		format, err := export.Negotiate(tc.name, tc.accept)
+		require.NoError(t, err)
+		require.Equal(t, tc.format, format, tc.accept)
+	}
+	_, err := export.Negotiate("docx", "")
+	require.True(t, errors.Is(err, export.ErrUnknownFormat))
+}
+
+func TestExportCSV(t *testing.T) {
+	var buf bytes.Buffer
+	w, err := export.NewCSV(&buf, nil)
+	require.NoError(t, err)
+	writeStatement(t, w)
+	records, err := csv.NewReader(&buf).ReadAll()
+	require.NoError(t, err)
+	require.Equal(t, [][]string{
+		export.Columns,
+		{"2024-10-05T10:00:00Z", "1", "u1", "deposit", "completed", "", "50.25", "150.25"},
+		{"2024-10-05T11:00:00Z", "2", "u2", "transfer", "completed", "7", "-20.00", "130.25"},
+	}, records)
+
+	buf.Reset()
+	w, err = export.NewCSV(&buf, []string{"amount", "uuid"})
+	require.NoError(t, err)
+	writeStatement(t, w)
+	require.Equal(t, "amount,uuid\n50.25,u1\n-20.00,u2\n", buf.String())
+
+	_, err = export.NewCSV(&buf, []string{"amount", "secret"})
+	require.True(t, errors.Is(err, export.ErrUnknownColumn))
+}
+
+func TestExportOFX(t *testing.T) {
+	var buf bytes.Buffer
+	writeStatement(t, export.NewOFX(&buf))
+	require.True(t, strings.HasPrefix(buf.String(), `<?xml version="1.0" encoding="UTF-8"?>`+"\n"+`<?OFX OFXHEADER="200"`))
+
+	var doc struct {
+		Currency     string `xml:"BANKMSGSRSV1>STMTTRNRS>STMTRS>CURDEF"`
+		AcctID       string `xml:"BANKMSGSRSV1>STMTTRNRS>STMTRS>BANKACCTFROM>ACCTID"`
+		Start        string `xml:"BANKMSGSRSV1>STMTTRNRS>STMTRS>BANKTRANLIST>DTSTART"`
+		Transactions []struct {
+			Type   string `xml:"TRNTYPE"`
+			Amount string `xml:"TRNAMT"`
+			FitID  string `xml:"FITID"`
+		} `xml:"BANKMSGSRSV1>STMTTRNRS>STMTRS>BANKTRANLIST>STMTTRN"`
+		Balance string `xml:"BANKMSGSRSV1>STMTTRNRS>STMTRS>LEDGERBAL>BALAMT"`
+	}
+	require.NoError(t, xml.Unmarshal(buf.Bytes(), &doc))
+	require.Equal(t, "RUB", doc.Currency)
+	require.Equal(t, "3", doc.AcctID)
+	require.Equal(t, "20241001000000", doc.Start)
+	require.Len(t, doc.Transactions, 2)
+	require.Equal(t, "CREDIT", doc.Transactions[0].Type)
+	require.Equal(t, "XFER", doc.Transactions[1].Type)
+	require.Equal(t, "-20.00", doc.Transactions[1].Amount)
+	require.Equal(t, "2-completed", doc.Transactions[1].FitID)
+	require.Equal(t, "130.25", doc.Balance)
+}
+
+func TestExportCamt053(t *testing.T) {
+	var buf bytes.Buffer
+	writeStatement(t, export.NewCamt053(&buf))
+
+	var doc struct {
+		XMLName xml.Name
+		Owner   string `xml:"BkToCstmrStmt>Stmt>Acct>Ownr>Nm"`
+		Bal     []struct {
+			Code      string `xml:"Tp>CdOrPrtry>Cd"`
+			Amt       string `xml:"Amt"`
+			CdtDbtInd string `xml:"CdtDbtInd"`
+		} `xml:"BkToCstmrStmt>Stmt>Bal"`
+		Entries []struct {
+			Amt struct {
+				Value string `xml:",chardata"`
+				Ccy   string `xml:"Ccy,attr"`
+			} `xml:"Amt"`
+			CdtDbtInd  string `xml:"CdtDbtInd"`
+			EndToEndId string `xml:"NtryDtls>TxDtls>Refs>EndToEndId"`
+		} `xml:"BkToCstmrStmt>Stmt>Ntry"`
+	}
+	require.NoError(t, xml.Unmarshal(buf.Bytes(), &doc))
+	require.Equal(t, "urn:iso:std:iso:20022:tech:xsd:camt.053.001.02", doc.XMLName.Space)
+	require.Equal(t, "aspan & co", doc.Owner)
+	require.Len(t, doc.Bal, 2)
+	require.Equal(t, "OPBD", doc.Bal[0].Code)
+	require.Equal(t, "100.00", doc.Bal[0].Amt)
+	require.Equal(t, "CLBD", doc.Bal[1].Code)
+	require.Equal(t, "130.25", doc.Bal[1].Amt)
+	require.Len(t, doc.Entries, 2)
+	require.Equal(t, "CRDT", doc.Entries[0].CdtDbtInd)
+	require.Equal(t, "DBIT", doc.Entries[1].CdtDbtInd)
+	require.Equal(t, "20.00", doc.Entries[1].Amt.Value)
+	require.Equal(t, "RUB", doc.Entries[1].Amt.Ccy)
+	require.Equal(t, "u2", doc.Entries[1].EndToEndId)
+}
+
+func TestExportPDF(t *testing.T) {
+	key := []byte("statementkey")
+	var buf bytes.Buffer
+	w := export.NewPDF(&buf, key)
+	writeStatement(t, w)
+	require.True(t, bytes.HasPrefix(buf.Bytes(), []byte("%PDF-")))
+	require.True(t, bytes.HasSuffix(bytes.TrimSpace(buf.Bytes()), []byte("%%EOF")))
+
+	statement, entries := testStatement()
+	statement.Entries = entries
+	require.Equal(t, export.Sign(key, statement), w.Signature())
+	require.Len(t, w.Signature(), 64)
+	// every printed field is signed
+	for name, edit := range map[string]func(s *repository.Statement){
+		"owner":        func(s *repository.Statement) { s.Owner += "x" },
+		"credits":      func(s *repository.Statement) { s.TotalCredits++ },
+		"debits":       func(s *repository.Statement) { s.TotalDebits++ },
+		"amount":       func(s *repository.Statement) { s.Entries[1].Amount = -21 },
+		"operation":    func(s *repository.Statement) { s.Entries[0].Operation = "transfer" },
+		"reference":    func(s *repository.Statement) { s.Entries[0].UUID += "x" },
+		"counterparty": func(s *repository.Statement) { s.Entries[1].Counterparty = nil },
+	} {
+		changed, _ := testStatement()
+		changed.Entries = append([]repository.StatementEntry(nil), entries...)
+		edit(&changed)
+		require.NotEqual(t, export.Sign(key, changed), w.Signature(), name)
+	}
+
+	// non-latin owners are rendered with the embedded font
+	buf.Reset()
+	unsigned := export.NewPDF(&buf, nil)
+	statement.Owner = "Аспандияр"
+	require.NoError(t, unsigned.Begin(statement))
+	require.NoError(t, unsigned.End())
+	require.Empty(t, unsigned.Signature())
+	require.True(t, bytes.HasPrefix(buf.Bytes(), []byte("%PDF-")))
+}
+
+// TestExportStreams checks that entries reach the output long before the export ends.
+func TestExportStreams(t *testing.T) {
+	for _, format := range []string{export.FormatCSV, export.FormatOFX, export.FormatCamt053} {
+		pr, pw := io.Pipe()
+		w, err := export.New(format, pw, nil, nil)
+		require.NoError(t, err)
+		statement, entries := testStatement()
+		var ended int32
+		done := make(chan error, 1)
+		go func() {
+			defer pw.Close()
+			err := w.Begin(statement)
+			for i := 0; i < 1000 && err == nil; i++ {
+				err = w.Entry(entries[0])
+			}
+			atomic.StoreInt32(&ended, 1)
+			if err == nil {
+				err = w.End()
+			}
+			done <- err
+		}()
+		var read []byte
+		buf := make([]byte, 512)
+		for !strings.Contains(string(read), "u1") {
+			n, err := pr.Read(buf)
+			require.NoError(t, err, format)
+			read = append(read, buf[:n]...)
+		}
+		require.Equal(t, int32(0), atomic.LoadInt32(&ended), format)
+		_, err = io.Copy(io.Discard, pr)
+		require.NoError(t, err)
+		require.NoError(t, <-done, format)
+	}
+}
@@ -0,0 +1,310 @@
+package tests
+
+import (
+	"context"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"EWallet/internal/rest"
+	"EWallet/internal/rpc"
+	"EWallet/pkg/logger"
+	"EWallet/pkg/models"
+	"EWallet/pkg/pb"
+	"EWallet/pkg/repository"
+	"EWallet/pkg/screening"
+
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// MockApp serves the gRPC API without a database, failing operations with err. Wallets belong to
+// owner, or to the caller when it is empty. requestID is the request ID GetWallet was last called with.
+type MockApp struct {
+	err        error
+	owner      string
+	operations int
+	requestID  string
+	mu         sync.Mutex
+	keys       map[string]*repository.IdempotencyKey
+}
+
+func (m *MockApp) CreateWallet(ctx context.Context, wallet repository.Wallet) (int, error) {
+	return 1, m.err
+}
+
+func (m *MockApp) GetWallet(ctx context.Context, id int, currency string) (repository.Wallet, error) {
+	m.mu.Lock()
+	m.requestID = logger.RequestID(ctx)
+	m.mu.Unlock()
+	owner := m.owner
+	if owner == "" {
+		owner = rpc.Username(ctx)
+	}
+	return repository.Wallet{Owner: owner, Balance: 100}, nil
+}
+
+func (m *MockApp) Deposit(ctx context.Context, id int, request *repository.FinRequest) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.operations++
+	return m.err
+}
+
+func (m *MockApp) Withdrawal(ctx context.Context, id int, request *repository.FinRequest) error {
+	return m.err
+}
+
+func (m *MockApp) Transfer(ctx context.Context, id int, request *repository.FinRequest) error {
+	return m.err
+}
+
+func (m *MockApp) GetTransactions(ctx context.Context, id int, params *models.TransactionQueryParams) (repository.TransactionPage, error) {
+	if params.Cursor == "" {
+		return repository.TransactionPage{
+			Transactions: []repository.Transaction{{Id: 1, FromId: id, Operation: "deposit"}, {Id: 2, FromId: id, Operation: "withdraw"}},
+			Total:        3,
+			NextCursor:   "next",
+		}, m.err
+	}
+	return repository.TransactionPage{Transactions: []repository.Transaction{{Id: 3, FromId: id, Operation: "deposit"}}, Total: 3}, m.err
+}
+
+func (m *MockApp) Freeze(ctx context.Context, id int) error {
+	return m.err
+}
+
+func (m *MockApp) BeginIdempotent(ctx context.Context, scope repository.IdempotencyScope, key, fingerprint string) (*repository.IdempotencyKey, string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.keys == nil {
+		m.keys = make(map[string]*repository.IdempotencyKey)
+	}
+	record, ok := m.keys[key]
+	switch {
+	case !ok:
+		m.keys[key] = &repository.IdempotencyKey{Key: key, Fingerprint: fingerprint}
+		return nil, "token", nil
+	case record.Fingerprint != fingerprint:
+		return nil, "", repository.ErrIdempotencyMismatch
+	case record.StatusCode == nil:
+		return nil, "", repository.ErrIdempotencyInProgress
+	}
+	return record, "", nil
+}
+
+func (m *MockApp) FinishIdempotent(ctx context.Context, scope repository.IdempotencyScope, key, token string, statusCode int, response []byte) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if statusCode >= 200 && statusCode < 300 {
+		m.keys[key].StatusCode, m.keys[key].Response = &statusCode, response
+		return nil
+	}
+	delete(m.keys, key)
+	return nil
+}
+
+func freePort(t *testing.T) string {
+	t.Helper()
+	lis, err := net.Listen("tcp", "localhost:0")
+	require.NoError(t, err)
+	addr := lis.Addr().String()
+	require.NoError(t, lis.Close())
+	return addr
+}
+
+func startGRPC(t *testing.T, app rpc.App) pb.EWalletClient {
+	t.Helper()
+	return startGRPCLogged(t, logrus.New(), app)
+}
+
+// startGRPCLogged starts the server with log.
+func startGRPCLogged(t *testing.T, log *logrus.Logger, app rpc.App) pb.EWalletClient {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	addr := freePort(t)
+	go func() {
+		_ = rpc.NewServer(log, app, "testsecret", map[string]string{"service-key": "billing"}).Run(ctx, addr)
+	}()
+	conn, err := grpc.DialContext(ctx, addr, grpc.WithTransportCredentials(insecure.NewCredentials()), grpc.WithBlock())
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = conn.Close() })
+	return pb.NewEWalletClient(conn)
+}
+
+func TestGRPCAuth(t *testing.T) {
+	client := startGRPC(t, &MockApp{})
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	_, err := client.GetWallet(ctx, &pb.GetWalletRequest{Id: 1})
+	require.Equal(t, codes.Unauthenticated, status.Code(err))
+	_, err = client.GetWallet(metadata.AppendToOutgoingContext(ctx, "x-api-key", "wrong"), &pb.GetWalletRequest{Id: 1})
+	require.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	w, err := client.GetWallet(metadata.AppendToOutgoingContext(ctx, "x-api-key", "service-key"), &pb.GetWalletRequest{Id: 1})
+	require.NoError(t, err)
+	require.Equal(t, "billing", w.Owner)
+	jwtToken, err := rest.NewRouter(logrus.New(), nil, "testsecret").GenToken("aspan")
+	require.NoError(t, err)
+	w, err = client.GetWallet(metadata.AppendToOutgoingContext(ctx, "authorization", "Bearer "+jwtToken), &pb.GetWalletRequest{Id: 1})
+	require.NoError(t, err)
+	require.Equal(t, "aspan", w.Owner)
+
+	stream, err := client.GetTransactions(metadata.AppendToOutgoingContext(ctx, "x-api-key", "service-key"), &pb.GetTransactionsRequest{WalletId: 3})
+	require.NoError(t, err)
+	var cnt int
+	for {
+		_, err = stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		cnt++
+	}
+	require.Equal(t, 3, cnt)
+}
+
+func TestGRPCErrorCodes(t *testing.T) {
+	for _, tc := range []struct {
+		err  error
+		code codes.Code
+	}{
+		{err: repository.ErrWalletNotFound, code: codes.NotFound},
+		{err: repository.ErrWalletTargetNotFound, code: codes.NotFound},
+		{err: repository.ErrInsufficientFunds, code: codes.FailedPrecondition},
+		{err: repository.ErrWalletFrozen, code: codes.FailedPrecondition},
+		{err: repository.ErrDuplicateKey, code: codes.AlreadyExists},
+		{err: screening.ErrTransactionDenied, code: codes.PermissionDenied},
+		{err: io.ErrUnexpectedEOF, code: codes.Internal},
+	} {
+		client := startGRPC(t, &MockApp{err: tc.err})
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		ctx = metadata.AppendToOutgoingContext(ctx, "x-api-key", "service-key")
+		_, err := client.Transfer(ctx, &pb.OperationRequest{WalletId: 1, WalletTarget: 2, Sum: 10, Uuid: uuid.New().String()})
+		require.Equal(t, tc.code, status.Code(err), tc.err.Error())
+		cancel()
+	}
+
+	client := startGRPC(t, &MockApp{err: screening.ErrPendingReview})
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	ctx = metadata.AppendToOutgoingContext(ctx, "x-api-key", "service-key")
+	res, err := client.Deposit(ctx, &pb.OperationRequest{WalletId: 1, Sum: 10, Uuid: uuid.New().String()})
+	require.NoError(t, err)
+	require.Equal(t, pb.OperationResponse_STATUS_PENDING_REVIEW, res.Status)
+	_, err = client.Deposit(ctx, &pb.OperationRequest{WalletId: 1, Sum: 10, Uuid: "bad"})
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+}
+
+func TestGRPCWalletOwner(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	ctx = metadata.AppendToOutgoingContext(ctx, "x-api-key", "service-key")
+	client := startGRPC(t, &MockApp{owner: "aspan"})
+	_, err := client.GetWallet(ctx, &pb.GetWalletRequest{Id: 1})
+	require.Equal(t, codes.PermissionDenied, status.Code(err))
+	_, err = client.Deposit(ctx, &pb.OperationRequest{WalletId: 1, Sum: 10, Uuid: uuid.New().String()})
+	require.Equal(t, codes.PermissionDenied, status.Code(err))
+	_, err = client.Freeze(ctx, &pb.FreezeRequest{Id: 1})
+	require.Equal(t, codes.PermissionDenied, status.Code(err))
+	stream, err := client.GetTransactions(ctx, &pb.GetTransactionsRequest{WalletId: 1})
+	require.NoError(t, err)
+	_, err = stream.Recv()
+	require.Equal(t, codes.PermissionDenied, status.Code(err))
+	_, err = client.CreateWallet(ctx, &pb.CreateWalletRequest{Owner: "aspan"})
+	require.Equal(t, codes.PermissionDenied, status.Code(err))
+	res, err := client.CreateWallet(ctx, &pb.CreateWalletRequest{Owner: "billing"})
+	require.NoError(t, err)
+	require.Equal(t, int64(1), res.Id)
+}
+
+func TestGRPCIdempotency(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	ctx = metadata.AppendToOutgoingContext(ctx, "x-api-key", "service-key")
+	app := &MockApp{}
+	client := startGRPC(t, app)
+	key := uuid.New().String()
+	for i := 0; i < 2; i++ {
+		var header metadata.MD
+		res, err := client.Deposit(ctx, &pb.OperationRequest{WalletId: 1, Sum: 10, Uuid: key}, grpc.Header(&header))
+		require.NoError(t, err)
+		require.Equal(t, pb.OperationResponse_STATUS_COMPLETED, res.Status)
+		require.Equal(t, i == 1, len(header.Get("idempotent-replayed")) > 0)
+	}
+	require.Equal(t, 1, app.operations, "the retry is replayed")
+	_, err := client.Deposit(ctx, &pb.OperationRequest{WalletId: 1, Sum: 20, Uuid: key})
+	require.Equal(t, codes.InvalidArgument, status.Code(err), "the key was used with another request")
+	_, err = client.Deposit(metadata.AppendToOutgoingContext(ctx, "idempotency-key", uuid.New().String()),
+		&pb.OperationRequest{WalletId: 1, Sum: 10, Uuid: key})
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	// a failed call frees its key
+	app.err = repository.ErrWalletFrozen
+	key = uuid.New().String()
+	_, err = client.Deposit(ctx, &pb.OperationRequest{WalletId: 1, Sum: 10, Uuid: key})
+	require.Equal(t, codes.FailedPrecondition, status.Code(err))
+	app.err = nil
+	res, err := client.Deposit(ctx, &pb.OperationRequest{WalletId: 1, Sum: 10, Uuid: key})
+	require.NoError(t, err)
+	require.Equal(t, pb.OperationResponse_STATUS_COMPLETED, res.Status)
+	require.Equal(t, 3, app.operations)
+}
+
+func TestGRPCRequestID(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	ctx = metadata.AppendToOutgoingContext(ctx, "x-api-key", "service-key")
+	app := &MockApp{}
+	client := startGRPC(t, app)
+
+	// the ID of the client is kept and reaches the App
+	var header metadata.MD
+	_, err := client.GetWallet(metadata.AppendToOutgoingContext(ctx, "x-request-id", "req-1"), &pb.GetWalletRequest{Id: 1}, grpc.Header(&header))
+	require.NoError(t, err)
+	require.Equal(t, []string{"req-1"}, header.Get("x-request-id"))
+	require.Equal(t, "req-1", app.requestID)
+
+	// a missing or unfit ID is replaced with a generated one
+	_, err = client.GetWallet(metadata.AppendToOutgoingContext(ctx, "x-request-id", strings.Repeat("x", 129)), &pb.GetWalletRequest{Id: 1}, grpc.Header(&header))
+	require.NoError(t, err)
+	_, err = uuid.Parse(header.Get("x-request-id")[0])
+	require.NoError(t, err)
+	require.Equal(t, header.Get("x-request-id")[0], app.requestID)
+
+	stream, err := client.GetTransactions(ctx, &pb.GetTransactionsRequest{WalletId: 1})
+	require.NoError(t, err)
+	header, err = stream.Header()
+	require.NoError(t, err)
+	require.Len(t, header.Get("x-request-id"), 1)
+	_, err = stream.Recv()
+	require.NoError(t, err)
+	require.Equal(t, header.Get("x-request-id")[0], app.requestID, "the stream authorizes with the ID of the call")
+}
+
+func TestGRPCRequestIDLogged(t *testing.T) {
+	log, err := logger.New("info", "json")
+	require.NoError(t, err)
+	out := &LogBuffer{}
+	log.SetOutput(out)
+	client := startGRPCLogged(t, log, &MockApp{err: io.ErrUnexpectedEOF})
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	ctx = metadata.AppendToOutgoingContext(ctx, "x-api-key", "service-key", "x-request-id", "req-failed")
+
+	_, err = client.Deposit(ctx, &pb.OperationRequest{WalletId: 1, Sum: 10, Uuid: uuid.New().String()})
+	require.Equal(t, codes.Internal, status.Code(err))
+	lines := out.lines("failed to execute operation: unexpected EOF")
+	require.Len(t, lines, 1)
+	require.Equal(t, "req-failed", lines[0]["request_id"], "the error is logged with the ID of the call")
+}
@@ -0,0 +1,133 @@
+package tests
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"EWallet/internal/rest"
+	"EWallet/pkg/health"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/require"
+)
+
+// FakeDependency is a dependency the checks report on, it fails with err.
+type FakeDependency struct {
+	mu  sync.Mutex
+	err error
+}
+
+func (d *FakeDependency) fail(err error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.err = err
+}
+
+func (d *FakeDependency) check(name string, critical bool) health.Check {
+	return health.Check{Name: name, Critical: critical, Run: func(ctx context.Context) (map[string]interface{}, error) {
+		d.mu.Lock()
+		defer d.mu.Unlock()
+		return map[string]interface{}{"name": name}, d.err
+	}}
+}
+
+func TestHealthChecker(t *testing.T) {
+	var db, exchange FakeDependency
+	var calls int32
+	cached := health.Check{Name: "cached", TTL: time.Hour, Run: func(ctx context.Context) (map[string]interface{}, error) {
+		atomic.AddInt32(&calls, 1)
+		return nil, nil
+	}}
+	slow := health.Check{Name: "slow", Run: func(ctx context.Context) (map[string]interface{}, error) {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}}
+	checker := health.NewChecker(logrus.New(), 50*time.Millisecond, db.check("db", true), exchange.check("exchange", false), cached, slow)
+
+	// a failing check that is not critical degrades the service
+	report := checker.Check(context.Background())
+	require.Equal(t, health.StatusDegraded, report.Status)
+	require.Equal(t, health.StatusUp, report.Checks["db"].Status)
+	require.Equal(t, "db", report.Checks["db"].Details["name"])
+	require.Equal(t, health.StatusDown, report.Checks["slow"].Status)
+	require.Equal(t, context.DeadlineExceeded.Error(), report.Checks["slow"].Error)
+
+	// a failing critical check makes it not ready
+	db.fail(errors.New("connection refused"))
+	exchange.fail(errors.New("timeout"))
+	report = checker.Check(context.Background())
+	require.Equal(t, health.StatusNotReady, report.Status)
+	require.Equal(t, "connection refused", report.Checks["db"].Error)
+	require.True(t, report.Checks["db"].Critical)
+	require.Equal(t, int32(1), atomic.LoadInt32(&calls), "the cached check runs once per TTL")
+}
+
+func TestReadiness(t *testing.T) {
+	var db, exchange FakeDependency
+	router := rest.NewRouter(logrus.New(), nil, "testsecret")
+	router.SetHealth(health.NewChecker(logrus.New(), time.Second, db.check("db", true), exchange.check("exchange", false)))
+	timeouts := rest.DefaultServerTimeouts
+	timeouts.ShutdownDelay = 300 * time.Millisecond
+	router.SetServerTimeouts(timeouts)
+	addr := freePort(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	stopped := make(chan error, 1)
+	go func() {
+		stopped <- router.Run(ctx, addr)
+	}()
+	time.Sleep(100 * time.Millisecond)
+	probe := func(path string) (int, health.Report) {
+		resp, err := http.Get("http://" + addr + path)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		var report health.Report
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&report))
+		return resp.StatusCode, report
+	}
+
+	code, report := probe("/healthz")
+	require.Equal(t, http.StatusOK, code)
+	require.Equal(t, "ok", report.Status)
+	code, report = probe("/readyz")
+	require.Equal(t, http.StatusOK, code)
+	require.Equal(t, health.StatusReady, report.Status)
+	require.Len(t, report.Checks, 2)
+
+	exchange.fail(errors.New("exchange api unreachable"))
+	code, report = probe("/readyz")
+	require.Equal(t, http.StatusOK, code)
+	require.Equal(t, health.StatusDegraded, report.Status)
+	require.Equal(t, "exchange api unreachable", report.Checks["exchange"].Error)
+
+	db.fail(errors.New("err pinging pg"))
+	code, report = probe("/readyz")
+	require.Equal(t, http.StatusServiceUnavailable, code)
+	require.Equal(t, health.StatusNotReady, report.Status)
+
+	// readiness fails as soon as the shutdown starts, while requests are still served
+	db.fail(nil)
+	exchange.fail(nil)
+	cancel()
+	time.Sleep(50 * time.Millisecond)
+	code, report = probe("/readyz")
+	require.Equal(t, http.StatusServiceUnavailable, code)
+	require.Equal(t, health.StatusShuttingDown, report.Status)
+	code, _ = probe("/healthz")
+	require.Equal(t, http.StatusOK, code)
+	require.NoError(t, <-stopped)
+}
+
+func (s *IntegrationTestSuite) TestPendingMigrations() {
+	ctx := context.Background()
+	require.NoError(s.T(), s.store.Ping(ctx))
+	pending, err := s.store.PendingMigrations(ctx)
+	require.NoError(s.T(), err)
+	require.Empty(s.T(), pending)
+}
@@ -0,0 +1,167 @@
+//nolint:bodyclose
+package tests
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"EWallet/pkg/repository"
+
+	"github.com/stretchr/testify/require"
+)
+
+func (s *IntegrationTestSuite) processIdempotentRequest(ctx context.Context, method, path, key string, body interface{}, response interface{}) *http.Response {
+	s.T().Helper()
+	requestBody, err := json.Marshal(body)
+	require.NoError(s.T(), err)
+	req, err := http.NewRequestWithContext(ctx, method, path, bytes.NewBuffer(requestBody))
+	require.NoError(s.T(), err)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Idempotency-Key", key)
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(s.T(), err)
+	defer func() {
+		require.NoError(s.T(), resp.Body.Close())
+	}()
+	if response != nil {
+		err = json.NewDecoder(resp.Body).Decode(response)
+		require.NoError(s.T(), err)
+	}
+	return resp
+}
+
+func (s *IntegrationTestSuite) TestDepoIdempotencyHeader() {
+	ctx := context.Background()
+	path := s.url + "/wallet"
+	wallet := repository.Wallet{
+		Owner:   "test1",
+		Balance: 1000,
+	}
+	var idMap map[string]int
+	resp := s.processRequest(ctx, http.MethodPost, path, wallet, &idMap)
+	require.Equal(s.T(), http.StatusCreated, resp.StatusCode)
+	id, ok := idMap["id"]
+	require.True(s.T(), ok)
+
+	key := "b2eb5a3b-d9d2-11ec-abbd-0242ac150001"
+	finreq := repository.FinRequest{Sum: 500.0}
+	resp = s.processIdempotentRequest(ctx, http.MethodPut, path+"/"+strconv.Itoa(id)+"/deposit", key, finreq, nil)
+	require.Equal(s.T(), http.StatusOK, resp.StatusCode)
+	resp = s.processIdempotentRequest(ctx, http.MethodPut, path+"/"+strconv.Itoa(id)+"/deposit", key, finreq, nil)
+	require.Equal(s.T(), http.StatusOK, resp.StatusCode)
+	require.Equal(s.T(), "true", resp.Header.Get("Idempotent-Replayed"))
+
+	finreq.Sum = 700.0
+	resp = s.processIdempotentRequest(ctx, http.MethodPut, path+"/"+strconv.Itoa(id)+"/deposit", key, finreq, nil)
+	require.Equal(s.T(), http.StatusUnprocessableEntity, resp.StatusCode)
+
+	var walletResp repository.Wallet
+	resp = s.processRequest(ctx, http.MethodGet, path+"/"+strconv.Itoa(id), nil, &walletResp)
+	require.Equal(s.T(), http.StatusOK, resp.StatusCode)
+	require.Equal(s.T(), 1500.0, walletResp.Balance)
+}
+
+func (s *IntegrationTestSuite) TestDepoIdempotencyHeaderMismatchUUID() {
+	ctx := context.Background()
+	path := s.url + "/wallet"
+	wallet := repository.Wallet{
+		Owner:   "test1",
+		Balance: 1000,
+	}
+	var idMap map[string]int
+	resp := s.processRequest(ctx, http.MethodPost, path, wallet, &idMap)
+	require.Equal(s.T(), http.StatusCreated, resp.StatusCode)
+	id, ok := idMap["id"]
+	require.True(s.T(), ok)
+
+	finreq := repository.FinRequest{Sum: 500.0, UUID: "b2eb5a3b-d9d2-11ec-abbd-0242ac150002"}
+	resp = s.processIdempotentRequest(ctx, http.MethodPut, path+"/"+strconv.Itoa(id)+"/deposit", "b2eb5a3b-d9d2-11ec-abbd-0242ac150003", finreq, nil)
+	require.Equal(s.T(), http.StatusBadRequest, resp.StatusCode)
+}
+
+func (s *IntegrationTestSuite) TestCreateWalletIdempotent() {
+	ctx := context.Background()
+	path := s.url + "/wallet"
+	wallet := repository.Wallet{
+		Owner:   "test1",
+		Balance: 1000,
+	}
+	key := "b2eb5a3b-d9d2-11ec-abbd-0242ac150004"
+	var idMap, idMap2 map[string]int
+	resp := s.processIdempotentRequest(ctx, http.MethodPost, path, key, wallet, &idMap)
+	require.Equal(s.T(), http.StatusCreated, resp.StatusCode)
+	resp = s.processIdempotentRequest(ctx, http.MethodPost, path, key, wallet, &idMap2)
+	require.Equal(s.T(), http.StatusCreated, resp.StatusCode)
+	require.Equal(s.T(), "true", resp.Header.Get("Idempotent-Replayed"))
+	require.Equal(s.T(), idMap["id"], idMap2["id"])
+}
+
+func (s *IntegrationTestSuite) TestIdempotencyScopedByWallet() {
+	ctx := context.Background()
+	path := s.url + "/wallet"
+	wallet := repository.Wallet{
+		Owner:   "test1",
+		Balance: 1000,
+	}
+	var idMap map[string]int
+	resp := s.processRequest(ctx, http.MethodPost, path, wallet, &idMap)
+	require.Equal(s.T(), http.StatusCreated, resp.StatusCode)
+	id := idMap["id"]
+	resp = s.processRequest(ctx, http.MethodPost, path, wallet, &idMap)
+	require.Equal(s.T(), http.StatusCreated, resp.StatusCode)
+	id2 := idMap["id"]
+
+	finreq := repository.FinRequest{Sum: 100.0, UUID: "b2eb5a3b-d9d2-11ec-abbd-0242ac150005"}
+	resp = s.processRequest(ctx, http.MethodPut, path+"/"+strconv.Itoa(id)+"/deposit", finreq, nil)
+	require.Equal(s.T(), http.StatusOK, resp.StatusCode)
+	resp = s.processRequest(ctx, http.MethodPut, path+"/"+strconv.Itoa(id2)+"/deposit", finreq, nil)
+	require.Equal(s.T(), http.StatusOK, resp.StatusCode)
+	require.Empty(s.T(), resp.Header.Get("Idempotent-Replayed"))
+
+	var walletResp repository.Wallet
+	resp = s.processRequest(ctx, http.MethodGet, path+"/"+strconv.Itoa(id2), nil, &walletResp)
+	require.Equal(s.T(), http.StatusOK, resp.StatusCode)
+	require.Equal(s.T(), 1100.0, walletResp.Balance)
+}
+
+func (s *IntegrationTestSuite) TestIdempotencyLeaseTakeover() {
+	ctx := context.Background()
+	scope := repository.IdempotencyScope{Username: "aspan", Operation: "deposit", WalletID: 1}
+	expiresAt := time.Now().Add(time.Hour)
+	_, reserved, err := s.store.ReserveIdempotencyKey(ctx, scope, "lease-live", "fp", "live", time.Now().Add(time.Minute), expiresAt)
+	require.NoError(s.T(), err)
+	require.True(s.T(), reserved)
+	record, reserved, err := s.store.ReserveIdempotencyKey(ctx, scope, "lease-live", "fp", "live", time.Now().Add(time.Minute), expiresAt)
+	require.NoError(s.T(), err)
+	require.False(s.T(), reserved, "the request holding the key is still running")
+	require.Nil(s.T(), record.StatusCode)
+
+	// the request reserving the key died without finishing it
+	_, reserved, err = s.store.ReserveIdempotencyKey(ctx, scope, "lease-lost", "fp", "first", time.Now().Add(-time.Minute), expiresAt)
+	require.NoError(s.T(), err)
+	require.True(s.T(), reserved)
+	_, reserved, err = s.store.ReserveIdempotencyKey(ctx, scope, "lease-lost", "other", "other", time.Now().Add(time.Minute), expiresAt)
+	require.NoError(s.T(), err)
+	require.False(s.T(), reserved, "a different request can't take the key over")
+	record, reserved, err = s.store.ReserveIdempotencyKey(ctx, scope, "lease-lost", "fp", "retry", time.Now().Add(time.Minute), expiresAt)
+	require.NoError(s.T(), err)
+	require.True(s.T(), reserved, "the retry takes the key over")
+	require.True(s.T(), record.LockedUntil.After(time.Now()))
+	require.Equal(s.T(), "retry", record.Token)
+
+	// the slow first request neither frees nor settles the key of the retry
+	err = s.store.ReleaseIdempotencyKey(ctx, scope, "lease-lost", "first")
+	require.ErrorIs(s.T(), err, repository.ErrIdempotencyKeyLost)
+	err = s.store.SaveIdempotentResponse(ctx, scope, "lease-lost", "first", http.StatusOK, []byte(`{"first":true}`))
+	require.ErrorIs(s.T(), err, repository.ErrIdempotencyKeyLost)
+	require.NoError(s.T(), s.store.SaveIdempotentResponse(ctx, scope, "lease-lost", "retry", http.StatusOK, []byte(`{}`)))
+	record, reserved, err = s.store.ReserveIdempotencyKey(ctx, scope, "lease-lost", "fp", "again", time.Now().Add(time.Minute), expiresAt)
+	require.NoError(s.T(), err)
+	require.False(s.T(), reserved)
+	require.Equal(s.T(), []byte(`{}`), record.Response)
+}
@@ -0,0 +1,196 @@
+package tests
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+
+	"EWallet/pkg/importer"
+	"EWallet/pkg/repository"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	importUUID1 = "0b6f1c8e-3c0a-4d8e-9a51-1f4a0c6d2b01"
+	importUUID2 = "0b6f1c8e-3c0a-4d8e-9a51-1f4a0c6d2b02"
+)
+
+// readRows reads every row of the file, keeping the row errors.
+func readRows(t *testing.T, r importer.Reader) ([]importer.Row, []importer.RowError) {
+	t.Helper()
+	var rows []importer.Row
+	var errs []importer.RowError
+	for {
+		row, err := r.Next()
+		if errors.Is(err, io.EOF) {
+			return rows, errs
+		}
+		var rowErr *importer.RowError
+		if errors.As(err, &rowErr) {
+			errs = append(errs, *rowErr)
+			continue
+		}
+		require.NoError(t, err)
+		rows = append(rows, row)
+	}
+}
+
+func TestImportCSV(t *testing.T) {
+	r, err := importer.NewCSVReader(strings.NewReader("uuid,operation,wallet_id,sum,wallet_target\n" +
+		importUUID1 + ",deposit,1,10.50,\n" +
+		importUUID2 + ",transfer,1,5,2\n" +
+		"x,withdraw,one,5,\n" +
+		"x,withdraw,1\n"))
+	require.NoError(t, err)
+	rows, errs := readRows(t, r)
+	require.Equal(t, []importer.Row{
+		{Line: 2, Operation: "deposit", WalletId: 1, Sum: 10.5, UUID: importUUID1},
+		{Line: 3, Operation: "transfer", WalletId: 1, WalletTarget: 2, Sum: 5, UUID: importUUID2},
+	}, rows)
+	require.Len(t, errs, 2)
+	require.Equal(t, importer.RowError{Line: 4, UUID: "x", Field: "wallet_id", Message: "must be an integer"}, errs[0])
+	require.Equal(t, 5, errs[1].Line)
+
+	_, err = importer.NewCSVReader(strings.NewReader("operation,wallet_id,sum\n"))
+	require.True(t, errors.Is(err, importer.ErrMissingColumn))
+	_, err = importer.NewCSVReader(strings.NewReader("operation,wallet_id,sum,uuid,date\n"))
+	require.True(t, errors.Is(err, importer.ErrUnknownColumn))
+}
+
+func TestImportJSONL(t *testing.T) {
+	r := importer.NewJSONLReader(strings.NewReader(
+		`{"operation":"deposit","wallet_id":1,"sum":10.5,"uuid":"` + importUUID1 + `"}` + "\n\n" +
+			`{"operation":"withdraw","wallet_id":"one","sum":1,"uuid":"x"}` + "\n" +
+			`{"operation":"withdraw","date":"2024-10-01"}` + "\n" +
+			`{"operation":"transfer","wallet_id":1,"wallet_target":2,"sum":5,"uuid":"` + importUUID2 + `"}`))
+	rows, errs := readRows(t, r)
+	require.Equal(t, []importer.Row{
+		{Line: 1, Operation: "deposit", WalletId: 1, Sum: 10.5, UUID: importUUID1},
+		{Line: 5, Operation: "transfer", WalletId: 1, WalletTarget: 2, Sum: 5, UUID: importUUID2},
+	}, rows)
+	require.Len(t, errs, 2)
+	require.Equal(t, importer.RowError{Line: 3, Field: "wallet_id", Message: "must be an integer"}, errs[0])
+	require.Equal(t, 4, errs[1].Line)
+	require.Contains(t, errs[1].Message, "unknown field")
+}
+
+func TestImportValidate(t *testing.T) {
+	valid := importer.Row{Line: 7, Operation: "transfer", WalletId: 1, WalletTarget: 2, Sum: 5, UUID: importUUID1}
+	require.Empty(t, valid.Validate())
+	for _, tc := range []struct {
+		name  string
+		edit  func(*importer.Row)
+		field string
+	}{
+		{"operation", func(r *importer.Row) { r.Operation = "refund" }, "operation"},
+		{"wallet", func(r *importer.Row) { r.WalletId = 0 }, "wallet_id"},
+		{"no target", func(r *importer.Row) { r.WalletTarget = 0 }, "wallet_target"},
+		{"same target", func(r *importer.Row) { r.WalletTarget = 1 }, "wallet_target"},
+		{"deposit target", func(r *importer.Row) { r.Operation = "deposit" }, "wallet_target"},
+		{"negative sum", func(r *importer.Row) { r.Sum = -1 }, "sum"},
+		{"precision", func(r *importer.Row) { r.Sum = 1.005 }, "sum"},
+		{"too large", func(r *importer.Row) { r.Sum = 100000000 }, "sum"},
+		{"uuid", func(r *importer.Row) { r.UUID = "abc" }, "uuid"},
+	} {
+		row := valid
+		tc.edit(&row)
+		errs := row.Validate()
+		require.Len(t, errs, 1, tc.name)
+		require.Equal(t, tc.field, errs[0].Field, tc.name)
+		require.Equal(t, 7, errs[0].Line, tc.name)
+	}
+}
+
+func TestImportFormatOf(t *testing.T) {
+	format, err := importer.FormatOf("text/csv; charset=utf-8")
+	require.NoError(t, err)
+	require.Equal(t, importer.FormatCSV, format)
+	format, err = importer.FormatOf("application/x-ndjson")
+	require.NoError(t, err)
+	require.Equal(t, importer.FormatJSONL, format)
+	_, err = importer.FormatOf("application/json")
+	require.True(t, errors.Is(err, importer.ErrUnknownFormat))
+}
+
+func (s *IntegrationTestSuite) TestImport() {
+	ctx := context.Background()
+	source, err := s.store.CreateWallet(ctx, repository.Wallet{Owner: "legacy", Balance: 0})
+	require.NoError(s.T(), err)
+	target, err := s.store.CreateWallet(ctx, repository.Wallet{Owner: "legacy", Balance: 0})
+	require.NoError(s.T(), err)
+	deposit, transfer, overdraft := uuid.New().String(), uuid.New().String(), uuid.New().String()
+	file := fmt.Sprintf("operation,wallet_id,wallet_target,sum,uuid\n"+
+		"deposit,%[1]d,,100,%[3]s\n"+
+		"transfer,%[1]d,%[2]d,40,%[4]s\n"+
+		"withdraw,%[2]d,,50,%[5]s\n"+
+		"deposit,999999999,,1,%[6]s\n"+
+		"deposit,%[1]d,,1,not-a-uuid\n"+
+		"deposit,%[1]d,,100,%[3]s\n", source, target, deposit, transfer, overdraft, uuid.New().String())
+	run := func(dryRun bool) importer.Report {
+		rows, err := importer.NewCSVReader(strings.NewReader(file))
+		require.NoError(s.T(), err)
+		report, err := s.app.Import(ctx, rows, dryRun)
+		require.NoError(s.T(), err)
+		return report
+	}
+
+	report := run(true)
+	require.Equal(s.T(), 6, report.Total)
+	require.Equal(s.T(), 2, report.Applied)
+	require.Equal(s.T(), 1, report.Skipped)
+	require.Equal(s.T(), 3, report.Failed)
+	require.Equal(s.T(), []string{"sum", "wallet_id", "uuid"}, []string{report.Errors[0].Field, report.Errors[1].Field, report.Errors[2].Field})
+	require.Equal(s.T(), 4, report.Errors[0].Line)
+	wallet, err := s.store.GetWallet(ctx, source)
+	require.NoError(s.T(), err)
+	require.Equal(s.T(), 0.0, wallet.Balance)
+
+	report = run(false)
+	require.Equal(s.T(), 2, report.Applied)
+	require.Equal(s.T(), 3, report.Failed)
+	wallet, err = s.store.GetWallet(ctx, target)
+	require.NoError(s.T(), err)
+	require.Equal(s.T(), 40.0, wallet.Balance)
+
+	// a second run resumes: applied rows are skipped, the rejected withdrawal keeps failing
+	report = run(false)
+	require.Equal(s.T(), 0, report.Applied)
+	require.Equal(s.T(), 3, report.Skipped)
+	require.Equal(s.T(), 3, report.Failed)
+	wallet, err = s.store.GetWallet(ctx, source)
+	require.NoError(s.T(), err)
+	require.Equal(s.T(), 60.0, wallet.Balance)
+
+	// only failed attempts hold the uuid of the withdrawal: it is applied once the funds are there
+	require.NoError(s.T(), s.store.Deposit(ctx, target, &repository.FinRequest{Sum: 10, UUID: uuid.New().String()}))
+	report = run(false)
+	require.Equal(s.T(), 1, report.Applied)
+	require.Equal(s.T(), 2, report.Failed)
+	t, err := s.store.GetTransactionByUUID(ctx, target, "withdraw", overdraft)
+	require.NoError(s.T(), err)
+	require.Equal(s.T(), repository.StatusCompleted, t.Status)
+
+	// a uuid is unique per wallet and operation: other wallets and operations may use it too
+	shared := uuid.New().String()
+	file = fmt.Sprintf("operation,wallet_id,wallet_target,sum,uuid\n"+
+		"deposit,%[1]d,,5,%[3]s\n"+
+		"deposit,%[2]d,,7,%[3]s\n"+
+		"withdraw,%[1]d,,1,%[3]s\n", source, target, shared)
+	report = run(false)
+	require.Equal(s.T(), 3, report.Applied)
+	require.Zero(s.T(), report.Failed)
+	report = run(false)
+	require.Equal(s.T(), 3, report.Skipped)
+	require.Zero(s.T(), report.Failed)
+	wallet, err = s.store.GetWallet(ctx, source)
+	require.NoError(s.T(), err)
+	require.Equal(s.T(), 64.0, wallet.Balance)
+	wallet, err = s.store.GetWallet(ctx, target)
+	require.NoError(s.T(), err)
+	require.Equal(s.T(), 7.0, wallet.Balance)
+}
@@ -13,6 +13,7 @@ import (
 
 	"EWallet/internal"
 	"EWallet/internal/rest"
+	"EWallet/pkg/jobs"
 	"EWallet/pkg/repository"
 
 	_ "github.com/jackc/pgx/v4/stdlib"
@@ -61,7 +62,11 @@ func (s *IntegrationTestSuite) SetupSuite() {
 	require.NoError(s.T(), err)
 	err = s.store.Migrate(migrate.Up)
 	require.NoError(s.T(), err)
-	s.app = internal.NewApp(s.log, s.store, &MockExchange{})
+	s.app = internal.NewApp(s.log, s.store, &MockExchange{}, nil, time.Hour, []byte("statementkey"))
+	go s.app.RunEventStream(ctx)
+	worker := jobs.NewWorker(s.log, s.store, 20*time.Millisecond, 2, time.Minute)
+	s.app.RegisterJobs(worker)
+	go worker.Run(ctx)
 	s.router = rest.NewRouter(s.log, s.app, "testsecret")
 	go func() {
 		_ = s.router.Run(ctx, "localhost:3001")
@@ -160,6 +165,15 @@ func (s *IntegrationTestSuite) TestUpdateWallet() {
 	require.Equal(s.T(), http.StatusOK, resp.StatusCode)
 	require.Equal(s.T(), walletResp.Owner, wallet2.Owner)
 	require.Equal(s.T(), walletResp.Balance, wallet2.Balance)
+
+	// the initial balance and the edit are recorded as transactions, so the statement adds up to the new balance
+	statement, err := s.store.GetStatement(ctx, id, time.Now().Add(-time.Hour), time.Now().Add(time.Hour))
+	require.NoError(s.T(), err)
+	require.Equal(s.T(), 0.0, statement.OpeningBalance)
+	require.Equal(s.T(), 1000.0, statement.ClosingBalance)
+	require.Len(s.T(), statement.Entries, 2)
+	require.Equal(s.T(), "deposit", statement.Entries[1].Operation)
+	require.Equal(s.T(), []float64{100, 900}, []float64{statement.Entries[0].Amount, statement.Entries[1].Amount})
 }
 
 func (s *IntegrationTestSuite) TestUpdateWalletNotFound() {
@@ -434,7 +448,8 @@ func (s *IntegrationTestSuite) TestDepoConflict() {
 	require.Equal(s.T(), walletResp.Balance, 2000.0)
 
 	resp = s.processRequest(ctx, http.MethodPut, path+"/"+strconv.Itoa(id)+"/deposit", finreq2, nil)
-	require.Equal(s.T(), http.StatusConflict, resp.StatusCode)
+	require.Equal(s.T(), http.StatusOK, resp.StatusCode)
+	require.Equal(s.T(), "true", resp.Header.Get("Idempotent-Replayed"))
 
 	resp = s.processRequest(ctx, http.MethodGet, path+"/"+strconv.Itoa(id), nil, &walletResp)
 	require.Equal(s.T(), http.StatusOK, resp.StatusCode)
@@ -540,7 +555,8 @@ func (s *IntegrationTestSuite) TestWithdrawConflict() {
 	resp = s.processRequest(ctx, http.MethodPut, path+"/"+strconv.Itoa(id)+"/withdraw", finreq, nil)
 	require.Equal(s.T(), http.StatusOK, resp.StatusCode)
 	resp = s.processRequest(ctx, http.MethodPut, path+"/"+strconv.Itoa(id)+"/withdraw", finreq, nil)
-	require.Equal(s.T(), http.StatusConflict, resp.StatusCode)
+	require.Equal(s.T(), http.StatusOK, resp.StatusCode)
+	require.Equal(s.T(), "true", resp.Header.Get("Idempotent-Replayed"))
 	var walletResp repository.Wallet
 	resp = s.processRequest(ctx, http.MethodGet, path+"/"+strconv.Itoa(id), nil, &walletResp)
 	require.Equal(s.T(), http.StatusOK, resp.StatusCode)
@@ -695,7 +711,7 @@ func (s *IntegrationTestSuite) TestTransferWalletConflict() {
 	require.Equal(s.T(), http.StatusOK, resp.StatusCode)
 
 	resp = s.processRequest(ctx, http.MethodPut, path+"/"+strconv.Itoa(idSender)+"/transfer", finreq2, nil)
-	require.Equal(s.T(), http.StatusConflict, resp.StatusCode)
+	require.Equal(s.T(), http.StatusUnprocessableEntity, resp.StatusCode)
 
 	var walletRespSender repository.Wallet
 	resp = s.processRequest(ctx, http.MethodGet, path+"/"+strconv.Itoa(idSender), nil, &walletRespSender)
@@ -794,12 +810,15 @@ func (s *IntegrationTestSuite) TestGetTransaction() {
 	resp = s.processRequest(ctx, http.MethodPut, path+"/"+strconv.Itoa(id)+"/deposit", finreq2, nil)
 	require.Equal(s.T(), http.StatusOK, resp.StatusCode)
 
-	var ans []repository.Transaction
+	var ans repository.TransactionPage
 	// TODO: url
 	resp = s.processRequest(ctx, http.MethodGet, path+"/"+strconv.Itoa(id)+"/transactions", nil, &ans)
 	require.Equal(s.T(), http.StatusOK, resp.StatusCode)
-	require.Equal(s.T(), ans[1].Sum, finreq2.Sum)
-	require.Equal(s.T(), ans[0].Sum, finreq.Sum)
+	// the first transaction records the initial balance
+	require.Len(s.T(), ans.Transactions, 3)
+	require.Equal(s.T(), ans.Transactions[0].Sum, wallet.Balance)
+	require.Equal(s.T(), ans.Transactions[2].Sum, finreq2.Sum)
+	require.Equal(s.T(), ans.Transactions[1].Sum, finreq.Sum)
 }
 
 func (s *IntegrationTestSuite) TestGetTransferTransaction() {
@@ -833,12 +852,12 @@ func (s *IntegrationTestSuite) TestGetTransferTransaction() {
 	resp = s.processRequest(ctx, http.MethodPut, path+"/"+strconv.Itoa(idSender)+"/transfer", finreq, nil)
 	require.Equal(s.T(), http.StatusOK, resp.StatusCode)
 
-	var ans []repository.Transaction
+	var ans repository.TransactionPage
 	// TODO: url
 	resp = s.processRequest(ctx, http.MethodGet, path+"/"+strconv.Itoa(idSender)+"/transactions", nil, &ans)
 	require.Equal(s.T(), http.StatusOK, resp.StatusCode)
-	require.Equal(s.T(), ans[0].Sum, finreq.Sum)
-	require.Equal(s.T(), ans[0].FromId, idSender)
+	require.Equal(s.T(), ans.Transactions[1].Sum, finreq.Sum)
+	require.Equal(s.T(), ans.Transactions[1].FromId, idSender)
 
 	resp = s.processRequest(ctx, http.MethodGet, path+"/"+strconv.Itoa(idGetter)+"/transactions", nil, &ans)
 	require.Equal(s.T(), http.StatusOK, resp.StatusCode)
@@ -873,11 +892,11 @@ func (s *IntegrationTestSuite) TestGetTransactionParams() {
 	resp = s.processRequest(ctx, http.MethodPut, path+"/"+strconv.Itoa(id)+"/deposit", finreq2, nil)
 	require.Equal(s.T(), http.StatusOK, resp.StatusCode)
 
-	var ans []repository.Transaction
+	var ans repository.TransactionPage
 	resp = s.processRequest(ctx, http.MethodGet, path+"/"+strconv.Itoa(id)+"/transactions?limit=1", nil, &ans)
 	require.Equal(s.T(), http.StatusOK, resp.StatusCode)
-	// require.Equal(s.T(), ans[0].Sum, finreq2.Sum)
-	require.Equal(s.T(), len(ans), 1)
+	// require.Equal(s.T(), ans.Transactions[0].Sum, finreq2.Sum)
+	require.Equal(s.T(), len(ans.Transactions), 1)
 }
 
 func (s *IntegrationTestSuite) TestGetTransactionNotFound() {
@@ -0,0 +1,310 @@
+//nolint:bodyclose
+package tests
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"EWallet/pkg/importer"
+	"EWallet/pkg/jobs"
+	"EWallet/pkg/repository"
+
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/require"
+)
+
+type MockJobStore struct {
+	mu     sync.Mutex
+	jobs   []*repository.Job
+	output map[int64][]byte
+}
+
+func (m *MockJobStore) add(jobType string, maxAttempts int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.jobs = append(m.jobs, &repository.Job{
+		Id: int64(len(m.jobs) + 1), Type: jobType, Status: repository.JobQueued, MaxAttempts: maxAttempts, RunAt: time.Now(),
+	})
+}
+
+func (m *MockJobStore) get(id int64) repository.Job {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return *m.jobs[id-1]
+}
+
+func (m *MockJobStore) ClaimJobs(ctx context.Context, types []string, limit int, visibility time.Duration) ([]repository.Job, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	claimed := make([]repository.Job, 0)
+	for _, j := range m.jobs {
+		if len(claimed) == limit || j.Status != repository.JobQueued || j.RunAt.After(time.Now()) {
+			continue
+		}
+		j.Status = repository.JobRunning
+		j.Attempts++
+		claimed = append(claimed, *j)
+	}
+	return claimed, nil
+}
+
+func (m *MockJobStore) ExtendJob(ctx context.Context, job repository.Job, visibility time.Duration) (bool, error) {
+	return true, nil
+}
+
+func (m *MockJobStore) finish(job repository.Job, update func(j *repository.Job)) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	j := m.jobs[job.Id-1]
+	if j.Status != repository.JobRunning || j.Attempts != job.Attempts {
+		return false
+	}
+	update(j)
+	return true
+}
+
+func (m *MockJobStore) CompleteJob(ctx context.Context, job repository.Job, result json.RawMessage, output []byte, contentType string) (bool, error) {
+	return m.finish(job, func(j *repository.Job) {
+		j.Status, j.Result = repository.JobSucceeded, result
+		if m.output == nil {
+			m.output = make(map[int64][]byte)
+		}
+		m.output[j.Id] = output
+	}), nil
+}
+
+func (m *MockJobStore) RetryJob(ctx context.Context, job repository.Job, reason string, runAt time.Time) (bool, error) {
+	return m.finish(job, func(j *repository.Job) {
+		j.Status, j.LastError, j.RunAt = repository.JobQueued, &reason, runAt
+	}), nil
+}
+
+func (m *MockJobStore) ReleaseJob(ctx context.Context, job repository.Job, reason string) (bool, error) {
+	return m.finish(job, func(j *repository.Job) {
+		j.Status, j.LastError, j.RunAt = repository.JobQueued, &reason, time.Now()
+		j.Attempts--
+	}), nil
+}
+
+func (m *MockJobStore) FailJob(ctx context.Context, job repository.Job, reason string) (bool, error) {
+	return m.finish(job, func(j *repository.Job) {
+		j.Status, j.LastError = repository.JobFailed, &reason
+	}), nil
+}
+
+func (m *MockJobStore) FailExpiredJobs(ctx context.Context) (int64, error) {
+	return 0, nil
+}
+
+// DeleteFinishedJobs drops the finished jobs, the ids of the others are kept as their positions.
+func (m *MockJobStore) DeleteFinishedJobs(ctx context.Context, before time.Time) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var cnt int64
+	for i, j := range m.jobs {
+		if j != nil && (j.Status == repository.JobSucceeded || j.Status == repository.JobFailed) &&
+			j.FinishedAt != nil && j.FinishedAt.Before(before) {
+			m.jobs[i] = nil
+			cnt++
+		}
+	}
+	return cnt, nil
+}
+
+func (m *MockJobStore) GetJobStats(ctx context.Context) ([]repository.JobStat, error) {
+	return nil, nil
+}
+
+// runWorker runs the worker until the test ends.
+func runWorker(t *testing.T, w *jobs.Worker) {
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	go w.Run(ctx)
+}
+
+func waitJob(t *testing.T, store *MockJobStore, id int64, status string) repository.Job {
+	require.Eventually(t, func() bool { return store.get(id).Status == status }, 2*time.Second, 5*time.Millisecond)
+	return store.get(id)
+}
+
+func TestJobsBackoff(t *testing.T) {
+	require.Equal(t, time.Duration(0), jobs.Backoff(0))
+	require.Equal(t, 5*time.Second, jobs.Backoff(1))
+	require.Equal(t, 20*time.Second, jobs.Backoff(3))
+	require.Equal(t, 10*time.Minute, jobs.Backoff(20))
+}
+
+func TestJobsWorkerRetries(t *testing.T) {
+	store := &MockJobStore{}
+	store.add("flaky", 3)
+	store.add("broken", 3)
+	store.add("panicking", 1)
+	var calls int32
+	var mu sync.Mutex
+	w := jobs.NewWorker(logrus.New(), store, 10*time.Millisecond, 2, time.Minute)
+	w.Register("flaky", func(ctx context.Context, job repository.Job) (jobs.Result, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		if calls++; calls == 1 {
+			return jobs.Result{}, errors.New("temporary")
+		}
+		return jobs.Result{Value: map[string]int{"attempt": job.Attempts}, Output: []byte("file"), ContentType: "text/plain"}, nil
+	})
+	w.Register("broken", func(ctx context.Context, job repository.Job) (jobs.Result, error) {
+		return jobs.Result{}, jobs.Permanent(errors.New("bad payload"))
+	})
+	w.Register("panicking", func(ctx context.Context, job repository.Job) (jobs.Result, error) {
+		panic("boom")
+	})
+	runWorker(t, w)
+
+	// the failed attempt is retried after the backoff
+	job := waitJob(t, store, 1, repository.JobQueued)
+	require.Equal(t, 1, job.Attempts)
+	require.Equal(t, "temporary", *job.LastError)
+	require.True(t, job.RunAt.After(time.Now().Add(4*time.Second)))
+	store.mu.Lock()
+	store.jobs[0].RunAt = time.Now()
+	store.mu.Unlock()
+	job = waitJob(t, store, 1, repository.JobSucceeded)
+	require.Equal(t, 2, job.Attempts)
+	require.JSONEq(t, `{"attempt":2}`, string(job.Result))
+	require.Equal(t, []byte("file"), store.output[1])
+
+	// permanent errors and the last attempt fail the job
+	job = waitJob(t, store, 2, repository.JobFailed)
+	require.Equal(t, 1, job.Attempts)
+	require.Equal(t, "bad payload", *job.LastError)
+	job = waitJob(t, store, 3, repository.JobFailed)
+	require.Equal(t, "panic: boom", *job.LastError)
+}
+
+func TestJobsWorkerRetention(t *testing.T) {
+	store := &MockJobStore{}
+	store.add("old", 1)
+	store.add("recent", 1)
+	store.add("queued", 1)
+	longAgo, now := time.Now().Add(-48*time.Hour), time.Now()
+	store.jobs[0].Status, store.jobs[0].FinishedAt = repository.JobSucceeded, &longAgo
+	store.jobs[1].Status, store.jobs[1].FinishedAt = repository.JobFailed, &now
+	store.jobs[2].RunAt = time.Now().Add(time.Hour)
+	w := jobs.NewWorker(logrus.New(), store, 10*time.Millisecond, 1, time.Minute)
+	w.SetRetention(24 * time.Hour)
+	runWorker(t, w)
+
+	// jobs finished before the retention are deleted on start, the others are kept
+	require.Eventually(t, func() bool {
+		store.mu.Lock()
+		defer store.mu.Unlock()
+		return store.jobs[0] == nil
+	}, 2*time.Second, 5*time.Millisecond)
+	require.Equal(t, repository.JobFailed, store.get(2).Status)
+	require.Equal(t, repository.JobQueued, store.get(3).Status)
+}
+
+func TestJobsWorkerShutdown(t *testing.T) {
+	store := &MockJobStore{}
+	store.add("slow", 3)
+	store.add("stuck", 3)
+	started, release := make(chan struct{}, 2), make(chan struct{})
+	w := jobs.NewWorker(logrus.New(), store, 10*time.Millisecond, 2, time.Minute)
+	w.Register("slow", func(ctx context.Context, job repository.Job) (jobs.Result, error) {
+		started <- struct{}{}
+		<-release
+		return jobs.Result{}, nil
+	})
+	w.Register("stuck", func(ctx context.Context, job repository.Job) (jobs.Result, error) {
+		started <- struct{}{}
+		<-ctx.Done()
+		return jobs.Result{}, ctx.Err()
+	})
+	runWorker(t, w)
+	<-started
+	<-started
+
+	// the drain waits for the running jobs until the deadline, the stuck one is then cancelled and queued again
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		close(release)
+	}()
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	err := w.Shutdown(ctx)
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+	require.Equal(t, repository.JobSucceeded, store.get(1).Status)
+	stuck := store.get(2)
+	require.Equal(t, repository.JobQueued, stuck.Status)
+	require.Equal(t, "interrupted by worker shutdown", *stuck.LastError)
+	require.False(t, stuck.RunAt.After(time.Now()))
+	require.Zero(t, stuck.Attempts, "the interrupted attempt is given back")
+
+	// nothing is claimed after the shutdown
+	time.Sleep(50 * time.Millisecond)
+	require.Equal(t, repository.JobQueued, store.get(2).Status)
+}
+
+// waitForJob polls the job status endpoint until the job is finished.
+func (s *IntegrationTestSuite) waitForJob(ctx context.Context, id int64) repository.Job {
+	var job repository.Job
+	require.Eventually(s.T(), func() bool {
+		resp := s.processRequest(ctx, http.MethodGet, s.url+"/jobs/"+strconv.FormatInt(id, 10), nil, &job)
+		require.Equal(s.T(), http.StatusOK, resp.StatusCode)
+		return job.Status == repository.JobSucceeded || job.Status == repository.JobFailed
+	}, 10*time.Second, 50*time.Millisecond)
+	return job
+}
+
+func (s *IntegrationTestSuite) TestJobs() {
+	ctx := context.Background()
+	id, err := s.store.CreateWallet(ctx, repository.Wallet{Owner: "jobs", Balance: 0})
+	require.NoError(s.T(), err)
+
+	// the import report is the result of the job
+	file := fmt.Sprintf("operation,wallet_id,sum,uuid\ndeposit,%d,25,%s\n", id, uuid.New().String())
+	job, err := s.app.EnqueueImport(ctx, "aspan", importer.FormatCSV, []byte(file), false)
+	require.NoError(s.T(), err)
+	job = s.waitForJob(ctx, job.Id)
+	require.Equal(s.T(), repository.JobSucceeded, job.Status)
+	var report importer.Report
+	require.NoError(s.T(), json.Unmarshal(job.Result, &report))
+	require.Equal(s.T(), 1, report.Applied)
+
+	// an export produces the file
+	resp := s.processRequest(ctx, http.MethodPost, s.url+"/wallet/"+strconv.Itoa(id)+"/exports?format=csv", nil, &job)
+	require.Equal(s.T(), http.StatusAccepted, resp.StatusCode)
+	require.Equal(s.T(), "/api/v1/jobs/"+strconv.FormatInt(job.Id, 10), resp.Header.Get("Location"))
+	job = s.waitForJob(ctx, job.Id)
+	require.Equal(s.T(), repository.JobSucceeded, job.Status)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.url+"/jobs/"+strconv.FormatInt(job.Id, 10)+"/output", nil)
+	require.NoError(s.T(), err)
+	req.Header.Set("Authorization", "Bearer "+token)
+	resp, err = http.DefaultClient.Do(req)
+	require.NoError(s.T(), err)
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(s.T(), err)
+	require.Equal(s.T(), http.StatusOK, resp.StatusCode)
+	require.Contains(s.T(), resp.Header.Get("Content-Disposition"), "wallet-"+strconv.Itoa(id))
+	require.True(s.T(), strings.Contains(string(body), "25.00"))
+
+	// jobs of other users are hidden
+	other, err := s.app.EnqueueMonthlyStatements(ctx, time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC))
+	require.NoError(s.T(), err)
+	resp = s.processRequest(ctx, http.MethodGet, s.url+"/jobs/"+strconv.FormatInt(other.Id, 10), nil, nil)
+	require.Equal(s.T(), http.StatusNotFound, resp.StatusCode)
+	again, err := s.app.EnqueueMonthlyStatements(ctx, time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC))
+	require.NoError(s.T(), err)
+	if again.Status == repository.JobQueued || again.Status == repository.JobRunning {
+		require.Equal(s.T(), other.Id, again.Id)
+	}
+}
@@ -0,0 +1,142 @@
+package tests
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"EWallet/internal/rest"
+	"EWallet/pkg/logger"
+	"EWallet/pkg/repository"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/require"
+)
+
+// LogBuffer collects the log lines written by the server goroutines.
+type LogBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *LogBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+// lines returns the JSON log lines with msg.
+func (b *LogBuffer) lines(msg string) []map[string]interface{} {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	var lines []map[string]interface{}
+	for _, line := range strings.Split(b.buf.String(), "\n") {
+		var entry map[string]interface{}
+		if json.Unmarshal([]byte(line), &entry) == nil && entry["msg"] == msg {
+			lines = append(lines, entry)
+		}
+	}
+	return lines
+}
+
+// LoggingApp logs from GetWallet like the App does and panics for wallet 13.
+type LoggingApp struct {
+	rest.App
+	log *logrus.Logger
+}
+
+func (a *LoggingApp) GetWallet(ctx context.Context, id int, currency string) (repository.Wallet, error) {
+	if id == 13 {
+		panic("unlucky wallet")
+	}
+	a.log.WithContext(ctx).Info("getting wallet")
+	return repository.Wallet{Owner: "aspan", Balance: 10}, nil
+}
+
+func TestRequestLogging(t *testing.T) {
+	log, err := logger.New("debug", "json")
+	require.NoError(t, err)
+	out := &LogBuffer{}
+	log.SetOutput(out)
+	router := rest.NewRouter(log, &LoggingApp{log: log}, "testsecret")
+	jwtToken, err := router.GenToken("aspan")
+	require.NoError(t, err)
+	addr := freePort(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		_ = router.Run(ctx, addr)
+	}()
+	time.Sleep(100 * time.Millisecond)
+	get := func(path, requestID string) *http.Response {
+		req, err := http.NewRequest(http.MethodGet, "http://"+addr+path, nil)
+		require.NoError(t, err)
+		req.Header.Set("Authorization", "Bearer "+jwtToken)
+		if requestID != "" {
+			req.Header.Set("X-Request-ID", requestID)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		require.NoError(t, resp.Body.Close())
+		return resp
+	}
+
+	// the request ID of the client is returned and tags the access log and the App log lines
+	resp := get("/api/v1/wallet/7", "req-42")
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Equal(t, "req-42", resp.Header.Get("X-Request-ID"))
+	require.Eventually(t, func() bool { return len(out.lines("request")) == 1 }, time.Second, 5*time.Millisecond)
+	access := out.lines("request")[0]
+	require.Equal(t, "req-42", access["request_id"])
+	require.Equal(t, "GET", access["method"])
+	require.Equal(t, "/api/v1/wallet/:id", access["route"])
+	require.Equal(t, float64(http.StatusOK), access["status"])
+	require.Equal(t, "aspan", access["user"])
+	require.Equal(t, "7", access["wallet_id"])
+	require.Contains(t, access, "latency_ms")
+	app := out.lines("getting wallet")
+	require.Len(t, app, 1)
+	require.Equal(t, "req-42", app[0]["request_id"])
+
+	// a missing or unfit request ID is replaced by a generated one
+	for i, id := range []string{"", "запрос-1", strings.Repeat("x", 200)} {
+		resp = get("/api/v1/wallet/7", id)
+		generated := resp.Header.Get("X-Request-ID")
+		require.Len(t, generated, 36)
+		require.Eventually(t, func() bool { return len(out.lines("request")) == i+2 }, time.Second, 5*time.Millisecond)
+		require.Equal(t, generated, out.lines("request")[i+1]["request_id"])
+	}
+
+	// a panic is answered with a problem and logged with the request
+	resp = get("/api/v1/wallet/13", "req-panic")
+	require.Equal(t, http.StatusInternalServerError, resp.StatusCode)
+	require.Equal(t, "application/problem+json", resp.Header.Get("Content-Type"))
+	require.Eventually(t, func() bool { return len(out.lines("request failed")) == 1 }, time.Second, 5*time.Millisecond)
+	require.Equal(t, "req-panic", out.lines("request failed")[0]["request_id"])
+	require.Equal(t, "13", out.lines("request failed")[0]["wallet_id"])
+
+	// probes are logged at debug level only
+	log.SetLevel(logrus.InfoLevel)
+	probe, err := http.Get("http://" + addr + "/healthz")
+	require.NoError(t, err)
+	require.NoError(t, probe.Body.Close())
+	resp = get("/api/v1/wallet/7", "req-last")
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Eventually(t, func() bool { return len(out.lines("request")) == 5 }, time.Second, 5*time.Millisecond)
+	require.Equal(t, "req-last", out.lines("request")[4]["request_id"])
+}
+
+func TestLoggerOptions(t *testing.T) {
+	_, err := logger.New("loud", "json")
+	require.Error(t, err)
+	_, err = logger.New("info", "xml")
+	require.Error(t, err)
+	log, err := logger.New("warn", "text")
+	require.NoError(t, err)
+	require.False(t, log.IsLevelEnabled(logrus.InfoLevel))
+}
@@ -0,0 +1,128 @@
+//nolint:bodyclose
+package tests
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"EWallet/internal"
+	"EWallet/internal/rest"
+	"EWallet/pkg/exchange"
+	"EWallet/pkg/metrics"
+	"EWallet/pkg/repository"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMetrics(t *testing.T) {
+	var from string
+	provider := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		from = r.URL.Query().Get("from")
+		if r.URL.Query().Get("to") == "xxx" {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		_, _ = fmt.Fprint(w, `{"success": true, "result": 20}`)
+	}))
+	defer provider.Close()
+	exch := exchange.NewExchangeRate(logrus.New(), provider.URL+"/convert?to=", "key", time.Second)
+	exch.SetBaseCurrency("KZT")
+	app := internal.NewApp(logrus.New(), &WalletStore{}, exch, nil, time.Hour, nil)
+	router := rest.NewRouter(logrus.New(), app, "testsecret")
+	jwtToken, err := router.GenToken("aspan")
+	require.NoError(t, err)
+	addr := freePort(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		_ = router.Run(ctx, addr)
+	}()
+	time.Sleep(100 * time.Millisecond)
+	get := func(path string) int {
+		req, err := http.NewRequest(http.MethodGet, "http://"+addr+path, nil)
+		require.NoError(t, err)
+		req.Header.Set("Authorization", "Bearer "+jwtToken)
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		require.NoError(t, resp.Body.Close())
+		return resp.StatusCode
+	}
+	requests := func(route, status string) float64 {
+		return testutil.ToFloat64(metrics.MetricHTTPRequests.WithLabelValues(route, http.MethodGet, status))
+	}
+	found := requests("/api/v1/wallet/:id", "200")
+	notFound := requests("/api/v1/wallet/:id", "404")
+	unmatched := requests("unmatched", "404")
+	statusErrors := testutil.ToFloat64(metrics.MetricExchangeErrors.WithLabelValues("GetRate", "status"))
+
+	require.Equal(t, http.StatusOK, get("/api/v1/wallet/1"))
+	require.Equal(t, http.StatusOK, get("/api/v1/wallet/2?currency=usd"))
+	require.Equal(t, "kzt", from, "amounts are converted from the wallet currency")
+	require.Equal(t, http.StatusNotFound, get("/api/v1/wallet/404"))
+	require.Equal(t, http.StatusInternalServerError, get("/api/v1/wallet/3?currency=xxx"))
+	for i := 0; i < 3; i++ {
+		require.Equal(t, http.StatusNotFound, get("/wp-admin/"+strconv.Itoa(i)))
+	}
+
+	// requests are counted by route pattern, unknown paths share one series
+	require.Equal(t, found+2, requests("/api/v1/wallet/:id", "200"))
+	require.Equal(t, notFound+1, requests("/api/v1/wallet/:id", "404"))
+	require.Equal(t, unmatched+3, requests("unmatched", "404"))
+	require.Equal(t, statusErrors+1, testutil.ToFloat64(metrics.MetricExchangeErrors.WithLabelValues("GetRate", "status")))
+
+	resp, err := http.Get("http://" + addr + "/metrics")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	exposed := string(body)
+	require.Contains(t, exposed, `ewallet_http_requests_total{method="GET",route="/api/v1/wallet/:id",status="200"}`)
+	require.Contains(t, exposed, `ewallet_http_request_duration_seconds_bucket{method="GET",route="/api/v1/wallet/:id",status="404",le="0.005"}`)
+	require.Contains(t, exposed, `ewallet_exchange_request_duration_seconds_count{operation="GetRate",outcome="ok"}`)
+	require.Contains(t, exposed, `ewallet_exchange_request_duration_seconds_count{operation="GetRate",outcome="error"}`)
+	require.Contains(t, exposed, "ewallet_http_requests_in_flight 1", "the scrape itself is in flight")
+	require.Contains(t, exposed, "go_goroutines")
+	require.NotContains(t, exposed, "/wp-admin")
+	require.NotContains(t, exposed, "ewallet_generic_http_request_duration")
+}
+
+func (s *IntegrationTestSuite) TestOperationMetrics() {
+	ctx := context.Background()
+	id, err := s.store.CreateWallet(ctx, repository.Wallet{Owner: "test1", Balance: 100})
+	require.NoError(s.T(), err)
+	deposits := testutil.ToFloat64(metrics.MetricOperations.WithLabelValues("deposit", repository.DefaultCurrency))
+	volume := testutil.ToFloat64(metrics.MetricOperationVolume.WithLabelValues("deposit", repository.DefaultCurrency))
+	rejected := testutil.ToFloat64(metrics.MetricInsufficientFunds.WithLabelValues("withdraw"))
+	replays := testutil.ToFloat64(metrics.MetricIdempotentReplays.WithLabelValues("deposit"))
+
+	path := s.url + "/wallet/" + strconv.Itoa(id)
+	key := "5d0e5a3b-d9d2-11ec-abbd-0242ac150001"
+	for i := 0; i < 2; i++ {
+		resp := s.processIdempotentRequest(ctx, http.MethodPut, path+"/deposit", key, repository.FinRequest{Sum: 50}, nil)
+		require.Equal(s.T(), http.StatusOK, resp.StatusCode)
+	}
+	err = s.store.Withdrawal(ctx, id, &repository.FinRequest{Sum: 1000})
+	require.ErrorIs(s.T(), err, repository.ErrInsufficientFunds)
+
+	require.Equal(s.T(), deposits+1, testutil.ToFloat64(metrics.MetricOperations.WithLabelValues("deposit", repository.DefaultCurrency)))
+	require.Equal(s.T(), volume+50, testutil.ToFloat64(metrics.MetricOperationVolume.WithLabelValues("deposit", repository.DefaultCurrency)))
+	require.Equal(s.T(), rejected+1, testutil.ToFloat64(metrics.MetricInsufficientFunds.WithLabelValues("withdraw")))
+	require.Equal(s.T(), replays+1, testutil.ToFloat64(metrics.MetricIdempotentReplays.WithLabelValues("deposit")))
+
+	// the operations are counted in the configured wallet currency
+	s.store.SetCurrency("KZT")
+	defer s.store.SetCurrency(repository.DefaultCurrency)
+	err = s.store.Deposit(ctx, id, &repository.FinRequest{Sum: 20})
+	require.NoError(s.T(), err)
+	require.Equal(s.T(), 1.0, testutil.ToFloat64(metrics.MetricOperations.WithLabelValues("deposit", "KZT")))
+	require.Equal(s.T(), 20.0, testutil.ToFloat64(metrics.MetricOperationVolume.WithLabelValues("deposit", "KZT")))
+	require.Equal(s.T(), deposits+1, testutil.ToFloat64(metrics.MetricOperations.WithLabelValues("deposit", repository.DefaultCurrency)))
+}
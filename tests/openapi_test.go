@@ -0,0 +1,118 @@
+package tests
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"EWallet/internal/rest"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/require"
+)
+
+var ginParam = regexp.MustCompile(`:(\w+)`)
+
+// TestOpenAPIRoutes fails when a route of rest.NewRouter is missing from api/wallet.yaml or the other way round.
+func TestOpenAPIRoutes(t *testing.T) {
+	spec, err := rest.LoadSpec()
+	require.NoError(t, err)
+	var documented []string
+	for path, item := range spec.Paths {
+		for method := range item.Operations() {
+			documented = append(documented, method+" "+path)
+		}
+	}
+	var registered []string
+	for _, route := range rest.NewRouter(logrus.New(), nil, "testsecret").Routes() {
+		registered = append(registered, route.Method+" "+ginParam.ReplaceAllString(route.Path, "{$1}"))
+	}
+	sort.Strings(documented)
+	sort.Strings(registered)
+	require.Equal(t, registered, documented)
+}
+
+// TestOpenAPIStreamed checks the operations answering with anything but JSON are marked with x-stream,
+// their responses are not checked against the spec.
+func TestOpenAPIStreamed(t *testing.T) {
+	spec, err := rest.LoadSpec()
+	require.NoError(t, err)
+	var streamed []string
+	for path, item := range spec.Paths {
+		for method, op := range item.Operations() {
+			marked, _ := op.Extensions["x-stream"].(bool)
+			if marked {
+				streamed = append(streamed, method+" "+path)
+			}
+			for code, resp := range op.Responses {
+				if !strings.HasPrefix(code, "2") || resp.Value == nil {
+					continue
+				}
+				for contentType := range resp.Value.Content {
+					if contentType != "application/json" && !marked && path != "/docs" && path != "/openapi.yaml" && path != "/metrics" {
+						t.Errorf("%s %s answers %s without x-stream", method, path, contentType)
+					}
+				}
+			}
+		}
+	}
+	sort.Strings(streamed)
+	require.Equal(t, []string{
+		"GET /api/v1/jobs/{id}/output",
+		"GET /api/v1/wallet/{id}/export",
+		"GET /api/v1/wallet/{id}/statements/{month}",
+		"GET /api/v1/wallet/{id}/stream",
+		"GET /docs/{file}",
+	}, streamed)
+}
+
+func TestOpenAPIValidation(t *testing.T) {
+	router := rest.NewRouter(logrus.New(), nil, "testsecret")
+	jwtToken, err := router.GenToken("aspan")
+	require.NoError(t, err)
+	addr := freePort(t)
+	go func() {
+		_ = router.Run(context.Background(), addr)
+	}()
+	time.Sleep(100 * time.Millisecond)
+	do := func(method, path, body string) *http.Response {
+		req, err := http.NewRequest(method, "http://"+addr+path, bytes.NewBufferString(body))
+		require.NoError(t, err)
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer "+jwtToken)
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		require.NoError(t, resp.Body.Close())
+		return resp
+	}
+
+	resp := do(http.MethodGet, "/openapi.yaml", "")
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	resp = do(http.MethodGet, "/docs", "")
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	resp = do(http.MethodGet, "/docs/swagger-ui-bundle.js", "")
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	resp = do(http.MethodGet, "/docs/missing.js", "")
+	require.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+	for _, tc := range []struct {
+		method, path, body string
+	}{
+		{http.MethodPut, "/api/v1/wallet/1/deposit", `{"sum": "ten"}`},
+		{http.MethodPut, "/api/v1/wallet/1/deposit", `{"walletTarget": 2}`},
+		{http.MethodPut, "/api/v1/wallet/abc/transfer", `{"sum": 10, "walletTarget": 2}`},
+		{http.MethodGet, "/api/v1/wallet/1/transactions?limit=many", ""},
+		{http.MethodGet, "/api/v1/wallet/1/transactions?sort=owner", ""},
+		{http.MethodPost, "/api/v1/webhooks", `{"url": "https://example.com"}`},
+		{http.MethodPost, "/auth", `{"username": "aspan"}`},
+	} {
+		resp = do(tc.method, tc.path, tc.body)
+		require.Equal(t, http.StatusBadRequest, resp.StatusCode, fmt.Sprintf("%s %s %s", tc.method, tc.path, tc.body))
+	}
+}
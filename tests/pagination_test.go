@@ -0,0 +1,112 @@
+//nolint:bodyclose
+package tests
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"EWallet/pkg/repository"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+func (s *IntegrationTestSuite) TestTransactionPages() {
+	ctx := context.Background()
+	path := s.url + "/wallet"
+	var idMap map[string]int
+	resp := s.processRequest(ctx, http.MethodPost, path, repository.Wallet{Owner: "test1", Balance: 1000}, &idMap)
+	require.Equal(s.T(), http.StatusCreated, resp.StatusCode)
+	id := idMap["id"]
+	for i := 1; i <= 5; i++ {
+		finreq := repository.FinRequest{Sum: float64(i), UUID: uuid.New().String()}
+		resp = s.processRequest(ctx, http.MethodPut, path+"/"+strconv.Itoa(id)+"/deposit", finreq, nil)
+		require.Equal(s.T(), http.StatusOK, resp.StatusCode)
+	}
+
+	transactions := path + "/" + strconv.Itoa(id) + "/transactions?sort=sum&limit=2"
+	var page repository.TransactionPage
+	resp = s.processRequest(ctx, http.MethodGet, transactions, nil, &page)
+	require.Equal(s.T(), http.StatusOK, resp.StatusCode)
+	require.Equal(s.T(), 6, page.Total, "the initial balance is a deposit too")
+	require.Len(s.T(), page.Transactions, 2)
+	require.Empty(s.T(), page.PrevCursor)
+	require.NotEmpty(s.T(), page.NextCursor)
+
+	// a transaction inserted meanwhile does not shift the following pages
+	resp = s.processRequest(ctx, http.MethodPut, path+"/"+strconv.Itoa(id)+"/deposit", repository.FinRequest{Sum: 0.5, UUID: uuid.New().String()}, nil)
+	require.Equal(s.T(), http.StatusOK, resp.StatusCode)
+
+	var next repository.TransactionPage
+	resp = s.processRequest(ctx, http.MethodGet, transactions+"&cursor="+url.QueryEscape(page.NextCursor), nil, &next)
+	require.Equal(s.T(), http.StatusOK, resp.StatusCode)
+	require.Equal(s.T(), 7, next.Total)
+	require.Len(s.T(), next.Transactions, 2)
+	require.Equal(s.T(), 3.0, next.Transactions[0].Sum)
+	require.Equal(s.T(), 4.0, next.Transactions[1].Sum)
+	require.NotEmpty(s.T(), next.PrevCursor)
+
+	var prev repository.TransactionPage
+	resp = s.processRequest(ctx, http.MethodGet, transactions+"&cursor="+url.QueryEscape(next.PrevCursor), nil, &prev)
+	require.Equal(s.T(), http.StatusOK, resp.StatusCode)
+	require.Len(s.T(), prev.Transactions, 2)
+	require.Equal(s.T(), 1.0, prev.Transactions[0].Sum)
+	require.Equal(s.T(), 2.0, prev.Transactions[1].Sum)
+	require.NotEmpty(s.T(), prev.PrevCursor)
+
+	// the cursor is bound to the order it was issued for
+	resp = s.processRequest(ctx, http.MethodGet, transactions+"&desc=true&cursor="+url.QueryEscape(page.NextCursor), nil, nil)
+	require.Equal(s.T(), http.StatusBadRequest, resp.StatusCode)
+}
+
+func (s *IntegrationTestSuite) TestTransactionFilters() {
+	ctx := context.Background()
+	path := s.url + "/wallet"
+	var idMap map[string]int
+	resp := s.processRequest(ctx, http.MethodPost, path, repository.Wallet{Owner: "test1", Balance: 1000}, &idMap)
+	require.Equal(s.T(), http.StatusCreated, resp.StatusCode)
+	id := idMap["id"]
+	resp = s.processRequest(ctx, http.MethodPost, path, repository.Wallet{Owner: "test1", Balance: 1000}, &idMap)
+	require.Equal(s.T(), http.StatusCreated, resp.StatusCode)
+	other := idMap["id"]
+
+	for _, op := range []struct {
+		wallet    int
+		operation string
+		request   repository.FinRequest
+	}{
+		{id, "deposit", repository.FinRequest{Sum: 10}},
+		{id, "withdraw", repository.FinRequest{Sum: 20}},
+		{id, "transfer", repository.FinRequest{Sum: 30, WalletTarget: other}},
+		{other, "transfer", repository.FinRequest{Sum: 40, WalletTarget: id}},
+	} {
+		op.request.UUID = uuid.New().String()
+		resp = s.processRequest(ctx, http.MethodPut, path+"/"+strconv.Itoa(op.wallet)+"/"+op.operation, op.request, nil)
+		require.Equal(s.T(), http.StatusOK, resp.StatusCode)
+	}
+
+	for _, tc := range []struct {
+		query string
+		sums  []float64
+	}{
+		{"direction=incoming", []float64{10, 40, 1000}},
+		{"direction=outgoing", []float64{20, 30}},
+		{"operation=transfer", []float64{30, 40}},
+		{"counterparty=" + strconv.Itoa(other), []float64{30, 40}},
+		{"min_sum=15&max_sum=30", []float64{20, 30}},
+		{"from=2000-01-01&to=2000-01-02", []float64{}},
+		{"operation=transfer&direction=incoming", []float64{40}},
+	} {
+		var page repository.TransactionPage
+		resp = s.processRequest(ctx, http.MethodGet, path+"/"+strconv.Itoa(id)+"/transactions?sort=sum&"+tc.query, nil, &page)
+		require.Equal(s.T(), http.StatusOK, resp.StatusCode, tc.query)
+		require.Equal(s.T(), len(tc.sums), page.Total, tc.query)
+		sums := []float64{}
+		for _, t := range page.Transactions {
+			sums = append(sums, t.Sum)
+		}
+		require.Equal(s.T(), tc.sums, sums, tc.query)
+	}
+}
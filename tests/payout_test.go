@@ -0,0 +1,135 @@
+//nolint:bodyclose
+package tests
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+
+	"EWallet/internal/rest"
+	"EWallet/pkg/repository"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+func (s *IntegrationTestSuite) TestPayouts() {
+	ctx := context.Background()
+	newWallet := func(balance float64) int {
+		id, err := s.store.CreateWallet(ctx, repository.Wallet{Owner: "payroll", Balance: balance})
+		require.NoError(s.T(), err)
+		return id
+	}
+	balance := func(id int) float64 {
+		wallet, err := s.store.GetWallet(ctx, id)
+		require.NoError(s.T(), err)
+		return wallet.Balance
+	}
+	source, first, second := newWallet(100), newWallet(0), newWallet(0)
+	frozen := newWallet(0)
+	require.NoError(s.T(), s.store.Freeze(ctx, frozen))
+	path := s.url + "/wallet/" + strconv.Itoa(source) + "/payouts"
+	item := func(target int, sum float64) repository.PayoutItem {
+		return repository.PayoutItem{WalletTarget: target, Sum: sum, UUID: uuid.New().String()}
+	}
+	// pay queues the payout and waits for its job to finish it
+	pay := func(request repository.PayoutRequest) repository.Payout {
+		var payout repository.Payout
+		resp := s.processRequest(ctx, http.MethodPost, path, request, &payout)
+		require.Equal(s.T(), http.StatusAccepted, resp.StatusCode)
+		require.Equal(s.T(), repository.PayoutProcessing, payout.Status)
+		require.NotNil(s.T(), payout.JobId)
+		job, err := s.store.GetJob(ctx, *payout.JobId)
+		require.NoError(s.T(), err)
+		require.Equal(s.T(), "aspan", job.Username)
+		require.Equal(s.T(), len(request.Items), payout.Pending)
+		require.Eventually(s.T(), func() bool {
+			resp = s.processRequest(ctx, http.MethodGet, s.url+"/payouts/"+strconv.Itoa(payout.Id), nil, &payout)
+			require.Equal(s.T(), http.StatusOK, resp.StatusCode)
+			return payout.Status != repository.PayoutProcessing
+		}, 10*time.Second, 50*time.Millisecond)
+		return payout
+	}
+
+	// the total is checked up front
+	var problem rest.Problem
+	resp := s.processRequest(ctx, http.MethodPost, path, repository.PayoutRequest{
+		Items: []repository.PayoutItem{item(first, 60), item(second, 50)},
+	}, &problem)
+	require.Equal(s.T(), http.StatusBadRequest, resp.StatusCode)
+	require.Equal(s.T(), rest.CodeInsufficientFunds, problem.Code)
+
+	// an atomic payout pays nothing when an item fails
+	payout := pay(repository.PayoutRequest{
+		Atomic: true,
+		Items:  []repository.PayoutItem{item(first, 10), item(frozen, 10)},
+	})
+	require.Equal(s.T(), repository.PayoutFailed, payout.Status)
+	require.Equal(s.T(), 2, payout.Failed)
+	require.Equal(s.T(), "wallet is frozen", *payout.Items[1].Error)
+	require.Equal(s.T(), "not paid, item 1 failed", *payout.Items[0].Error)
+	require.Equal(s.T(), 100.0, balance(source))
+	require.Equal(s.T(), 0.0, balance(first))
+
+	payout = pay(repository.PayoutRequest{
+		Atomic: true,
+		Items:  []repository.PayoutItem{item(first, 10), item(second, 20.5)},
+	})
+	require.Equal(s.T(), repository.PayoutCompleted, payout.Status)
+	require.Equal(s.T(), 30.5, payout.Total)
+	require.Equal(s.T(), 69.5, balance(source))
+	require.Equal(s.T(), 20.5, balance(second))
+
+	// best effort pays the items that can be paid
+	payout = pay(repository.PayoutRequest{
+		Items: []repository.PayoutItem{item(first, 5), item(frozen, 5), item(second, 5)},
+	})
+	require.Equal(s.T(), repository.PayoutPartiallyCompleted, payout.Status)
+	require.Equal(s.T(), 2, payout.Completed)
+	require.Equal(s.T(), 1, payout.Failed)
+	require.Equal(s.T(), repository.PayoutItemFailed, payout.Items[1].Status)
+	require.Equal(s.T(), 59.5, balance(source))
+	require.Equal(s.T(), 15.0, balance(first))
+
+	var fetched repository.Payout
+	resp = s.processRequest(ctx, http.MethodGet, s.url+"/payouts/"+strconv.Itoa(payout.Id), nil, &fetched)
+	require.Equal(s.T(), http.StatusOK, resp.StatusCode)
+	require.Equal(s.T(), payout.Status, fetched.Status)
+	require.Len(s.T(), fetched.Items, 3)
+	require.NotNil(s.T(), fetched.FinishedAt)
+
+	resp = s.processRequest(ctx, http.MethodGet, s.url+"/payouts/"+strconv.Itoa(payout.Id+1000), nil, nil)
+	require.Equal(s.T(), http.StatusNotFound, resp.StatusCode)
+
+	// an item paid before is not paid twice, even when another wallet reused its uuid since
+	paid := item(second, 1)
+	require.NoError(s.T(), s.store.Transfer(ctx, source, &repository.FinRequest{Sum: paid.Sum, WalletTarget: paid.WalletTarget, UUID: paid.UUID}))
+	require.NoError(s.T(), s.store.Transfer(ctx, first, &repository.FinRequest{Sum: 2, WalletTarget: second, UUID: paid.UUID}))
+	payout = pay(repository.PayoutRequest{Items: []repository.PayoutItem{paid}})
+	require.Equal(s.T(), repository.PayoutCompleted, payout.Status)
+	require.Equal(s.T(), 58.5, balance(source))
+	require.Equal(s.T(), 13.0, balance(first))
+	require.Equal(s.T(), 28.5, balance(second))
+
+	// an item held for review keeps the payout processing until the review resolves it
+	held := item(first, 5)
+	reviewID, err := s.store.HoldTransaction(ctx, "transfer", source, &repository.FinRequest{Sum: held.Sum, WalletTarget: held.WalletTarget, UUID: held.UUID}, "test", "held")
+	require.NoError(s.T(), err)
+	resp = s.processRequest(ctx, http.MethodPost, path, repository.PayoutRequest{Items: []repository.PayoutItem{held, item(second, 5)}}, &payout)
+	require.Equal(s.T(), http.StatusAccepted, resp.StatusCode)
+	require.Eventually(s.T(), func() bool {
+		payout, err = s.store.GetPayout(ctx, payout.Id)
+		require.NoError(s.T(), err)
+		return payout.Completed == 1
+	}, 10*time.Second, 50*time.Millisecond)
+	require.Equal(s.T(), repository.PayoutProcessing, payout.Status)
+	require.Equal(s.T(), repository.PayoutItemPendingReview, payout.Items[0].Status)
+	require.Nil(s.T(), payout.FinishedAt)
+	require.NoError(s.T(), s.app.ApproveReview(ctx, reviewID, "admin"))
+	payout, err = s.store.GetPayout(ctx, payout.Id)
+	require.NoError(s.T(), err)
+	require.Equal(s.T(), repository.PayoutCompleted, payout.Status)
+	require.Equal(s.T(), repository.PayoutItemCompleted, payout.Items[0].Status)
+	require.NotNil(s.T(), payout.FinishedAt)
+}
@@ -0,0 +1,108 @@
+package tests
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"EWallet/pkg/repository"
+	"EWallet/pkg/screening"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/require"
+)
+
+type MockHistory struct {
+	transferred    bool
+	transfers      int
+	ownerChangedAt *time.Time
+}
+
+func (m *MockHistory) HasTransferred(ctx context.Context, from, to int) (bool, error) {
+	return m.transferred, nil
+}
+
+func (m *MockHistory) CountTransfers(ctx context.Context, from int, since time.Time, maxSum float64) (int, error) {
+	return m.transfers, nil
+}
+
+func (m *MockHistory) OwnerChangedAt(ctx context.Context, id int) (*time.Time, error) {
+	return m.ownerChangedAt, nil
+}
+
+func TestScreenerAllow(t *testing.T) {
+	history := &MockHistory{transferred: true}
+	s := screening.NewScreener(logrus.New(), screening.DefaultRules(history)...)
+	decision, err := s.Screen(context.Background(), screening.Operation{Kind: "transfer", WalletID: 1, TargetID: 2, Sum: 50000})
+	require.NoError(t, err)
+	require.Equal(t, screening.Allow, decision.Verdict)
+}
+
+func TestScreenerNewTargetLargeAmount(t *testing.T) {
+	history := &MockHistory{}
+	s := screening.NewScreener(logrus.New(), screening.DefaultRules(history)...)
+	decision, err := s.Screen(context.Background(), screening.Operation{Kind: "transfer", WalletID: 1, TargetID: 2, Sum: 50000})
+	require.NoError(t, err)
+	require.Equal(t, screening.Review, decision.Verdict)
+	require.Equal(t, "new_target_large_amount", decision.Rule)
+
+	decision, err = s.Screen(context.Background(), screening.Operation{Kind: "deposit", WalletID: 1, Sum: 50000})
+	require.NoError(t, err)
+	require.Equal(t, screening.Allow, decision.Verdict)
+}
+
+func TestScreenerSmallTransfersBurst(t *testing.T) {
+	history := &MockHistory{transferred: true, transfers: screening.DefaultBurstLimit}
+	s := screening.NewScreener(logrus.New(), screening.DefaultRules(history)...)
+	decision, err := s.Screen(context.Background(), screening.Operation{Kind: "transfer", WalletID: 1, TargetID: 2, Sum: 10})
+	require.NoError(t, err)
+	require.Equal(t, screening.Review, decision.Verdict)
+	require.Equal(t, "small_transfers_burst", decision.Rule)
+}
+
+func TestScreenerRecentOwnerChange(t *testing.T) {
+	changedAt := time.Now().Add(-time.Hour)
+	history := &MockHistory{transferred: true, ownerChangedAt: &changedAt}
+	s := screening.NewScreener(logrus.New(), screening.DefaultRules(history)...)
+	decision, err := s.Screen(context.Background(), screening.Operation{Kind: "withdraw", WalletID: 1, Sum: 500})
+	require.NoError(t, err)
+	require.Equal(t, screening.Review, decision.Verdict)
+	require.Equal(t, "recent_owner_change", decision.Rule)
+}
+
+func TestScreenerDenyWins(t *testing.T) {
+	history := &MockHistory{}
+	s := screening.NewScreener(logrus.New(), screening.DefaultRules(history)...)
+	decision, err := s.Screen(context.Background(), screening.Operation{Kind: "transfer", WalletID: 1, TargetID: 2, Sum: screening.DefaultMaxAmount + 1})
+	require.NoError(t, err)
+	require.Equal(t, screening.Deny, decision.Verdict)
+	require.Equal(t, "max_amount", decision.Rule)
+}
+
+func (s *IntegrationTestSuite) TestApproveReviewRejected() {
+	ctx := context.Background()
+	id, err := s.store.CreateWallet(ctx, repository.Wallet{Owner: "test1", Balance: 100})
+	require.NoError(s.T(), err)
+	reviewID, err := s.store.HoldTransaction(ctx, "withdraw", id, &repository.FinRequest{Sum: 500, UUID: "0d5c1a3b-d9d2-11ec-abbd-0242ac150001"}, "test", "held")
+	require.NoError(s.T(), err)
+
+	// the money can't be moved: the review is approved and its transaction fails together
+	err = s.app.ApproveReview(ctx, reviewID, "admin")
+	require.ErrorIs(s.T(), err, repository.ErrInsufficientFunds)
+	review, err := s.store.GetReview(ctx, reviewID)
+	require.NoError(s.T(), err)
+	require.Equal(s.T(), repository.ReviewApproved, review.Status)
+	t, err := s.store.GetTransactionByUUID(ctx, id, "withdraw", "0d5c1a3b-d9d2-11ec-abbd-0242ac150001")
+	require.NoError(s.T(), err)
+	require.Equal(s.T(), repository.StatusFailed, t.Status)
+	require.ErrorIs(s.T(), s.app.RejectReview(ctx, reviewID, "admin"), repository.ErrReviewNotPending)
+
+	reviewID, err = s.store.HoldTransaction(ctx, "withdraw", id, &repository.FinRequest{Sum: 50, UUID: "0d5c1a3b-d9d2-11ec-abbd-0242ac150002"}, "test", "held")
+	require.NoError(s.T(), err)
+	require.NoError(s.T(), s.app.ApproveReview(ctx, reviewID, "admin"))
+	wallet, err := s.store.GetWallet(ctx, id)
+	require.NoError(s.T(), err)
+	require.Equal(s.T(), 50.0, wallet.Balance)
+	require.ErrorIs(s.T(), s.app.ApproveReview(ctx, reviewID, "admin"), repository.ErrReviewNotPending)
+	require.ErrorIs(s.T(), s.app.ApproveReview(ctx, 1<<30, "admin"), repository.ErrReviewNotFound)
+}
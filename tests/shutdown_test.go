@@ -0,0 +1,116 @@
+package tests
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"EWallet/internal/rest"
+	"EWallet/pkg/repository"
+
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/require"
+)
+
+// BlockingApp holds transfers until release is closed, other methods of rest.App except the
+// idempotency ones are not served.
+type BlockingApp struct {
+	rest.App
+	started chan struct{}
+	release chan struct{}
+}
+
+func (b *BlockingApp) Transfer(ctx context.Context, id int, request *repository.FinRequest) error {
+	b.started <- struct{}{}
+	<-b.release
+	return nil
+}
+
+func (b *BlockingApp) BeginIdempotent(ctx context.Context, scope repository.IdempotencyScope, key, fingerprint string) (*repository.IdempotencyKey, string, error) {
+	return nil, "token", nil
+}
+
+func (b *BlockingApp) FinishIdempotent(ctx context.Context, scope repository.IdempotencyScope, key, token string, statusCode int, response []byte) error {
+	return nil
+}
+
+func startTransfer(t *testing.T, addr, token string) <-chan *http.Response {
+	t.Helper()
+	req, err := http.NewRequest(http.MethodPut, "http://"+addr+"/api/v1/wallet/1/transfer", strings.NewReader(`{"sum":10,"walletTarget":2,"uuid":"`+uuid.New().String()+`"}`))
+	require.NoError(t, err)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+token)
+	done := make(chan *http.Response, 1)
+	go func() {
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			done <- nil
+			return
+		}
+		_ = resp.Body.Close()
+		done <- resp
+	}()
+	return done
+}
+
+func TestGracefulShutdown(t *testing.T) {
+	app := &BlockingApp{started: make(chan struct{}, 1), release: make(chan struct{})}
+	router := rest.NewRouter(logrus.New(), app, "testsecret")
+	jwtToken, err := router.GenToken("aspan")
+	require.NoError(t, err)
+	addr := freePort(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	stopped := make(chan error, 1)
+	go func() {
+		stopped <- router.Run(ctx, addr)
+	}()
+	time.Sleep(100 * time.Millisecond)
+
+	// the transfer in flight when the shutdown starts completes
+	done := startTransfer(t, addr, jwtToken)
+	<-app.started
+	cancel()
+	time.Sleep(50 * time.Millisecond)
+	_, err = http.Get("http://" + addr + "/openapi.yaml")
+	require.Error(t, err, "new connections are refused while draining")
+	select {
+	case <-stopped:
+		t.Fatal("the server stopped before the transfer completed")
+	default:
+	}
+	close(app.release)
+	resp := <-done
+	require.NotNil(t, resp)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.NoError(t, <-stopped)
+}
+
+func TestGracefulShutdownDeadline(t *testing.T) {
+	app := &BlockingApp{started: make(chan struct{}, 1), release: make(chan struct{})}
+	defer close(app.release)
+	router := rest.NewRouter(logrus.New(), app, "testsecret")
+	timeouts := rest.DefaultServerTimeouts
+	timeouts.Shutdown = 100 * time.Millisecond
+	router.SetServerTimeouts(timeouts)
+	jwtToken, err := router.GenToken("aspan")
+	require.NoError(t, err)
+	addr := freePort(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	stopped := make(chan error, 1)
+	go func() {
+		stopped <- router.Run(ctx, addr)
+	}()
+	time.Sleep(100 * time.Millisecond)
+
+	// a request still running after the drain deadline is cut off
+	done := startTransfer(t, addr, jwtToken)
+	<-app.started
+	cancel()
+	require.ErrorIs(t, <-stopped, context.DeadlineExceeded)
+	require.Nil(t, <-done)
+}
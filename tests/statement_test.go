@@ -0,0 +1,180 @@
+//nolint:bodyclose
+package tests
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"EWallet/pkg/export"
+	"EWallet/pkg/repository"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+func (s *IntegrationTestSuite) TestStatement() {
+	ctx := context.Background()
+	path := s.url + "/wallet"
+	from := time.Now().Add(-time.Minute)
+	var idMap map[string]int
+	resp := s.processRequest(ctx, http.MethodPost, path, repository.Wallet{Owner: "test1", Balance: 100}, &idMap)
+	require.Equal(s.T(), http.StatusCreated, resp.StatusCode)
+	id := idMap["id"]
+	resp = s.processRequest(ctx, http.MethodPost, path, repository.Wallet{Owner: "test1", Balance: 100}, &idMap)
+	require.Equal(s.T(), http.StatusCreated, resp.StatusCode)
+	other := idMap["id"]
+
+	for _, op := range []struct {
+		wallet    int
+		operation string
+		request   repository.FinRequest
+	}{
+		{id, "deposit", repository.FinRequest{Sum: 50.25}},
+		{id, "withdraw", repository.FinRequest{Sum: 30.1}},
+		{id, "transfer", repository.FinRequest{Sum: 20, WalletTarget: other}},
+		{other, "transfer", repository.FinRequest{Sum: 10, WalletTarget: id}},
+	} {
+		op.request.UUID = uuid.New().String()
+		resp = s.processRequest(ctx, http.MethodPut, path+"/"+strconv.Itoa(op.wallet)+"/"+op.operation, op.request, nil)
+		require.Equal(s.T(), http.StatusOK, resp.StatusCode)
+	}
+
+	var statement repository.Statement
+	resp = s.processRequest(ctx, http.MethodGet, path+"/"+strconv.Itoa(id)+"/statement?from="+url.QueryEscape(from.Format(time.RFC3339)), nil, &statement)
+	require.Equal(s.T(), http.StatusOK, resp.StatusCode)
+	// the balance the wallet was created with is its first movement
+	require.Equal(s.T(), 0.0, statement.OpeningBalance)
+	require.Equal(s.T(), 160.25, statement.TotalCredits)
+	require.Equal(s.T(), 50.1, statement.TotalDebits)
+	require.Equal(s.T(), 110.15, statement.ClosingBalance)
+	require.Len(s.T(), statement.Entries, 5)
+	require.Equal(s.T(), []float64{100, 50.25, -30.1, -20, 10}, []float64{
+		statement.Entries[0].Amount, statement.Entries[1].Amount, statement.Entries[2].Amount, statement.Entries[3].Amount, statement.Entries[4].Amount,
+	})
+	require.Equal(s.T(), "deposit", statement.Entries[0].Operation)
+	require.Equal(s.T(), 100.0, statement.Entries[0].Balance)
+	require.Equal(s.T(), 150.25, statement.Entries[1].Balance)
+	require.Equal(s.T(), statement.ClosingBalance, statement.Entries[4].Balance)
+	require.Equal(s.T(), other, *statement.Entries[4].Counterparty)
+
+	var wallet repository.Wallet
+	resp = s.processRequest(ctx, http.MethodGet, path+"/"+strconv.Itoa(id), nil, &wallet)
+	require.Equal(s.T(), http.StatusOK, resp.StatusCode)
+	require.Equal(s.T(), wallet.Balance, statement.ClosingBalance)
+
+	// a period after the last movement opens and closes with the current balance
+	later := time.Now().Add(time.Hour)
+	query := "?from=" + url.QueryEscape(later.Format(time.RFC3339)) + "&to=" + url.QueryEscape(later.Add(time.Hour).Format(time.RFC3339))
+	resp = s.processRequest(ctx, http.MethodGet, path+"/"+strconv.Itoa(id)+"/statement"+query, nil, &statement)
+	require.Equal(s.T(), http.StatusOK, resp.StatusCode)
+	require.Equal(s.T(), wallet.Balance, statement.OpeningBalance)
+	require.Equal(s.T(), wallet.Balance, statement.ClosingBalance)
+	require.Empty(s.T(), statement.Entries)
+
+	resp = s.processRequest(ctx, http.MethodGet, path+"/"+strconv.Itoa(id)+"/statement", nil, nil)
+	require.Equal(s.T(), http.StatusBadRequest, resp.StatusCode)
+}
+
+func (s *IntegrationTestSuite) TestExportStatement() {
+	ctx := context.Background()
+	id, err := s.store.CreateWallet(ctx, repository.Wallet{Owner: "aspan", Balance: 100})
+	require.NoError(s.T(), err)
+	require.NoError(s.T(), s.store.Deposit(ctx, id, &repository.FinRequest{Sum: 12.5, UUID: uuid.New().String()}))
+
+	download := func(query, accept string) (*http.Response, string) {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.url+"/wallet/"+strconv.Itoa(id)+"/export"+query, nil)
+		require.NoError(s.T(), err)
+		req.Header.Set("Authorization", "Bearer "+token)
+		if accept != "" {
+			req.Header.Set("Accept", accept)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(s.T(), err)
+		body, err := io.ReadAll(resp.Body)
+		require.NoError(s.T(), err)
+		require.NoError(s.T(), resp.Body.Close())
+		return resp, string(body)
+	}
+
+	resp, body := download("?columns=operation,amount,balance", "")
+	require.Equal(s.T(), http.StatusOK, resp.StatusCode)
+	require.Equal(s.T(), "text/csv; charset=utf-8", resp.Header.Get("Content-Type"))
+	require.Contains(s.T(), resp.Header.Get("Content-Disposition"), "attachment")
+	require.Equal(s.T(), "operation,amount,balance\ndeposit,100.00,100.00\ndeposit,12.50,112.50\n", body)
+
+	resp, body = download("", "application/x-ofx")
+	require.Equal(s.T(), http.StatusOK, resp.StatusCode)
+	require.Equal(s.T(), "application/x-ofx", resp.Header.Get("Content-Type"))
+	require.Contains(s.T(), body, "<TRNAMT>12.50</TRNAMT>")
+
+	resp, body = download("?format=camt053", "text/csv")
+	require.Equal(s.T(), http.StatusOK, resp.StatusCode)
+	require.Contains(s.T(), body, "<Cd>CLBD</Cd>")
+
+	resp, body = download("?format=pdf", "")
+	require.Equal(s.T(), http.StatusOK, resp.StatusCode)
+	require.Equal(s.T(), "application/pdf", resp.Header.Get("Content-Type"))
+	require.True(s.T(), strings.HasPrefix(body, "%PDF-"))
+
+	resp, _ = download("?columns=secret", "")
+	require.Equal(s.T(), http.StatusBadRequest, resp.StatusCode)
+}
+
+func (s *IntegrationTestSuite) TestMonthlyStatements() {
+	ctx := context.Background()
+	id, err := s.store.CreateWallet(ctx, repository.Wallet{Owner: "aspan", Balance: 100})
+	require.NoError(s.T(), err)
+	require.NoError(s.T(), s.store.Deposit(ctx, id, &repository.FinRequest{Sum: 12.5, UUID: uuid.New().String()}))
+
+	now := time.Now().UTC()
+	month := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+	cnt, err := s.app.GenerateMonthlyStatements(ctx, month)
+	require.NoError(s.T(), err)
+	require.Positive(s.T(), cnt)
+	// statements already stored are skipped
+	cnt, err = s.app.GenerateMonthlyStatements(ctx, month)
+	require.NoError(s.T(), err)
+	require.Zero(s.T(), cnt)
+
+	path := s.url + "/wallet/" + strconv.Itoa(id) + "/statements"
+	var docs []repository.StatementDocument
+	resp := s.processRequest(ctx, http.MethodGet, path, nil, &docs)
+	require.Equal(s.T(), http.StatusOK, resp.StatusCode)
+	require.Len(s.T(), docs, 1)
+	require.True(s.T(), month.Equal(docs[0].PeriodStart))
+
+	statement, err := s.store.GetStatement(ctx, id, month, month.AddDate(0, 1, 0))
+	require.NoError(s.T(), err)
+	require.Equal(s.T(), export.Sign([]byte("statementkey"), statement), docs[0].Signature)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, path+"/"+month.Format("2006-01"), nil)
+	require.NoError(s.T(), err)
+	req.Header.Set("Authorization", "Bearer "+token)
+	resp, err = http.DefaultClient.Do(req)
+	require.NoError(s.T(), err)
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(s.T(), err)
+	require.NoError(s.T(), resp.Body.Close())
+	require.Equal(s.T(), http.StatusOK, resp.StatusCode)
+	require.Equal(s.T(), "application/pdf", resp.Header.Get("Content-Type"))
+	require.Equal(s.T(), docs[0].Signature, resp.Header.Get("X-Statement-Signature"))
+	require.Len(s.T(), body, docs[0].Size)
+
+	resp = s.processRequest(ctx, http.MethodGet, path+"/"+month.AddDate(-1, 0, 0).Format("2006-01"), nil, nil)
+	require.Equal(s.T(), http.StatusNotFound, resp.StatusCode)
+
+	// no wallet of the suite existed last month, so there is no statement to generate and nothing is queued
+	runCtx, cancel := context.WithTimeout(ctx, 100*time.Millisecond)
+	defer cancel()
+	s.app.RunMonthlyStatements(runCtx, 10*time.Millisecond)
+	jobs, err := s.store.GetJobs(ctx, "", repository.JobMonthlyStatements, 1000)
+	require.NoError(s.T(), err)
+	for _, job := range jobs {
+		require.NotEqual(s.T(), repository.JobMonthlyStatements+":"+month.AddDate(0, -1, 0).Format("2006-01"), *job.Key)
+	}
+}
@@ -0,0 +1,139 @@
+package tests
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"EWallet/pkg/events"
+	"EWallet/pkg/repository"
+
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEventTouches(t *testing.T) {
+	target := 2
+	e := events.Event{WalletId: 1, TargetId: &target, Type: events.Transferred, Payload: json.RawMessage(`{"from_id":1,"to_id":2}`)}
+	require.True(t, e.Touches(1))
+	require.True(t, e.Touches(2))
+	require.False(t, e.Touches(3))
+}
+
+func TestHubBroadcast(t *testing.T) {
+	hub := events.NewHub(logrus.New(), nil)
+	sub := hub.Subscribe(2)
+	other := hub.Subscribe(3)
+	target := 2
+	hub.Broadcast(events.Event{Id: 1, WalletId: 1, TargetId: &target, Type: events.Transferred, Payload: json.RawMessage(`{"to_id":2}`)})
+	e := <-sub.C
+	require.Equal(t, int64(1), e.Id)
+	require.Len(t, other.C, 0)
+	hub.Unsubscribe(other)
+	_, ok := <-other.C
+	require.False(t, ok)
+
+	// a subscriber that falls behind is dropped and has to resume
+	for i := 0; i < 100; i++ {
+		hub.Broadcast(events.Event{Id: int64(i + 2), WalletId: 2, Type: events.Deposited, Payload: json.RawMessage(`{}`)})
+	}
+	cnt := 0
+	for range sub.C {
+		cnt++
+	}
+	require.Less(t, cnt, 100)
+	hub.Unsubscribe(sub)
+}
+
+type sseEvent struct {
+	id    string
+	event string
+	data  string
+}
+
+func readSSE(sc *bufio.Scanner) (sseEvent, error) {
+	var e sseEvent
+	for sc.Scan() {
+		line := sc.Text()
+		switch {
+		case line == "":
+			if e.event != "" {
+				return e, nil
+			}
+		case strings.HasPrefix(line, "id:"):
+			e.id = strings.TrimPrefix(line, "id:")
+		case strings.HasPrefix(line, "event:"):
+			e.event = strings.TrimPrefix(line, "event:")
+		case strings.HasPrefix(line, "data:"):
+			e.data = strings.TrimPrefix(line, "data:")
+		}
+	}
+	return e, fmt.Errorf("stream closed: %v", sc.Err())
+}
+
+func (s *IntegrationTestSuite) openStream(ctx context.Context, id int, lastEventID string) *http.Response {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, fmt.Sprintf("%s/wallet/%d/stream", s.url, id), nil)
+	require.NoError(s.T(), err)
+	req.Header.Set("Authorization", "Bearer "+token)
+	if lastEventID != "" {
+		req.Header.Set("Last-Event-ID", lastEventID)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(s.T(), err)
+	return resp
+}
+
+func (s *IntegrationTestSuite) TestWalletStream() {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	id, err := s.store.CreateWallet(ctx, repository.Wallet{Owner: "aspan", Balance: 100})
+	require.NoError(s.T(), err)
+	other, err := s.store.CreateWallet(ctx, repository.Wallet{Owner: "test1", Balance: 100})
+	require.NoError(s.T(), err)
+
+	resp := s.openStream(ctx, other, "")
+	require.Equal(s.T(), http.StatusForbidden, resp.StatusCode)
+	require.NoError(s.T(), resp.Body.Close())
+
+	resp = s.openStream(ctx, id, "")
+	require.Equal(s.T(), http.StatusOK, resp.StatusCode)
+	sc := bufio.NewScanner(resp.Body)
+	e, err := readSSE(sc)
+	require.NoError(s.T(), err)
+	require.Equal(s.T(), events.WalletCreated, e.event)
+	created := e.id
+	e, err = readSSE(sc)
+	require.NoError(s.T(), err)
+	require.Equal(s.T(), events.Deposited, e.event, "the initial balance")
+	e, err = readSSE(sc)
+	require.NoError(s.T(), err)
+	require.Equal(s.T(), "balance", e.event)
+
+	err = s.store.Transfer(ctx, other, &repository.FinRequest{Sum: 10, WalletTarget: id, UUID: uuid.New().String()})
+	require.NoError(s.T(), err)
+	e, err = readSSE(sc)
+	require.NoError(s.T(), err)
+	require.Equal(s.T(), events.Transferred, e.event)
+	e, err = readSSE(sc)
+	require.NoError(s.T(), err)
+	require.Equal(s.T(), "balance", e.event)
+	require.JSONEq(s.T(), fmt.Sprintf(`{"wallet_id":%d,"balance":110}`, id), e.data)
+	require.NoError(s.T(), resp.Body.Close())
+
+	// resume after the creation replays the initial deposit and the transfer
+	resp = s.openStream(ctx, id, created)
+	defer resp.Body.Close()
+	sc = bufio.NewScanner(resp.Body)
+	e, err = readSSE(sc)
+	require.NoError(s.T(), err)
+	require.Equal(s.T(), events.Deposited, e.event)
+	e, err = readSSE(sc)
+	require.NoError(s.T(), err)
+	require.Equal(s.T(), events.Transferred, e.event)
+}
@@ -0,0 +1,151 @@
+package tests
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"EWallet/internal"
+	"EWallet/internal/rest"
+	"EWallet/pkg/exchange"
+	"EWallet/pkg/logger"
+	"EWallet/pkg/repository"
+	"EWallet/pkg/tracing"
+
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+var (
+	spans          = tracetest.NewInMemoryExporter()
+	installTracing sync.Once
+)
+
+// recordSpans makes the spans ended from now on recorded in the returned exporter. The provider is
+// installed once: instrumentation created before keeps the first provider installed.
+func recordSpans() *tracetest.InMemoryExporter {
+	installTracing.Do(func() {
+		tracing.Install(sdktrace.NewTracerProvider(sdktrace.WithSyncer(spans)))
+	})
+	spans.Reset()
+	return spans
+}
+
+// span returns the recorded span with name.
+func span(t *testing.T, exporter *tracetest.InMemoryExporter, name string) tracetest.SpanStub {
+	for _, s := range exporter.GetSpans() {
+		if s.Name == name {
+			return s
+		}
+	}
+	require.Failf(t, "span not recorded", "no span %q", name)
+	return tracetest.SpanStub{}
+}
+
+// WalletStore is a store holding the wallets 1 to 9.
+type WalletStore struct {
+	internal.Storage
+}
+
+func (m *WalletStore) GetWallet(ctx context.Context, id int) (repository.Wallet, error) {
+	if id >= 10 {
+		return repository.Wallet{}, repository.ErrWalletNotFound
+	}
+	return repository.Wallet{Owner: "aspan", Balance: 10}, nil
+}
+
+func TestTracing(t *testing.T) {
+	exporter := recordSpans()
+	traceparents := make(chan string, 1)
+	provider := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		traceparents <- r.Header.Get("traceparent")
+		_, _ = fmt.Fprint(w, `{"success": true, "result": 20}`)
+	}))
+	defer provider.Close()
+	log, err := logger.New("info", "json")
+	require.NoError(t, err)
+	out := &LogBuffer{}
+	log.SetOutput(out)
+	exch := exchange.NewExchangeRate(log, provider.URL+"/convert?to=", "key", time.Second)
+	app := internal.NewApp(log, &WalletStore{}, exch, nil, time.Hour, nil)
+	router := rest.NewRouter(log, app, "testsecret")
+	jwtToken, err := router.GenToken("aspan")
+	require.NoError(t, err)
+	addr := freePort(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		_ = router.Run(ctx, addr)
+	}()
+	time.Sleep(100 * time.Millisecond)
+	get := func(path string) int {
+		req, err := http.NewRequest(http.MethodGet, "http://"+addr+path, nil)
+		require.NoError(t, err)
+		req.Header.Set("Authorization", "Bearer "+jwtToken)
+		req.Header.Set("X-Request-ID", "req-traced")
+		// the caller's trace is continued
+		req.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		require.NoError(t, resp.Body.Close())
+		return resp.StatusCode
+	}
+
+	require.Equal(t, http.StatusOK, get("/api/v1/wallet/7?currency=usd"))
+	require.Eventually(t, func() bool { return len(exporter.GetSpans()) == 3 }, time.Second, 5*time.Millisecond)
+	server := span(t, exporter, "/api/v1/wallet/:id")
+	method := span(t, exporter, "App.GetWallet")
+	client := span(t, exporter, "exchange GET")
+	require.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", server.SpanContext.TraceID().String())
+	require.Equal(t, "00f067aa0ba902b7", server.Parent.SpanID().String())
+	require.Contains(t, server.Attributes, attribute.String("request.id", "req-traced"))
+	require.Equal(t, server.SpanContext.SpanID(), method.Parent.SpanID())
+	require.Contains(t, method.Attributes, attribute.Int("wallet.id", 7))
+	require.Equal(t, method.SpanContext.SpanID(), client.Parent.SpanID())
+	// the exchange API gets the trace context of the call
+	require.Equal(t, "00-"+client.SpanContext.TraceID().String()+"-"+client.SpanContext.SpanID().String()+"-01", <-traceparents)
+	access := out.lines("request")
+	require.Len(t, access, 1)
+	require.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", access[0]["trace_id"])
+	require.Equal(t, server.SpanContext.SpanID().String(), access[0]["span_id"])
+
+	// failed operations mark their spans
+	exporter.Reset()
+	require.Equal(t, http.StatusNotFound, get("/api/v1/wallet/404"))
+	require.Eventually(t, func() bool { return len(exporter.GetSpans()) == 2 }, time.Second, 5*time.Millisecond)
+	method = span(t, exporter, "App.GetWallet")
+	require.Equal(t, codes.Error, method.Status.Code)
+	require.Len(t, method.Events, 1, "the error is recorded")
+
+	// probes are not traced
+	exporter.Reset()
+	probe, err := http.Get("http://" + addr + "/healthz")
+	require.NoError(t, err)
+	require.NoError(t, probe.Body.Close())
+	time.Sleep(50 * time.Millisecond)
+	require.Empty(t, exporter.GetSpans())
+}
+
+func (s *IntegrationTestSuite) TestQuerySpans() {
+	exporter := recordSpans()
+	ctx, parent := tracing.Start(context.Background(), "test")
+	id, err := s.store.CreateWallet(ctx, repository.Wallet{Owner: "traced", Balance: 1})
+	require.NoError(s.T(), err)
+	_, err = s.store.GetWallet(ctx, id)
+	require.NoError(s.T(), err)
+	parent.End()
+	queries := 0
+	for _, sp := range exporter.GetSpans() {
+		if sp.Parent.SpanID() == parent.SpanContext().SpanID() && sp.Name != "test" {
+			queries++
+		}
+	}
+	require.GreaterOrEqual(s.T(), queries, 2, "every query is a child span of the operation")
+}
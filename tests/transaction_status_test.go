@@ -0,0 +1,72 @@
+//nolint:bodyclose
+package tests
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+
+	"EWallet/pkg/repository"
+
+	"github.com/stretchr/testify/require"
+)
+
+func (s *IntegrationTestSuite) TestFailedWithdrawIsRecorded() {
+	ctx := context.Background()
+	path := s.url + "/wallet"
+	wallet := repository.Wallet{
+		Owner:   "test1",
+		Balance: 100,
+	}
+	finreq := repository.FinRequest{
+		Sum:  1000.0,
+		UUID: "a1eb5a3b-d9d2-11ec-abbd-0242ac150001",
+	}
+	var idMap map[string]int
+	resp := s.processRequest(ctx, http.MethodPost, path, wallet, &idMap)
+	require.Equal(s.T(), http.StatusCreated, resp.StatusCode)
+	id, ok := idMap["id"]
+	require.True(s.T(), ok)
+
+	resp = s.processRequest(ctx, http.MethodPut, path+"/"+strconv.Itoa(id)+"/withdraw", finreq, nil)
+	require.Equal(s.T(), http.StatusBadRequest, resp.StatusCode)
+
+	var ans repository.TransactionPage
+	resp = s.processRequest(ctx, http.MethodGet, path+"/"+strconv.Itoa(id)+"/transactions?status=failed", nil, &ans)
+	require.Equal(s.T(), http.StatusOK, resp.StatusCode)
+	require.Len(s.T(), ans.Transactions, 1)
+	require.Equal(s.T(), repository.StatusFailed, ans.Transactions[0].Status)
+	require.NotNil(s.T(), ans.Transactions[0].Reason)
+
+	// the uuid of a failed attempt can be retried
+	finreq.Sum = 50
+	resp = s.processRequest(ctx, http.MethodPut, path+"/"+strconv.Itoa(id)+"/withdraw", finreq, nil)
+	require.Equal(s.T(), http.StatusOK, resp.StatusCode)
+
+	resp = s.processRequest(ctx, http.MethodGet, path+"/"+strconv.Itoa(id)+"/transactions?status=completed&operation=withdraw", nil, &ans)
+	require.Equal(s.T(), http.StatusOK, resp.StatusCode)
+	require.Len(s.T(), ans.Transactions, 1)
+
+	var statuses []repository.TransactionStatus
+	resp = s.processRequest(ctx, http.MethodGet, s.url+"/transactions/"+strconv.Itoa(ans.Transactions[0].Id)+"/statuses", nil, &statuses)
+	require.Equal(s.T(), http.StatusOK, resp.StatusCode)
+	require.Len(s.T(), statuses, 1)
+	require.Equal(s.T(), repository.StatusCompleted, statuses[0].Status)
+}
+
+func (s *IntegrationTestSuite) TestGetTransactionBadStatus() {
+	ctx := context.Background()
+	path := s.url + "/wallet"
+	wallet := repository.Wallet{
+		Owner:   "test1",
+		Balance: 100,
+	}
+	var idMap map[string]int
+	resp := s.processRequest(ctx, http.MethodPost, path, wallet, &idMap)
+	require.Equal(s.T(), http.StatusCreated, resp.StatusCode)
+	id, ok := idMap["id"]
+	require.True(s.T(), ok)
+
+	resp = s.processRequest(ctx, http.MethodGet, path+"/"+strconv.Itoa(id)+"/transactions?status=lost", nil, nil)
+	require.Equal(s.T(), http.StatusBadRequest, resp.StatusCode)
+}
@@ -0,0 +1,137 @@
+package tests
+
+import (
+	"testing"
+	"time"
+
+	"EWallet/pkg/models"
+	"EWallet/pkg/repository"
+	"EWallet/pkg/validation"
+
+	"github.com/stretchr/testify/require"
+)
+
+func requireFieldError(t *testing.T, fields []validation.FieldError, field, message string) {
+	t.Helper()
+	require.Len(t, fields, 1)
+	require.Equal(t, field, fields[0].Field)
+	require.Equal(t, message, fields[0].Message)
+}
+
+func TestValidateWallet(t *testing.T) {
+	require.Empty(t, validation.Validate(&models.WalletRequest{Owner: "aspan", Balance: 100.5}))
+	require.Empty(t, validation.Validate(&models.WalletRequest{Owner: "aspan"}))
+
+	for _, tc := range []struct {
+		name    string
+		wallet  models.WalletRequest
+		field   string
+		message string
+	}{
+		{"empty owner", models.WalletRequest{Balance: 1}, "owner", "is required"},
+		{"blank owner", models.WalletRequest{Owner: "   "}, "owner", "is required"},
+		{"long owner", models.WalletRequest{Owner: string(make([]byte, 256))}, "owner", "must be at most 255 characters long"},
+		{"negative balance", models.WalletRequest{Owner: "aspan", Balance: -1}, "balance", "must be at least 0"},
+		{"balance over max", models.WalletRequest{Owner: "aspan", Balance: 100000000}, "balance", "must be at most 99999999.99"},
+		{"balance precision", models.WalletRequest{Owner: "aspan", Balance: 1.001}, "balance", "must have at most 2 decimal places"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			requireFieldError(t, validation.Validate(&tc.wallet), tc.field, tc.message)
+		})
+	}
+}
+
+func TestValidateOperation(t *testing.T) {
+	require.Empty(t, validation.ValidateOperation("deposit", 1, &models.OperationRequest{Sum: 0.01}))
+	require.Empty(t, validation.ValidateOperation("withdraw", 1, &models.OperationRequest{Sum: 99999999.99}))
+	require.Empty(t, validation.ValidateOperation("transfer", 1, &models.OperationRequest{Sum: 10, WalletTarget: 2}))
+
+	for _, tc := range []struct {
+		name      string
+		operation string
+		request   models.OperationRequest
+		field     string
+		message   string
+	}{
+		{"zero sum", "deposit", models.OperationRequest{}, "sum", "must be greater than 0"},
+		{"negative sum", "withdraw", models.OperationRequest{Sum: -5}, "sum", "must be greater than 0"},
+		{"sum over max", "deposit", models.OperationRequest{Sum: 100000000}, "sum", "must be at most 99999999.99"},
+		{"sum precision", "deposit", models.OperationRequest{Sum: 0.005}, "sum", "must have at most 2 decimal places"},
+		{"negative target", "deposit", models.OperationRequest{Sum: 1, WalletTarget: -1}, "walletTarget", "must be at least 0"},
+		{"missing target", "transfer", models.OperationRequest{Sum: 1}, "walletTarget", "is required"},
+		{"same wallet", "transfer", models.OperationRequest{Sum: 1, WalletTarget: 1}, "walletTarget", "must differ from the source wallet"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			requireFieldError(t, validation.ValidateOperation(tc.operation, 1, &tc.request), tc.field, tc.message)
+		})
+	}
+}
+
+func TestValidateQueryParams(t *testing.T) {
+	require.Empty(t, validation.Validate(&models.TransactionQueryParams{}))
+	from, to := time.Date(2024, 10, 1, 0, 0, 0, 0, time.UTC), time.Date(2024, 11, 1, 0, 0, 0, 0, time.UTC)
+	require.Empty(t, validation.Validate(&models.TransactionQueryParams{
+		Limit: 1000, Sort: "sum", Status: repository.StatusCompleted, From: &from, To: &to,
+		Operation: "transfer", Direction: repository.DirectionIncoming, MinSum: 10, MaxSum: 10, Counterparty: 2,
+	}))
+
+	for _, tc := range []struct {
+		name    string
+		params  models.TransactionQueryParams
+		field   string
+		message string
+	}{
+		{"negative limit", models.TransactionQueryParams{Limit: -1}, "limit", "must be at least 0"},
+		{"limit over max", models.TransactionQueryParams{Limit: 1001}, "limit", "must be at most 1000"},
+		{"unknown sort", models.TransactionQueryParams{Sort: "owner"}, "sort", "must be one of: date, sum"},
+		{"unknown status", models.TransactionQueryParams{Status: "lost"}, "status", "must be one of: pending, completed, failed, reversed"},
+		{"unknown operation", models.TransactionQueryParams{Operation: "refund"}, "operation", "must be one of: deposit, withdraw, transfer"},
+		{"unknown direction", models.TransactionQueryParams{Direction: "both"}, "direction", "must be one of: incoming, outgoing"},
+		{"empty date range", models.TransactionQueryParams{From: &to, To: &from}, "to", "must be after from"},
+		{"negative min sum", models.TransactionQueryParams{MinSum: -1}, "min_sum", "must be at least 0"},
+		{"empty amount range", models.TransactionQueryParams{MinSum: 10, MaxSum: 5}, "max_sum", "must be at least min_sum"},
+		{"negative counterparty", models.TransactionQueryParams{Counterparty: -1}, "counterparty", "must be at least 0"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			requireFieldError(t, validation.Validate(&tc.params), tc.field, tc.message)
+		})
+	}
+}
+
+func TestValidatePayout(t *testing.T) {
+	const (
+		uuid1 = "9d3c4a8e-6f1b-4d2a-8c5e-2b7f0a1d3e01"
+		uuid2 = "9d3c4a8e-6f1b-4d2a-8c5e-2b7f0a1d3e02"
+	)
+	valid := func() models.PayoutRequest {
+		return models.PayoutRequest{Items: []models.PayoutItem{
+			{WalletTarget: 2, Sum: 10, UUID: uuid1},
+			{WalletTarget: 3, Sum: 5.5, UUID: uuid2},
+		}}
+	}
+	request := valid()
+	require.Empty(t, validation.ValidatePayout(1, &request))
+
+	for _, tc := range []struct {
+		name    string
+		edit    func(*models.PayoutRequest)
+		field   string
+		message string
+	}{
+		{"no items", func(r *models.PayoutRequest) { r.Items = nil }, "items", "is required"},
+		{"too many items", func(r *models.PayoutRequest) { r.Items = make([]models.PayoutItem, 1001) }, "items", "must have at most 1000 items"},
+		{"sum", func(r *models.PayoutRequest) { r.Items[1].Sum = 0 }, "items[1].sum", "must be greater than 0"},
+		{"uuid", func(r *models.PayoutRequest) { r.Items[0].UUID = "abc" }, "items[0].uuid", "must be a UUID"},
+		{"source target", func(r *models.PayoutRequest) { r.Items[1].WalletTarget = 1 }, "items[1].walletTarget", "must differ from the source wallet"},
+		{"repeated uuid", func(r *models.PayoutRequest) { r.Items[1].UUID = uuid1 }, "items[1].uuid", "is used by item 0"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			request := valid()
+			tc.edit(&request)
+			fields := validation.ValidatePayout(1, &request)
+			require.NotEmpty(t, fields)
+			require.Equal(t, tc.field, fields[0].Field)
+			require.Equal(t, tc.message, fields[0].Message)
+		})
+	}
+}
@@ -0,0 +1,172 @@
+package tests
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"EWallet/internal/rest"
+	"EWallet/pkg/events"
+	"EWallet/pkg/repository"
+	"EWallet/pkg/webhooks"
+
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/require"
+)
+
+type MockWebhookStore struct {
+	deliveries []repository.WebhookDelivery
+	attempts   []repository.WebhookAttempt
+	statuses   []string
+}
+
+func (m *MockWebhookStore) EnqueueWebhookDeliveries(ctx context.Context, e events.Event) error {
+	return nil
+}
+
+func (m *MockWebhookStore) ClaimWebhookDeliveries(ctx context.Context, limit int, lease time.Duration) ([]repository.WebhookDelivery, error) {
+	deliveries := m.deliveries
+	m.deliveries = nil
+	return deliveries, nil
+}
+
+func (m *MockWebhookStore) RecordWebhookAttempt(ctx context.Context, attempt repository.WebhookAttempt, status string, nextAttemptAt time.Time) error {
+	m.attempts = append(m.attempts, attempt)
+	m.statuses = append(m.statuses, status)
+	return nil
+}
+
+func TestWebhookSignature(t *testing.T) {
+	body := []byte(`{"id":1}`)
+	sig := webhooks.Sign("secret", 1700000000, body)
+	require.True(t, webhooks.Verify("secret", 1700000000, body, sig))
+	require.False(t, webhooks.Verify("other", 1700000000, body, sig))
+	require.False(t, webhooks.Verify("secret", 1700000001, body, sig))
+	require.False(t, webhooks.Verify("secret", 1700000000, []byte(`{"id":2}`), sig))
+}
+
+func TestWebhookBackoff(t *testing.T) {
+	require.Equal(t, 30*time.Second, webhooks.Backoff(1))
+	require.Equal(t, 60*time.Second, webhooks.Backoff(2))
+	require.Equal(t, 4*time.Minute, webhooks.Backoff(4))
+	require.Equal(t, 6*time.Hour, webhooks.Backoff(20))
+}
+
+func TestWebhookWorker(t *testing.T) {
+	var signed bool
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		ts, _ := strconv.ParseInt(r.Header.Get(webhooks.HeaderTimestamp), 10, 64)
+		signed = webhooks.Verify("secret", ts, body, r.Header.Get(webhooks.HeaderSignature))
+		if r.Header.Get(webhooks.HeaderEvent) == events.Withdrawn {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer srv.Close()
+	store := &MockWebhookStore{deliveries: []repository.WebhookDelivery{
+		{Id: 1, EventType: events.Deposited, Payload: json.RawMessage(`{"sum":10}`), Url: srv.URL, Secret: "secret"},
+		{Id: 2, EventType: events.Withdrawn, Payload: json.RawMessage(`{}`), Url: srv.URL, Secret: "secret", Attempts: 1},
+		{Id: 3, EventType: events.Withdrawn, Payload: json.RawMessage(`{}`), Url: srv.URL, Secret: "secret", Attempts: webhooks.MaxAttempts - 1},
+	}}
+	worker := webhooks.NewWorker(logrus.New(), store, srv.Client(), time.Second, 10)
+	cnt, err := worker.Deliver(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, 3, cnt)
+	require.True(t, signed)
+	require.Equal(t, []string{repository.WebhookDelivered, repository.WebhookPending, repository.WebhookDead}, store.statuses)
+	require.Nil(t, store.attempts[0].Error)
+	require.Equal(t, http.StatusInternalServerError, *store.attempts[1].StatusCode)
+	require.NotNil(t, store.attempts[1].Error)
+}
+
+func TestWebhookAddress(t *testing.T) {
+	ctx := context.Background()
+	for _, url := range []string{"https://93.184.216.34/hook", "http://[2606:2800:220:1::]:8080/hook"} {
+		require.NoError(t, webhooks.CheckURL(ctx, url), url)
+	}
+	for _, url := range []string{"ftp://93.184.216.34/hook", "/hook", "http:///hook"} {
+		require.ErrorIs(t, webhooks.CheckURL(ctx, url), webhooks.ErrInvalidURL, url)
+	}
+	for _, url := range []string{
+		"http://169.254.169.254/latest/meta-data", "http://localhost:5432", "http://api.localhost/hook",
+		"http://127.0.0.1/hook", "http://[::1]/hook", "http://10.0.0.1/hook", "http://192.168.1.1/hook",
+		"http://100.64.0.1/hook", "http://0.0.0.0/hook", "http://[::ffff:127.0.0.1]/hook",
+	} {
+		require.ErrorIs(t, webhooks.CheckURL(ctx, url), webhooks.ErrForbiddenAddress, url)
+	}
+
+	// the default client refuses to connect to a non-public address whatever the url looked like
+	var hit bool
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hit = true
+	}))
+	defer srv.Close()
+	store := &MockWebhookStore{deliveries: []repository.WebhookDelivery{
+		{Id: 1, EventType: events.Deposited, Payload: json.RawMessage(`{}`), Url: srv.URL, Secret: "secret"},
+	}}
+	cnt, err := webhooks.NewWorker(logrus.New(), store, nil, time.Second, 10).Deliver(ctx)
+	require.NoError(t, err)
+	require.Equal(t, 1, cnt)
+	require.False(t, hit)
+	require.Contains(t, *store.attempts[0].Error, "not public")
+	require.Equal(t, []string{repository.WebhookPending}, store.statuses)
+}
+
+func (s *IntegrationTestSuite) TestWebhookDeliveries() {
+	ctx := context.Background()
+	id, err := s.store.CreateWallet(ctx, repository.Wallet{Owner: "test1", Balance: 100})
+	require.NoError(s.T(), err)
+	target, err := s.store.CreateWallet(ctx, repository.Wallet{Owner: "aspan", Balance: 0})
+	require.NoError(s.T(), err)
+
+	var sub repository.WebhookSubscription
+	resp := s.processRequest(ctx, http.MethodPost, s.url+"/webhooks", rest.WebhookRequest{
+		WalletId:  target,
+		EventType: events.Transferred,
+		Url:       "https://93.184.216.34/hook",
+	}, &sub)
+	require.Equal(s.T(), http.StatusCreated, resp.StatusCode)
+	require.NotEmpty(s.T(), sub.Secret)
+	for _, url := range []string{"ftp://93.184.216.34/hook", "http://169.254.169.254/latest/meta-data", "http://localhost:5432"} {
+		resp = s.processRequest(ctx, http.MethodPost, s.url+"/webhooks", rest.WebhookRequest{WalletId: target, Url: url}, nil)
+		require.Equal(s.T(), http.StatusBadRequest, resp.StatusCode, url)
+	}
+	resp = s.processRequest(ctx, http.MethodPost, s.url+"/webhooks", rest.WebhookRequest{
+		WalletId: id,
+		Url:      "https://93.184.216.34/hook",
+	}, nil)
+	require.Equal(s.T(), http.StatusForbidden, resp.StatusCode, "the wallet belongs to another user")
+
+	err = s.store.Transfer(ctx, id, &repository.FinRequest{Sum: 10, WalletTarget: target, UUID: uuid.New().String()})
+	require.NoError(s.T(), err)
+	for {
+		cnt, err := s.store.DispatchEvents(ctx, 100, webhooks.NewSink(s.store).Publish)
+		require.NoError(s.T(), err)
+		if cnt == 0 {
+			break
+		}
+	}
+	var deliveries []repository.WebhookDelivery
+	resp = s.processRequest(ctx, http.MethodGet, fmt.Sprintf("%s/webhooks/%d/deliveries", s.url, sub.Id), nil, &deliveries)
+	require.Equal(s.T(), http.StatusOK, resp.StatusCode)
+	require.Len(s.T(), deliveries, 1)
+	require.Equal(s.T(), events.Transferred, deliveries[0].EventType)
+	require.Equal(s.T(), repository.WebhookPending, deliveries[0].Status)
+
+	var delivery repository.WebhookDelivery
+	resp = s.processRequest(ctx, http.MethodPost, fmt.Sprintf("%s/webhooks/%d/test", s.url, sub.Id), nil, &delivery)
+	require.Equal(s.T(), http.StatusAccepted, resp.StatusCode)
+	require.Equal(s.T(), webhooks.TestEvent, delivery.EventType)
+	resp = s.processRequest(ctx, http.MethodPost, fmt.Sprintf("%s/webhooks/deliveries/%d/redeliver", s.url, delivery.Id), nil, &delivery)
+	require.Equal(s.T(), http.StatusAccepted, resp.StatusCode)
+	require.Equal(s.T(), repository.WebhookPending, delivery.Status)
+	resp = s.processRequest(ctx, http.MethodDelete, fmt.Sprintf("%s/webhooks/%d", s.url, sub.Id), nil, nil)
+	require.Equal(s.T(), http.StatusOK, resp.StatusCode)
+}